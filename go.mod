@@ -6,11 +6,10 @@ replace github.com/BelWue/flowpipeline => .
 
 require (
 	github.com/BelWue/bgp_routeinfo v0.0.0-20221004100427-d8095fc566dd
-	github.com/BelWue/flowfilter v1.0.0
 	github.com/ClickHouse/clickhouse-go/v2 v2.37.2
 	github.com/IBM/sarama v1.45.2
 	github.com/Yawning/cryptopan v0.0.0-20170504040949-65bca51288fe
-	github.com/alouca/gosnmp v0.0.0-20170620005048-04d83944c9ab
+	github.com/alecthomas/participle/v2 v2.1.4
 	github.com/asecurityteam/rolling v2.0.4+incompatible
 	github.com/banviktor/asnlookup v0.1.1
 	github.com/bwNetFlow/ip_prefix_trie v0.0.0-20210830112018-b360b7b65c04
@@ -18,6 +17,7 @@ require (
 	github.com/elastic/go-lumber v0.1.1
 	github.com/go-co-op/gocron/v2 v2.16.2
 	github.com/google/gopacket v1.1.19
+	github.com/gosnmp/gosnmp v1.38.0
 	github.com/influxdata/influxdb-client-go/v2 v2.14.0
 	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/netsampler/goflow2/v2 v2.2.3
@@ -32,7 +32,6 @@ require (
 
 require (
 	github.com/Shopify/sarama v1.38.1 // indirect
-	github.com/alecthomas/participle/v2 v2.1.4 // indirect
 	github.com/banviktor/go-mrt v0.0.0-20230515165434-0ce2ad0d8984 // indirect
 	github.com/go-viper/mapstructure/v2 v2.4.0 // indirect
 	github.com/jonboulle/clockwork v0.5.0 // indirect
@@ -55,7 +54,6 @@ require (
 
 require (
 	github.com/ClickHouse/ch-go v0.66.1 // indirect
-	github.com/alouca/gologger v0.0.0-20120904114645-7d4b7291de9c // indirect
 	github.com/andybalholm/brotli v1.2.0 // indirect
 	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
@@ -84,7 +82,7 @@ require (
 	github.com/k-sone/critbitgo v1.4.0 // indirect
 	github.com/klauspost/compress v1.18.0
 	github.com/libp2p/go-reuseport v0.4.0 // indirect
-	github.com/osrg/gobgp/v3 v3.37.0 // indirect
+	github.com/osrg/gobgp/v3 v3.37.0
 	github.com/paulmach/orb v0.11.1 // indirect
 	github.com/pelletier/go-toml/v2 v2.2.4 // indirect
 	github.com/pierrec/lz4/v4 v4.1.22 // indirect
@@ -21,14 +21,20 @@ import (
 
 	"github.com/BelWue/flowpipeline/pipeline"
 
+	_ "github.com/BelWue/flowpipeline/segments/alert/alertgate"
 	_ "github.com/BelWue/flowpipeline/segments/alert/http"
 
 	_ "github.com/BelWue/flowpipeline/segments/controlflow/branch"
 
+	_ "github.com/BelWue/flowpipeline/segments/filter/biflow"
+	_ "github.com/BelWue/flowpipeline/segments/filter/dedup"
 	_ "github.com/BelWue/flowpipeline/segments/filter/drop"
 	_ "github.com/BelWue/flowpipeline/segments/filter/elephant"
 
 	_ "github.com/BelWue/flowpipeline/segments/filter/flowfilter"
+	_ "github.com/BelWue/flowpipeline/segments/filter/ratelimit"
+	_ "github.com/BelWue/flowpipeline/segments/filter/rollup"
+	_ "github.com/BelWue/flowpipeline/segments/filter/sample"
 
 	_ "github.com/BelWue/flowpipeline/segments/input/bpf"
 	_ "github.com/BelWue/flowpipeline/segments/input/diskbuffer"
@@ -44,16 +50,19 @@ import (
 	_ "github.com/BelWue/flowpipeline/segments/modify/addnetid"
 	_ "github.com/BelWue/flowpipeline/segments/modify/addrstrings"
 	_ "github.com/BelWue/flowpipeline/segments/modify/anonymize"
+	_ "github.com/BelWue/flowpipeline/segments/modify/appclass"
 	_ "github.com/BelWue/flowpipeline/segments/modify/aslookup"
 	_ "github.com/BelWue/flowpipeline/segments/modify/bgp"
 	_ "github.com/BelWue/flowpipeline/segments/modify/dropfields"
 	_ "github.com/BelWue/flowpipeline/segments/modify/geolocation"
+	_ "github.com/BelWue/flowpipeline/segments/modify/ifinventory"
 	_ "github.com/BelWue/flowpipeline/segments/modify/normalize"
 	_ "github.com/BelWue/flowpipeline/segments/modify/protomap"
 	_ "github.com/BelWue/flowpipeline/segments/modify/remoteaddress"
 	_ "github.com/BelWue/flowpipeline/segments/modify/reversedns"
 	_ "github.com/BelWue/flowpipeline/segments/modify/snmp"
 	_ "github.com/BelWue/flowpipeline/segments/modify/sync_timestamps"
+	_ "github.com/BelWue/flowpipeline/segments/modify/threatintel"
 
 	_ "github.com/BelWue/flowpipeline/segments/pass"
 
@@ -72,8 +81,16 @@ import (
 	_ "github.com/BelWue/flowpipeline/segments/print/printflowdump"
 	_ "github.com/BelWue/flowpipeline/segments/print/toptalkers"
 
+	_ "github.com/BelWue/flowpipeline/segments/analysis/anomaly"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/billing"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/cardinality"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/ddos"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/heavyhitters"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/peering"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/scandetect"
 	_ "github.com/BelWue/flowpipeline/segments/analysis/toptalkers_metrics"
 	_ "github.com/BelWue/flowpipeline/segments/analysis/traffic_specific_toptalkers"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/trafficmatrix"
 )
 
 var Version string
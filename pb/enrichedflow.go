@@ -1,10 +1,16 @@
 package pb
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
+	"strings"
 )
 
+// Prefix used to reference single entries of the Labels map wherever a field
+// name is expected, i.e. `labels.customer` refers to Labels["customer"].
+const LabelPrefix = "labels."
+
 var (
 	FlowDirectionMap = map[uint32]string{
 		0: "Incoming",
@@ -77,6 +83,84 @@ func (flow *EnrichedFlow) Peer() string {
 	}
 }
 
+// Returns the label key referenced by a field name of the form `labels.<key>`
+// and whether the field name was such a reference at all.
+func LabelKey(fieldname string) (string, bool) {
+	if !strings.HasPrefix(fieldname, LabelPrefix) || len(fieldname) == len(LabelPrefix) {
+		return "", false
+	}
+	return fieldname[len(LabelPrefix):], true
+}
+
+func (flow *EnrichedFlow) GetLabel(key string) string {
+	return flow.GetLabels()[key]
+}
+
+// Sets a label on this flow, initializing the Labels map if necessary. This is
+// the preferred way for enriching segments to add information without
+// requiring a dedicated field.
+func (flow *EnrichedFlow) SetLabel(key string, value string) {
+	if flow.Labels == nil {
+		flow.Labels = make(map[string]string)
+	}
+	flow.Labels[key] = value
+}
+
+// Returns the Bytes and Packets of this flow, scaled by its SamplingRate
+// unless it has been normalized already. This is the preferred way for
+// segments to account traffic volumes regardless of preceding normalization.
+func (flow *EnrichedFlow) ScaledCounters() (uint64, uint64) {
+	if flow.GetNormalized() == EnrichedFlow_No && flow.GetSamplingRate() > 0 {
+		return flow.GetBytes() * flow.GetSamplingRate(), flow.GetPackets() * flow.GetSamplingRate()
+	}
+	return flow.GetBytes(), flow.GetPackets()
+}
+
+// Returns a binary key made of the given fields of this flow, suitable for
+// grouping flows in maps. Supported fields are srcaddr, dstaddr, srcport,
+// dstport, proto, cid, sampleraddress, netid, srcid, dstid, srcas and dstas,
+// any other fields are ignored. Network IDs include both their integer and
+// string variants, and variable length values are prefixed with their length.
+func (flow *EnrichedFlow) GroupKey(fields []string) string {
+	var key []byte
+	appendBytes := func(value []byte) {
+		key = binary.AppendUvarint(key, uint64(len(value)))
+		key = append(key, value...)
+	}
+	for _, field := range fields {
+		switch field {
+		case "srcaddr":
+			appendBytes(flow.SrcAddr)
+		case "dstaddr":
+			appendBytes(flow.DstAddr)
+		case "srcport":
+			key = binary.BigEndian.AppendUint32(key, flow.SrcPort)
+		case "dstport":
+			key = binary.BigEndian.AppendUint32(key, flow.DstPort)
+		case "proto":
+			key = binary.BigEndian.AppendUint32(key, flow.Proto)
+		case "cid":
+			key = binary.BigEndian.AppendUint32(key, flow.Cid)
+		case "sampleraddress":
+			appendBytes(flow.SamplerAddress)
+		case "netid":
+			key = binary.BigEndian.AppendUint32(key, flow.NetId)
+			appendBytes([]byte(flow.NetIdString))
+		case "srcid":
+			key = binary.BigEndian.AppendUint32(key, flow.SrcId)
+			appendBytes([]byte(flow.SrcIdString))
+		case "dstid":
+			key = binary.BigEndian.AppendUint32(key, flow.DstId)
+			appendBytes([]byte(flow.DstIdString))
+		case "srcas":
+			key = binary.BigEndian.AppendUint32(key, flow.SrcAs)
+		case "dstas":
+			key = binary.BigEndian.AppendUint32(key, flow.DstAs)
+		}
+	}
+	return string(key)
+}
+
 func (flow *EnrichedFlow) EtypeString() string {
 	return EtypeMap[flow.GetEtype()]
 }
@@ -165,6 +165,268 @@ func (EnrichedFlow_LayerStack) EnumDescriptor() ([]byte, []int) {
 	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 1}
 }
 
+// alert/alertgate
+type EnrichedFlow_AlertEventType int32
+
+const (
+	EnrichedFlow_NoAlertEvent  EnrichedFlow_AlertEventType = 0 // not a notification
+	EnrichedFlow_AlertOpen     EnrichedFlow_AlertEventType = 1
+	EnrichedFlow_AlertRepeat   EnrichedFlow_AlertEventType = 2 // after the suppression window
+	EnrichedFlow_AlertEscalate EnrichedFlow_AlertEventType = 3 // on growing counts or rates
+	EnrichedFlow_AlertResolve  EnrichedFlow_AlertEventType = 4
+)
+
+// Enum value maps for EnrichedFlow_AlertEventType.
+var (
+	EnrichedFlow_AlertEventType_name = map[int32]string{
+		0: "NoAlertEvent",
+		1: "AlertOpen",
+		2: "AlertRepeat",
+		3: "AlertEscalate",
+		4: "AlertResolve",
+	}
+	EnrichedFlow_AlertEventType_value = map[string]int32{
+		"NoAlertEvent":  0,
+		"AlertOpen":     1,
+		"AlertRepeat":   2,
+		"AlertEscalate": 3,
+		"AlertResolve":  4,
+	}
+)
+
+func (x EnrichedFlow_AlertEventType) Enum() *EnrichedFlow_AlertEventType {
+	p := new(EnrichedFlow_AlertEventType)
+	*p = x
+	return p
+}
+
+func (x EnrichedFlow_AlertEventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EnrichedFlow_AlertEventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_pb_enrichedflow_proto_enumTypes[2].Descriptor()
+}
+
+func (EnrichedFlow_AlertEventType) Type() protoreflect.EnumType {
+	return &file_pb_enrichedflow_proto_enumTypes[2]
+}
+
+func (x EnrichedFlow_AlertEventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EnrichedFlow_AlertEventType.Descriptor instead.
+func (EnrichedFlow_AlertEventType) EnumDescriptor() ([]byte, []int) {
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 2}
+}
+
+// analysis/anomaly
+type EnrichedFlow_AnomalyEventType int32
+
+const (
+	EnrichedFlow_NoAnomalyEvent EnrichedFlow_AnomalyEventType = 0 // not an event
+	EnrichedFlow_AnomalyStart   EnrichedFlow_AnomalyEventType = 1
+	EnrichedFlow_AnomalyEnd     EnrichedFlow_AnomalyEventType = 2
+)
+
+// Enum value maps for EnrichedFlow_AnomalyEventType.
+var (
+	EnrichedFlow_AnomalyEventType_name = map[int32]string{
+		0: "NoAnomalyEvent",
+		1: "AnomalyStart",
+		2: "AnomalyEnd",
+	}
+	EnrichedFlow_AnomalyEventType_value = map[string]int32{
+		"NoAnomalyEvent": 0,
+		"AnomalyStart":   1,
+		"AnomalyEnd":     2,
+	}
+)
+
+func (x EnrichedFlow_AnomalyEventType) Enum() *EnrichedFlow_AnomalyEventType {
+	p := new(EnrichedFlow_AnomalyEventType)
+	*p = x
+	return p
+}
+
+func (x EnrichedFlow_AnomalyEventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EnrichedFlow_AnomalyEventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_pb_enrichedflow_proto_enumTypes[3].Descriptor()
+}
+
+func (EnrichedFlow_AnomalyEventType) Type() protoreflect.EnumType {
+	return &file_pb_enrichedflow_proto_enumTypes[3]
+}
+
+func (x EnrichedFlow_AnomalyEventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EnrichedFlow_AnomalyEventType.Descriptor instead.
+func (EnrichedFlow_AnomalyEventType) EnumDescriptor() ([]byte, []int) {
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 3}
+}
+
+// analysis/ddos
+type EnrichedFlow_DdosEventType int32
+
+const (
+	EnrichedFlow_NoDdosEvent  EnrichedFlow_DdosEventType = 0 // not an event
+	EnrichedFlow_AttackStart  EnrichedFlow_DdosEventType = 1
+	EnrichedFlow_AttackUpdate EnrichedFlow_DdosEventType = 2
+	EnrichedFlow_AttackEnd    EnrichedFlow_DdosEventType = 3
+)
+
+// Enum value maps for EnrichedFlow_DdosEventType.
+var (
+	EnrichedFlow_DdosEventType_name = map[int32]string{
+		0: "NoDdosEvent",
+		1: "AttackStart",
+		2: "AttackUpdate",
+		3: "AttackEnd",
+	}
+	EnrichedFlow_DdosEventType_value = map[string]int32{
+		"NoDdosEvent":  0,
+		"AttackStart":  1,
+		"AttackUpdate": 2,
+		"AttackEnd":    3,
+	}
+)
+
+func (x EnrichedFlow_DdosEventType) Enum() *EnrichedFlow_DdosEventType {
+	p := new(EnrichedFlow_DdosEventType)
+	*p = x
+	return p
+}
+
+func (x EnrichedFlow_DdosEventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EnrichedFlow_DdosEventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_pb_enrichedflow_proto_enumTypes[4].Descriptor()
+}
+
+func (EnrichedFlow_DdosEventType) Type() protoreflect.EnumType {
+	return &file_pb_enrichedflow_proto_enumTypes[4]
+}
+
+func (x EnrichedFlow_DdosEventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EnrichedFlow_DdosEventType.Descriptor instead.
+func (EnrichedFlow_DdosEventType) EnumDescriptor() ([]byte, []int) {
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 4}
+}
+
+// analysis/scandetect
+type EnrichedFlow_ScanType int32
+
+const (
+	EnrichedFlow_NoScan         EnrichedFlow_ScanType = 0
+	EnrichedFlow_HorizontalScan EnrichedFlow_ScanType = 1 // one source, many destination addresses on a port
+	EnrichedFlow_VerticalScan   EnrichedFlow_ScanType = 2 // one source, many ports on a destination address
+)
+
+// Enum value maps for EnrichedFlow_ScanType.
+var (
+	EnrichedFlow_ScanType_name = map[int32]string{
+		0: "NoScan",
+		1: "HorizontalScan",
+		2: "VerticalScan",
+	}
+	EnrichedFlow_ScanType_value = map[string]int32{
+		"NoScan":         0,
+		"HorizontalScan": 1,
+		"VerticalScan":   2,
+	}
+)
+
+func (x EnrichedFlow_ScanType) Enum() *EnrichedFlow_ScanType {
+	p := new(EnrichedFlow_ScanType)
+	*p = x
+	return p
+}
+
+func (x EnrichedFlow_ScanType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EnrichedFlow_ScanType) Descriptor() protoreflect.EnumDescriptor {
+	return file_pb_enrichedflow_proto_enumTypes[5].Descriptor()
+}
+
+func (EnrichedFlow_ScanType) Type() protoreflect.EnumType {
+	return &file_pb_enrichedflow_proto_enumTypes[5]
+}
+
+func (x EnrichedFlow_ScanType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EnrichedFlow_ScanType.Descriptor instead.
+func (EnrichedFlow_ScanType) EnumDescriptor() ([]byte, []int) {
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 5}
+}
+
+// filter/biflow
+type EnrichedFlow_BiflowInitiatorType int32
+
+const (
+	EnrichedFlow_NoInitiator EnrichedFlow_BiflowInitiatorType = 0 // not stitched
+	EnrichedFlow_TcpSyn      EnrichedFlow_BiflowInitiatorType = 1
+	EnrichedFlow_FirstSeen   EnrichedFlow_BiflowInitiatorType = 2
+	EnrichedFlow_ServicePort EnrichedFlow_BiflowInitiatorType = 3
+)
+
+// Enum value maps for EnrichedFlow_BiflowInitiatorType.
+var (
+	EnrichedFlow_BiflowInitiatorType_name = map[int32]string{
+		0: "NoInitiator",
+		1: "TcpSyn",
+		2: "FirstSeen",
+		3: "ServicePort",
+	}
+	EnrichedFlow_BiflowInitiatorType_value = map[string]int32{
+		"NoInitiator": 0,
+		"TcpSyn":      1,
+		"FirstSeen":   2,
+		"ServicePort": 3,
+	}
+)
+
+func (x EnrichedFlow_BiflowInitiatorType) Enum() *EnrichedFlow_BiflowInitiatorType {
+	p := new(EnrichedFlow_BiflowInitiatorType)
+	*p = x
+	return p
+}
+
+func (x EnrichedFlow_BiflowInitiatorType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EnrichedFlow_BiflowInitiatorType) Descriptor() protoreflect.EnumDescriptor {
+	return file_pb_enrichedflow_proto_enumTypes[6].Descriptor()
+}
+
+func (EnrichedFlow_BiflowInitiatorType) Type() protoreflect.EnumType {
+	return &file_pb_enrichedflow_proto_enumTypes[6]
+}
+
+func (x EnrichedFlow_BiflowInitiatorType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EnrichedFlow_BiflowInitiatorType.Descriptor instead.
+func (EnrichedFlow_BiflowInitiatorType) EnumDescriptor() ([]byte, []int) {
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 6}
+}
+
 // modify/anonymize
 type EnrichedFlow_AnonymizedType int32
 
@@ -202,11 +464,11 @@ func (x EnrichedFlow_AnonymizedType) String() string {
 }
 
 func (EnrichedFlow_AnonymizedType) Descriptor() protoreflect.EnumDescriptor {
-	return file_pb_enrichedflow_proto_enumTypes[2].Descriptor()
+	return file_pb_enrichedflow_proto_enumTypes[7].Descriptor()
 }
 
 func (EnrichedFlow_AnonymizedType) Type() protoreflect.EnumType {
-	return &file_pb_enrichedflow_proto_enumTypes[2]
+	return &file_pb_enrichedflow_proto_enumTypes[7]
 }
 
 func (x EnrichedFlow_AnonymizedType) Number() protoreflect.EnumNumber {
@@ -215,7 +477,7 @@ func (x EnrichedFlow_AnonymizedType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use EnrichedFlow_AnonymizedType.Descriptor instead.
 func (EnrichedFlow_AnonymizedType) EnumDescriptor() ([]byte, []int) {
-	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 2}
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 7}
 }
 
 type EnrichedFlow_ValidationStatusType int32
@@ -254,11 +516,11 @@ func (x EnrichedFlow_ValidationStatusType) String() string {
 }
 
 func (EnrichedFlow_ValidationStatusType) Descriptor() protoreflect.EnumDescriptor {
-	return file_pb_enrichedflow_proto_enumTypes[3].Descriptor()
+	return file_pb_enrichedflow_proto_enumTypes[8].Descriptor()
 }
 
 func (EnrichedFlow_ValidationStatusType) Type() protoreflect.EnumType {
-	return &file_pb_enrichedflow_proto_enumTypes[3]
+	return &file_pb_enrichedflow_proto_enumTypes[8]
 }
 
 func (x EnrichedFlow_ValidationStatusType) Number() protoreflect.EnumNumber {
@@ -267,7 +529,7 @@ func (x EnrichedFlow_ValidationStatusType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use EnrichedFlow_ValidationStatusType.Descriptor instead.
 func (EnrichedFlow_ValidationStatusType) EnumDescriptor() ([]byte, []int) {
-	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 3}
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 8}
 }
 
 // modify/normalize
@@ -301,11 +563,11 @@ func (x EnrichedFlow_NormalizedType) String() string {
 }
 
 func (EnrichedFlow_NormalizedType) Descriptor() protoreflect.EnumDescriptor {
-	return file_pb_enrichedflow_proto_enumTypes[4].Descriptor()
+	return file_pb_enrichedflow_proto_enumTypes[9].Descriptor()
 }
 
 func (EnrichedFlow_NormalizedType) Type() protoreflect.EnumType {
-	return &file_pb_enrichedflow_proto_enumTypes[4]
+	return &file_pb_enrichedflow_proto_enumTypes[9]
 }
 
 func (x EnrichedFlow_NormalizedType) Number() protoreflect.EnumNumber {
@@ -314,7 +576,7 @@ func (x EnrichedFlow_NormalizedType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use EnrichedFlow_NormalizedType.Descriptor instead.
 func (EnrichedFlow_NormalizedType) EnumDescriptor() ([]byte, []int) {
-	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 4}
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 9}
 }
 
 // modify/remoteaddress
@@ -351,11 +613,11 @@ func (x EnrichedFlow_RemoteAddrType) String() string {
 }
 
 func (EnrichedFlow_RemoteAddrType) Descriptor() protoreflect.EnumDescriptor {
-	return file_pb_enrichedflow_proto_enumTypes[5].Descriptor()
+	return file_pb_enrichedflow_proto_enumTypes[10].Descriptor()
 }
 
 func (EnrichedFlow_RemoteAddrType) Type() protoreflect.EnumType {
-	return &file_pb_enrichedflow_proto_enumTypes[5]
+	return &file_pb_enrichedflow_proto_enumTypes[10]
 }
 
 func (x EnrichedFlow_RemoteAddrType) Number() protoreflect.EnumNumber {
@@ -364,7 +626,7 @@ func (x EnrichedFlow_RemoteAddrType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use EnrichedFlow_RemoteAddrType.Descriptor instead.
 func (EnrichedFlow_RemoteAddrType) EnumDescriptor() ([]byte, []int) {
-	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 5}
+	return file_pb_enrichedflow_proto_rawDescGZIP(), []int{0, 10}
 }
 
 type EnrichedFlow struct {
@@ -436,66 +698,103 @@ type EnrichedFlow struct {
 	BgpCommunities []uint32 `protobuf:"varint,101,rep,packed,name=bgp_communities,json=bgpCommunities,proto3" json:"bgp_communities,omitempty"`
 	AsPath         []uint32 `protobuf:"varint,102,rep,packed,name=as_path,json=asPath,proto3" json:"as_path,omitempty"`
 	// MPLS information
-	MplsTtl                    []uint32                  `protobuf:"varint,80,rep,packed,name=mpls_ttl,json=mplsTtl,proto3" json:"mpls_ttl,omitempty"`
-	MplsLabel                  []uint32                  `protobuf:"varint,81,rep,packed,name=mpls_label,json=mplsLabel,proto3" json:"mpls_label,omitempty"`
-	MplsIp                     [][]byte                  `protobuf:"bytes,82,rep,name=mpls_ip,json=mplsIp,proto3" json:"mpls_ip,omitempty"`
-	HasMpls                    bool                      `protobuf:"varint,53,opt,name=has_mpls,json=hasMpls,proto3" json:"has_mpls,omitempty"`
-	MplsCount                  uint32                    `protobuf:"varint,54,opt,name=mpls_count,json=mplsCount,proto3" json:"mpls_count,omitempty"`
-	Mpls_1Ttl                  uint32                    `protobuf:"varint,55,opt,name=mpls_1_ttl,json=mpls1Ttl,proto3" json:"mpls_1_ttl,omitempty"`                // First TTL
-	Mpls_1Label                uint32                    `protobuf:"varint,56,opt,name=mpls_1_label,json=mpls1Label,proto3" json:"mpls_1_label,omitempty"`          // First Label
-	Mpls_2Ttl                  uint32                    `protobuf:"varint,57,opt,name=mpls_2_ttl,json=mpls2Ttl,proto3" json:"mpls_2_ttl,omitempty"`                // Second TTL
-	Mpls_2Label                uint32                    `protobuf:"varint,58,opt,name=mpls_2_label,json=mpls2Label,proto3" json:"mpls_2_label,omitempty"`          // Second Label
-	Mpls_3Ttl                  uint32                    `protobuf:"varint,59,opt,name=mpls_3_ttl,json=mpls3Ttl,proto3" json:"mpls_3_ttl,omitempty"`                // Third TTL
-	Mpls_3Label                uint32                    `protobuf:"varint,60,opt,name=mpls_3_label,json=mpls3Label,proto3" json:"mpls_3_label,omitempty"`          // Third Label
-	MplsLastTtl                uint32                    `protobuf:"varint,61,opt,name=mpls_last_ttl,json=mplsLastTtl,proto3" json:"mpls_last_ttl,omitempty"`       // Last TTL
-	MplsLastLabel              uint32                    `protobuf:"varint,62,opt,name=mpls_last_label,json=mplsLastLabel,proto3" json:"mpls_last_label,omitempty"` // Last Label
-	MplsLabelIp                []byte                    `protobuf:"bytes,65,opt,name=mpls_label_ip,json=mplsLabelIp,proto3" json:"mpls_label_ip,omitempty"`        // MPLS TOP Label IP
-	ObservationDomainId        uint32                    `protobuf:"varint,70,opt,name=observation_domain_id,json=observationDomainId,proto3" json:"observation_domain_id,omitempty"`
-	ObservationPointId         uint32                    `protobuf:"varint,71,opt,name=observation_point_id,json=observationPointId,proto3" json:"observation_point_id,omitempty"`
-	SrcCountry                 string                    `protobuf:"bytes,1000,opt,name=src_country,json=srcCountry,proto3" json:"src_country,omitempty"`
-	DstCountry                 string                    `protobuf:"bytes,1001,opt,name=dst_country,json=dstCountry,proto3" json:"dst_country,omitempty"`
-	LayerStack                 []EnrichedFlow_LayerStack `protobuf:"varint,103,rep,packed,name=layer_stack,json=layerStack,proto3,enum=flowpb.EnrichedFlow_LayerStack" json:"layer_stack,omitempty"`
-	LayerSize                  []uint32                  `protobuf:"varint,104,rep,packed,name=layer_size,json=layerSize,proto3" json:"layer_size,omitempty"`
-	Ipv6RoutingHeaderAddresses [][]byte                  `protobuf:"bytes,105,rep,name=ipv6_routing_header_addresses,json=ipv6RoutingHeaderAddresses,proto3" json:"ipv6_routing_header_addresses,omitempty"` // SRv6
-	Ipv6RoutingHeaderSegLeft   uint32                    `protobuf:"varint,106,opt,name=ipv6_routing_header_seg_left,json=ipv6RoutingHeaderSegLeft,proto3" json:"ipv6_routing_header_seg_left,omitempty"`    // SRv6
-	PacketBytesMin             uint32                    `protobuf:"varint,2100,opt,name=PacketBytesMin,proto3" json:"PacketBytesMin,omitempty"`                                                             // new, single packet means uint32 < MTU
-	PacketBytesMax             uint32                    `protobuf:"varint,2101,opt,name=PacketBytesMax,proto3" json:"PacketBytesMax,omitempty"`                                                             // new
-	PacketBytesMean            uint32                    `protobuf:"varint,2102,opt,name=PacketBytesMean,proto3" json:"PacketBytesMean,omitempty"`                                                           // new
-	PacketBytesStdDev          uint32                    `protobuf:"varint,2103,opt,name=PacketBytesStdDev,proto3" json:"PacketBytesStdDev,omitempty"`                                                       // new
-	PacketIATMin               uint64                    `protobuf:"varint,2110,opt,name=PacketIATMin,proto3" json:"PacketIATMin,omitempty"`                                                                 // new
-	PacketIATMax               uint64                    `protobuf:"varint,2111,opt,name=PacketIATMax,proto3" json:"PacketIATMax,omitempty"`                                                                 // new
-	PacketIATMean              uint64                    `protobuf:"varint,2112,opt,name=PacketIATMean,proto3" json:"PacketIATMean,omitempty"`                                                               // new
-	PacketIATStdDev            uint64                    `protobuf:"varint,2113,opt,name=PacketIATStdDev,proto3" json:"PacketIATStdDev,omitempty"`                                                           // new
-	HeaderBytes                uint32                    `protobuf:"varint,2120,opt,name=HeaderBytes,proto3" json:"HeaderBytes,omitempty"`                                                                   // new
-	FINFlagCount               uint64                    `protobuf:"varint,2130,opt,name=FINFlagCount,proto3" json:"FINFlagCount,omitempty"`                                                                 // new
-	SYNFlagCount               uint64                    `protobuf:"varint,2131,opt,name=SYNFlagCount,proto3" json:"SYNFlagCount,omitempty"`                                                                 // new
-	RSTFlagCount               uint64                    `protobuf:"varint,2132,opt,name=RSTFlagCount,proto3" json:"RSTFlagCount,omitempty"`                                                                 // new
-	PSHFlagCount               uint64                    `protobuf:"varint,2133,opt,name=PSHFlagCount,proto3" json:"PSHFlagCount,omitempty"`                                                                 // new
-	ACKFlagCount               uint64                    `protobuf:"varint,2134,opt,name=ACKFlagCount,proto3" json:"ACKFlagCount,omitempty"`                                                                 // new
-	URGFlagCount               uint64                    `protobuf:"varint,2135,opt,name=URGFlagCount,proto3" json:"URGFlagCount,omitempty"`                                                                 // new
-	CWRFlagCount               uint64                    `protobuf:"varint,2136,opt,name=CWRFlagCount,proto3" json:"CWRFlagCount,omitempty"`                                                                 // new
-	ECEFlagCount               uint64                    `protobuf:"varint,2137,opt,name=ECEFlagCount,proto3" json:"ECEFlagCount,omitempty"`                                                                 // new
-	PayloadPackets             uint64                    `protobuf:"varint,2140,opt,name=PayloadPackets,proto3" json:"PayloadPackets,omitempty"`                                                             // new
-	TimeActiveMin              uint64                    `protobuf:"varint,2150,opt,name=TimeActiveMin,proto3" json:"TimeActiveMin,omitempty"`                                                               // new
-	TimeActiveMax              uint64                    `protobuf:"varint,2151,opt,name=TimeActiveMax,proto3" json:"TimeActiveMax,omitempty"`                                                               // new
-	TimeActiveMean             uint64                    `protobuf:"varint,2152,opt,name=TimeActiveMean,proto3" json:"TimeActiveMean,omitempty"`                                                             // new
-	TimeActiveStdDev           uint64                    `protobuf:"varint,2153,opt,name=TimeActiveStdDev,proto3" json:"TimeActiveStdDev,omitempty"`                                                         // new
-	TimeIdleMin                uint64                    `protobuf:"varint,2154,opt,name=TimeIdleMin,proto3" json:"TimeIdleMin,omitempty"`                                                                   // new
-	TimeIdleMax                uint64                    `protobuf:"varint,2155,opt,name=TimeIdleMax,proto3" json:"TimeIdleMax,omitempty"`                                                                   // new
-	TimeIdleMean               uint64                    `protobuf:"varint,2156,opt,name=TimeIdleMean,proto3" json:"TimeIdleMean,omitempty"`                                                                 // new
-	TimeIdleStdDev             uint64                    `protobuf:"varint,2157,opt,name=TimeIdleStdDev,proto3" json:"TimeIdleStdDev,omitempty"`                                                             // new
+	MplsTtl                    []uint32                         `protobuf:"varint,80,rep,packed,name=mpls_ttl,json=mplsTtl,proto3" json:"mpls_ttl,omitempty"`
+	MplsLabel                  []uint32                         `protobuf:"varint,81,rep,packed,name=mpls_label,json=mplsLabel,proto3" json:"mpls_label,omitempty"`
+	MplsIp                     [][]byte                         `protobuf:"bytes,82,rep,name=mpls_ip,json=mplsIp,proto3" json:"mpls_ip,omitempty"`
+	HasMpls                    bool                             `protobuf:"varint,53,opt,name=has_mpls,json=hasMpls,proto3" json:"has_mpls,omitempty"`
+	MplsCount                  uint32                           `protobuf:"varint,54,opt,name=mpls_count,json=mplsCount,proto3" json:"mpls_count,omitempty"`
+	Mpls_1Ttl                  uint32                           `protobuf:"varint,55,opt,name=mpls_1_ttl,json=mpls1Ttl,proto3" json:"mpls_1_ttl,omitempty"`                // First TTL
+	Mpls_1Label                uint32                           `protobuf:"varint,56,opt,name=mpls_1_label,json=mpls1Label,proto3" json:"mpls_1_label,omitempty"`          // First Label
+	Mpls_2Ttl                  uint32                           `protobuf:"varint,57,opt,name=mpls_2_ttl,json=mpls2Ttl,proto3" json:"mpls_2_ttl,omitempty"`                // Second TTL
+	Mpls_2Label                uint32                           `protobuf:"varint,58,opt,name=mpls_2_label,json=mpls2Label,proto3" json:"mpls_2_label,omitempty"`          // Second Label
+	Mpls_3Ttl                  uint32                           `protobuf:"varint,59,opt,name=mpls_3_ttl,json=mpls3Ttl,proto3" json:"mpls_3_ttl,omitempty"`                // Third TTL
+	Mpls_3Label                uint32                           `protobuf:"varint,60,opt,name=mpls_3_label,json=mpls3Label,proto3" json:"mpls_3_label,omitempty"`          // Third Label
+	MplsLastTtl                uint32                           `protobuf:"varint,61,opt,name=mpls_last_ttl,json=mplsLastTtl,proto3" json:"mpls_last_ttl,omitempty"`       // Last TTL
+	MplsLastLabel              uint32                           `protobuf:"varint,62,opt,name=mpls_last_label,json=mplsLastLabel,proto3" json:"mpls_last_label,omitempty"` // Last Label
+	MplsLabelIp                []byte                           `protobuf:"bytes,65,opt,name=mpls_label_ip,json=mplsLabelIp,proto3" json:"mpls_label_ip,omitempty"`        // MPLS TOP Label IP
+	ObservationDomainId        uint32                           `protobuf:"varint,70,opt,name=observation_domain_id,json=observationDomainId,proto3" json:"observation_domain_id,omitempty"`
+	ObservationPointId         uint32                           `protobuf:"varint,71,opt,name=observation_point_id,json=observationPointId,proto3" json:"observation_point_id,omitempty"`
+	SrcCountry                 string                           `protobuf:"bytes,1000,opt,name=src_country,json=srcCountry,proto3" json:"src_country,omitempty"`
+	DstCountry                 string                           `protobuf:"bytes,1001,opt,name=dst_country,json=dstCountry,proto3" json:"dst_country,omitempty"`
+	LayerStack                 []EnrichedFlow_LayerStack        `protobuf:"varint,103,rep,packed,name=layer_stack,json=layerStack,proto3,enum=flowpb.EnrichedFlow_LayerStack" json:"layer_stack,omitempty"`
+	LayerSize                  []uint32                         `protobuf:"varint,104,rep,packed,name=layer_size,json=layerSize,proto3" json:"layer_size,omitempty"`
+	Ipv6RoutingHeaderAddresses [][]byte                         `protobuf:"bytes,105,rep,name=ipv6_routing_header_addresses,json=ipv6RoutingHeaderAddresses,proto3" json:"ipv6_routing_header_addresses,omitempty"` // SRv6
+	Ipv6RoutingHeaderSegLeft   uint32                           `protobuf:"varint,106,opt,name=ipv6_routing_header_seg_left,json=ipv6RoutingHeaderSegLeft,proto3" json:"ipv6_routing_header_seg_left,omitempty"`    // SRv6
+	PacketBytesMin             uint32                           `protobuf:"varint,2100,opt,name=PacketBytesMin,proto3" json:"PacketBytesMin,omitempty"`                                                             // new, single packet means uint32 < MTU
+	PacketBytesMax             uint32                           `protobuf:"varint,2101,opt,name=PacketBytesMax,proto3" json:"PacketBytesMax,omitempty"`                                                             // new
+	PacketBytesMean            uint32                           `protobuf:"varint,2102,opt,name=PacketBytesMean,proto3" json:"PacketBytesMean,omitempty"`                                                           // new
+	PacketBytesStdDev          uint32                           `protobuf:"varint,2103,opt,name=PacketBytesStdDev,proto3" json:"PacketBytesStdDev,omitempty"`                                                       // new
+	PacketIATMin               uint64                           `protobuf:"varint,2110,opt,name=PacketIATMin,proto3" json:"PacketIATMin,omitempty"`                                                                 // new
+	PacketIATMax               uint64                           `protobuf:"varint,2111,opt,name=PacketIATMax,proto3" json:"PacketIATMax,omitempty"`                                                                 // new
+	PacketIATMean              uint64                           `protobuf:"varint,2112,opt,name=PacketIATMean,proto3" json:"PacketIATMean,omitempty"`                                                               // new
+	PacketIATStdDev            uint64                           `protobuf:"varint,2113,opt,name=PacketIATStdDev,proto3" json:"PacketIATStdDev,omitempty"`                                                           // new
+	HeaderBytes                uint32                           `protobuf:"varint,2120,opt,name=HeaderBytes,proto3" json:"HeaderBytes,omitempty"`                                                                   // new
+	FINFlagCount               uint64                           `protobuf:"varint,2130,opt,name=FINFlagCount,proto3" json:"FINFlagCount,omitempty"`                                                                 // new
+	SYNFlagCount               uint64                           `protobuf:"varint,2131,opt,name=SYNFlagCount,proto3" json:"SYNFlagCount,omitempty"`                                                                 // new
+	RSTFlagCount               uint64                           `protobuf:"varint,2132,opt,name=RSTFlagCount,proto3" json:"RSTFlagCount,omitempty"`                                                                 // new
+	PSHFlagCount               uint64                           `protobuf:"varint,2133,opt,name=PSHFlagCount,proto3" json:"PSHFlagCount,omitempty"`                                                                 // new
+	ACKFlagCount               uint64                           `protobuf:"varint,2134,opt,name=ACKFlagCount,proto3" json:"ACKFlagCount,omitempty"`                                                                 // new
+	URGFlagCount               uint64                           `protobuf:"varint,2135,opt,name=URGFlagCount,proto3" json:"URGFlagCount,omitempty"`                                                                 // new
+	CWRFlagCount               uint64                           `protobuf:"varint,2136,opt,name=CWRFlagCount,proto3" json:"CWRFlagCount,omitempty"`                                                                 // new
+	ECEFlagCount               uint64                           `protobuf:"varint,2137,opt,name=ECEFlagCount,proto3" json:"ECEFlagCount,omitempty"`                                                                 // new
+	PayloadPackets             uint64                           `protobuf:"varint,2140,opt,name=PayloadPackets,proto3" json:"PayloadPackets,omitempty"`                                                             // new
+	TimeActiveMin              uint64                           `protobuf:"varint,2150,opt,name=TimeActiveMin,proto3" json:"TimeActiveMin,omitempty"`                                                               // new
+	TimeActiveMax              uint64                           `protobuf:"varint,2151,opt,name=TimeActiveMax,proto3" json:"TimeActiveMax,omitempty"`                                                               // new
+	TimeActiveMean             uint64                           `protobuf:"varint,2152,opt,name=TimeActiveMean,proto3" json:"TimeActiveMean,omitempty"`                                                             // new
+	TimeActiveStdDev           uint64                           `protobuf:"varint,2153,opt,name=TimeActiveStdDev,proto3" json:"TimeActiveStdDev,omitempty"`                                                         // new
+	TimeIdleMin                uint64                           `protobuf:"varint,2154,opt,name=TimeIdleMin,proto3" json:"TimeIdleMin,omitempty"`                                                                   // new
+	TimeIdleMax                uint64                           `protobuf:"varint,2155,opt,name=TimeIdleMax,proto3" json:"TimeIdleMax,omitempty"`                                                                   // new
+	TimeIdleMean               uint64                           `protobuf:"varint,2156,opt,name=TimeIdleMean,proto3" json:"TimeIdleMean,omitempty"`                                                                 // new
+	TimeIdleStdDev             uint64                           `protobuf:"varint,2157,opt,name=TimeIdleStdDev,proto3" json:"TimeIdleStdDev,omitempty"`                                                             // new
+	AlertEvent                 EnrichedFlow_AlertEventType      `protobuf:"varint,2270,opt,name=AlertEvent,proto3,enum=flowpb.EnrichedFlow_AlertEventType" json:"AlertEvent,omitempty"`
+	AlertId                    uint64                           `protobuf:"varint,2271,opt,name=AlertId,proto3" json:"AlertId,omitempty"`
+	AlertKey                   string                           `protobuf:"bytes,2272,opt,name=AlertKey,proto3" json:"AlertKey,omitempty"`
+	AlertLevel                 uint32                           `protobuf:"varint,2273,opt,name=AlertLevel,proto3" json:"AlertLevel,omitempty"`           // starting at 1, increased by escalations
+	AlertFlows                 uint64                           `protobuf:"varint,2274,opt,name=AlertFlows,proto3" json:"AlertFlows,omitempty"`           // since the alert opened
+	AlertBytes                 uint64                           `protobuf:"varint,2275,opt,name=AlertBytes,proto3" json:"AlertBytes,omitempty"`           // since the alert opened
+	AlertPackets               uint64                           `protobuf:"varint,2276,opt,name=AlertPackets,proto3" json:"AlertPackets,omitempty"`       // since the alert opened
+	AlertBps                   uint64                           `protobuf:"varint,2277,opt,name=AlertBps,proto3" json:"AlertBps,omitempty"`               // of the last interval
+	AlertSuppressed            uint64                           `protobuf:"varint,2278,opt,name=AlertSuppressed,proto3" json:"AlertSuppressed,omitempty"` // flows since the last notification
+	AlertFirstSeenNs           uint64                           `protobuf:"varint,2279,opt,name=AlertFirstSeenNs,proto3" json:"AlertFirstSeenNs,omitempty"`
+	AlertLastSeenNs            uint64                           `protobuf:"varint,2280,opt,name=AlertLastSeenNs,proto3" json:"AlertLastSeenNs,omitempty"`
+	AnomalyEvent               EnrichedFlow_AnomalyEventType    `protobuf:"varint,2260,opt,name=AnomalyEvent,proto3,enum=flowpb.EnrichedFlow_AnomalyEventType" json:"AnomalyEvent,omitempty"`
+	AnomalyMetric              string                           `protobuf:"bytes,2261,opt,name=AnomalyMetric,proto3" json:"AnomalyMetric,omitempty"`       // one of bps, pps, fps, tcp, udp and icmp
+	AnomalyValue               float64                          `protobuf:"fixed64,2262,opt,name=AnomalyValue,proto3" json:"AnomalyValue,omitempty"`       // of the last interval
+	AnomalyExpected            float64                          `protobuf:"fixed64,2263,opt,name=AnomalyExpected,proto3" json:"AnomalyExpected,omitempty"` // by the baseline
+	AnomalySigma               float64                          `protobuf:"fixed64,2264,opt,name=AnomalySigma,proto3" json:"AnomalySigma,omitempty"`       // deviation in standard deviations, negative below the baseline
+	DdosEvent                  EnrichedFlow_DdosEventType       `protobuf:"varint,2220,opt,name=DdosEvent,proto3,enum=flowpb.EnrichedFlow_DdosEventType" json:"DdosEvent,omitempty"`
+	DdosAttackId               uint64                           `protobuf:"varint,2221,opt,name=DdosAttackId,proto3" json:"DdosAttackId,omitempty"`
+	DdosVectors                []string                         `protobuf:"bytes,2222,rep,name=DdosVectors,proto3" json:"DdosVectors,omitempty"` // by share of bytes, descending
+	DdosBps                    uint64                           `protobuf:"varint,2223,opt,name=DdosBps,proto3" json:"DdosBps,omitempty"`        // of the last interval
+	DdosPps                    uint64                           `protobuf:"varint,2224,opt,name=DdosPps,proto3" json:"DdosPps,omitempty"`        // of the last interval
+	DdosBaselineBps            uint64                           `protobuf:"varint,2225,opt,name=DdosBaselineBps,proto3" json:"DdosBaselineBps,omitempty"`
+	DdosBaselinePps            uint64                           `protobuf:"varint,2226,opt,name=DdosBaselinePps,proto3" json:"DdosBaselinePps,omitempty"`
+	DdosPeakBps                uint64                           `protobuf:"varint,2227,opt,name=DdosPeakBps,proto3" json:"DdosPeakBps,omitempty"`
+	DdosPeakPps                uint64                           `protobuf:"varint,2228,opt,name=DdosPeakPps,proto3" json:"DdosPeakPps,omitempty"`
+	DdosTopSources             []string                         `protobuf:"bytes,2229,rep,name=DdosTopSources,proto3" json:"DdosTopSources,omitempty"`
+	DdosTopSrcPorts            []uint32                         `protobuf:"varint,2230,rep,packed,name=DdosTopSrcPorts,proto3" json:"DdosTopSrcPorts,omitempty"`
+	DdosTopDstPorts            []uint32                         `protobuf:"varint,2231,rep,packed,name=DdosTopDstPorts,proto3" json:"DdosTopDstPorts,omitempty"`
+	Scan                       EnrichedFlow_ScanType            `protobuf:"varint,2240,opt,name=Scan,proto3,enum=flowpb.EnrichedFlow_ScanType" json:"Scan,omitempty"`
+	ScanEvent                  bool                             `protobuf:"varint,2241,opt,name=ScanEvent,proto3" json:"ScanEvent,omitempty"`     // set on events, which do not represent traffic themselves
+	ScanTargets                uint64                           `protobuf:"varint,2242,opt,name=ScanTargets,proto3" json:"ScanTargets,omitempty"` // number of distinct destination addresses or ports
+	ReverseBytes               uint64                           `protobuf:"varint,2210,opt,name=ReverseBytes,proto3" json:"ReverseBytes,omitempty"`
+	ReversePackets             uint64                           `protobuf:"varint,2211,opt,name=ReversePackets,proto3" json:"ReversePackets,omitempty"`
+	ReverseTcpFlags            uint32                           `protobuf:"varint,2212,opt,name=ReverseTcpFlags,proto3" json:"ReverseTcpFlags,omitempty"`
+	BiflowInitiator            EnrichedFlow_BiflowInitiatorType `protobuf:"varint,2213,opt,name=BiflowInitiator,proto3,enum=flowpb.EnrichedFlow_BiflowInitiatorType" json:"BiflowInitiator,omitempty"` // src is the initiator if set
+	// filter/rollup
+	RollupFlows uint64 `protobuf:"varint,2250,opt,name=RollupFlows,proto3" json:"RollupFlows,omitempty"` // number of flows summarized
 	// modify/addcid
-	Cid                           uint32                      `protobuf:"varint,2000,opt,name=Cid,proto3" json:"Cid,omitempty"`            // TODO: deprecate and provide as helper?
-	CidString                     string                      `protobuf:"bytes,2001,opt,name=CidString,proto3" json:"CidString,omitempty"` // deprecated, delete for v1.0.0
-	SrcCid                        uint32                      `protobuf:"varint,2012,opt,name=SrcCid,proto3" json:"SrcCid,omitempty"`
-	DstCid                        uint32                      `protobuf:"varint,2013,opt,name=DstCid,proto3" json:"DstCid,omitempty"`
+	Cid       uint32 `protobuf:"varint,2000,opt,name=Cid,proto3" json:"Cid,omitempty"`            // TODO: deprecate and provide as helper?
+	CidString string `protobuf:"bytes,2001,opt,name=CidString,proto3" json:"CidString,omitempty"` // deprecated, delete for v1.0.0
+	SrcCid    uint32 `protobuf:"varint,2012,opt,name=SrcCid,proto3" json:"SrcCid,omitempty"`
+	DstCid    uint32 `protobuf:"varint,2013,opt,name=DstCid,proto3" json:"DstCid,omitempty"`
 	// modify/addnetid
 	NetId                         uint32                      `protobuf:"varint,2017,opt,name=NetId,proto3" json:"NetId,omitempty"`
-	NetIdString                   string                      `protobuf:"varint,2018,opt,name=NetIdString,proto3" json:"NetIdString,omitempty"`
-	SrcId                         uint32                      `protobuf:"varint,2019,opt,name=SrcId,proto3" json:"NetId,omitempty"`
-	SrcIdString                   string                      `protobuf:"varint,2020,opt,name=SrcIdString,proto3" json:"NetIdString,omitempty"`
-	DstId                         uint32                      `protobuf:"varint,2021,opt,name=DstId,proto3" json:"NetId,omitempty"`
-	DstIdString                   string                      `protobuf:"varint,2022,opt,name=DstIdString,proto3" json:"DstIdString,omitempty"`
+	NetIdString                   string                      `protobuf:"bytes,2018,opt,name=NetIdString,proto3" json:"NetIdString,omitempty"`
+	SrcId                         uint32                      `protobuf:"varint,2019,opt,name=SrcId,proto3" json:"SrcId,omitempty"`
+	SrcIdString                   string                      `protobuf:"bytes,2020,opt,name=SrcIdString,proto3" json:"SrcIdString,omitempty"`
+	DstId                         uint32                      `protobuf:"varint,2021,opt,name=DstId,proto3" json:"DstId,omitempty"`
+	DstIdString                   string                      `protobuf:"bytes,2022,opt,name=DstIdString,proto3" json:"DstIdString,omitempty"`
 	SrcAddrAnon                   EnrichedFlow_AnonymizedType `protobuf:"varint,2160,opt,name=SrcAddrAnon,proto3,enum=flowpb.EnrichedFlow_AnonymizedType" json:"SrcAddrAnon,omitempty"`
 	DstAddrAnon                   EnrichedFlow_AnonymizedType `protobuf:"varint,2161,opt,name=DstAddrAnon,proto3,enum=flowpb.EnrichedFlow_AnonymizedType" json:"DstAddrAnon,omitempty"`
 	SrcAddrPreservedLen           uint32                      `protobuf:"varint,2162,opt,name=SrcAddrPreservedLen,proto3" json:"SrcAddrPreservedLen,omitempty"`
@@ -509,11 +808,16 @@ type EnrichedFlow struct {
 	Med              uint32                            `protobuf:"varint,2172,opt,name=Med,proto3" json:"Med,omitempty"`
 	LocalPref        uint32                            `protobuf:"varint,2173,opt,name=LocalPref,proto3" json:"LocalPref,omitempty"`
 	ValidationStatus EnrichedFlow_ValidationStatusType `protobuf:"varint,2174,opt,name=ValidationStatus,proto3,enum=flowpb.EnrichedFlow_ValidationStatusType" json:"ValidationStatus,omitempty"`
+	LargeCommunities []string                          `protobuf:"bytes,2175,rep,name=LargeCommunities,proto3" json:"LargeCommunities,omitempty"` // formatted as global:local1:local2, standard communities are in bgp_communities
 	// modify/geolocation
-	RemoteCountry string                      `protobuf:"bytes,2010,opt,name=RemoteCountry,proto3" json:"RemoteCountry,omitempty"` // TODO: deprecate and provide as helper
-	SrcCountryBW  string                      `protobuf:"bytes,2014,opt,name=SrcCountryBW,proto3" json:"SrcCountryBW,omitempty"`
-	DstCountryBW  string                      `protobuf:"bytes,2015,opt,name=DstCountryBW,proto3" json:"DstCountryBW,omitempty"`
-	Normalized    EnrichedFlow_NormalizedType `protobuf:"varint,2002,opt,name=Normalized,proto3,enum=flowpb.EnrichedFlow_NormalizedType" json:"Normalized,omitempty"` // TODO: deprecate and replace with helper?
+	RemoteCountry        string                      `protobuf:"bytes,2010,opt,name=RemoteCountry,proto3" json:"RemoteCountry,omitempty"` // TODO: deprecate and provide as helper
+	SrcCountryBW         string                      `protobuf:"bytes,2014,opt,name=SrcCountryBW,proto3" json:"SrcCountryBW,omitempty"`
+	DstCountryBW         string                      `protobuf:"bytes,2015,opt,name=DstCountryBW,proto3" json:"DstCountryBW,omitempty"`
+	Normalized           EnrichedFlow_NormalizedType `protobuf:"varint,2002,opt,name=Normalized,proto3,enum=flowpb.EnrichedFlow_NormalizedType" json:"Normalized,omitempty"` // TODO: deprecate and replace with helper?
+	OriginalSamplingRate uint64                      `protobuf:"varint,2025,opt,name=OriginalSamplingRate,proto3" json:"OriginalSamplingRate,omitempty"`                     // SamplingRate is set to 1 after normalization
+	// modify/ifinventory, also sets the modify/snmp fields below
+	SrcIfRole string `protobuf:"bytes,2023,opt,name=SrcIfRole,proto3" json:"SrcIfRole,omitempty"`
+	DstIfRole string `protobuf:"bytes,2024,opt,name=DstIfRole,proto3" json:"DstIfRole,omitempty"`
 	// modify/protomap
 	ProtoName  string                      `protobuf:"bytes,2009,opt,name=ProtoName,proto3" json:"ProtoName,omitempty"`                                            // TODO: deprecate and replace with helper, why lug a string along...
 	RemoteAddr EnrichedFlow_RemoteAddrType `protobuf:"varint,2011,opt,name=RemoteAddr,proto3,enum=flowpb.EnrichedFlow_RemoteAddrType" json:"RemoteAddr,omitempty"` // TODO: figure out a better system? applicable only to service providers right now...
@@ -533,7 +837,8 @@ type EnrichedFlow struct {
 	DstIfDesc  string `protobuf:"bytes,2007,opt,name=DstIfDesc,proto3" json:"DstIfDesc,omitempty"`    // TODO: rename to match InIf and OutIf
 	DstIfSpeed uint32 `protobuf:"varint,2008,opt,name=DstIfSpeed,proto3" json:"DstIfSpeed,omitempty"` // TODO: rename to match InIf and OutIf
 	// general
-	Note string `protobuf:"bytes,2016,opt,name=Note,proto3" json:"Note,omitempty"` // free-form field to implement anything
+	Note   string            `protobuf:"bytes,2016,opt,name=Note,proto3" json:"Note,omitempty"`                                                                               // free-form field to implement anything
+	Labels map[string]string `protobuf:"bytes,2400,rep,name=Labels,proto3" json:"Labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // free-form key/value annotations, referenced as labels.<key>
 	// modify/addrstrings
 	SourceIP       string `protobuf:"bytes,2290,opt,name=SourceIP,proto3" json:"SourceIP,omitempty"`
 	DestinationIP  string `protobuf:"bytes,2291,opt,name=DestinationIP,proto3" json:"DestinationIP,omitempty"`
@@ -1262,6 +1567,258 @@ func (x *EnrichedFlow) GetTimeIdleStdDev() uint64 {
 	return 0
 }
 
+func (x *EnrichedFlow) GetAlertEvent() EnrichedFlow_AlertEventType {
+	if x != nil {
+		return x.AlertEvent
+	}
+	return EnrichedFlow_NoAlertEvent
+}
+
+func (x *EnrichedFlow) GetAlertId() uint64 {
+	if x != nil {
+		return x.AlertId
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAlertKey() string {
+	if x != nil {
+		return x.AlertKey
+	}
+	return ""
+}
+
+func (x *EnrichedFlow) GetAlertLevel() uint32 {
+	if x != nil {
+		return x.AlertLevel
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAlertFlows() uint64 {
+	if x != nil {
+		return x.AlertFlows
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAlertBytes() uint64 {
+	if x != nil {
+		return x.AlertBytes
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAlertPackets() uint64 {
+	if x != nil {
+		return x.AlertPackets
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAlertBps() uint64 {
+	if x != nil {
+		return x.AlertBps
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAlertSuppressed() uint64 {
+	if x != nil {
+		return x.AlertSuppressed
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAlertFirstSeenNs() uint64 {
+	if x != nil {
+		return x.AlertFirstSeenNs
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAlertLastSeenNs() uint64 {
+	if x != nil {
+		return x.AlertLastSeenNs
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAnomalyEvent() EnrichedFlow_AnomalyEventType {
+	if x != nil {
+		return x.AnomalyEvent
+	}
+	return EnrichedFlow_NoAnomalyEvent
+}
+
+func (x *EnrichedFlow) GetAnomalyMetric() string {
+	if x != nil {
+		return x.AnomalyMetric
+	}
+	return ""
+}
+
+func (x *EnrichedFlow) GetAnomalyValue() float64 {
+	if x != nil {
+		return x.AnomalyValue
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAnomalyExpected() float64 {
+	if x != nil {
+		return x.AnomalyExpected
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetAnomalySigma() float64 {
+	if x != nil {
+		return x.AnomalySigma
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetDdosEvent() EnrichedFlow_DdosEventType {
+	if x != nil {
+		return x.DdosEvent
+	}
+	return EnrichedFlow_NoDdosEvent
+}
+
+func (x *EnrichedFlow) GetDdosAttackId() uint64 {
+	if x != nil {
+		return x.DdosAttackId
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetDdosVectors() []string {
+	if x != nil {
+		return x.DdosVectors
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetDdosBps() uint64 {
+	if x != nil {
+		return x.DdosBps
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetDdosPps() uint64 {
+	if x != nil {
+		return x.DdosPps
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetDdosBaselineBps() uint64 {
+	if x != nil {
+		return x.DdosBaselineBps
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetDdosBaselinePps() uint64 {
+	if x != nil {
+		return x.DdosBaselinePps
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetDdosPeakBps() uint64 {
+	if x != nil {
+		return x.DdosPeakBps
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetDdosPeakPps() uint64 {
+	if x != nil {
+		return x.DdosPeakPps
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetDdosTopSources() []string {
+	if x != nil {
+		return x.DdosTopSources
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetDdosTopSrcPorts() []uint32 {
+	if x != nil {
+		return x.DdosTopSrcPorts
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetDdosTopDstPorts() []uint32 {
+	if x != nil {
+		return x.DdosTopDstPorts
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetScan() EnrichedFlow_ScanType {
+	if x != nil {
+		return x.Scan
+	}
+	return EnrichedFlow_NoScan
+}
+
+func (x *EnrichedFlow) GetScanEvent() bool {
+	if x != nil {
+		return x.ScanEvent
+	}
+	return false
+}
+
+func (x *EnrichedFlow) GetScanTargets() uint64 {
+	if x != nil {
+		return x.ScanTargets
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetReverseBytes() uint64 {
+	if x != nil {
+		return x.ReverseBytes
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetReversePackets() uint64 {
+	if x != nil {
+		return x.ReversePackets
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetReverseTcpFlags() uint32 {
+	if x != nil {
+		return x.ReverseTcpFlags
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetBiflowInitiator() EnrichedFlow_BiflowInitiatorType {
+	if x != nil {
+		return x.BiflowInitiator
+	}
+	return EnrichedFlow_NoInitiator
+}
+
+func (x *EnrichedFlow) GetRollupFlows() uint64 {
+	if x != nil {
+		return x.RollupFlows
+	}
+	return 0
+}
+
 func (x *EnrichedFlow) GetCid() uint32 {
 	if x != nil {
 		return x.Cid
@@ -1409,6 +1966,13 @@ func (x *EnrichedFlow) GetValidationStatus() EnrichedFlow_ValidationStatusType {
 	return EnrichedFlow_Unknown
 }
 
+func (x *EnrichedFlow) GetLargeCommunities() []string {
+	if x != nil {
+		return x.LargeCommunities
+	}
+	return nil
+}
+
 func (x *EnrichedFlow) GetRemoteCountry() string {
 	if x != nil {
 		return x.RemoteCountry
@@ -1437,6 +2001,27 @@ func (x *EnrichedFlow) GetNormalized() EnrichedFlow_NormalizedType {
 	return EnrichedFlow_No
 }
 
+func (x *EnrichedFlow) GetOriginalSamplingRate() uint64 {
+	if x != nil {
+		return x.OriginalSamplingRate
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetSrcIfRole() string {
+	if x != nil {
+		return x.SrcIfRole
+	}
+	return ""
+}
+
+func (x *EnrichedFlow) GetDstIfRole() string {
+	if x != nil {
+		return x.DstIfRole
+	}
+	return ""
+}
+
 func (x *EnrichedFlow) GetProtoName() string {
 	if x != nil {
 		return x.ProtoName
@@ -1549,6 +2134,13 @@ func (x *EnrichedFlow) GetNote() string {
 	return ""
 }
 
+func (x *EnrichedFlow) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
 func (x *EnrichedFlow) GetSourceIP() string {
 	if x != nil {
 		return x.SourceIP
@@ -1630,7 +2222,7 @@ var File_pb_enrichedflow_proto protoreflect.FileDescriptor
 
 const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\n" +
-	"\x15pb/enrichedflow.proto\x12\x06flowpb\"\xfd-\n" +
+	"\x15pb/enrichedflow.proto\x12\x06flowpb\"\xab@\n" +
 	"\fEnrichedFlow\x121\n" +
 	"\x04type\x18\x01 \x01(\x0e2\x1d.flowpb.EnrichedFlow.FlowTypeR\x04type\x12#\n" +
 	"\rtime_received\x18\x02 \x01(\x04R\ftimeReceived\x12(\n" +
@@ -1743,11 +2335,61 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\vTimeIdleMin\x18\xea\x10 \x01(\x04R\vTimeIdleMin\x12!\n" +
 	"\vTimeIdleMax\x18\xeb\x10 \x01(\x04R\vTimeIdleMax\x12#\n" +
 	"\fTimeIdleMean\x18\xec\x10 \x01(\x04R\fTimeIdleMean\x12'\n" +
-	"\x0eTimeIdleStdDev\x18\xed\x10 \x01(\x04R\x0eTimeIdleStdDev\x12\x11\n" +
+	"\x0eTimeIdleStdDev\x18\xed\x10 \x01(\x04R\x0eTimeIdleStdDev\x12D\n" +
+	"\n" +
+	"AlertEvent\x18\xde\x11 \x01(\x0e2#.flowpb.EnrichedFlow.AlertEventTypeR\n" +
+	"AlertEvent\x12\x19\n" +
+	"\aAlertId\x18\xdf\x11 \x01(\x04R\aAlertId\x12\x1b\n" +
+	"\bAlertKey\x18\xe0\x11 \x01(\tR\bAlertKey\x12\x1f\n" +
+	"\n" +
+	"AlertLevel\x18\xe1\x11 \x01(\rR\n" +
+	"AlertLevel\x12\x1f\n" +
+	"\n" +
+	"AlertFlows\x18\xe2\x11 \x01(\x04R\n" +
+	"AlertFlows\x12\x1f\n" +
+	"\n" +
+	"AlertBytes\x18\xe3\x11 \x01(\x04R\n" +
+	"AlertBytes\x12#\n" +
+	"\fAlertPackets\x18\xe4\x11 \x01(\x04R\fAlertPackets\x12\x1b\n" +
+	"\bAlertBps\x18\xe5\x11 \x01(\x04R\bAlertBps\x12)\n" +
+	"\x0fAlertSuppressed\x18\xe6\x11 \x01(\x04R\x0fAlertSuppressed\x12+\n" +
+	"\x10AlertFirstSeenNs\x18\xe7\x11 \x01(\x04R\x10AlertFirstSeenNs\x12)\n" +
+	"\x0fAlertLastSeenNs\x18\xe8\x11 \x01(\x04R\x0fAlertLastSeenNs\x12J\n" +
+	"\fAnomalyEvent\x18\xd4\x11 \x01(\x0e2%.flowpb.EnrichedFlow.AnomalyEventTypeR\fAnomalyEvent\x12%\n" +
+	"\rAnomalyMetric\x18\xd5\x11 \x01(\tR\rAnomalyMetric\x12#\n" +
+	"\fAnomalyValue\x18\xd6\x11 \x01(\x01R\fAnomalyValue\x12)\n" +
+	"\x0fAnomalyExpected\x18\xd7\x11 \x01(\x01R\x0fAnomalyExpected\x12#\n" +
+	"\fAnomalySigma\x18\xd8\x11 \x01(\x01R\fAnomalySigma\x12A\n" +
+	"\tDdosEvent\x18\xac\x11 \x01(\x0e2\".flowpb.EnrichedFlow.DdosEventTypeR\tDdosEvent\x12#\n" +
+	"\fDdosAttackId\x18\xad\x11 \x01(\x04R\fDdosAttackId\x12!\n" +
+	"\vDdosVectors\x18\xae\x11 \x03(\tR\vDdosVectors\x12\x19\n" +
+	"\aDdosBps\x18\xaf\x11 \x01(\x04R\aDdosBps\x12\x19\n" +
+	"\aDdosPps\x18\xb0\x11 \x01(\x04R\aDdosPps\x12)\n" +
+	"\x0fDdosBaselineBps\x18\xb1\x11 \x01(\x04R\x0fDdosBaselineBps\x12)\n" +
+	"\x0fDdosBaselinePps\x18\xb2\x11 \x01(\x04R\x0fDdosBaselinePps\x12!\n" +
+	"\vDdosPeakBps\x18\xb3\x11 \x01(\x04R\vDdosPeakBps\x12!\n" +
+	"\vDdosPeakPps\x18\xb4\x11 \x01(\x04R\vDdosPeakPps\x12'\n" +
+	"\x0eDdosTopSources\x18\xb5\x11 \x03(\tR\x0eDdosTopSources\x12)\n" +
+	"\x0fDdosTopSrcPorts\x18\xb6\x11 \x03(\rR\x0fDdosTopSrcPorts\x12)\n" +
+	"\x0fDdosTopDstPorts\x18\xb7\x11 \x03(\rR\x0fDdosTopDstPorts\x122\n" +
+	"\x04Scan\x18\xc0\x11 \x01(\x0e2\x1d.flowpb.EnrichedFlow.ScanTypeR\x04Scan\x12\x1d\n" +
+	"\tScanEvent\x18\xc1\x11 \x01(\bR\tScanEvent\x12!\n" +
+	"\vScanTargets\x18\xc2\x11 \x01(\x04R\vScanTargets\x12#\n" +
+	"\fReverseBytes\x18\xa2\x11 \x01(\x04R\fReverseBytes\x12'\n" +
+	"\x0eReversePackets\x18\xa3\x11 \x01(\x04R\x0eReversePackets\x12)\n" +
+	"\x0fReverseTcpFlags\x18\xa4\x11 \x01(\rR\x0fReverseTcpFlags\x12S\n" +
+	"\x0fBiflowInitiator\x18\xa5\x11 \x01(\x0e2(.flowpb.EnrichedFlow.BiflowInitiatorTypeR\x0fBiflowInitiator\x12!\n" +
+	"\vRollupFlows\x18\xca\x11 \x01(\x04R\vRollupFlows\x12\x11\n" +
 	"\x03Cid\x18\xd0\x0f \x01(\rR\x03Cid\x12\x1d\n" +
 	"\tCidString\x18\xd1\x0f \x01(\tR\tCidString\x12\x17\n" +
 	"\x06SrcCid\x18\xdc\x0f \x01(\rR\x06SrcCid\x12\x17\n" +
-	"\x06DstCid\x18\xdd\x0f \x01(\rR\x06DstCid\x12F\n" +
+	"\x06DstCid\x18\xdd\x0f \x01(\rR\x06DstCid\x12\x15\n" +
+	"\x05NetId\x18\xe1\x0f \x01(\rR\x05NetId\x12!\n" +
+	"\vNetIdString\x18\xe2\x0f \x01(\tR\vNetIdString\x12\x15\n" +
+	"\x05SrcId\x18\xe3\x0f \x01(\rR\x05SrcId\x12!\n" +
+	"\vSrcIdString\x18\xe4\x0f \x01(\tR\vSrcIdString\x12\x15\n" +
+	"\x05DstId\x18\xe5\x0f \x01(\rR\x05DstId\x12!\n" +
+	"\vDstIdString\x18\xe6\x0f \x01(\tR\vDstIdString\x12F\n" +
 	"\vSrcAddrAnon\x18\xf0\x10 \x01(\x0e2#.flowpb.EnrichedFlow.AnonymizedTypeR\vSrcAddrAnon\x12F\n" +
 	"\vDstAddrAnon\x18\xf1\x10 \x01(\x0e2#.flowpb.EnrichedFlow.AnonymizedTypeR\vDstAddrAnon\x121\n" +
 	"\x13SrcAddrPreservedLen\x18\xf2\x10 \x01(\rR\x13SrcAddrPreservedLen\x121\n" +
@@ -1758,13 +2400,17 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\x1dNextHopAnonPreservedPrefixLen\x18\xf7\x10 \x01(\rR\x1dNextHopAnonPreservedPrefixLen\x12\x11\n" +
 	"\x03Med\x18\xfc\x10 \x01(\rR\x03Med\x12\x1d\n" +
 	"\tLocalPref\x18\xfd\x10 \x01(\rR\tLocalPref\x12V\n" +
-	"\x10ValidationStatus\x18\xfe\x10 \x01(\x0e2).flowpb.EnrichedFlow.ValidationStatusTypeR\x10ValidationStatus\x12%\n" +
+	"\x10ValidationStatus\x18\xfe\x10 \x01(\x0e2).flowpb.EnrichedFlow.ValidationStatusTypeR\x10ValidationStatus\x12+\n" +
+	"\x10LargeCommunities\x18\xff\x10 \x03(\tR\x10LargeCommunities\x12%\n" +
 	"\rRemoteCountry\x18\xda\x0f \x01(\tR\rRemoteCountry\x12#\n" +
 	"\fSrcCountryBW\x18\xde\x0f \x01(\tR\fSrcCountryBW\x12#\n" +
 	"\fDstCountryBW\x18\xdf\x0f \x01(\tR\fDstCountryBW\x12D\n" +
 	"\n" +
 	"Normalized\x18\xd2\x0f \x01(\x0e2#.flowpb.EnrichedFlow.NormalizedTypeR\n" +
-	"Normalized\x12\x1d\n" +
+	"Normalized\x123\n" +
+	"\x14OriginalSamplingRate\x18\xe9\x0f \x01(\x04R\x14OriginalSamplingRate\x12\x1d\n" +
+	"\tSrcIfRole\x18\xe7\x0f \x01(\tR\tSrcIfRole\x12\x1d\n" +
+	"\tDstIfRole\x18\xe8\x0f \x01(\tR\tDstIfRole\x12\x1d\n" +
 	"\tProtoName\x18\xd9\x0f \x01(\tR\tProtoName\x12D\n" +
 	"\n" +
 	"RemoteAddr\x18\xdb\x0f \x01(\x0e2#.flowpb.EnrichedFlow.RemoteAddrTypeR\n" +
@@ -1786,7 +2432,8 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\n" +
 	"DstIfSpeed\x18\xd8\x0f \x01(\rR\n" +
 	"DstIfSpeed\x12\x13\n" +
-	"\x04Note\x18\xe0\x0f \x01(\tR\x04Note\x12\x1b\n" +
+	"\x04Note\x18\xe0\x0f \x01(\tR\x04Note\x129\n" +
+	"\x06Labels\x18\xe0\x12 \x03(\v2 .flowpb.EnrichedFlow.LabelsEntryR\x06Labels\x12\x1b\n" +
 	"\bSourceIP\x18\xf2\x11 \x01(\tR\bSourceIP\x12%\n" +
 	"\rDestinationIP\x18\xf3\x11 \x01(\tR\rDestinationIP\x12\x1d\n" +
 	"\tNextHopIP\x18\xf4\x11 \x01(\tR\tNextHopIP\x12\x1d\n" +
@@ -1797,7 +2444,10 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\rEgressVrfIDBW\x18\xec\x13 \x01(\rR\rEgressVrfIDBW\x12%\n" +
 	"\rTimeFlowStart\x18\xea\x13 \x01(\x04R\rTimeFlowStart\x12\x1f\n" +
 	"\vsrc_as_path\x18\xd7\x17 \x03(\rR\tsrcAsPath\x12\x1f\n" +
-	"\vdst_as_path\x18\xd8\x17 \x03(\rR\tdstAsPath\"]\n" +
+	"\vdst_as_path\x18\xd8\x17 \x03(\rR\tdstAsPath\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"]\n" +
 	"\bFlowType\x12\x0f\n" +
 	"\vFLOWUNKNOWN\x10\x00\x12\v\n" +
 	"\aSFLOW_5\x10\x01\x12\x0e\n" +
@@ -1828,7 +2478,34 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\n" +
 	"\x06Teredo\x10\r\x12\n" +
 	"\n" +
-	"\x06Custom\x10c\"V\n" +
+	"\x06Custom\x10c\"g\n" +
+	"\x0eAlertEventType\x12\x10\n" +
+	"\fNoAlertEvent\x10\x00\x12\r\n" +
+	"\tAlertOpen\x10\x01\x12\x0f\n" +
+	"\vAlertRepeat\x10\x02\x12\x11\n" +
+	"\rAlertEscalate\x10\x03\x12\x10\n" +
+	"\fAlertResolve\x10\x04\"H\n" +
+	"\x10AnomalyEventType\x12\x12\n" +
+	"\x0eNoAnomalyEvent\x10\x00\x12\x10\n" +
+	"\fAnomalyStart\x10\x01\x12\x0e\n" +
+	"\n" +
+	"AnomalyEnd\x10\x02\"R\n" +
+	"\rDdosEventType\x12\x0f\n" +
+	"\vNoDdosEvent\x10\x00\x12\x0f\n" +
+	"\vAttackStart\x10\x01\x12\x10\n" +
+	"\fAttackUpdate\x10\x02\x12\r\n" +
+	"\tAttackEnd\x10\x03\"<\n" +
+	"\bScanType\x12\n" +
+	"\n" +
+	"\x06NoScan\x10\x00\x12\x12\n" +
+	"\x0eHorizontalScan\x10\x01\x12\x10\n" +
+	"\fVerticalScan\x10\x02\"R\n" +
+	"\x13BiflowInitiatorType\x12\x0f\n" +
+	"\vNoInitiator\x10\x00\x12\n" +
+	"\n" +
+	"\x06TcpSyn\x10\x01\x12\r\n" +
+	"\tFirstSeen\x10\x02\x12\x0f\n" +
+	"\vServicePort\x10\x03\"V\n" +
 	"\x0eAnonymizedType\x12\x11\n" +
 	"\rNotAnonymized\x10\x00\x12\r\n" +
 	"\tCryptoPAN\x10\x01\x12\n" +
@@ -1860,32 +2537,44 @@ func file_pb_enrichedflow_proto_rawDescGZIP() []byte {
 	return file_pb_enrichedflow_proto_rawDescData
 }
 
-var file_pb_enrichedflow_proto_enumTypes = make([]protoimpl.EnumInfo, 6)
-var file_pb_enrichedflow_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pb_enrichedflow_proto_enumTypes = make([]protoimpl.EnumInfo, 11)
+var file_pb_enrichedflow_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_pb_enrichedflow_proto_goTypes = []any{
 	(EnrichedFlow_FlowType)(0),             // 0: flowpb.EnrichedFlow.FlowType
 	(EnrichedFlow_LayerStack)(0),           // 1: flowpb.EnrichedFlow.LayerStack
-	(EnrichedFlow_AnonymizedType)(0),       // 2: flowpb.EnrichedFlow.AnonymizedType
-	(EnrichedFlow_ValidationStatusType)(0), // 3: flowpb.EnrichedFlow.ValidationStatusType
-	(EnrichedFlow_NormalizedType)(0),       // 4: flowpb.EnrichedFlow.NormalizedType
-	(EnrichedFlow_RemoteAddrType)(0),       // 5: flowpb.EnrichedFlow.RemoteAddrType
-	(*EnrichedFlow)(nil),                   // 6: flowpb.EnrichedFlow
+	(EnrichedFlow_AlertEventType)(0),       // 2: flowpb.EnrichedFlow.AlertEventType
+	(EnrichedFlow_AnomalyEventType)(0),     // 3: flowpb.EnrichedFlow.AnomalyEventType
+	(EnrichedFlow_DdosEventType)(0),        // 4: flowpb.EnrichedFlow.DdosEventType
+	(EnrichedFlow_ScanType)(0),             // 5: flowpb.EnrichedFlow.ScanType
+	(EnrichedFlow_BiflowInitiatorType)(0),  // 6: flowpb.EnrichedFlow.BiflowInitiatorType
+	(EnrichedFlow_AnonymizedType)(0),       // 7: flowpb.EnrichedFlow.AnonymizedType
+	(EnrichedFlow_ValidationStatusType)(0), // 8: flowpb.EnrichedFlow.ValidationStatusType
+	(EnrichedFlow_NormalizedType)(0),       // 9: flowpb.EnrichedFlow.NormalizedType
+	(EnrichedFlow_RemoteAddrType)(0),       // 10: flowpb.EnrichedFlow.RemoteAddrType
+	(*EnrichedFlow)(nil),                   // 11: flowpb.EnrichedFlow
+	nil,                                    // 12: flowpb.EnrichedFlow.LabelsEntry
 }
 var file_pb_enrichedflow_proto_depIdxs = []int32{
-	0, // 0: flowpb.EnrichedFlow.type:type_name -> flowpb.EnrichedFlow.FlowType
-	1, // 1: flowpb.EnrichedFlow.layer_stack:type_name -> flowpb.EnrichedFlow.LayerStack
-	2, // 2: flowpb.EnrichedFlow.SrcAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
-	2, // 3: flowpb.EnrichedFlow.DstAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
-	2, // 4: flowpb.EnrichedFlow.SamplerAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
-	2, // 5: flowpb.EnrichedFlow.NextHopAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
-	3, // 6: flowpb.EnrichedFlow.ValidationStatus:type_name -> flowpb.EnrichedFlow.ValidationStatusType
-	4, // 7: flowpb.EnrichedFlow.Normalized:type_name -> flowpb.EnrichedFlow.NormalizedType
-	5, // 8: flowpb.EnrichedFlow.RemoteAddr:type_name -> flowpb.EnrichedFlow.RemoteAddrType
-	9, // [9:9] is the sub-list for method output_type
-	9, // [9:9] is the sub-list for method input_type
-	9, // [9:9] is the sub-list for extension type_name
-	9, // [9:9] is the sub-list for extension extendee
-	0, // [0:9] is the sub-list for field type_name
+	0,  // 0: flowpb.EnrichedFlow.type:type_name -> flowpb.EnrichedFlow.FlowType
+	1,  // 1: flowpb.EnrichedFlow.layer_stack:type_name -> flowpb.EnrichedFlow.LayerStack
+	2,  // 2: flowpb.EnrichedFlow.AlertEvent:type_name -> flowpb.EnrichedFlow.AlertEventType
+	3,  // 3: flowpb.EnrichedFlow.AnomalyEvent:type_name -> flowpb.EnrichedFlow.AnomalyEventType
+	4,  // 4: flowpb.EnrichedFlow.DdosEvent:type_name -> flowpb.EnrichedFlow.DdosEventType
+	5,  // 5: flowpb.EnrichedFlow.Scan:type_name -> flowpb.EnrichedFlow.ScanType
+	6,  // 6: flowpb.EnrichedFlow.BiflowInitiator:type_name -> flowpb.EnrichedFlow.BiflowInitiatorType
+	7,  // 7: flowpb.EnrichedFlow.SrcAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
+	7,  // 8: flowpb.EnrichedFlow.DstAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
+	7,  // 9: flowpb.EnrichedFlow.SamplerAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
+	7,  // 10: flowpb.EnrichedFlow.NextHopAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
+	8,  // 11: flowpb.EnrichedFlow.ValidationStatus:type_name -> flowpb.EnrichedFlow.ValidationStatusType
+	9,  // 12: flowpb.EnrichedFlow.Normalized:type_name -> flowpb.EnrichedFlow.NormalizedType
+	10, // 13: flowpb.EnrichedFlow.RemoteAddr:type_name -> flowpb.EnrichedFlow.RemoteAddrType
+	12, // 14: flowpb.EnrichedFlow.Labels:type_name -> flowpb.EnrichedFlow.LabelsEntry
+	15, // [15:15] is the sub-list for method output_type
+	15, // [15:15] is the sub-list for method input_type
+	15, // [15:15] is the sub-list for extension type_name
+	15, // [15:15] is the sub-list for extension extendee
+	0,  // [0:15] is the sub-list for field type_name
 }
 
 func init() { file_pb_enrichedflow_proto_init() }
@@ -1898,8 +2587,8 @@ func file_pb_enrichedflow_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pb_enrichedflow_proto_rawDesc), len(file_pb_enrichedflow_proto_rawDesc)),
-			NumEnums:      6,
-			NumMessages:   1,
+			NumEnums:      11,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
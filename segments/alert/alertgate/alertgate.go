@@ -0,0 +1,374 @@
+// The `alertgate` segment turns matching flows into alert notifications and is
+// placed in front of alert outputs such as `http`, which would otherwise notify
+// once per flow. Flows are grouped into alerts by the fields given in `key`. For
+// a list of fields, check our protobuf definition. Single entries of the Labels
+// field can be used as `labels.<key>`.
+//
+// The first flow of a key opens an alert, which is notified immediately.
+// Further flows of this key are counted, but their notifications are suppressed
+// for the duration of `window`, after which a repeat is notified if flows have
+// been seen in the meantime. Once per `interval`, the number of flows and the
+// bit rate of each alert in this interval are compared to those at its last
+// escalation, or at its first full interval. If either has grown by the factor
+// `escalation`, the alert's level is increased and an escalation is notified
+// regardless of the suppression window. Alerts without any flows for the
+// duration of `resolve` are closed, notifying their resolution. At most
+// `maxalerts` alerts are open at once, flows of further keys being skipped
+// until alerts are resolved. Open alerts are not resolved on shutdown.
+//
+// Notifications are copies of the flow which opened the alert, with the Alert
+// fields set to the current state of the alert. Only notifications are passed
+// on, all other flows are available to the `else` branch when using this
+// segment as a condition of the `branch` segment. Open alerts are listed as
+// JSON by an http endpoint, using the `endpoint` and `alertspath` parameters.
+package alertgate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Alertgate struct {
+	segments.BaseFilterSegment
+	Key        []string      // required, fields by which flows are grouped into alerts
+	Window     time.Duration // optional, default is 15m, duration in which repeated notifications are suppressed
+	Interval   time.Duration // optional, default is 1m, interval in which escalations are checked
+	Escalation float64       // optional, default is 2, growth factor of flows or bit rate constituting an escalation
+	Resolve    time.Duration // optional, default is 5m, duration without flows after which an alert is resolved
+	MaxAlerts  int           // optional, default is 10000, maximum number of open alerts
+	Endpoint   string        // optional, default is ":8080"
+	AlertsPath string        // optional, default is "/alerts"
+
+	mutex  sync.Mutex // guards all fields below, which are read by http handlers
+	alerts map[string]*alert
+	lastId uint64
+	full   bool // whether flows of new keys are being skipped
+}
+
+// An open alert. Exported fields are listed by the http endpoint.
+type alert struct {
+	Id           uint64    `json:"id"`
+	Key          string    `json:"key"`
+	Level        uint32    `json:"level"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	LastNotified time.Time `json:"last_notified"`
+	Flows        uint64    `json:"flows"`
+	Bytes        uint64    `json:"bytes"`
+	Packets      uint64    `json:"packets"`
+	Bps          uint64    `json:"bps"`        // of the last interval
+	Suppressed   uint64    `json:"suppressed"` // flows since the last notification
+
+	flow          *pb.EnrichedFlow // copy of the flow which opened the alert
+	intervalFlows uint64
+	intervalBytes uint64
+	baseFlows     uint64 // flows per interval at the last escalation
+	baseBps       uint64 // bit rate at the last escalation
+}
+
+func (segment *Alertgate) New(config map[string]string) segments.Segment {
+	if config["key"] == "" {
+		log.Error().Msg("Alertgate: The 'key' parameter is required.")
+		return nil
+	}
+	var key []string
+	reflected := reflect.ValueOf(&pb.EnrichedFlow{}).Elem()
+	for _, field := range strings.Split(config["key"], ",") {
+		field = strings.TrimSpace(field)
+		key = append(key, field)
+		if _, ok := pb.LabelKey(field); ok {
+			continue
+		}
+		if value := reflected.FieldByName(field); !value.IsValid() || !value.CanSet() {
+			log.Error().Msgf("Alertgate: Field '%s' in 'key' parameter is not valid.", field)
+			return nil
+		}
+	}
+
+	var window = 15 * time.Minute
+	if config["window"] != "" {
+		if parsedWindow, err := time.ParseDuration(config["window"]); err == nil && parsedWindow >= 0 {
+			window = parsedWindow
+		} else {
+			log.Error().Msg("Alertgate: Could not parse 'window' parameter, using default 15m.")
+		}
+	} else {
+		log.Info().Msg("Alertgate: 'window' set to default 15m.")
+	}
+
+	var interval = time.Minute
+	if config["interval"] != "" {
+		if parsedInterval, err := time.ParseDuration(config["interval"]); err == nil && parsedInterval > 0 {
+			interval = parsedInterval
+		} else {
+			log.Error().Msg("Alertgate: Could not parse 'interval' parameter, using default 1m.")
+		}
+	} else {
+		log.Info().Msg("Alertgate: 'interval' set to default 1m.")
+	}
+
+	var escalation = 2.0
+	if config["escalation"] != "" {
+		if parsedEscalation, err := strconv.ParseFloat(config["escalation"], 64); err == nil && parsedEscalation > 1 {
+			escalation = parsedEscalation
+		} else {
+			log.Error().Msg("Alertgate: Could not parse 'escalation' parameter, using default 2.")
+		}
+	} else {
+		log.Info().Msg("Alertgate: 'escalation' set to default 2.")
+	}
+
+	var resolve = 5 * time.Minute
+	if config["resolve"] != "" {
+		if parsedResolve, err := time.ParseDuration(config["resolve"]); err == nil && parsedResolve > 0 {
+			resolve = parsedResolve
+		} else {
+			log.Error().Msg("Alertgate: Could not parse 'resolve' parameter, using default 5m.")
+		}
+	} else {
+		log.Info().Msg("Alertgate: 'resolve' set to default 5m.")
+	}
+
+	var maxAlerts = 10000
+	if config["maxalerts"] != "" {
+		if parsedMaxAlerts, err := strconv.Atoi(config["maxalerts"]); err == nil && parsedMaxAlerts > 0 {
+			maxAlerts = parsedMaxAlerts
+		} else {
+			log.Error().Msg("Alertgate: Could not parse 'maxalerts' parameter, using default 10000.")
+		}
+	} else {
+		log.Info().Msg("Alertgate: 'maxalerts' set to default 10000.")
+	}
+
+	newsegment := &Alertgate{
+		Key:        key,
+		Window:     window,
+		Interval:   interval,
+		Escalation: escalation,
+		Resolve:    resolve,
+		MaxAlerts:  maxAlerts,
+		Endpoint:   ":8080",
+		AlertsPath: "/alerts",
+	}
+	if config["endpoint"] != "" {
+		newsegment.Endpoint = config["endpoint"]
+	} else {
+		log.Info().Msg("Alertgate: Missing configuration parameter 'endpoint'. Using default port ':8080'")
+	}
+	if config["alertspath"] != "" {
+		newsegment.AlertsPath = config["alertspath"]
+	} else {
+		log.Info().Msg("Alertgate: Missing configuration parameter 'alertspath'. Using default path '/alerts'")
+	}
+	return newsegment
+}
+
+func (segment *Alertgate) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup()
+	segment.serve()
+
+	ticker := time.NewTicker(segment.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			if notification := segment.account(msg, time.Now()); notification != nil {
+				segment.Out <- notification
+			}
+			if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+		case now := <-ticker.C:
+			for _, notification := range segment.evaluate(now) {
+				segment.Out <- notification
+			}
+		}
+	}
+}
+
+func (segment *Alertgate) setup() {
+	segment.alerts = make(map[string]*alert)
+}
+
+func (segment *Alertgate) serve() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(segment.AlertsPath, segment.handleAlerts)
+	go func() {
+		err := http.ListenAndServe(segment.Endpoint, mux)
+		if err != nil {
+			log.Error().Err(err).Msgf("Alertgate: Failed to start http endpoint on %s", segment.Endpoint)
+		}
+	}()
+	log.Info().Msgf("Alertgate: Enabled alerts on %s, listening at %s.", segment.AlertsPath, segment.Endpoint)
+}
+
+// Returns the values of all key fields in a readable format.
+func (segment *Alertgate) key(msg *pb.EnrichedFlow) string {
+	var key strings.Builder
+	reflected := reflect.ValueOf(msg).Elem()
+	for i, field := range segment.Key {
+		if i > 0 {
+			key.WriteByte(',')
+		}
+		key.WriteString(field)
+		key.WriteByte('=')
+		if labelKey, ok := pb.LabelKey(field); ok {
+			key.WriteString(msg.GetLabel(labelKey))
+		} else if value := reflected.FieldByName(field); value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8 {
+			if addr, ok := netip.AddrFromSlice(value.Bytes()); ok {
+				key.WriteString(addr.Unmap().String())
+			} else {
+				fmt.Fprintf(&key, "%x", value.Bytes())
+			}
+		} else {
+			fmt.Fprint(&key, value.Interface())
+		}
+	}
+	return key.String()
+}
+
+// Accounts a flow to its alert, opening the alert if necessary. Returns the
+// notification of a newly opened alert.
+func (segment *Alertgate) account(msg *pb.EnrichedFlow, now time.Time) *pb.EnrichedFlow {
+	key := segment.key(msg)
+	bytes, packets := msg.ScaledCounters()
+
+	segment.mutex.Lock()
+	defer segment.mutex.Unlock()
+	a, ok := segment.alerts[key]
+	if !ok {
+		if len(segment.alerts) >= segment.MaxAlerts {
+			if !segment.full {
+				log.Warn().Msgf("Alertgate: Reached %d open alerts, skipping flows of new keys.", segment.MaxAlerts)
+				segment.full = true
+			}
+			return nil
+		}
+		segment.lastId += 1
+		a = &alert{
+			Id:        segment.lastId,
+			Key:       key,
+			Level:     1,
+			FirstSeen: now,
+			flow:      proto.Clone(msg).(*pb.EnrichedFlow),
+		}
+		segment.alerts[key] = a
+	}
+	a.LastSeen = now
+	a.Flows += 1
+	a.Bytes += bytes
+	a.Packets += packets
+	a.intervalFlows += 1
+	a.intervalBytes += bytes
+	if !ok {
+		return segment.notify(a, pb.EnrichedFlow_AlertOpen, now)
+	}
+	a.Suppressed += 1
+	return nil
+}
+
+// Checks all alerts for escalations, repeats and resolutions at the end of an
+// interval. Returns the resulting notifications.
+func (segment *Alertgate) evaluate(now time.Time) []*pb.EnrichedFlow {
+	segment.mutex.Lock()
+	defer segment.mutex.Unlock()
+
+	var notifications []*pb.EnrichedFlow
+	open := make([]*alert, 0, len(segment.alerts))
+	for _, a := range segment.alerts {
+		open = append(open, a)
+	}
+	// notify in a deterministic order
+	sort.Slice(open, func(i, j int) bool { return open[i].Id < open[j].Id })
+	for _, a := range open {
+		if now.Sub(a.LastSeen) >= segment.Resolve {
+			a.Bps = 0
+			notifications = append(notifications, segment.notify(a, pb.EnrichedFlow_AlertResolve, now))
+			delete(segment.alerts, a.Key)
+			continue
+		}
+
+		// the first interval of an alert is partial, hence not used as baseline
+		elapsed := min(now.Sub(a.FirstSeen), segment.Interval)
+		partial := elapsed < segment.Interval
+		if elapsed > 0 {
+			a.Bps = uint64(float64(a.intervalBytes*8) / elapsed.Seconds())
+		}
+		if !partial && a.baseFlows == 0 {
+			a.baseFlows, a.baseBps = a.intervalFlows, a.Bps
+		} else if !partial && (float64(a.intervalFlows) >= segment.Escalation*float64(a.baseFlows) ||
+			(a.baseBps > 0 && float64(a.Bps) >= segment.Escalation*float64(a.baseBps))) {
+			a.Level += 1
+			a.baseFlows, a.baseBps = a.intervalFlows, a.Bps
+			notifications = append(notifications, segment.notify(a, pb.EnrichedFlow_AlertEscalate, now))
+		} else if a.Suppressed > 0 && now.Sub(a.LastNotified) >= segment.Window {
+			notifications = append(notifications, segment.notify(a, pb.EnrichedFlow_AlertRepeat, now))
+		}
+		a.intervalFlows, a.intervalBytes = 0, 0
+	}
+	if len(segment.alerts) < segment.MaxAlerts {
+		segment.full = false
+	}
+	return notifications
+}
+
+// Returns a notification with the alert's current state. The caller is
+// required to hold the mutex.
+func (segment *Alertgate) notify(a *alert, event pb.EnrichedFlow_AlertEventType, now time.Time) *pb.EnrichedFlow {
+	msg := proto.Clone(a.flow).(*pb.EnrichedFlow)
+	msg.AlertEvent = event
+	msg.AlertId = a.Id
+	msg.AlertKey = a.Key
+	msg.AlertLevel = a.Level
+	msg.AlertFlows = a.Flows
+	msg.AlertBytes = a.Bytes
+	msg.AlertPackets = a.Packets
+	msg.AlertBps = a.Bps
+	msg.AlertSuppressed = a.Suppressed
+	msg.AlertFirstSeenNs = uint64(a.FirstSeen.UnixNano())
+	msg.AlertLastSeenNs = uint64(a.LastSeen.UnixNano())
+	a.LastNotified = now
+	a.Suppressed = 0
+	return msg
+}
+
+func (segment *Alertgate) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	segment.mutex.Lock()
+	alerts := make([]alert, 0, len(segment.alerts))
+	for _, a := range segment.alerts {
+		alerts = append(alerts, *a)
+	}
+	segment.mutex.Unlock()
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Id < alerts[j].Id })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(alerts); err != nil {
+		log.Warn().Err(err).Msg("Alertgate: Failed to write alerts.")
+	}
+}
+
+func init() {
+	segment := &Alertgate{}
+	segments.RegisterSegment("alertgate", segment)
+}
@@ -0,0 +1,146 @@
+package alertgate
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Alertgate Segment test, suppression and repeats
+func TestSegment_Alertgate_suppress(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Alertgate]("alertgate", map[string]string{"key": "DstAddr,labels.rule", "window": "10m", "interval": "1m", "resolve": "30m"})
+	segment.setup()
+	flow := &pb.EnrichedFlow{DstAddr: []byte{192, 0, 2, 1}, Bytes: 100, Labels: map[string]string{"rule": "ssh"}}
+	now := time.Now()
+
+	open := segment.account(flow, now)
+	if open == nil || open.AlertEvent != pb.EnrichedFlow_AlertOpen || open.AlertKey != "DstAddr=192.0.2.1,labels.rule=ssh" || open.AlertLevel != 1 {
+		t.Fatalf("([error] Segment Alertgate is not opening alerts correctly: %v", open)
+	}
+	for i := 0; i < 5; i++ {
+		if segment.account(flow, now) != nil {
+			t.Fatal("([error] Segment Alertgate is not suppressing repeated flows.")
+		}
+	}
+	if notifications := segment.evaluate(now.Add(time.Minute)); len(notifications) != 0 {
+		t.Fatalf("([error] Segment Alertgate is notifying within the window: %v", notifications)
+	}
+
+	segment.account(flow, now.Add(5*time.Minute))
+	notifications := segment.evaluate(now.Add(10 * time.Minute))
+	if len(notifications) != 1 || notifications[0].AlertEvent != pb.EnrichedFlow_AlertRepeat {
+		t.Fatalf("([error] Segment Alertgate is not repeating after the window: %v", notifications)
+	}
+	if repeat := notifications[0]; repeat.AlertFlows != 7 || repeat.AlertBytes != 700 || repeat.AlertSuppressed != 6 || repeat.AlertId != open.AlertId {
+		t.Errorf("([error] Segment Alertgate is not counting flows correctly: %v", repeat)
+	}
+	if segment.account(&pb.EnrichedFlow{DstAddr: []byte{192, 0, 2, 2}}, now) == nil {
+		t.Error("([error] Segment Alertgate is not opening alerts for other keys.")
+	}
+}
+
+// Alertgate Segment test, escalation and resolution
+func TestSegment_Alertgate_escalate(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Alertgate]("alertgate", map[string]string{"key": "DstAddr", "interval": "1m", "resolve": "5m"})
+	segment.setup()
+	now := time.Now()
+	flow := &pb.EnrichedFlow{DstAddr: []byte{192, 0, 2, 1}, Bytes: 100, Packets: 1, SamplingRate: 10}
+
+	segment.account(flow, now)
+	segment.account(flow, now)
+	if notifications := segment.evaluate(now.Add(time.Minute)); len(notifications) != 0 {
+		t.Fatalf("([error] Segment Alertgate is escalating the first interval: %v", notifications)
+	}
+	for i := 0; i < 4; i++ {
+		segment.account(flow, now.Add(time.Minute))
+	}
+	notifications := segment.evaluate(now.Add(2 * time.Minute))
+	if len(notifications) != 1 || notifications[0].AlertEvent != pb.EnrichedFlow_AlertEscalate || notifications[0].AlertLevel != 2 {
+		t.Fatalf("([error] Segment Alertgate is not escalating growing alerts: %v", notifications)
+	}
+	if escalation := notifications[0]; escalation.AlertBps != 4*1000*8/60 || escalation.AlertBytes != 6000 || escalation.AlertPackets != 60 {
+		t.Errorf("([error] Segment Alertgate is not scaling sampled flows: %v", escalation)
+	}
+
+	notifications = segment.evaluate(now.Add(6 * time.Minute))
+	if len(notifications) != 1 || notifications[0].AlertEvent != pb.EnrichedFlow_AlertResolve || notifications[0].AlertLevel != 2 {
+		t.Fatalf("([error] Segment Alertgate is not resolving alerts: %v", notifications)
+	}
+	if len(segment.alerts) != 0 {
+		t.Error("([error] Segment Alertgate is keeping resolved alerts.")
+	}
+	if open := segment.account(flow, now.Add(7*time.Minute)); open == nil || open.AlertId == notifications[0].AlertId {
+		t.Error("([error] Segment Alertgate is not reopening resolved alerts.")
+	}
+}
+
+// Alertgate Segment test, alert opened in the middle of an interval
+func TestSegment_Alertgate_partialInterval(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Alertgate]("alertgate", map[string]string{"key": "DstAddr", "interval": "1m"})
+	segment.setup()
+	flow := &pb.EnrichedFlow{DstAddr: []byte{192, 0, 2, 1}, Bytes: 100}
+	opened := time.Now()
+	tick := opened.Add(10 * time.Second)
+
+	// one flow every 10 seconds, the alert opening 10 seconds before a tick
+	segment.account(flow, opened)
+	for i := 0; i < 3; i++ {
+		if notifications := segment.evaluate(tick); len(notifications) != 0 {
+			t.Fatalf("([error] Segment Alertgate is escalating constant traffic after a partial interval: %v", notifications)
+		}
+		for j := 0; j < 6; j++ {
+			segment.account(flow, tick.Add(time.Duration(j)*10*time.Second))
+		}
+		tick = tick.Add(time.Minute)
+	}
+	if bps := segment.alerts["DstAddr=192.0.2.1"].Bps; bps != 80 {
+		t.Errorf("([error] Segment Alertgate is not computing the bit rate correctly: %d", bps)
+	}
+}
+
+// Alertgate Segment test, maximum number of open alerts
+func TestSegment_Alertgate_maxalerts(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Alertgate]("alertgate", map[string]string{"key": "Cid", "maxalerts": "2"})
+	segment.setup()
+	now := time.Now()
+	for cid := uint32(1); cid <= 3; cid++ {
+		open := segment.account(&pb.EnrichedFlow{Cid: cid}, now)
+		if (open != nil) != (cid <= 2) {
+			t.Errorf("([error] Segment Alertgate is not limiting open alerts at cid %d.", cid)
+		}
+	}
+}
+
+// Alertgate Segment test, listing open alerts
+func TestSegment_Alertgate_endpoint(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Alertgate]("alertgate", map[string]string{"key": "Cid"})
+	segment.setup()
+	now := time.Now()
+	segment.account(&pb.EnrichedFlow{Cid: 2, Bytes: 10}, now)
+	segment.account(&pb.EnrichedFlow{Cid: 1, Bytes: 10}, now)
+	segment.account(&pb.EnrichedFlow{Cid: 1, Bytes: 10}, now)
+
+	recorder := httptest.NewRecorder()
+	segment.handleAlerts(recorder, httptest.NewRequest("GET", "/alerts", nil))
+	var alerts []alert
+	if err := json.NewDecoder(recorder.Body).Decode(&alerts); err != nil {
+		t.Fatalf("([error] Segment Alertgate is not listing alerts as JSON: %v", err)
+	}
+	if len(alerts) != 2 || alerts[0].Key != "Cid=2" || alerts[1].Flows != 2 || alerts[1].Suppressed != 1 {
+		t.Errorf("([error] Segment Alertgate is not listing open alerts correctly: %v", alerts)
+	}
+}
+
+// Alertgate Segment test, invalid key fields
+func TestSegment_Alertgate_invalidKey(t *testing.T) {
+	if (&Alertgate{}).New(map[string]string{"key": "Cid,NoSuchField"}) != nil {
+		t.Error("([error] Segment Alertgate is accepting invalid key fields.")
+	}
+	if (&Alertgate{}).New(map[string]string{}) != nil {
+		t.Error("([error] Segment Alertgate is accepting a missing key.")
+	}
+}
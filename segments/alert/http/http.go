@@ -1,33 +1,105 @@
-// The `http` segment is currently a work in progress and is limited to sending
-// post requests with the full flow data to a single endpoint at the moment. The
-// roadmap includes various features such as different methods, builtin
-// conditional, limiting payload data, and multiple receivers.
+// The `http` segment sends flows to a webhook, for instance to alert on flows
+// selected by preceding filter segments. Flows are passed on immediately,
+// while requests are sent asynchronously: Flows are put into a queue holding
+// up to `queuesize` flows, and flows arriving at a full queue are skipped for
+// sending. A number of `workers` send requests concurrently.
+//
+// Each request contains up to `batchsize` flows, a batch being sent once it is
+// full or `batchtimeout` after its first flow. Failed requests are retried up
+// to `retries` times, waiting `backoff` before the first retry and doubling
+// this duration for each further one. Requests are considered failed on
+// connection errors, timeouts as set by `timeout`, and the status codes 429 and
+// 5xx. On shutdown, queued flows are sent for at most `shutdowntimeout`, after
+// which pending requests are canceled and the remaining flows are dropped.
+//
+// Requests are sent to `url` using `method`, which is one of POST, PUT and
+// PATCH. Additional headers are set using parameters of the form
+// `header.<name>`, and authentication is added using either `username` and
+// `password` for basic authentication or `token` for bearer authentication.
+//
+// By default, the body is the JSON representation of the flow, or a JSON array
+// of flows if `batchsize` is larger than one. A custom body can be given as a
+// Go template using `template` or `templatefile`, with the `contenttype`
+// parameter setting the content type accordingly. Templates are executed with
+// `.Flows` being the batch and `.Flow` being its first flow, and can use the
+// function `json` to encode flows or any other value as JSON.
 package http
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
-	"google.golang.org/protobuf/encoding/protojson"
 )
 
 type Http struct {
 	segments.BaseSegment
-	Url string
-	// TODO: add async parameter
-	// TODO: add timeout options
-	// TODO: add more HTTP methods
-	// TODO: add ability to include custom message
-	// TODO: add conditional http send?
-	// TODO: add ability to limit data sent?
+	Url             string             // required, http or https url requests are sent to
+	Method          string             // optional, default is "POST", one of "POST", "PUT" and "PATCH"
+	Headers         map[string]string  // optional, default is none, set using 'header.<name>' parameters
+	Username        string             // optional, default is "", user for basic authentication
+	Password        string             // optional, default is "", password for basic authentication
+	Token           string             // optional, default is "", token for bearer authentication
+	Template        *template.Template // optional, default is none, template of the body
+	ContentType     string             // optional, default is "application/json"
+	QueueSize       int                // optional, default is 1000, number of flows queued for sending
+	Workers         int                // optional, default is 4, number of concurrent requests
+	BatchSize       int                // optional, default is 1, maximum number of flows per request
+	BatchTimeout    time.Duration      // optional, default is 1s, time after which incomplete batches are sent
+	Timeout         time.Duration      // optional, default is 10s, timeout of a request
+	Retries         int                // optional, default is 3, number of retries of failed requests
+	Backoff         time.Duration      // optional, default is 1s, wait before the first retry, doubled for each further one
+	ShutdownTimeout time.Duration      // optional, default is 10s, time for sending queued flows on shutdown
+
+	client   *http.Client
+	dropped  atomic.Uint64 // flows dropped on shutdown
+	failing  atomic.Bool   // whether the last request failed, for limiting logs
+	skipping atomic.Bool   // whether the last flow was skipped, for limiting logs
+}
+
+// Parameters of the form `header.<name>` set headers.
+const headerPrefix = "header."
+
+var methods = []string{"POST", "PUT", "PATCH"}
+
+// The data templates are executed with.
+type templateData struct {
+	Flows []*pb.EnrichedFlow
+	Flow  *pb.EnrichedFlow
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		var data []byte
+		var err error
+		if msg, ok := value.(proto.Message); ok {
+			data, err = protojson.Marshal(msg)
+		} else {
+			data, err = json.Marshal(value)
+		}
+		return string(data), err
+	},
 }
 
-func (segment Http) New(config map[string]string) segments.Segment {
+func (segment *Http) New(config map[string]string) segments.Segment {
 	requestUrl, err := url.Parse(config["url"])
 	if err != nil {
 		log.Error().Err(err).Msgf("Http: error parsing url parameter")
@@ -37,37 +109,334 @@ func (segment Http) New(config map[string]string) segments.Segment {
 		log.Error().Msgf("Http: error parsing url parameter, scheme must be 'http://' or 'https://'")
 		return nil
 	}
-	return &Http{Url: config["url"]}
+	newsegment := &Http{
+		Url:             config["url"],
+		Method:          "POST",
+		Headers:         make(map[string]string),
+		Username:        config["username"],
+		Password:        config["password"],
+		Token:           config["token"],
+		ContentType:     "application/json",
+		QueueSize:       1000,
+		Workers:         4,
+		BatchSize:       1,
+		BatchTimeout:    time.Second,
+		Timeout:         10 * time.Second,
+		Retries:         3,
+		Backoff:         time.Second,
+		ShutdownTimeout: 10 * time.Second,
+	}
+
+	if config["method"] != "" {
+		method := strings.ToUpper(config["method"])
+		if !slices.Contains(methods, method) {
+			log.Error().Msgf("Http: Unknown method '%s', options are %s.", config["method"], strings.Join(methods, ", "))
+			return nil
+		}
+		newsegment.Method = method
+	} else {
+		log.Info().Msg("Http: 'method' set to default 'POST'.")
+	}
+
+	for key, value := range config {
+		if name, ok := strings.CutPrefix(key, headerPrefix); ok && name != "" {
+			newsegment.Headers[name] = value
+		}
+	}
+	if newsegment.Token != "" && (newsegment.Username != "" || newsegment.Password != "") {
+		log.Error().Msg("Http: Basic authentication using 'username' and 'password' and bearer authentication using 'token' are mutually exclusive.")
+		return nil
+	}
+
+	if config["template"] != "" && config["templatefile"] != "" {
+		log.Error().Msg("Http: The 'template' and 'templatefile' parameters are mutually exclusive.")
+		return nil
+	}
+	text := config["template"]
+	if config["templatefile"] != "" {
+		data, err := os.ReadFile(segments.ContainerVolumePrefix + config["templatefile"])
+		if err != nil {
+			log.Error().Err(err).Msg("Http: File specified in 'templatefile' is not accessible.")
+			return nil
+		}
+		text = string(data)
+	}
+	if text != "" {
+		newsegment.Template, err = template.New("body").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			log.Error().Err(err).Msg("Http: Could not parse template.")
+			return nil
+		}
+	}
+	if config["contenttype"] != "" {
+		newsegment.ContentType = config["contenttype"]
+	}
+
+	if config["queuesize"] != "" {
+		if parsedQueueSize, err := strconv.Atoi(config["queuesize"]); err == nil && parsedQueueSize > 0 {
+			newsegment.QueueSize = parsedQueueSize
+		} else {
+			log.Error().Msg("Http: Could not parse 'queuesize' parameter, using default 1000.")
+		}
+	} else {
+		log.Info().Msg("Http: 'queuesize' set to default 1000.")
+	}
+
+	if config["workers"] != "" {
+		if parsedWorkers, err := strconv.Atoi(config["workers"]); err == nil && parsedWorkers > 0 {
+			newsegment.Workers = parsedWorkers
+		} else {
+			log.Error().Msg("Http: Could not parse 'workers' parameter, using default 4.")
+		}
+	} else {
+		log.Info().Msg("Http: 'workers' set to default 4.")
+	}
+
+	if config["batchsize"] != "" {
+		if parsedBatchSize, err := strconv.Atoi(config["batchsize"]); err == nil && parsedBatchSize > 0 {
+			newsegment.BatchSize = parsedBatchSize
+		} else {
+			log.Error().Msg("Http: Could not parse 'batchsize' parameter, using default 1.")
+		}
+	} else {
+		log.Info().Msg("Http: 'batchsize' set to default 1.")
+	}
+
+	if config["batchtimeout"] != "" {
+		if parsedBatchTimeout, err := time.ParseDuration(config["batchtimeout"]); err == nil && parsedBatchTimeout > 0 {
+			newsegment.BatchTimeout = parsedBatchTimeout
+		} else {
+			log.Error().Msg("Http: Could not parse 'batchtimeout' parameter, using default 1s.")
+		}
+	} else if newsegment.BatchSize > 1 {
+		log.Info().Msg("Http: 'batchtimeout' set to default 1s.")
+	}
+
+	if config["timeout"] != "" {
+		if parsedTimeout, err := time.ParseDuration(config["timeout"]); err == nil && parsedTimeout > 0 {
+			newsegment.Timeout = parsedTimeout
+		} else {
+			log.Error().Msg("Http: Could not parse 'timeout' parameter, using default 10s.")
+		}
+	} else {
+		log.Info().Msg("Http: 'timeout' set to default 10s.")
+	}
+
+	if config["retries"] != "" {
+		if parsedRetries, err := strconv.Atoi(config["retries"]); err == nil && parsedRetries >= 0 {
+			newsegment.Retries = parsedRetries
+		} else {
+			log.Error().Msg("Http: Could not parse 'retries' parameter, using default 3.")
+		}
+	} else {
+		log.Info().Msg("Http: 'retries' set to default 3.")
+	}
+
+	if config["backoff"] != "" {
+		if parsedBackoff, err := time.ParseDuration(config["backoff"]); err == nil && parsedBackoff >= 0 {
+			newsegment.Backoff = parsedBackoff
+		} else {
+			log.Error().Msg("Http: Could not parse 'backoff' parameter, using default 1s.")
+		}
+	} else if newsegment.Retries > 0 {
+		log.Info().Msg("Http: 'backoff' set to default 1s.")
+	}
+
+	if config["shutdowntimeout"] != "" {
+		if parsedShutdownTimeout, err := time.ParseDuration(config["shutdowntimeout"]); err == nil && parsedShutdownTimeout >= 0 {
+			newsegment.ShutdownTimeout = parsedShutdownTimeout
+		} else {
+			log.Error().Msg("Http: Could not parse 'shutdowntimeout' parameter, using default 10s.")
+		}
+	} else {
+		log.Info().Msg("Http: 'shutdowntimeout' set to default 10s.")
+	}
+	return newsegment
 }
 
 func (segment *Http) Run(wg *sync.WaitGroup) {
+	segment.client = &http.Client{Timeout: segment.Timeout}
+	// canceled once the shutdown timeout expires
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := make(chan *pb.EnrichedFlow, segment.QueueSize)
+	batches := make(chan []*pb.EnrichedFlow)
+	senders := &sync.WaitGroup{}
+	senders.Add(1 + segment.Workers)
+	go func() {
+		defer senders.Done()
+		segment.batch(queue, batches)
+	}()
+	for i := 0; i < segment.Workers; i++ {
+		go func() {
+			defer senders.Done()
+			for flows := range batches {
+				segment.send(ctx, flows)
+			}
+		}()
+	}
 	defer func() {
+		close(queue)
+		deadline := time.AfterFunc(segment.ShutdownTimeout, cancel)
+		senders.Wait()
+		deadline.Stop()
+		cancel()
+		if dropped := segment.dropped.Load(); dropped > 0 {
+			log.Warn().Msgf("Http: Dropped %d flows not sent within the shutdown timeout.", dropped)
+		}
 		close(segment.Out)
 		wg.Done()
 	}()
-	var limitLog bool
+
 	for msg := range segment.In {
+		// this is the only sender, so the queue can not fill up in between
+		if len(queue) < cap(queue) {
+			// the flow is modified by subsequent segments while waiting for its request
+			queue <- proto.Clone(msg).(*pb.EnrichedFlow)
+			if segment.skipping.CompareAndSwap(true, false) {
+				log.Info().Msg("Http: Queue has capacity again, flows are being sent again.")
+			}
+		} else {
+			if segment.skipping.CompareAndSwap(false, true) {
+				log.Warn().Msg("Http: Queue is full, skipping at least one flow.")
+				log.Warn().Msg("Http: Above message will not repeat for every flow and is effective until resolved.")
+			}
+		}
+		segment.Out <- msg
+	}
+}
+
+// Collects queued flows into batches, sending incomplete batches after the
+// batch timeout.
+func (segment *Http) batch(queue <-chan *pb.EnrichedFlow, batches chan<- []*pb.EnrichedFlow) {
+	defer close(batches)
+	var pending []*pb.EnrichedFlow
+	timer := time.NewTimer(segment.BatchTimeout)
+	timer.Stop()
+	for {
+		select {
+		case msg, ok := <-queue:
+			if !ok {
+				if len(pending) > 0 {
+					batches <- pending
+				}
+				return
+			}
+			pending = append(pending, msg)
+			if len(pending) >= segment.BatchSize {
+				timer.Stop()
+				batches <- pending
+				pending = nil
+			} else if len(pending) == 1 {
+				timer.Reset(segment.BatchTimeout)
+			}
+		case <-timer.C:
+			if len(pending) > 0 {
+				batches <- pending
+				pending = nil
+			}
+		}
+	}
+}
+
+// Returns the body of a request for the given flows.
+func (segment *Http) body(flows []*pb.EnrichedFlow) ([]byte, error) {
+	if segment.Template != nil {
+		var buffer bytes.Buffer
+		err := segment.Template.Execute(&buffer, templateData{Flows: flows, Flow: flows[0]})
+		return buffer.Bytes(), err
+	}
+	if segment.BatchSize == 1 {
+		return protojson.Marshal(flows[0])
+	}
+	var buffer bytes.Buffer
+	buffer.WriteByte('[')
+	for i, msg := range flows {
 		data, err := protojson.Marshal(msg)
 		if err != nil {
-			log.Warn().Err(err).Msg("Http: Skipping a flow, failed to recode protobuf as JSON: ")
-			continue
+			return nil, err
 		}
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(data)
+	}
+	buffer.WriteByte(']')
+	return buffer.Bytes(), nil
+}
 
-		resp, err := http.Post(segment.Url, "application/json", bytes.NewBuffer(data))
-		if err != nil {
-			log.Error().Err(err).Msg("Http: Request setup error, skipping at least one flow")
-			log.Error().Msg("Http: Above message will not repeat for every flow and is effective until resolved.")
-			limitLog = true
-		} else if !(resp.StatusCode-200 < 100) {
-			log.Error().Msgf("Http: Server endpoint error, skipping at least one flow. Code %s.", resp.Status)
-			log.Error().Msg("Http: Above message will not repeat for every flow and is effective until resolved.")
-			limitLog = true
-		} else if limitLog {
-			log.Info().Msg("Http: Previous error is resolved, flows are being posted to configured url successfully again.")
-			limitLog = false
+// Sends a batch of flows, retrying failed requests. Flows are dropped once the
+// context is canceled.
+func (segment *Http) send(ctx context.Context, flows []*pb.EnrichedFlow) {
+	if ctx.Err() != nil {
+		segment.dropped.Add(uint64(len(flows)))
+		return
+	}
+	data, err := segment.body(flows)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Http: Skipping %d flows, failed to create request body.", len(flows))
+		return
+	}
+
+	backoff := segment.Backoff
+	for try := 0; ; try++ {
+		retry, err := segment.request(ctx, data)
+		if ctx.Err() != nil {
+			segment.dropped.Add(uint64(len(flows)))
+			return
 		}
-		segment.Out <- msg
+		if err == nil {
+			if segment.failing.CompareAndSwap(true, false) {
+				log.Info().Msg("Http: Previous error is resolved, flows are being sent to configured url successfully again.")
+			}
+			return
+		}
+		if !retry || try >= segment.Retries {
+			if segment.failing.CompareAndSwap(false, true) {
+				log.Error().Err(err).Msgf("Http: Request failed after %d tries, skipping at least one flow.", try+1)
+				log.Error().Msg("Http: Above message will not repeat for every flow and is effective until resolved.")
+			}
+			return
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			segment.dropped.Add(uint64(len(flows)))
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// Sends a single request, returning whether the request should be retried if
+// it failed and an error.
+func (segment *Http) request(ctx context.Context, data []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, segment.Method, segment.Url, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", segment.ContentType)
+	for name, value := range segment.Headers {
+		req.Header.Set(name, value)
+	}
+	if segment.Username != "" || segment.Password != "" {
+		req.SetBasicAuth(segment.Username, segment.Password)
+	} else if segment.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+segment.Token)
+	}
+
+	resp, err := segment.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
 	}
+	err = fmt.Errorf("server endpoint returned %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
 }
 
 func init() {
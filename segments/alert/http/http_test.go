@@ -1,7 +1,16 @@
 package http
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
@@ -15,3 +24,153 @@ func TestSegment_Http_passthrough(t *testing.T) {
 		t.Error("([error] Segment Http is not working.")
 	}
 }
+
+// Runs the given flows through an http segment, returning once all flows are
+// passed and the segment has exited.
+func runHttp(config map[string]string, flows ...*pb.EnrichedFlow) {
+	segment := segments.LookupSegment("http").New(config)
+	if segment == nil {
+		log.Fatal().Msg("Configured segment 'http' could not be initialized properly, see previous messages.")
+	}
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(wg)
+
+	go func() {
+		for _, flow := range flows {
+			in <- flow
+		}
+		close(in)
+	}()
+	for range out {
+	}
+	wg.Wait()
+}
+
+// Http Segment test, templates, batches and request options
+func TestSegment_Http_template(t *testing.T) {
+	var bodies []string
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if r.Method != "PUT" || r.Header.Get("X-Source") != "flowpipeline" || user != "alert" || password != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		bodies = append(bodies, string(body))
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	runHttp(map[string]string{
+		"url":             server.URL,
+		"method":          "put",
+		"header.X-Source": "flowpipeline",
+		"username":        "alert",
+		"password":        "secret",
+		"batchsize":       "2",
+		"workers":         "1",
+		"template":        `{"text": "{{ len .Flows }} flows, first {{ .Flow.SrcAddrObj }}", "flows": [{{ range $i, $f := .Flows }}{{ if $i }},{{ end }}{{ json $f }}{{ end }}]}`,
+	},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, Bytes: 1},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 2}, Bytes: 2},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 3}, Bytes: 3})
+
+	if len(bodies) != 2 {
+		t.Fatalf("([error] Segment Http is not batching flows, got %d requests.", len(bodies))
+	}
+	var payloads [2]struct {
+		Text  string
+		Flows []struct{ Bytes string }
+	}
+	for i, body := range bodies {
+		if err := json.Unmarshal([]byte(body), &payloads[i]); err != nil {
+			t.Fatalf("([error] Segment Http is not rendering valid JSON: %s", body)
+		}
+	}
+	if payloads[0].Text != "2 flows, first 192.0.2.1" || len(payloads[0].Flows) != 2 || payloads[0].Flows[1].Bytes != "2" {
+		t.Errorf("([error] Segment Http is not rendering templates correctly: %s", bodies[0])
+	}
+	if payloads[1].Text != "1 flows, first 192.0.2.3" || len(payloads[1].Flows) != 1 {
+		t.Errorf("([error] Segment Http is not sending incomplete batches: %s", bodies[1])
+	}
+}
+
+// Http Segment test, retries
+func TestSegment_Http_retry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	runHttp(map[string]string{"url": server.URL, "backoff": "1ms"}, &pb.EnrichedFlow{})
+	if requests.Load() != 3 {
+		t.Errorf("([error] Segment Http is not retrying failed requests, got %d requests.", requests.Load())
+	}
+
+	requests.Store(-10)
+	runHttp(map[string]string{"url": server.URL, "backoff": "1ms", "retries": "2"}, &pb.EnrichedFlow{})
+	if requests.Load() != -7 {
+		t.Errorf("([error] Segment Http is not limiting retries, got %d requests.", requests.Load()+10)
+	}
+}
+
+// Http Segment test, passing flows while the remote is blocking
+func TestSegment_Http_async(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	segment := segments.LookupSegment("http").New(map[string]string{"url": server.URL, "queuesize": "1", "workers": "1"})
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(wg)
+
+	timeout := time.After(time.Second)
+	for i := 0; i < 10; i++ {
+		in <- &pb.EnrichedFlow{}
+		select {
+		case <-out:
+		case <-timeout:
+			t.Fatal("([error] Segment Http is blocking the pipeline while the remote is not responding.")
+		}
+	}
+	close(release)
+	close(in)
+	for range out {
+	}
+	wg.Wait()
+}
+
+// Http Segment test, shutdown timeout while the remote is failing
+func TestSegment_Http_shutdown(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	runHttp(map[string]string{"url": server.URL, "backoff": "1h", "workers": "1", "shutdowntimeout": "100ms"},
+		&pb.EnrichedFlow{}, &pb.EnrichedFlow{}, &pb.EnrichedFlow{})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("([error] Segment Http is not respecting the shutdown timeout, took %s.", elapsed)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("([error] Segment Http is not dropping queued flows on shutdown, got %d requests.", requests.Load())
+	}
+}
@@ -0,0 +1,569 @@
+// The `anomaly` segment learns traffic baselines of networks and emits events
+// for deviations from them. Networks are identified by the Cid field set by the
+// `addcid` segment or by the NetId fields set by the `addnetid` segment, as
+// chosen by `key`. Flows without a network are ignored.
+//
+// Once per `interval`, the metrics 'bps', 'pps' and 'fps' (flows per second)
+// as well as the protocol mix as the shares of bytes of 'tcp', 'udp' and
+// 'icmp' are computed for each network. Known networks without traffic have
+// rates of zero.
+//
+// Baselines are exponentially weighted moving averages of the mean and
+// variance of each metric, with the weight of new values set by `alpha`. To
+// account for daily or weekly patterns, as chosen by `seasonality`, a separate
+// baseline is kept for each slot of the season, the slots' duration being set
+// by `slot`. Seasons are aligned to the local time zone. A value is an anomaly
+// if it deviates from the baseline of its slot by more than `sigma` standard
+// deviations, once this baseline has learned from at least `minsamples` values.
+// Anomalous values are not learned, unless a metric stays anomalous for
+// `relearn` consecutive intervals. Such a lasting change of the traffic level,
+// for instance after a migration, discards all baselines of the metric, which
+// are then learned anew from the current traffic.
+//
+// Events are flows with the network's Cid or NetId fields and the AnomalyEvent
+// field set to AnomalyStart or AnomalyEnd, the latter being emitted once the
+// metric is within the baseline again or its baselines are discarded. Events
+// contain the affected metric, its value, the expected value and the deviation
+// in standard deviations. Baselines are kept for at most `maxkeys` networks,
+// evicting the least recently seen one and ending its anomalies. If `statefile`
+// is set, baselines are written to this file every `saveinterval` and on
+// shutdown, and restored from it on startup.
+//
+// By default, events are injected into the pipeline in addition to all flows.
+// If `eventsonly` is set, only events are passed and all other flows are
+// available to the `else` branch when using this segment as a condition of the
+// `branch` segment. Events can additionally be written as JSON lines to a file
+// given by `filename`. The current values, expected values and deviations are
+// also exported as Prometheus metrics, using the `endpoint` and `metricspath`
+// parameters. The parameter `traffictype` is passed as label of the metrics,
+// so this segment can be used multiple times in one pipeline.
+package anomaly
+
+import (
+	"bufio"
+	"container/list"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Anomaly struct {
+	segments.BaseFilterSegment
+	Key          string        // optional, default is "cid", one of "cid" and "netid"
+	Interval     time.Duration // optional, default is 1m, interval in which metrics are computed
+	Seasonality  time.Duration // optional, default is "daily", one of "none", "daily" and "weekly", as duration of the season
+	Slot         time.Duration // optional, default is 1h, duration of the slots of a season
+	Alpha        float64       // optional, default is 0.05, weight of new values in the baselines
+	Sigma        float64       // optional, default is 3, standard deviations constituting an anomaly
+	MinSamples   uint64        // optional, default is 30, values learned by a baseline before it is used
+	Relearn      int           // optional, default is 60, consecutive anomalous intervals after which baselines are discarded, 0 disables this
+	MaxKeys      int           // optional, default is 10000, maximum number of networks tracked
+	StateFile    string        // optional, default is "", file baselines are persisted to
+	SaveInterval time.Duration // optional, default is 10m, interval in which baselines are persisted
+	EventsOnly   bool          // optional, default is false, whether to pass only events
+	File         *os.File      // optional, default is none, file to write events to
+	Endpoint     string        // optional, default is ":8080"
+	MetricsPath  string        // optional, default is "/metrics"
+	TrafficType  string        // optional, default is ""
+
+	networks map[string]*list.Element
+	lru      *list.List // networks by last flow, least recently seen first
+	lastSave time.Time
+	writer   *bufio.Writer
+	values   *prometheus.GaugeVec
+	expected *prometheus.GaugeVec
+	sigmas   *prometheus.GaugeVec
+}
+
+// The metrics with baselines, the shares being of bytes.
+var metrics = []struct {
+	name  string
+	floor func(mean float64) float64 // of the standard deviation
+}{
+	{"bps", rateFloor},
+	{"pps", rateFloor},
+	{"fps", rateFloor},
+	{"tcp", shareFloor},
+	{"udp", shareFloor},
+	{"icmp", shareFloor},
+}
+
+func rateFloor(mean float64) float64 {
+	return max(0.05*mean, 1)
+}
+
+func shareFloor(mean float64) float64 {
+	return 0.02
+}
+
+// A network with its baselines, which are persisted, and its traffic in the
+// current interval.
+type network struct {
+	Cid         uint32   `json:"cid,omitempty"`
+	NetId       uint32   `json:"netid,omitempty"`
+	NetIdString string   `json:"netidstring,omitempty"`
+	Models      [][]slot `json:"models"` // by metric and slot
+
+	key        string
+	bytes      uint64
+	packets    uint64
+	flows      uint64
+	protoBytes [3]uint64 // tcp, udp, icmp
+	anomalous  []int     // consecutive anomalous intervals by metric
+	values     []float64 // of the last interval by metric
+}
+
+func (segment Anomaly) New(config map[string]string) segments.Segment {
+	var key = "cid"
+	switch config["key"] {
+	case "":
+		log.Info().Msg("Anomaly: 'key' set to default 'cid'.")
+	case "cid", "netid":
+		key = config["key"]
+	default:
+		log.Error().Msg("Anomaly: The 'key' parameter is required to be one of 'cid' or 'netid'.")
+		return nil
+	}
+
+	var interval = time.Minute
+	if config["interval"] != "" {
+		if parsedInterval, err := time.ParseDuration(config["interval"]); err == nil && parsedInterval > 0 {
+			interval = parsedInterval
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'interval' parameter, using default 1m.")
+		}
+	} else {
+		log.Info().Msg("Anomaly: 'interval' set to default 1m.")
+	}
+
+	var seasonality = 24 * time.Hour
+	switch config["seasonality"] {
+	case "":
+		log.Info().Msg("Anomaly: 'seasonality' set to default 'daily'.")
+	case "none":
+		seasonality = 0
+	case "daily":
+	case "weekly":
+		seasonality = 7 * 24 * time.Hour
+	default:
+		log.Error().Msg("Anomaly: The 'seasonality' parameter is required to be one of 'none', 'daily' or 'weekly'.")
+		return nil
+	}
+
+	var slot = time.Hour
+	if config["slot"] != "" {
+		if parsedSlot, err := time.ParseDuration(config["slot"]); err == nil && parsedSlot > 0 {
+			slot = parsedSlot
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'slot' parameter, using default 1h.")
+		}
+	} else if seasonality != 0 {
+		log.Info().Msg("Anomaly: 'slot' set to default 1h.")
+	}
+	if seasonality != 0 && seasonality%slot != 0 {
+		log.Error().Msg("Anomaly: The season has to be a multiple of 'slot'.")
+		return nil
+	}
+
+	var alpha = 0.05
+	if config["alpha"] != "" {
+		if parsedAlpha, err := strconv.ParseFloat(config["alpha"], 64); err == nil && parsedAlpha > 0 && parsedAlpha <= 1 {
+			alpha = parsedAlpha
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'alpha' parameter, using default 0.05.")
+		}
+	} else {
+		log.Info().Msg("Anomaly: 'alpha' set to default 0.05.")
+	}
+
+	var sigma = 3.0
+	if config["sigma"] != "" {
+		if parsedSigma, err := strconv.ParseFloat(config["sigma"], 64); err == nil && parsedSigma > 0 {
+			sigma = parsedSigma
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'sigma' parameter, using default 3.")
+		}
+	} else {
+		log.Info().Msg("Anomaly: 'sigma' set to default 3.")
+	}
+
+	var minSamples uint64 = 30
+	if config["minsamples"] != "" {
+		if parsedMinSamples, err := strconv.ParseUint(config["minsamples"], 10, 64); err == nil && parsedMinSamples > 0 {
+			minSamples = parsedMinSamples
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'minsamples' parameter, using default 30.")
+		}
+	} else {
+		log.Info().Msg("Anomaly: 'minsamples' set to default 30.")
+	}
+
+	var relearn = 60
+	if config["relearn"] != "" {
+		if parsedRelearn, err := strconv.Atoi(config["relearn"]); err == nil && parsedRelearn >= 0 {
+			relearn = parsedRelearn
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'relearn' parameter, using default 60.")
+		}
+	} else {
+		log.Info().Msg("Anomaly: 'relearn' set to default 60.")
+	}
+
+	var maxKeys = 10000
+	if config["maxkeys"] != "" {
+		if parsedMaxKeys, err := strconv.Atoi(config["maxkeys"]); err == nil && parsedMaxKeys > 0 {
+			maxKeys = parsedMaxKeys
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'maxkeys' parameter, using default 10000.")
+		}
+	} else {
+		log.Info().Msg("Anomaly: 'maxkeys' set to default 10000.")
+	}
+
+	var saveInterval = 10 * time.Minute
+	if config["saveinterval"] != "" {
+		if parsedSaveInterval, err := time.ParseDuration(config["saveinterval"]); err == nil && parsedSaveInterval > 0 {
+			saveInterval = parsedSaveInterval
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'saveinterval' parameter, using default 10m.")
+		}
+	} else if config["statefile"] != "" {
+		log.Info().Msg("Anomaly: 'saveinterval' set to default 10m.")
+	}
+	if config["statefile"] == "" {
+		log.Info().Msg("Anomaly: No 'statefile' set, baselines will be lost on restart.")
+	}
+
+	var eventsOnly bool
+	if config["eventsonly"] != "" {
+		if parsedEventsOnly, err := strconv.ParseBool(config["eventsonly"]); err == nil {
+			eventsOnly = parsedEventsOnly
+		} else {
+			log.Error().Msg("Anomaly: Could not parse 'eventsonly' parameter, using default false.")
+		}
+	} else {
+		log.Info().Msg("Anomaly: 'eventsonly' set to default false.")
+	}
+
+	var file *os.File
+	if config["filename"] != "" {
+		var err error
+		file, err = os.Create(config["filename"])
+		if err != nil {
+			log.Error().Err(err).Msg("Anomaly: File specified in 'filename' is not accessible.")
+			return nil
+		}
+	}
+
+	newsegment := &Anomaly{
+		Key:          key,
+		Interval:     interval,
+		Seasonality:  seasonality,
+		Slot:         slot,
+		Alpha:        alpha,
+		Sigma:        sigma,
+		MinSamples:   minSamples,
+		Relearn:      relearn,
+		MaxKeys:      maxKeys,
+		StateFile:    config["statefile"],
+		SaveInterval: saveInterval,
+		EventsOnly:   eventsOnly,
+		File:         file,
+		Endpoint:     ":8080",
+		MetricsPath:  "/metrics",
+		TrafficType:  config["traffictype"],
+	}
+	if config["endpoint"] != "" {
+		newsegment.Endpoint = config["endpoint"]
+	} else {
+		log.Info().Msg("Anomaly: Missing configuration parameter 'endpoint'. Using default port ':8080'")
+	}
+	if config["metricspath"] != "" {
+		newsegment.MetricsPath = config["metricspath"]
+	} else {
+		log.Info().Msg("Anomaly: Missing configuration parameter 'metricspath'. Using default path '/metrics'")
+	}
+	return newsegment
+}
+
+func (segment *Anomaly) Run(wg *sync.WaitGroup) {
+	defer func() {
+		if segment.writer != nil {
+			segment.writer.Flush()
+		}
+		if segment.StateFile != "" {
+			if err := segment.save(); err != nil {
+				log.Error().Err(err).Msgf("Anomaly: Could not persist baselines to '%s'.", segment.StateFile)
+			}
+		}
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup(time.Now())
+	segment.serveMetrics()
+
+	ticker := time.NewTicker(segment.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.account(msg)
+			if !segment.EventsOnly {
+				segment.Out <- msg
+			} else if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+		case now := <-ticker.C:
+			segment.evaluate(now)
+		}
+	}
+}
+
+func (segment *Anomaly) setup(now time.Time) {
+	segment.networks = make(map[string]*list.Element)
+	segment.lru = list.New()
+	segment.lastSave = now
+	if segment.File != nil {
+		segment.writer = bufio.NewWriter(segment.File)
+	}
+	labels := []string{"traffic_type", "key", "metric"}
+	segment.values = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "anomaly_value",
+		Help: "Value of the metric in the last interval.",
+	}, labels)
+	segment.expected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "anomaly_expected",
+		Help: "Value of the metric expected by the baseline.",
+	}, labels)
+	segment.sigmas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "anomaly_sigma",
+		Help: "Deviation of the metric from the baseline in standard deviations.",
+	}, labels)
+	if segment.StateFile != "" {
+		if err := segment.load(); err != nil {
+			log.Error().Err(err).Msgf("Anomaly: Could not restore baselines from '%s', starting empty.", segment.StateFile)
+		}
+	}
+}
+
+func (segment *Anomaly) serveMetrics() {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(segment.values, segment.expected, segment.sigmas)
+
+	mux := http.NewServeMux()
+	mux.Handle(segment.MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		err := http.ListenAndServe(segment.Endpoint, mux)
+		if err != nil {
+			log.Error().Err(err).Msgf("Anomaly: Failed to start http endpoint on %s", segment.Endpoint)
+		}
+	}()
+	log.Info().Msgf("Anomaly: Enabled metrics on %s, listening at %s.", segment.MetricsPath, segment.Endpoint)
+}
+
+// Returns the number of slots of a season.
+func (segment *Anomaly) slots() int {
+	if segment.Seasonality == 0 {
+		return 1
+	}
+	return int(segment.Seasonality / segment.Slot)
+}
+
+// Returns the slot of the season the given time belongs to, in local time.
+func (segment *Anomaly) slotOf(t time.Time) int {
+	if segment.Seasonality == 0 {
+		return 0
+	}
+	_, offset := t.Zone()
+	local := time.Duration(t.Unix()+int64(offset)) * time.Second
+	return int(local % segment.Seasonality / segment.Slot)
+}
+
+// Adds a flow to the traffic of its network.
+func (segment *Anomaly) account(msg *pb.EnrichedFlow) {
+	var key string
+	switch segment.Key {
+	case "cid":
+		if msg.Cid != 0 {
+			key = strconv.FormatUint(uint64(msg.Cid), 10)
+		}
+	case "netid":
+		if msg.NetIdString != "" {
+			key = msg.NetIdString
+		} else if msg.NetId != 0 {
+			key = strconv.FormatUint(uint64(msg.NetId), 10)
+		}
+	}
+	if key == "" {
+		return
+	}
+
+	var n *network
+	if element, ok := segment.networks[key]; ok {
+		segment.lru.MoveToBack(element)
+		n = element.Value.(*network)
+	} else {
+		if segment.lru.Len() >= segment.MaxKeys {
+			segment.evict(time.Now())
+		}
+		n = &network{key: key, Models: make([][]slot, len(metrics))}
+		for i := range n.Models {
+			n.Models[i] = make([]slot, segment.slots())
+		}
+		switch segment.Key {
+		case "cid":
+			n.Cid = msg.Cid
+		case "netid":
+			n.NetId, n.NetIdString = msg.NetId, msg.NetIdString
+		}
+		segment.networks[key] = segment.lru.PushBack(n)
+	}
+
+	bytes, packets := msg.ScaledCounters()
+	n.bytes += bytes
+	n.packets += packets
+	n.flows += 1
+	switch msg.Proto {
+	case 6:
+		n.protoBytes[0] += bytes
+	case 17:
+		n.protoBytes[1] += bytes
+	case 1, 58:
+		n.protoBytes[2] += bytes
+	}
+}
+
+// Removes the least recently seen network, ending its anomalies and deleting
+// its Prometheus metrics.
+func (segment *Anomaly) evict(now time.Time) {
+	oldest := segment.lru.Front()
+	n := oldest.Value.(*network)
+	delete(segment.networks, n.key)
+	segment.lru.Remove(oldest)
+	index := segment.slotOf(now.Add(-segment.Interval))
+	for i := range metrics {
+		if n.anomalous != nil && n.anomalous[i] > 0 {
+			s := &n.Models[i][index]
+			value := n.values[i]
+			segment.emit(n, i, pb.EnrichedFlow_AnomalyEnd, value, s.Mean, s.sigma(value, metrics[i].floor(s.Mean)), now)
+		}
+		labels := []string{segment.TrafficType, n.key, metrics[i].name}
+		segment.values.DeleteLabelValues(labels...)
+		segment.expected.DeleteLabelValues(labels...)
+		segment.sigmas.DeleteLabelValues(labels...)
+	}
+}
+
+// Computes the metrics of the last interval for all networks, compares them
+// to the baselines and updates those accordingly.
+func (segment *Anomaly) evaluate(now time.Time) {
+	seconds := segment.Interval.Seconds()
+	index := segment.slotOf(now.Add(-segment.Interval))
+	for element := segment.lru.Front(); element != nil; element = element.Next() {
+		n := element.Value.(*network)
+		if n.anomalous == nil {
+			n.anomalous = make([]int, len(metrics))
+			n.values = make([]float64, len(metrics))
+		}
+		values := []float64{
+			float64(n.bytes*8) / seconds,
+			float64(n.packets) / seconds,
+			float64(n.flows) / seconds,
+		}
+		// shares are undefined without traffic
+		if n.bytes > 0 {
+			for _, protoBytes := range n.protoBytes {
+				values = append(values, float64(protoBytes)/float64(n.bytes))
+			}
+		}
+
+		for i, value := range values {
+			n.values[i] = value
+			labels := []string{segment.TrafficType, n.key, metrics[i].name}
+			segment.values.WithLabelValues(labels...).Set(value)
+			s := &n.Models[i][index]
+			if s.Samples < segment.MinSamples {
+				s.update(value, segment.Alpha)
+				continue
+			}
+			sigma := s.sigma(value, metrics[i].floor(s.Mean))
+			segment.expected.WithLabelValues(labels...).Set(s.Mean)
+			segment.sigmas.WithLabelValues(labels...).Set(sigma)
+			anomalous := sigma > segment.Sigma || sigma < -segment.Sigma
+			if anomalous && n.anomalous[i] == 0 {
+				segment.emit(n, i, pb.EnrichedFlow_AnomalyStart, value, s.Mean, sigma, now)
+			} else if !anomalous && n.anomalous[i] > 0 {
+				segment.emit(n, i, pb.EnrichedFlow_AnomalyEnd, value, s.Mean, sigma, now)
+			}
+			if !anomalous {
+				n.anomalous[i] = 0
+				s.update(value, segment.Alpha)
+				continue
+			}
+			n.anomalous[i] += 1
+			if segment.Relearn > 0 && n.anomalous[i] >= segment.Relearn {
+				// the traffic level has changed permanently
+				segment.emit(n, i, pb.EnrichedFlow_AnomalyEnd, value, s.Mean, sigma, now)
+				n.anomalous[i] = 0
+				n.Models[i] = make([]slot, segment.slots())
+				n.Models[i][index].update(value, segment.Alpha)
+				segment.expected.DeleteLabelValues(labels...)
+				segment.sigmas.DeleteLabelValues(labels...)
+			}
+		}
+		n.bytes, n.packets, n.flows, n.protoBytes = 0, 0, 0, [3]uint64{}
+	}
+	if segment.writer != nil {
+		segment.writer.Flush()
+	}
+	if segment.StateFile != "" && now.Sub(segment.lastSave) >= segment.SaveInterval {
+		if err := segment.save(); err != nil {
+			log.Error().Err(err).Msgf("Anomaly: Could not persist baselines to '%s'.", segment.StateFile)
+		}
+		segment.lastSave = now
+	}
+}
+
+func (segment *Anomaly) emit(n *network, metric int, event pb.EnrichedFlow_AnomalyEventType, value float64, expected float64, sigma float64, now time.Time) {
+	msg := &pb.EnrichedFlow{
+		Cid:             n.Cid,
+		NetId:           n.NetId,
+		NetIdString:     n.NetIdString,
+		TimeReceivedNs:  uint64(now.UnixNano()),
+		TimeFlowStartNs: uint64(now.Add(-segment.Interval).UnixNano()),
+		TimeFlowEndNs:   uint64(now.UnixNano()),
+		AnomalyEvent:    event,
+		AnomalyMetric:   metrics[metric].name,
+		AnomalyValue:    value,
+		AnomalyExpected: expected,
+		AnomalySigma:    sigma,
+	}
+
+	if segment.writer != nil {
+		if data, err := protojson.Marshal(msg); err == nil {
+			segment.writer.Write(data)
+			segment.writer.WriteString("\n")
+		} else {
+			log.Warn().Err(err).Msg("Anomaly: Failed to encode event as JSON.")
+		}
+	}
+	segment.Out <- msg
+}
+
+func init() {
+	segment := &Anomaly{}
+	segments.RegisterSegment("anomaly", segment)
+}
@@ -0,0 +1,146 @@
+package anomaly
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Anomaly Segment test, detection and recovery
+func TestSegment_Anomaly_detect(t *testing.T) {
+	segment, out := segments.NewTestSegment[*Anomaly]("anomaly", map[string]string{"seasonality": "none", "interval": "1s", "minsamples": "5"})
+	segment.setup(time.Now())
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		segment.account(&pb.EnrichedFlow{Cid: 42, Proto: 6, Bytes: 1000 + uint64(i%2)*100, Packets: 10})
+		segment.evaluate(now)
+	}
+	if len(out) != 0 {
+		t.Fatal("([error] Segment Anomaly is emitting events for steady traffic.")
+	}
+
+	segment.account(&pb.EnrichedFlow{Cid: 42, Proto: 17, Bytes: 100000, Packets: 10, SamplingRate: 1})
+	segment.evaluate(now)
+	events := map[string]*pb.EnrichedFlow{}
+	for len(out) > 0 {
+		event := <-out
+		events[event.AnomalyMetric] = event
+	}
+	// the shift from tcp to udp is an anomaly in both shares
+	bps := events["bps"]
+	if len(events) != 3 || bps == nil || events["tcp"] == nil || events["udp"] == nil {
+		t.Fatalf("([error] Segment Anomaly is not detecting the right metrics: %v", events)
+	}
+	if bps.AnomalyEvent != pb.EnrichedFlow_AnomalyStart || bps.Cid != 42 || bps.AnomalyValue != 800000 || bps.AnomalySigma < 3 {
+		t.Errorf("([error] Segment Anomaly is not describing anomalies correctly: %v", bps)
+	}
+
+	segment.account(&pb.EnrichedFlow{Cid: 42, Proto: 6, Bytes: 1050, Packets: 10})
+	segment.evaluate(now)
+	if len(out) != 3 {
+		t.Fatal("([error] Segment Anomaly is not ending anomalies.")
+	}
+	if event := <-out; event.AnomalyEvent != pb.EnrichedFlow_AnomalyEnd {
+		t.Error("([error] Segment Anomaly is not emitting end events.")
+	}
+}
+
+// Anomaly Segment test, eviction of anomalous networks
+func TestSegment_Anomaly_evict(t *testing.T) {
+	segment, out := segments.NewTestSegment[*Anomaly]("anomaly", map[string]string{"seasonality": "none", "interval": "1s", "minsamples": "5", "maxkeys": "1"})
+	segment.setup(time.Now())
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		segment.account(&pb.EnrichedFlow{Cid: 42, Proto: 6, Bytes: 1000, Packets: 10})
+		segment.evaluate(now)
+	}
+	segment.account(&pb.EnrichedFlow{Cid: 42, Proto: 6, Bytes: 100000, Packets: 10})
+	segment.evaluate(now)
+	if len(out) != 1 {
+		t.Fatalf("([error] Segment Anomaly is not detecting anomalies: %d events", len(out))
+	}
+	<-out
+
+	segment.account(&pb.EnrichedFlow{Cid: 43, Proto: 6, Bytes: 1000, Packets: 10})
+	if len(out) != 1 {
+		t.Fatal("([error] Segment Anomaly is not ending anomalies of evicted networks.")
+	}
+	if event := <-out; event.AnomalyEvent != pb.EnrichedFlow_AnomalyEnd || event.Cid != 42 || event.AnomalyMetric != "bps" {
+		t.Errorf("([error] Segment Anomaly is not describing anomalies of evicted networks correctly: %v", event)
+	}
+	if segment.values.DeleteLabelValues("", "42", "bps") || segment.expected.DeleteLabelValues("", "42", "bps") {
+		t.Error("([error] Segment Anomaly is keeping metrics of evicted networks.")
+	}
+}
+
+// Anomaly Segment test, relearning after a lasting level shift
+func TestSegment_Anomaly_relearn(t *testing.T) {
+	segment, out := segments.NewTestSegment[*Anomaly]("anomaly", map[string]string{"seasonality": "none", "interval": "1s", "minsamples": "5", "relearn": "3"})
+	segment.setup(time.Now())
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		segment.account(&pb.EnrichedFlow{Cid: 42, Proto: 6, Bytes: 1000, Packets: 10})
+		segment.evaluate(now)
+	}
+
+	events := map[pb.EnrichedFlow_AnomalyEventType]int{}
+	for i := 0; i < 20; i++ {
+		segment.account(&pb.EnrichedFlow{Cid: 42, Proto: 6, Bytes: 100000, Packets: 10})
+		segment.evaluate(now)
+		for len(out) > 0 {
+			event := <-out
+			if event.AnomalyMetric != "bps" {
+				t.Fatalf("([error] Segment Anomaly is detecting the wrong metric: %v", event)
+			}
+			events[event.AnomalyEvent] += 1
+		}
+	}
+	if events[pb.EnrichedFlow_AnomalyStart] != 1 || events[pb.EnrichedFlow_AnomalyEnd] != 1 {
+		t.Errorf("([error] Segment Anomaly is not relearning after a lasting level shift: %v", events)
+	}
+	if s := segment.networks["42"].Value.(*network).Models[0][0]; s.Samples < 5 || s.Mean != 800000 {
+		t.Errorf("([error] Segment Anomaly is not learning the new level: %v", s)
+	}
+}
+
+// Anomaly Segment test, seasonal slots
+func TestSegment_Anomaly_slots(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Anomaly]("anomaly", map[string]string{"seasonality": "weekly", "slot": "1h"})
+	segment.setup(time.Now())
+	if segment.slots() != 168 {
+		t.Errorf("([error] Segment Anomaly has %d instead of 168 slots per week.", segment.slots())
+	}
+	monday := time.Date(2024, 1, 1, 0, 30, 0, 0, time.Local)
+	if segment.slotOf(monday.Add(time.Hour)) != (segment.slotOf(monday)+1)%168 || segment.slotOf(monday.Add(7*24*time.Hour)) != segment.slotOf(monday) {
+		t.Error("([error] Segment Anomaly is not assigning slots correctly.")
+	}
+	if segment := (Anomaly{}).New(map[string]string{"slot": "7h"}); segment != nil {
+		t.Error("([error] Segment Anomaly accepts slots not dividing the season.")
+	}
+}
+
+// Anomaly Segment test, persistence
+func TestSegment_Anomaly_state(t *testing.T) {
+	config := map[string]string{"key": "netid", "seasonality": "none", "statefile": filepath.Join(t.TempDir(), "anomaly.json")}
+	segment, _ := segments.NewTestSegment[*Anomaly]("anomaly", config)
+	segment.setup(time.Now())
+	segment.account(&pb.EnrichedFlow{NetIdString: "campus", Bytes: 1000})
+	segment.evaluate(time.Now())
+	if err := segment.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := segments.NewTestSegment[*Anomaly]("anomaly", config)
+	restored.setup(time.Now())
+	element, ok := restored.networks["campus"]
+	if !ok {
+		t.Fatal("([error] Segment Anomaly is not restoring baselines.")
+	}
+	n := element.Value.(*network)
+	if n.NetIdString != "campus" || n.Models[0][0].Samples != 1 || n.Models[0][0].Mean != 8000.0/60 {
+		t.Errorf("([error] Segment Anomaly is not restoring baselines correctly: %+v", n.Models[0][0])
+	}
+}
@@ -0,0 +1,87 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// The baseline of a metric within one slot of the season, being exponentially
+// weighted moving averages of its mean and variance.
+type slot struct {
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	Samples  uint64  `json:"samples"`
+}
+
+func (s *slot) update(value float64, alpha float64) {
+	if s.Samples == 0 {
+		s.Mean = value
+	} else {
+		diff := value - s.Mean
+		increment := alpha * diff
+		s.Mean += increment
+		s.Variance = (1 - alpha) * (s.Variance + diff*increment)
+	}
+	s.Samples += 1
+}
+
+// Returns the deviation of a value from the baseline in standard deviations.
+// The standard deviation is at least the given floor, so that steady traffic
+// does not turn minor changes into anomalies.
+func (s *slot) sigma(value float64, floor float64) float64 {
+	return (value - s.Mean) / max(math.Sqrt(s.Variance), floor)
+}
+
+// Restores the models from the state file, which is not required to exist.
+func (segment *Anomaly) load() error {
+	data, err := os.ReadFile(segments.ContainerVolumePrefix + segment.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var networks map[string]*network
+	if err := json.Unmarshal(data, &networks); err != nil {
+		return err
+	}
+	for key, n := range networks {
+		if len(segment.networks) >= segment.MaxKeys {
+			break
+		}
+		n.key = key
+		if len(n.Models) != len(metrics) {
+			n.Models = make([][]slot, len(metrics))
+		}
+		for i := range n.Models {
+			// models of a different season are discarded
+			if len(n.Models[i]) != segment.slots() {
+				n.Models[i] = make([]slot, segment.slots())
+			}
+		}
+		segment.networks[key] = segment.lru.PushBack(n)
+	}
+	return nil
+}
+
+// Writes the models to the state file, replacing it atomically.
+func (segment *Anomaly) save() error {
+	networks := make(map[string]*network, len(segment.networks))
+	for key, element := range segment.networks {
+		networks[key] = element.Value.(*network)
+	}
+	data, err := json.Marshal(networks)
+	if err != nil {
+		return err
+	}
+
+	filename := segments.ContainerVolumePrefix + segment.StateFile
+	if err := os.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
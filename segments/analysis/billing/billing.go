@@ -0,0 +1,412 @@
+// The `billing` segment determines the 95th percentile bandwidth of customers,
+// as commonly used for billing. Customers are identified by the Cid field set
+// by the `addcid` segment or by the NetId fields set by the `addnetid` segment,
+// as chosen by `key`. Flows without a customer are ignored.
+//
+// Traffic is accounted separately for inbound and outbound traffic of each
+// customer. By default, this is determined by the RemoteAddr field set by the
+// `remoteaddress` segment, flows from a remote source address being inbound.
+// If `direction` is set to `flowdirection`, the FlowDirection field is used
+// instead, ingress flows being inbound, which is suitable for flows exported on
+// border interfaces.
+//
+// Once per `interval`, aligned to the wall clock, a rate sample in bits per
+// second is taken for each customer and direction, including samples of zero
+// for known customers without traffic. At the start of a month, as determined
+// in UTC, all samples are discarded. The month-to-date value is the
+// `percentile` of all intervals of the month so far, discarding the highest
+// samples above the percentile, and the current value is the latest sample.
+// Intervals without a sample, i.e. before a customer was first seen or while
+// the pipeline was not running, count as zero.
+//
+// If `statefile` is set, samples are written to this file after each interval
+// and restored from it on startup, so that restarts do not reset the billing
+// period. The current and month-to-date values are exported as Prometheus
+// metrics and as JSON, using the `endpoint`, `metricspath` and `billingpath`
+// parameters. The parameter `traffictype` is passed as label of the Prometheus
+// metrics, so this segment can be used multiple times in one pipeline.
+package billing
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Traffic directions from the perspective of customers.
+const (
+	inbound = iota
+	outbound
+)
+
+var directionNames = [2]string{"inbound", "outbound"}
+
+type Billing struct {
+	segments.BaseSegment
+	Key         string        // optional, default is "cid", one of "cid" and "netid"
+	Direction   string        // optional, default is "remoteaddr", one of "remoteaddr" and "flowdirection"
+	Interval    time.Duration // optional, default is 5m, interval of rate samples
+	Percentile  float64       // optional, default is 95, percentile of the samples reported as month-to-date value
+	StateFile   string        // optional, default is "", file samples are persisted to
+	Endpoint    string        // optional, default is ":8080"
+	MetricsPath string        // optional, default is "/metrics"
+	BillingPath string        // optional, default is "/billing", path of the JSON report
+	TrafficType string        // optional, default is ""
+
+	mutex     sync.Mutex // guards all fields below, which are read by http handlers
+	month     string     // month of the samples, as formatted by monthFormat
+	customers map[string]*customer
+	since     time.Time // start of the current interval
+	current   *prometheus.GaugeVec
+	monthly   *prometheus.GaugeVec
+}
+
+const monthFormat = "2006-01"
+
+// The traffic of a customer by direction.
+type customer struct {
+	bytes   [2]uint64   // of the current interval
+	samples [2][]uint64 // rates in bits per second
+}
+
+func (segment *Billing) New(config map[string]string) segments.Segment {
+	newsegment := &Billing{
+		Key:         "cid",
+		Direction:   "remoteaddr",
+		Interval:    5 * time.Minute,
+		Percentile:  95,
+		StateFile:   config["statefile"],
+		Endpoint:    ":8080",
+		MetricsPath: "/metrics",
+		BillingPath: "/billing",
+		TrafficType: config["traffictype"],
+	}
+
+	switch config["key"] {
+	case "":
+		log.Info().Msg("Billing: 'key' set to default 'cid'.")
+	case "cid", "netid":
+		newsegment.Key = config["key"]
+	default:
+		log.Error().Msg("Billing: The 'key' parameter is required to be one of 'cid' or 'netid'.")
+		return nil
+	}
+
+	switch config["direction"] {
+	case "":
+		log.Info().Msg("Billing: 'direction' set to default 'remoteaddr'.")
+	case "remoteaddr", "flowdirection":
+		newsegment.Direction = config["direction"]
+	default:
+		log.Error().Msg("Billing: The 'direction' parameter is required to be one of 'remoteaddr' or 'flowdirection'.")
+		return nil
+	}
+
+	if config["interval"] != "" {
+		if parsedInterval, err := time.ParseDuration(config["interval"]); err == nil && parsedInterval >= time.Second {
+			newsegment.Interval = parsedInterval
+		} else {
+			log.Error().Msg("Billing: Could not parse 'interval' parameter, using default 5m.")
+		}
+	} else {
+		log.Info().Msg("Billing: 'interval' set to default 5m.")
+	}
+
+	if config["percentile"] != "" {
+		if parsedPercentile, err := strconv.ParseFloat(config["percentile"], 64); err == nil && parsedPercentile > 0 && parsedPercentile <= 100 {
+			newsegment.Percentile = parsedPercentile
+		} else {
+			log.Error().Msg("Billing: Could not parse 'percentile' parameter, using default 95.")
+		}
+	} else {
+		log.Info().Msg("Billing: 'percentile' set to default 95.")
+	}
+
+	if newsegment.StateFile == "" {
+		log.Info().Msg("Billing: No 'statefile' set, samples will be lost on restart.")
+	}
+	if config["endpoint"] != "" {
+		newsegment.Endpoint = config["endpoint"]
+	} else {
+		log.Info().Msg("Billing: Missing configuration parameter 'endpoint'. Using default port ':8080'")
+	}
+	if config["metricspath"] != "" {
+		newsegment.MetricsPath = config["metricspath"]
+	} else {
+		log.Info().Msg("Billing: Missing configuration parameter 'metricspath'. Using default path '/metrics'")
+	}
+	if config["billingpath"] != "" {
+		newsegment.BillingPath = config["billingpath"]
+	} else {
+		log.Info().Msg("Billing: Missing configuration parameter 'billingpath'. Using default path '/billing'")
+	}
+	if newsegment.MetricsPath == newsegment.BillingPath {
+		log.Error().Msg("Billing: The 'metricspath' and 'billingpath' parameters need to differ.")
+		return nil
+	}
+	return newsegment
+}
+
+func (segment *Billing) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup(time.Now())
+	segment.serve()
+
+	timer := time.NewTimer(time.Until(segment.since.Truncate(segment.Interval).Add(segment.Interval)))
+	defer timer.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.account(msg)
+			segment.Out <- msg
+		case now := <-timer.C:
+			end := now.Truncate(segment.Interval)
+			segment.rotate(end)
+			timer.Reset(time.Until(end.Add(segment.Interval)))
+		}
+	}
+}
+
+func (segment *Billing) setup(now time.Time) {
+	segment.customers = make(map[string]*customer)
+	segment.month = now.UTC().Format(monthFormat)
+	segment.since = now
+	segment.current = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "billing_current_bps",
+		Help: "Rate of the latest interval in bits per second.",
+	}, []string{"traffic_type", "customer", "direction"})
+	segment.monthly = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "billing_percentile_bps",
+		Help: "Percentile of the rates of all intervals of the current month in bits per second.",
+	}, []string{"traffic_type", "customer", "direction"})
+	if segment.StateFile != "" {
+		if err := segment.load(); err != nil {
+			log.Error().Err(err).Msgf("Billing: Could not restore samples from '%s', starting empty.", segment.StateFile)
+		}
+	}
+	segment.updateMetrics()
+}
+
+func (segment *Billing) serve() {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(segment.current, segment.monthly)
+
+	mux := http.NewServeMux()
+	mux.Handle(segment.MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc(segment.BillingPath, segment.handleReport)
+	go func() {
+		err := http.ListenAndServe(segment.Endpoint, mux)
+		if err != nil {
+			log.Error().Err(err).Msgf("Billing: Failed to start http endpoint on %s", segment.Endpoint)
+		}
+	}()
+	log.Info().Msgf("Billing: Enabled metrics on %s and reports on %s, listening at %s.", segment.MetricsPath, segment.BillingPath, segment.Endpoint)
+}
+
+// Returns the customer and direction of a flow, or false if the flow can not
+// be attributed.
+func (segment *Billing) classify(msg *pb.EnrichedFlow) (string, int, bool) {
+	var key string
+	switch segment.Key {
+	case "cid":
+		if msg.Cid != 0 {
+			key = strconv.FormatUint(uint64(msg.Cid), 10)
+		}
+	case "netid":
+		if msg.NetIdString != "" {
+			key = msg.NetIdString
+		} else if msg.NetId != 0 {
+			key = strconv.FormatUint(uint64(msg.NetId), 10)
+		}
+	}
+	if key == "" {
+		return "", 0, false
+	}
+
+	switch segment.Direction {
+	case "remoteaddr":
+		switch msg.RemoteAddr {
+		case pb.EnrichedFlow_Src:
+			return key, inbound, true
+		case pb.EnrichedFlow_Dst:
+			return key, outbound, true
+		}
+	case "flowdirection":
+		switch msg.FlowDirection {
+		case 0: // ingress
+			return key, inbound, true
+		case 1: // egress
+			return key, outbound, true
+		}
+	}
+	return "", 0, false
+}
+
+func (segment *Billing) account(msg *pb.EnrichedFlow) {
+	key, direction, ok := segment.classify(msg)
+	if !ok {
+		return
+	}
+	bytes, _ := msg.ScaledCounters()
+
+	segment.mutex.Lock()
+	defer segment.mutex.Unlock()
+	c, ok := segment.customers[key]
+	if !ok {
+		c = &customer{}
+		segment.customers[key] = c
+	}
+	c.bytes[direction] += bytes
+}
+
+// Takes a sample for all customers from the traffic of the interval ending at
+// the given time, discarding the samples of previous months.
+func (segment *Billing) rotate(end time.Time) {
+	segment.mutex.Lock()
+	seconds := end.Sub(segment.since).Seconds()
+	if seconds <= 0 {
+		segment.mutex.Unlock()
+		return
+	}
+	// the interval belongs to the month it started in
+	if month := segment.since.UTC().Format(monthFormat); month != segment.month {
+		log.Info().Msgf("Billing: Starting new billing period %s, discarding samples of %s.", month, segment.month)
+		segment.month = month
+		for _, c := range segment.customers {
+			c.samples = [2][]uint64{}
+		}
+		segment.current.Reset()
+		segment.monthly.Reset()
+	}
+	for _, c := range segment.customers {
+		for direction := range c.bytes {
+			c.samples[direction] = append(c.samples[direction], uint64(float64(c.bytes[direction])*8/seconds))
+			c.bytes[direction] = 0
+		}
+	}
+	segment.since = end
+	segment.mutex.Unlock()
+
+	segment.updateMetrics()
+	if segment.StateFile != "" {
+		if err := segment.save(); err != nil {
+			log.Error().Err(err).Msgf("Billing: Could not persist samples to '%s'.", segment.StateFile)
+		}
+	}
+}
+
+// Returns the given percentile of the samples of the given number of
+// intervals, i.e. the highest sample after discarding all samples above the
+// percentile. Intervals without a sample count as zero.
+func percentile(samples []uint64, intervals int, p float64) uint64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	missing := max(intervals-len(samples), 0)
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	index := int(math.Ceil(p/100*float64(len(sorted)+missing))) - 1 - missing
+	if index < 0 {
+		return 0
+	}
+	return sorted[index]
+}
+
+// Returns the number of intervals of the billing period which have ended.
+func (segment *Billing) intervals() int {
+	start, err := time.Parse(monthFormat, segment.month)
+	if err != nil {
+		return 0
+	}
+	return int(math.Ceil(float64(segment.since.Sub(start)) / float64(segment.Interval)))
+}
+
+// The values of a customer in one direction.
+type usage struct {
+	Current    uint64 `json:"current_bps"`
+	Percentile uint64 `json:"percentile_bps"`
+	Samples    int    `json:"samples"`
+}
+
+// Returns the values of all customers by direction.
+func (segment *Billing) usages() map[string][2]usage {
+	segment.mutex.Lock()
+	defer segment.mutex.Unlock()
+	result := make(map[string][2]usage, len(segment.customers))
+	intervals := segment.intervals()
+	for key, c := range segment.customers {
+		var u [2]usage
+		for direction, samples := range c.samples {
+			if len(samples) > 0 {
+				u[direction] = usage{
+					Current:    samples[len(samples)-1],
+					Percentile: percentile(samples, intervals, segment.Percentile),
+					Samples:    len(samples),
+				}
+			}
+		}
+		result[key] = u
+	}
+	return result
+}
+
+func (segment *Billing) updateMetrics() {
+	for key, u := range segment.usages() {
+		for direction := range u {
+			if u[direction].Samples == 0 {
+				continue
+			}
+			labels := []string{segment.TrafficType, key, directionNames[direction]}
+			segment.current.WithLabelValues(labels...).Set(float64(u[direction].Current))
+			segment.monthly.WithLabelValues(labels...).Set(float64(u[direction].Percentile))
+		}
+	}
+}
+
+func (segment *Billing) handleReport(w http.ResponseWriter, r *http.Request) {
+	usages := segment.usages()
+	segment.mutex.Lock()
+	report := struct {
+		Month      string                      `json:"month"`
+		Percentile float64                     `json:"percentile"`
+		Customers  map[string]map[string]usage `json:"customers"`
+	}{
+		Month:      segment.month,
+		Percentile: segment.Percentile,
+		Customers:  make(map[string]map[string]usage, len(usages)),
+	}
+	segment.mutex.Unlock()
+	for key, u := range usages {
+		report.Customers[key] = map[string]usage{
+			directionNames[inbound]:  u[inbound],
+			directionNames[outbound]: u[outbound],
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Warn().Err(err).Msg("Billing: Failed to write report.")
+	}
+}
+
+func init() {
+	segment := &Billing{}
+	segments.RegisterSegment("billing", segment)
+}
@@ -0,0 +1,109 @@
+package billing
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+var start = time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)
+
+// Billing Segment test, percentile
+func TestSegment_Billing_percentile(t *testing.T) {
+	var samples []uint64
+	for i := uint64(100); i > 0; i-- {
+		samples = append(samples, i)
+	}
+	if p := percentile(samples, 100, 95); p != 95 {
+		t.Errorf("([error] Segment Billing is not discarding the top 5%% of samples, got %d.", p)
+	}
+	if p := percentile(samples[:10], 10, 95); p != 100 {
+		t.Errorf("([error] Segment Billing is not computing percentiles of few samples correctly, got %d.", p)
+	}
+	if p := percentile(samples[:10], 100, 95); p != 95 {
+		t.Errorf("([error] Segment Billing is not counting missing intervals as zero, got %d.", p)
+	}
+	if p := percentile(samples[:4], 100, 95); p != 0 {
+		t.Errorf("([error] Segment Billing is not counting missing intervals as zero, got %d.", p)
+	}
+}
+
+// Billing Segment test, sampling by customer and direction
+func TestSegment_Billing_samples(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Billing]("billing", map[string]string{"percentile": "100"})
+	segment.setup(start)
+	segment.account(&pb.EnrichedFlow{Cid: 1, RemoteAddr: pb.EnrichedFlow_Src, Bytes: 37500, SamplingRate: 1000})
+	segment.account(&pb.EnrichedFlow{Cid: 1, RemoteAddr: pb.EnrichedFlow_Dst, Bytes: 37500000})
+	segment.account(&pb.EnrichedFlow{Cid: 0, RemoteAddr: pb.EnrichedFlow_Src, Bytes: 1})
+	segment.account(&pb.EnrichedFlow{Cid: 2, Bytes: 1})
+	segment.rotate(start.Add(5 * time.Minute))
+	segment.rotate(start.Add(10 * time.Minute))
+
+	usages := segment.usages()
+	if len(usages) != 1 {
+		t.Fatal("([error] Segment Billing is accounting flows without customer or direction.")
+	}
+	u := usages["1"]
+	if u[inbound].Samples != 2 || u[inbound].Percentile != 1000000 || u[inbound].Current != 0 {
+		t.Errorf("([error] Segment Billing is not sampling inbound rates correctly: %+v", u[inbound])
+	}
+	if u[outbound].Percentile != 1000000 {
+		t.Errorf("([error] Segment Billing is not sampling outbound rates correctly: %+v", u[outbound])
+	}
+
+	segment.rotate(start.Add(time.Hour))
+	segment.rotate(start.Add(time.Hour + 5*time.Minute))
+	if segment.month != "2024-02" || segment.usages()["1"][inbound].Samples != 1 {
+		t.Error("([error] Segment Billing is not starting a new billing period.")
+	}
+}
+
+// Billing Segment test, persistence and report
+func TestSegment_Billing_state(t *testing.T) {
+	config := map[string]string{"statefile": filepath.Join(t.TempDir(), "billing.json"), "key": "netid", "direction": "flowdirection", "percentile": "100"}
+	segment, _ := segments.NewTestSegment[*Billing]("billing", config)
+	segment.setup(start)
+	segment.account(&pb.EnrichedFlow{NetIdString: "customer", FlowDirection: 1, Bytes: 75000})
+	segment.rotate(start.Add(time.Minute))
+
+	restored, _ := segments.NewTestSegment[*Billing]("billing", config)
+	restored.setup(start.Add(2 * time.Minute))
+	u := restored.usages()["customer"]
+	if u[outbound].Samples != 1 || u[outbound].Current != 10000 {
+		t.Fatalf("([error] Segment Billing is not restoring samples: %+v", u)
+	}
+
+	recorder := httptest.NewRecorder()
+	restored.handleReport(recorder, httptest.NewRequest("GET", "/billing", nil))
+	var report struct {
+		Month     string
+		Customers map[string]map[string]usage
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Month != "2024-01" || report.Customers["customer"]["outbound"].Percentile != 10000 {
+		t.Error("([error] Segment Billing is not reporting values correctly.")
+	}
+}
+
+// Billing Segment test, customers first seen within the billing period
+func TestSegment_Billing_missingIntervals(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Billing]("billing", map[string]string{})
+	month := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	segment.setup(month)
+	for i := 1; i <= 20; i++ {
+		if i == 20 {
+			segment.account(&pb.EnrichedFlow{Cid: 1, RemoteAddr: pb.EnrichedFlow_Src, Bytes: 37500})
+		}
+		segment.rotate(month.Add(time.Duration(i) * 5 * time.Minute))
+	}
+	if u := segment.usages()["1"][inbound]; u.Samples != 1 || u.Current != 1000 || u.Percentile != 0 {
+		t.Errorf("([error] Segment Billing is not counting intervals before a customer was seen as zero: %+v", u)
+	}
+}
@@ -0,0 +1,59 @@
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// The persisted samples of a billing period.
+type state struct {
+	Month   string                 `json:"month"`
+	Samples map[string][2][]uint64 `json:"samples"` // by customer and direction
+}
+
+// Restores the samples from the state file, which is not required to exist.
+func (segment *Billing) load() error {
+	data, err := os.ReadFile(segments.ContainerVolumePrefix + segment.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	segment.mutex.Lock()
+	defer segment.mutex.Unlock()
+	// samples of a previous month are discarded by the first rotation
+	segment.month = s.Month
+	for key, samples := range s.Samples {
+		segment.customers[key] = &customer{samples: samples}
+	}
+	return nil
+}
+
+// Writes the samples to the state file, replacing it atomically.
+func (segment *Billing) save() error {
+	segment.mutex.Lock()
+	s := state{Month: segment.month, Samples: make(map[string][2][]uint64, len(segment.customers))}
+	for key, c := range segment.customers {
+		s.Samples[key] = c.samples
+	}
+	data, err := json.Marshal(s)
+	segment.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	filename := segments.ContainerVolumePrefix + segment.StateFile
+	if err := os.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
@@ -0,0 +1,317 @@
+// The `cardinality` segment estimates the number of distinct values of a field
+// per key, for instance the number of distinct sources per destination address
+// to see botnet fan-in, or the number of distinct destination ports per source
+// address to see scanner fan-out. The `pairs` parameter is a comma-separated
+// list of such pairs in the format `key/counted`, the key possibly consisting of
+// several fields joined by `+`, for example `srcaddr+dstaddr/dstport`. Fields
+// are any of `srcaddr`, `dstaddr`, `srcport`, `dstport`, `proto`, `cid`,
+// `sampleraddress`, `srcas` and `dstas`.
+//
+// Distinct values are counted in tumbling windows set by `window` using
+// HyperLogLog sketches, whose memory usage and accuracy are set by
+// `precision`. Each sketch uses 2^precision bytes with a standard error of
+// about 1.04/sqrt(2^precision). At most `maxkeys` keys are tracked per pair,
+// evicting the least recently seen one.
+//
+// At the end of each window, the estimates of all keys reaching `threshold`
+// are exported as Prometheus metrics, using the `endpoint` and `metricspath`
+// parameters. The parameter `traffictype` is passed as label of the metrics, so
+// this segment can be used multiple times in one pipeline. If `annotate` is
+// set, flows are labeled with the current estimate of their key for each pair,
+// the label being named `cardinality_<key>_<counted>` with any `+` in the key
+// replaced by `_`, so it can be referenced in flowfilter expressions.
+package cardinality
+
+import (
+	"container/list"
+	"hash/maphash"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Cardinality struct {
+	segments.BaseSegment
+	Pairs       []string      // optional, default is "dstaddr/srcaddr", pairs of key fields and counted field
+	Window      time.Duration // optional, default is 5m, duration in which distinct values are counted
+	Precision   int           // optional, default is 10, between 4 and 16, sketches use 2^precision bytes
+	MaxKeys     int           // optional, default is 10000, maximum number of keys tracked per pair
+	Threshold   uint64        // optional, default is 100, minimum estimate exported as metric
+	Annotate    bool          // optional, default is false, whether to label flows with the current estimates
+	Endpoint    string        // optional, default is ":8080"
+	MetricsPath string        // optional, default is "/metrics"
+	TrafficType string        // optional, default is ""
+
+	pairs     []*pair
+	seed      maphash.Seed
+	estimates *prometheus.GaugeVec
+}
+
+var fields = []string{"srcaddr", "dstaddr", "srcport", "dstport", "proto", "cid", "sampleraddress", "srcas", "dstas"}
+
+// A key and counted field combination, with the sketches of all keys.
+type pair struct {
+	name     string
+	key      []string
+	counted  string
+	label    string // of annotated flows
+	sketches map[string]*list.Element
+	lru      *list.List // sketches by last flow, least recently seen first
+}
+
+type sketch struct {
+	key string
+	hll *hyperLogLog
+}
+
+func (segment Cardinality) New(config map[string]string) segments.Segment {
+	var pairs = []string{"dstaddr/srcaddr"}
+	if config["pairs"] != "" {
+		pairs = nil
+		for _, p := range strings.Split(config["pairs"], ",") {
+			pairs = append(pairs, strings.TrimSpace(strings.ToLower(p)))
+		}
+	} else {
+		log.Info().Msg("Cardinality: 'pairs' set to default 'dstaddr/srcaddr'.")
+	}
+	var parsed []*pair
+	for _, name := range pairs {
+		key, counted, found := strings.Cut(name, "/")
+		if !found {
+			log.Error().Msgf("Cardinality: Pair '%s' is not in the format 'key/counted'.", name)
+			return nil
+		}
+		p := &pair{
+			name:    name,
+			key:     strings.Split(key, "+"),
+			counted: counted,
+			label:   "cardinality_" + strings.ReplaceAll(key, "+", "_") + "_" + counted,
+		}
+		for _, field := range append(slices.Clone(p.key), p.counted) {
+			if !slices.Contains(fields, field) {
+				log.Error().Msgf("Cardinality: Unknown field '%s' in pair '%s', options are %s.", field, name, strings.Join(fields, ", "))
+				return nil
+			}
+		}
+		if slices.Contains(p.key, p.counted) {
+			log.Error().Msgf("Cardinality: Pair '%s' counts a field of its key.", name)
+			return nil
+		}
+		parsed = append(parsed, p)
+	}
+
+	var window = 5 * time.Minute
+	if config["window"] != "" {
+		if parsedWindow, err := time.ParseDuration(config["window"]); err == nil && parsedWindow > 0 {
+			window = parsedWindow
+		} else {
+			log.Error().Msg("Cardinality: Could not parse 'window' parameter, using default 5m.")
+		}
+	} else {
+		log.Info().Msg("Cardinality: 'window' set to default 5m.")
+	}
+
+	var precision = 10
+	if config["precision"] != "" {
+		if parsedPrecision, err := strconv.Atoi(config["precision"]); err == nil && parsedPrecision >= 4 && parsedPrecision <= 16 {
+			precision = parsedPrecision
+		} else {
+			log.Error().Msg("Cardinality: Could not parse 'precision' parameter, using default 10.")
+		}
+	} else {
+		log.Info().Msg("Cardinality: 'precision' set to default 10.")
+	}
+
+	var maxKeys = 10000
+	if config["maxkeys"] != "" {
+		if parsedMaxKeys, err := strconv.Atoi(config["maxkeys"]); err == nil && parsedMaxKeys > 0 {
+			maxKeys = parsedMaxKeys
+		} else {
+			log.Error().Msg("Cardinality: Could not parse 'maxkeys' parameter, using default 10000.")
+		}
+	} else {
+		log.Info().Msg("Cardinality: 'maxkeys' set to default 10000.")
+	}
+
+	var threshold uint64 = 100
+	if config["threshold"] != "" {
+		if parsedThreshold, err := strconv.ParseUint(config["threshold"], 10, 64); err == nil {
+			threshold = parsedThreshold
+		} else {
+			log.Error().Msg("Cardinality: Could not parse 'threshold' parameter, using default 100.")
+		}
+	} else {
+		log.Info().Msg("Cardinality: 'threshold' set to default 100.")
+	}
+
+	var annotate bool
+	if config["annotate"] != "" {
+		if parsedAnnotate, err := strconv.ParseBool(config["annotate"]); err == nil {
+			annotate = parsedAnnotate
+		} else {
+			log.Error().Msg("Cardinality: Could not parse 'annotate' parameter, using default false.")
+		}
+	} else {
+		log.Info().Msg("Cardinality: 'annotate' set to default false.")
+	}
+
+	newsegment := &Cardinality{
+		Pairs:       pairs,
+		Window:      window,
+		Precision:   precision,
+		MaxKeys:     maxKeys,
+		Threshold:   threshold,
+		Annotate:    annotate,
+		Endpoint:    ":8080",
+		MetricsPath: "/metrics",
+		TrafficType: config["traffictype"],
+		pairs:       parsed,
+	}
+	if config["endpoint"] != "" {
+		newsegment.Endpoint = config["endpoint"]
+	} else {
+		log.Info().Msg("Cardinality: Missing configuration parameter 'endpoint'. Using default port ':8080'")
+	}
+	if config["metricspath"] != "" {
+		newsegment.MetricsPath = config["metricspath"]
+	} else {
+		log.Info().Msg("Cardinality: Missing configuration parameter 'metricspath'. Using default path '/metrics'")
+	}
+	return newsegment
+}
+
+func (segment *Cardinality) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup()
+	segment.serveMetrics()
+
+	ticker := time.NewTicker(segment.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.count(msg)
+			segment.Out <- msg
+		case <-ticker.C:
+			segment.rotate()
+		}
+	}
+}
+
+func (segment *Cardinality) setup() {
+	segment.seed = maphash.MakeSeed()
+	for _, p := range segment.pairs {
+		p.sketches = make(map[string]*list.Element)
+		p.lru = list.New()
+	}
+	segment.estimates = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cardinality_estimate",
+		Help: "Estimated number of distinct values of the counted field per key within the last window.",
+	}, []string{"traffic_type", "pair", "key"})
+}
+
+func (segment *Cardinality) serveMetrics() {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(segment.estimates)
+
+	mux := http.NewServeMux()
+	mux.Handle(segment.MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		err := http.ListenAndServe(segment.Endpoint, mux)
+		if err != nil {
+			log.Error().Err(err).Msgf("Cardinality: Failed to start http endpoint on %s", segment.Endpoint)
+		}
+	}()
+	log.Info().Msgf("Cardinality: Enabled metrics on %s, listening at %s.", segment.MetricsPath, segment.Endpoint)
+}
+
+// Adds the flow's counted values to the sketches of its keys, labeling the
+// flow with the resulting estimates if configured.
+func (segment *Cardinality) count(msg *pb.EnrichedFlow) {
+	for _, p := range segment.pairs {
+		values := make([]string, len(p.key))
+		for i, field := range p.key {
+			values[i] = format(msg, field)
+		}
+		key := strings.Join(values, ",")
+
+		var s *sketch
+		if element, ok := p.sketches[key]; ok {
+			p.lru.MoveToBack(element)
+			s = element.Value.(*sketch)
+		} else {
+			if p.lru.Len() >= segment.MaxKeys {
+				oldest := p.lru.Front()
+				delete(p.sketches, oldest.Value.(*sketch).key)
+				p.lru.Remove(oldest)
+			}
+			s = &sketch{key: key, hll: newHyperLogLog(uint8(segment.Precision))}
+			p.sketches[key] = p.lru.PushBack(s)
+		}
+		s.hll.add(maphash.String(segment.seed, format(msg, p.counted)))
+
+		if segment.Annotate {
+			msg.SetLabel(p.label, strconv.FormatUint(s.hll.estimate(), 10))
+		}
+	}
+}
+
+// Exports the estimates of the finished window and starts a new one.
+func (segment *Cardinality) rotate() {
+	segment.estimates.Reset()
+	for _, p := range segment.pairs {
+		for key, element := range p.sketches {
+			if estimate := element.Value.(*sketch).hll.estimate(); estimate >= segment.Threshold {
+				segment.estimates.WithLabelValues(segment.TrafficType, p.name, key).Set(float64(estimate))
+			}
+		}
+		p.sketches = make(map[string]*list.Element)
+		p.lru.Init()
+	}
+}
+
+// Returns a field formatted for metrics.
+func format(msg *pb.EnrichedFlow, field string) string {
+	switch field {
+	case "srcaddr":
+		return msg.SrcAddrObj().String()
+	case "dstaddr":
+		return msg.DstAddrObj().String()
+	case "srcport":
+		return strconv.FormatUint(uint64(msg.SrcPort), 10)
+	case "dstport":
+		return strconv.FormatUint(uint64(msg.DstPort), 10)
+	case "proto":
+		return strconv.FormatUint(uint64(msg.Proto), 10)
+	case "cid":
+		return strconv.FormatUint(uint64(msg.Cid), 10)
+	case "sampleraddress":
+		return msg.SamplerAddressObj().String()
+	case "srcas":
+		return strconv.FormatUint(uint64(msg.SrcAs), 10)
+	case "dstas":
+		return strconv.FormatUint(uint64(msg.DstAs), 10)
+	}
+	return ""
+}
+
+func init() {
+	segment := &Cardinality{}
+	segments.RegisterSegment("cardinality", segment)
+}
@@ -0,0 +1,79 @@
+package cardinality
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Cardinality Segment test, sketch accuracy
+func TestSegment_Cardinality_hyperLogLog(t *testing.T) {
+	seed := maphash.MakeSeed()
+	for _, n := range []uint64{10, 1000, 100000} {
+		h := newHyperLogLog(12)
+		for i := uint64(0); i < n; i++ {
+			value := binary.BigEndian.AppendUint64(nil, i)
+			h.add(maphash.Bytes(seed, value))
+			h.add(maphash.Bytes(seed, value))
+		}
+		if estimate := float64(h.estimate()); estimate < 0.9*float64(n) || estimate > 1.1*float64(n) {
+			t.Errorf("([error] Segment Cardinality is estimating %d distinct values as %.0f.", n, estimate)
+		}
+	}
+}
+
+// Cardinality Segment test, passthrough test
+func TestSegment_Cardinality_passthrough(t *testing.T) {
+	result := segments.TestSegment("cardinality", map[string]string{"endpoint": "127.0.0.1:0"},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}})
+	if result == nil {
+		t.Error("([error] Segment Cardinality is not passing through flows.")
+	}
+}
+
+// Cardinality Segment test, annotation and export
+func TestSegment_Cardinality_count(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*Cardinality]("cardinality", map[string]string{"pairs": "dstaddr/srcaddr, srcaddr+dstaddr/dstport", "annotate": "true", "threshold": "3"})
+	segment.setup()
+	var flow *pb.EnrichedFlow
+	for i := byte(1); i <= 5; i++ {
+		flow = &pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, i}, DstAddr: []byte{198, 51, 100, 1}, DstPort: 22}
+		segment.count(flow)
+	}
+	segment.count(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 2}, DstPort: 22})
+	if flow.GetLabel("cardinality_dstaddr_srcaddr") != "5" || flow.GetLabel("cardinality_srcaddr_dstaddr_dstport") != "1" {
+		t.Errorf("([error] Segment Cardinality is not annotating flows correctly: %v", flow.Labels)
+	}
+
+	segment.rotate()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(segment.estimates)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Fatal("([error] Segment Cardinality is not exporting exactly the estimates above the threshold.")
+	}
+	metric := families[0].GetMetric()[0]
+	if metric.GetGauge().GetValue() != 5 || metric.GetLabel()[0].GetValue() != "198.51.100.1" {
+		t.Errorf("([error] Segment Cardinality is exporting a wrong estimate: %v", metric)
+	}
+	if len(segment.pairs[0].sketches) != 0 {
+		t.Error("([error] Segment Cardinality is not starting a new window.")
+	}
+}
+
+// Cardinality Segment test, invalid pairs
+func TestSegment_Cardinality_invalidPairs(t *testing.T) {
+	for _, pairs := range []string{"dstaddr", "dstaddr/bytes", "srcaddr+dstport/dstport"} {
+		if segment := (Cardinality{}).New(map[string]string{"pairs": pairs}); segment != nil {
+			t.Errorf("([error] Segment Cardinality accepts invalid pairs '%s'.", pairs)
+		}
+	}
+}
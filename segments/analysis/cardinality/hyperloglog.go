@@ -0,0 +1,60 @@
+package cardinality
+
+import (
+	"math"
+	"math/bits"
+)
+
+// A HyperLogLog sketch estimating the number of distinct hashes added to it.
+// The sum and number of zero registers are maintained on each update, so that
+// estimates are available in constant time.
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+	sum       float64 // of 2^-register over all registers
+	zeros     int     // number of registers being zero
+}
+
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	m := 1 << precision
+	return &hyperLogLog{
+		precision: precision,
+		registers: make([]uint8, m),
+		sum:       float64(m),
+		zeros:     m,
+	}
+}
+
+func (h *hyperLogLog) add(hash uint64) {
+	index := hash >> (64 - h.precision)
+	// the guard bit limits the rank to 64 - precision + 1
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+	if old := h.registers[index]; rank > old {
+		if old == 0 {
+			h.zeros -= 1
+		}
+		h.sum += math.Ldexp(1, -int(rank)) - math.Ldexp(1, -int(old))
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(len(h.registers))
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / h.sum
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && h.zeros > 0 {
+		estimate = m * math.Log(m/float64(h.zeros))
+	}
+	return uint64(math.Round(estimate))
+}
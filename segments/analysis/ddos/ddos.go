@@ -0,0 +1,503 @@
+// The `ddos` segment detects volumetric DDoS attacks against single
+// destination addresses and emits events describing their lifecycle.
+//
+// Traffic is accounted per destination in intervals set by `interval`. Each
+// destination has a baseline of bits and packets per second, which is an
+// exponentially weighted moving average over roughly the duration set by
+// `baselinewindow`. A destination is considered under attack if its bits or
+// packets per second exceed both `factor` times its baseline and the absolute
+// floors given by `floorbps` and `floorpps`. The baseline is not updated during
+// an attack. An attack ends when its destination has not exceeded the
+// thresholds for the duration set by `cooldown`.
+//
+// Traffic is classified into the vectors 'udp_amplification' (UDP from well
+// known amplification source ports), 'udp_flood', 'syn_flood' (TCP with SYN
+// but without ACK flag), 'tcp', 'icmp' and 'other'. Events list all vectors
+// with at least 10% of the attack's bytes.
+//
+// Events are flows with the DstAddr of the attacked destination and the
+// DdosEvent field set to AttackStart, AttackUpdate or AttackEnd. Updates are
+// emitted every `updateinterval` while an attack is ongoing. Events contain
+// the attack's vectors, current, baseline and peak rates as well as the
+// `topn` top sources, source ports and destination ports. Bytes and Packets
+// contain the traffic of the attack so far, and TimeFlowStartNs is set to the
+// start of the attack. The number of sources and ports tracked per destination
+// is limited by `maxtracked`, and the number of destinations by
+// `maxdestinations`, evicting the least recently seen destination.
+//
+// By default, events are injected into the pipeline in addition to all flows.
+// If `eventsonly` is set, only events are passed and all other flows are
+// available to the `else` branch when using this segment as a condition of the
+// `branch` segment. Events can additionally be written as JSON lines to a file
+// given by `filename`.
+package ddos
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/list"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Ddos struct {
+	segments.BaseFilterSegment
+	Interval        time.Duration // optional, default is 10s, interval in which rates are computed and attacks are detected
+	BaselineWindow  time.Duration // optional, default is 1h, averaging window of the baseline
+	Factor          float64       // optional, default is 10, factor by which rates have to exceed the baseline
+	FloorBps        uint64        // optional, default is 1000000000, bits per second which have to be exceeded in any case
+	FloorPps        uint64        // optional, default is 100000, packets per second which have to be exceeded in any case
+	Cooldown        time.Duration // optional, default is 1m, time below thresholds after which an attack ends
+	UpdateInterval  time.Duration // optional, default is 1m, interval of update events, 0 disables them
+	TopN            int           // optional, default is 5, number of top sources and ports in events
+	MaxTracked      int           // optional, default is 1000, number of sources and ports tracked per destination
+	MaxDestinations int           // optional, default is 100000, number of destinations tracked
+	EventsOnly      bool          // optional, default is false, whether to pass only events
+	File            *os.File      // optional, default is none, file to write events to
+
+	destinations map[string]*list.Element
+	lru          *list.List // destinations by last flow, least recently seen first
+	attackId     uint64
+	writer       *bufio.Writer
+}
+
+// Source ports of protocols commonly abused for UDP amplification.
+var amplificationPorts = []uint32{
+	19,    // chargen
+	53,    // dns
+	111,   // portmap
+	123,   // ntp
+	137,   // netbios
+	161,   // snmp
+	389,   // cldap
+	1900,  // ssdp
+	3702,  // ws-discovery
+	5353,  // mdns
+	11211, // memcached
+}
+
+type destination struct {
+	address  []byte
+	counters // of the current interval
+	baseline struct {
+		bps float64
+		pps float64
+	}
+	attack *attack // nil if not under attack
+}
+
+type counters struct {
+	bytes    uint64
+	packets  uint64
+	vectors  map[string]uint64 // bytes per vector
+	sources  map[string]uint64 // bytes per source address
+	srcPorts map[uint32]uint64 // bytes per source port
+	dstPorts map[uint32]uint64 // bytes per destination port
+}
+
+type attack struct {
+	id         uint64
+	start      time.Time
+	lastAbove  time.Time
+	lastUpdate time.Time
+	bps        uint64
+	pps        uint64
+	peakBps    uint64
+	peakPps    uint64
+	counters   // of the whole attack
+}
+
+func (segment Ddos) New(config map[string]string) segments.Segment {
+	newsegment := &Ddos{
+		Interval:        10 * time.Second,
+		BaselineWindow:  time.Hour,
+		Factor:          10,
+		FloorBps:        1000000000,
+		FloorPps:        100000,
+		Cooldown:        time.Minute,
+		UpdateInterval:  time.Minute,
+		TopN:            5,
+		MaxTracked:      1000,
+		MaxDestinations: 100000,
+	}
+
+	if config["interval"] != "" {
+		if parsed, err := time.ParseDuration(config["interval"]); err == nil && parsed > 0 {
+			newsegment.Interval = parsed
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'interval' parameter, using default 10s.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'interval' set to default 10s.")
+	}
+
+	if config["baselinewindow"] != "" {
+		if parsed, err := time.ParseDuration(config["baselinewindow"]); err == nil && parsed > 0 {
+			newsegment.BaselineWindow = parsed
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'baselinewindow' parameter, using default 1h.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'baselinewindow' set to default 1h.")
+	}
+
+	if config["cooldown"] != "" {
+		if parsed, err := time.ParseDuration(config["cooldown"]); err == nil && parsed >= 0 {
+			newsegment.Cooldown = parsed
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'cooldown' parameter, using default 1m.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'cooldown' set to default 1m.")
+	}
+
+	if config["updateinterval"] != "" {
+		if parsed, err := time.ParseDuration(config["updateinterval"]); err == nil && parsed >= 0 {
+			newsegment.UpdateInterval = parsed
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'updateinterval' parameter, using default 1m.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'updateinterval' set to default 1m.")
+	}
+
+	if config["factor"] != "" {
+		if parsedFactor, err := strconv.ParseFloat(config["factor"], 64); err == nil && parsedFactor >= 1 {
+			newsegment.Factor = parsedFactor
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'factor' parameter, using default 10.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'factor' set to default 10.")
+	}
+
+	if config["floorbps"] != "" {
+		if parsedFloor, err := strconv.ParseUint(config["floorbps"], 10, 64); err == nil {
+			newsegment.FloorBps = parsedFloor
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'floorbps' parameter, using default 1000000000.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'floorbps' set to default 1000000000.")
+	}
+
+	if config["floorpps"] != "" {
+		if parsedFloor, err := strconv.ParseUint(config["floorpps"], 10, 64); err == nil {
+			newsegment.FloorPps = parsedFloor
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'floorpps' parameter, using default 100000.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'floorpps' set to default 100000.")
+	}
+
+	if config["topn"] != "" {
+		if parsed, err := strconv.Atoi(config["topn"]); err == nil && parsed > 0 {
+			newsegment.TopN = parsed
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'topn' parameter, using default 5.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'topn' set to default 5.")
+	}
+
+	if config["maxtracked"] != "" {
+		if parsed, err := strconv.Atoi(config["maxtracked"]); err == nil && parsed > 0 {
+			newsegment.MaxTracked = parsed
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'maxtracked' parameter, using default 1000.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'maxtracked' set to default 1000.")
+	}
+
+	if config["maxdestinations"] != "" {
+		if parsed, err := strconv.Atoi(config["maxdestinations"]); err == nil && parsed > 0 {
+			newsegment.MaxDestinations = parsed
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'maxdestinations' parameter, using default 100000.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'maxdestinations' set to default 100000.")
+	}
+
+	if config["eventsonly"] != "" {
+		if parsedEventsOnly, err := strconv.ParseBool(config["eventsonly"]); err == nil {
+			newsegment.EventsOnly = parsedEventsOnly
+		} else {
+			log.Error().Msg("Ddos: Could not parse 'eventsonly' parameter, using default false.")
+		}
+	} else {
+		log.Info().Msg("Ddos: 'eventsonly' set to default false.")
+	}
+
+	if config["filename"] != "" {
+		file, err := os.Create(config["filename"])
+		if err != nil {
+			log.Error().Err(err).Msg("Ddos: File specified in 'filename' is not accessible.")
+			return nil
+		}
+		newsegment.File = file
+	}
+	return newsegment
+}
+
+func (segment *Ddos) Run(wg *sync.WaitGroup) {
+	defer func() {
+		if segment.writer != nil {
+			segment.writer.Flush()
+		}
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup()
+
+	ticker := time.NewTicker(segment.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.account(msg)
+			if !segment.EventsOnly {
+				segment.Out <- msg
+			} else if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+		case now := <-ticker.C:
+			segment.evaluate(now)
+		}
+	}
+}
+
+func (segment *Ddos) setup() {
+	segment.destinations = make(map[string]*list.Element)
+	segment.lru = list.New()
+	if segment.File != nil {
+		segment.writer = bufio.NewWriter(segment.File)
+	}
+}
+
+// Adds a flow to the counters of its destination.
+func (segment *Ddos) account(msg *pb.EnrichedFlow) {
+	var dst *destination
+	if element, ok := segment.destinations[string(msg.DstAddr)]; ok {
+		segment.lru.MoveToBack(element)
+		dst = element.Value.(*destination)
+	} else {
+		if segment.lru.Len() >= segment.MaxDestinations {
+			oldest := segment.lru.Front()
+			segment.remove(oldest, time.Now())
+		}
+		dst = &destination{address: bytes.Clone(msg.DstAddr)}
+		dst.reset()
+		segment.destinations[string(msg.DstAddr)] = segment.lru.PushBack(dst)
+	}
+
+	size, packets := msg.ScaledCounters()
+	dst.bytes += size
+	dst.packets += packets
+	dst.vectors[vector(msg)] += size
+	track(dst.sources, net.IP(msg.SrcAddr).String(), size, segment.MaxTracked)
+	track(dst.srcPorts, msg.SrcPort, size, segment.MaxTracked)
+	track(dst.dstPorts, msg.DstPort, size, segment.MaxTracked)
+}
+
+// Returns the attack vector a flow belongs to.
+func vector(msg *pb.EnrichedFlow) string {
+	switch msg.Proto {
+	case 1, 58:
+		return "icmp"
+	case 6:
+		if msg.TcpFlags&0x12 == 0x02 {
+			return "syn_flood"
+		}
+		return "tcp"
+	case 17:
+		if slices.Contains(amplificationPorts, msg.SrcPort) {
+			return "udp_amplification"
+		}
+		return "udp_flood"
+	}
+	return "other"
+}
+
+// Computes the rates of the last interval for all destinations and updates
+// baselines and attacks accordingly.
+func (segment *Ddos) evaluate(now time.Time) {
+	seconds := segment.Interval.Seconds()
+	alpha := min(1, seconds/segment.BaselineWindow.Seconds())
+	for element := segment.lru.Front(); element != nil; {
+		next := element.Next()
+		dst := element.Value.(*destination)
+		bps := float64(dst.bytes*8) / seconds
+		pps := float64(dst.packets) / seconds
+		above := (bps > max(float64(segment.FloorBps), segment.Factor*dst.baseline.bps)) ||
+			(pps > max(float64(segment.FloorPps), segment.Factor*dst.baseline.pps))
+
+		if a := dst.attack; a != nil {
+			a.merge(&dst.counters, segment.MaxTracked)
+			a.bps, a.pps = uint64(bps), uint64(pps)
+			a.peakBps, a.peakPps = max(a.peakBps, a.bps), max(a.peakPps, a.pps)
+			if above {
+				a.lastAbove = now
+			}
+			if now.Sub(a.lastAbove) >= segment.Cooldown {
+				segment.emit(dst, pb.EnrichedFlow_AttackEnd, now)
+				dst.attack = nil
+			} else if segment.UpdateInterval > 0 && now.Sub(a.lastUpdate) >= segment.UpdateInterval {
+				segment.emit(dst, pb.EnrichedFlow_AttackUpdate, now)
+				a.lastUpdate = now
+			}
+		} else if above {
+			segment.attackId += 1
+			dst.attack = &attack{
+				id:         segment.attackId,
+				start:      now.Add(-segment.Interval),
+				lastAbove:  now,
+				lastUpdate: now,
+				bps:        uint64(bps),
+				pps:        uint64(pps),
+				peakBps:    uint64(bps),
+				peakPps:    uint64(pps),
+			}
+			dst.attack.counters.reset()
+			dst.attack.merge(&dst.counters, segment.MaxTracked)
+			segment.emit(dst, pb.EnrichedFlow_AttackStart, now)
+		} else {
+			dst.baseline.bps += alpha * (bps - dst.baseline.bps)
+			dst.baseline.pps += alpha * (pps - dst.baseline.pps)
+		}
+
+		if dst.attack == nil && dst.bytes == 0 && dst.baseline.bps < 1 {
+			segment.remove(element, now)
+		} else {
+			dst.reset()
+		}
+		element = next
+	}
+	if segment.writer != nil {
+		segment.writer.Flush()
+	}
+}
+
+// Stops tracking a destination, ending its attack if there is one.
+func (segment *Ddos) remove(element *list.Element, now time.Time) {
+	dst := element.Value.(*destination)
+	if dst.attack != nil {
+		segment.emit(dst, pb.EnrichedFlow_AttackEnd, now)
+	}
+	delete(segment.destinations, string(dst.address))
+	segment.lru.Remove(element)
+}
+
+func (segment *Ddos) emit(dst *destination, event pb.EnrichedFlow_DdosEventType, now time.Time) {
+	a := dst.attack
+	msg := &pb.EnrichedFlow{
+		DstAddr:         bytes.Clone(dst.address),
+		Bytes:           a.bytes,
+		Packets:         a.packets,
+		TimeReceivedNs:  uint64(now.UnixNano()),
+		TimeFlowStartNs: uint64(a.start.UnixNano()),
+		TimeFlowEndNs:   uint64(now.UnixNano()),
+		DdosEvent:       event,
+		DdosAttackId:    a.id,
+		DdosBps:         a.bps,
+		DdosPps:         a.pps,
+		DdosBaselineBps: uint64(dst.baseline.bps),
+		DdosBaselinePps: uint64(dst.baseline.pps),
+		DdosPeakBps:     a.peakBps,
+		DdosPeakPps:     a.peakPps,
+		DdosTopSources:  top(a.sources, segment.TopN),
+		DdosTopSrcPorts: top(a.srcPorts, segment.TopN),
+		DdosTopDstPorts: top(a.dstPorts, segment.TopN),
+	}
+	for _, v := range top(a.vectors, len(a.vectors)) {
+		if a.vectors[v]*10 >= a.bytes {
+			msg.DdosVectors = append(msg.DdosVectors, v)
+		}
+	}
+
+	if segment.writer != nil {
+		if data, err := protojson.Marshal(msg); err == nil {
+			segment.writer.Write(data)
+			segment.writer.WriteString("\n")
+		} else {
+			log.Warn().Err(err).Msg("Ddos: Failed to encode event as JSON.")
+		}
+	}
+	segment.Out <- msg
+}
+
+// Adds a value to a map of counters unless it already holds the maximum number
+// of entries.
+func track[K comparable](m map[K]uint64, key K, value uint64, limit int) {
+	if _, ok := m[key]; ok || len(m) < limit {
+		m[key] += value
+	}
+}
+
+// Returns the keys with the largest counters in descending order.
+func top[K cmp.Ordered](m map[K]uint64, n int) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b K) int {
+		if c := cmp.Compare(m[b], m[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return keys[:min(len(keys), n)]
+}
+
+func (c *counters) reset() {
+	c.bytes, c.packets = 0, 0
+	if c.vectors == nil {
+		c.vectors = make(map[string]uint64)
+		c.sources = make(map[string]uint64)
+		c.srcPorts = make(map[uint32]uint64)
+		c.dstPorts = make(map[uint32]uint64)
+		return
+	}
+	clear(c.vectors)
+	clear(c.sources)
+	clear(c.srcPorts)
+	clear(c.dstPorts)
+}
+
+func (a *attack) merge(c *counters, limit int) {
+	a.bytes += c.bytes
+	a.packets += c.packets
+	for key, value := range c.vectors {
+		a.vectors[key] += value
+	}
+	for key, value := range c.sources {
+		track(a.sources, key, value, limit)
+	}
+	for key, value := range c.srcPorts {
+		track(a.srcPorts, key, value, limit)
+	}
+	for key, value := range c.dstPorts {
+		track(a.dstPorts, key, value, limit)
+	}
+}
+
+func init() {
+	segment := &Ddos{}
+	segments.RegisterSegment("ddos", segment)
+}
@@ -0,0 +1,77 @@
+package ddos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+func nextEvent(out chan *pb.EnrichedFlow) *pb.EnrichedFlow {
+	select {
+	case msg := <-out:
+		return msg
+	default:
+		return nil
+	}
+}
+
+var victim = []byte{203, 0, 113, 1}
+
+// Ddos Segment test, passthrough test
+func TestSegment_Ddos_passthrough(t *testing.T) {
+	result := segments.TestSegment("ddos", map[string]string{},
+		&pb.EnrichedFlow{DstAddr: victim, Bytes: 1500})
+	if result == nil || result.Bytes != 1500 {
+		t.Error("([error] Segment Ddos is not passing through flows.")
+	}
+}
+
+// Ddos Segment test, attack lifecycle
+func TestSegment_Ddos_lifecycle(t *testing.T) {
+	segment, out := segments.NewTestSegment[*Ddos]("ddos", map[string]string{"interval": "1s", "floorbps": "8000", "floorpps": "10",
+		"cooldown": "2s", "updateinterval": "1s"})
+	segment.setup()
+	start := time.Now()
+	for i := byte(1); i <= 10; i++ {
+		segment.account(&pb.EnrichedFlow{SrcAddr: []byte{198, 51, 100, i}, DstAddr: victim, Proto: 17, SrcPort: 53,
+			DstPort: 40000, Bytes: 1000, Packets: 1, SamplingRate: 10})
+	}
+	segment.account(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: victim, Proto: 6, DstPort: 80,
+		TcpFlags: 0x02, Bytes: 60, Packets: 1})
+	segment.evaluate(start.Add(time.Second))
+	event := nextEvent(out)
+	if event == nil || event.DdosEvent != pb.EnrichedFlow_AttackStart || string(event.DstAddr) != string(victim) {
+		t.Fatal("([error] Segment Ddos is not detecting an attack.")
+	}
+	if len(event.DdosVectors) != 1 || event.DdosVectors[0] != "udp_amplification" || event.DdosBps != 800480 ||
+		len(event.DdosTopSources) != 5 || event.DdosTopSrcPorts[0] != 53 || event.DdosTopDstPorts[0] != 40000 {
+		t.Errorf("([error] Segment Ddos is not describing the attack correctly: %v", event)
+	}
+
+	segment.evaluate(start.Add(2 * time.Second))
+	if event := nextEvent(out); event == nil || event.DdosEvent != pb.EnrichedFlow_AttackUpdate || event.DdosBps != 0 {
+		t.Error("([error] Segment Ddos is not updating an ongoing attack.")
+	}
+	segment.evaluate(start.Add(3 * time.Second))
+	if event := nextEvent(out); event == nil || event.DdosEvent != pb.EnrichedFlow_AttackEnd || event.Bytes != 100060 ||
+		event.DdosPeakBps != 800480 || event.DdosAttackId != 1 {
+		t.Error("([error] Segment Ddos is not ending an attack after the cooldown.")
+	}
+}
+
+// Ddos Segment test, thresholds relative to the baseline
+func TestSegment_Ddos_baseline(t *testing.T) {
+	segment, out := segments.NewTestSegment[*Ddos]("ddos", map[string]string{"interval": "1s", "baselinewindow": "1s", "floorbps": "8000"})
+	segment.setup()
+	now := time.Now()
+	for _, size := range []uint64{500, 5000, 60000} {
+		segment.account(&pb.EnrichedFlow{DstAddr: victim, Proto: 6, Bytes: size, Packets: 1})
+		now = now.Add(time.Second)
+		segment.evaluate(now)
+		if event := nextEvent(out); (event != nil) != (size == 60000) {
+			t.Errorf("([error] Segment Ddos is not using the baseline correctly for %d bytes.", size)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+// The `heavyhitters` segment determines the keys with the most traffic, for
+// instance the top source AS and destination port combinations. The key is set
+// by the `key` parameter, a comma-separated list of `srcaddr`, `dstaddr`,
+// `srcport`, `dstport`, `proto`, `cid`, `sampleraddress`, `srcas` and `dstas`.
+// Traffic is measured in bytes, packets or flows, as set by `metric`.
+//
+// Memory usage is bounded by using the Space-Saving algorithm with a fixed
+// number of counters, set by `capacity`. Reported values are upper bounds of
+// the true values, with the maximum overestimation being reported as error.
+// The capacity should be considerably larger than the number of heavy hitters
+// reported, `topn`, to keep errors small.
+//
+// The sliding window set by `window` is divided into `buckets`, each of which
+// is tracked separately. Whenever a bucket is completed, the `topn` keys of the
+// whole window are reported and the oldest bucket is discarded. Reports are
+// either exported as Prometheus metrics, using the `endpoint` and `metricspath`
+// parameters, or written to a file given by `filename`, defaulting to stdout.
+// The parameter `traffictype` is passed as label of the Prometheus metrics, so
+// this segment can be used multiple times in one pipeline.
+//
+// If `filter` is set, only flows belonging to the heavy hitters of the last
+// report are passed, other flows are available to the `else` branch when using
+// this segment as a condition of the `branch` segment. Before the first report,
+// all flows are dropped in this mode.
+package heavyhitters
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type HeavyHitters struct {
+	segments.BaseFilterSegment
+	Key         []string      // optional, default is "dstaddr", fields identifying a heavy hitter
+	Metric      string        // optional, default is "bytes", one of "bytes", "packets" and "flows"
+	TopN        int           // optional, default is 10, number of heavy hitters per report
+	Capacity    int           // optional, default is 1000, number of counters per bucket
+	Window      time.Duration // optional, default is 5m, size of the sliding window
+	Buckets     int           // optional, default is 5, number of buckets per window, reports are made once per bucket
+	Report      string        // optional, default is "prometheus", one of "prometheus" and "file"
+	Endpoint    string        // optional, default is ":8080", relevant to 'prometheus' only
+	MetricsPath string        // optional, default is "/metrics", relevant to 'prometheus' only
+	TrafficType string        // optional, default is "", relevant to 'prometheus' only
+	Filter      bool          // optional, default is false, whether to pass only flows of heavy hitters
+
+	file     *os.File
+	sketches []*spaceSaving // ring of buckets, current is the newest
+	current  int
+	heavy    map[string]bool // keys of the last report
+	values   *prometheus.GaugeVec
+	errors   *prometheus.GaugeVec
+}
+
+var keyFields = []string{"srcaddr", "dstaddr", "srcport", "dstport", "proto", "cid", "sampleraddress", "srcas", "dstas"}
+
+// A merged counter of all buckets of the window.
+type hitter struct {
+	labels []string
+	count  uint64
+	error  uint64
+}
+
+func (segment HeavyHitters) New(config map[string]string) segments.Segment {
+	var key = []string{"dstaddr"}
+	if config["key"] != "" {
+		key = nil
+		for _, field := range strings.Split(config["key"], ",") {
+			field = strings.TrimSpace(strings.ToLower(field))
+			if !slices.Contains(keyFields, field) {
+				log.Error().Msgf("HeavyHitters: Unknown key field '%s', options are %s.", field, strings.Join(keyFields, ", "))
+				return nil
+			}
+			key = append(key, field)
+		}
+	} else {
+		log.Info().Msg("HeavyHitters: 'key' set to default 'dstaddr'.")
+	}
+
+	var metric = "bytes"
+	switch config["metric"] {
+	case "":
+		log.Info().Msg("HeavyHitters: 'metric' set to default 'bytes'.")
+	case "bytes", "packets", "flows":
+		metric = config["metric"]
+	default:
+		log.Error().Msg("HeavyHitters: The 'metric' parameter is required to be one of 'bytes', 'packets' or 'flows'.")
+		return nil
+	}
+
+	var topN = 10
+	if config["topn"] != "" {
+		if parsedTopN, err := strconv.Atoi(config["topn"]); err == nil && parsedTopN > 0 {
+			topN = parsedTopN
+		} else {
+			log.Error().Msg("HeavyHitters: Could not parse 'topn' parameter, using default 10.")
+		}
+	} else {
+		log.Info().Msg("HeavyHitters: 'topn' set to default 10.")
+	}
+
+	var capacity = 1000
+	if config["capacity"] != "" {
+		if parsedCapacity, err := strconv.Atoi(config["capacity"]); err == nil && parsedCapacity > 0 {
+			capacity = parsedCapacity
+		} else {
+			log.Error().Msg("HeavyHitters: Could not parse 'capacity' parameter, using default 1000.")
+		}
+	} else {
+		log.Info().Msg("HeavyHitters: 'capacity' set to default 1000.")
+	}
+	if capacity < topN {
+		log.Error().Msg("HeavyHitters: Capacity has to be at least as large as topn.")
+		return nil
+	}
+
+	var window = 5 * time.Minute
+	if config["window"] != "" {
+		if parsedWindow, err := time.ParseDuration(config["window"]); err == nil && parsedWindow > 0 {
+			window = parsedWindow
+		} else {
+			log.Error().Msg("HeavyHitters: Could not parse 'window' parameter, using default 5m.")
+		}
+	} else {
+		log.Info().Msg("HeavyHitters: 'window' set to default 5m.")
+	}
+
+	var buckets = 5
+	if config["buckets"] != "" {
+		if parsedBuckets, err := strconv.Atoi(config["buckets"]); err == nil && parsedBuckets > 0 {
+			buckets = parsedBuckets
+		} else {
+			log.Error().Msg("HeavyHitters: Could not parse 'buckets' parameter, using default 5.")
+		}
+	} else {
+		log.Info().Msg("HeavyHitters: 'buckets' set to default 5.")
+	}
+
+	var filter bool
+	if config["filter"] != "" {
+		if parsedFilter, err := strconv.ParseBool(config["filter"]); err == nil {
+			filter = parsedFilter
+		} else {
+			log.Error().Msg("HeavyHitters: Could not parse 'filter' parameter, using default false.")
+		}
+	} else {
+		log.Info().Msg("HeavyHitters: 'filter' set to default false.")
+	}
+
+	newsegment := &HeavyHitters{
+		Key:         key,
+		Metric:      metric,
+		TopN:        topN,
+		Capacity:    capacity,
+		Window:      window,
+		Buckets:     buckets,
+		Report:      "prometheus",
+		Endpoint:    ":8080",
+		MetricsPath: "/metrics",
+		TrafficType: config["traffictype"],
+		Filter:      filter,
+	}
+
+	switch config["report"] {
+	case "":
+		log.Info().Msg("HeavyHitters: 'report' set to default 'prometheus'.")
+	case "prometheus", "file":
+		newsegment.Report = config["report"]
+	default:
+		log.Error().Msg("HeavyHitters: The 'report' parameter is required to be one of 'prometheus' or 'file'.")
+		return nil
+	}
+
+	if newsegment.Report == "file" {
+		newsegment.file = os.Stdout
+		if config["filename"] != "" {
+			file, err := os.Create(config["filename"])
+			if err != nil {
+				log.Error().Err(err).Msg("HeavyHitters: File specified in 'filename' is not accessible.")
+				return nil
+			}
+			newsegment.file = file
+		}
+		log.Info().Msgf("HeavyHitters: configured output to %s", newsegment.file.Name())
+	} else {
+		if config["endpoint"] != "" {
+			newsegment.Endpoint = config["endpoint"]
+		} else {
+			log.Info().Msg("HeavyHitters: Missing configuration parameter 'endpoint'. Using default port ':8080'")
+		}
+		if config["metricspath"] != "" {
+			newsegment.MetricsPath = config["metricspath"]
+		} else {
+			log.Info().Msg("HeavyHitters: Missing configuration parameter 'metricspath'. Using default path '/metrics'")
+		}
+	}
+	return newsegment
+}
+
+func (segment *HeavyHitters) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup()
+	if segment.Report == "prometheus" {
+		segment.serveMetrics()
+	}
+
+	ticker := time.NewTicker(segment.Window / time.Duration(segment.Buckets))
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				segment.report(segment.top())
+				return
+			}
+			if segment.count(msg) || !segment.Filter {
+				segment.Out <- msg
+			} else if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+		case <-ticker.C:
+			segment.rotate()
+		}
+	}
+}
+
+func (segment *HeavyHitters) setup() {
+	segment.sketches = make([]*spaceSaving, segment.Buckets)
+	for i := range segment.sketches {
+		segment.sketches[i] = newSpaceSaving(segment.Capacity)
+	}
+	segment.heavy = make(map[string]bool)
+}
+
+// Adds a flow to the current bucket, returning whether it belongs to one of
+// the heavy hitters of the last report.
+func (segment *HeavyHitters) count(msg *pb.EnrichedFlow) bool {
+	var weight uint64
+	bytes, packets := msg.ScaledCounters()
+	switch segment.Metric {
+	case "bytes":
+		weight = bytes
+	case "packets":
+		weight = packets
+	case "flows":
+		weight = 1
+	}
+	labels := segment.labels(msg)
+	key := strings.Join(labels, "\x00")
+	segment.sketches[segment.current].add(key, labels, weight)
+	return segment.heavy[key]
+}
+
+// Reports the heavy hitters of the current window and starts a new bucket,
+// discarding the oldest one.
+func (segment *HeavyHitters) rotate() {
+	hitters := segment.top()
+	segment.heavy = make(map[string]bool, len(hitters))
+	for _, h := range hitters {
+		segment.heavy[strings.Join(h.labels, "\x00")] = true
+	}
+	segment.report(hitters)
+	segment.current = (segment.current + 1) % len(segment.sketches)
+	segment.sketches[segment.current] = newSpaceSaving(segment.Capacity)
+}
+
+// Merges all buckets and returns the keys with the largest counts. Keys not
+// tracked in some bucket are accounted with that bucket's smallest count, so
+// that the merged counts remain upper bounds.
+func (segment *HeavyHitters) top() []*hitter {
+	merged := make(map[string]*hitter)
+	for _, sketch := range segment.sketches {
+		for key, c := range sketch.counters {
+			h, ok := merged[key]
+			if !ok {
+				h = &hitter{labels: c.labels}
+				merged[key] = h
+			}
+			h.count += c.count
+			h.error += c.error
+		}
+	}
+	hitters := make([]*hitter, 0, len(merged))
+	for key, h := range merged {
+		for _, sketch := range segment.sketches {
+			if _, ok := sketch.counters[key]; !ok {
+				h.count += sketch.min()
+				h.error += sketch.min()
+			}
+		}
+		hitters = append(hitters, h)
+	}
+	slices.SortFunc(hitters, func(a, b *hitter) int {
+		if a.count != b.count {
+			if a.count > b.count {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a.labels, b.labels)
+	})
+	return hitters[:min(len(hitters), segment.TopN)]
+}
+
+func (segment *HeavyHitters) report(hitters []*hitter) {
+	switch segment.Report {
+	case "prometheus":
+		segment.values.Reset()
+		segment.errors.Reset()
+		for _, h := range hitters {
+			labels := append([]string{segment.TrafficType}, h.labels...)
+			segment.values.WithLabelValues(labels...).Set(float64(h.count))
+			segment.errors.WithLabelValues(labels...).Set(float64(h.error))
+		}
+	case "file":
+		writer := bufio.NewWriter(segment.file)
+		fmt.Fprintln(writer, "===================================================================")
+		for _, h := range hitters {
+			fields := make([]string, len(segment.Key))
+			for i, field := range segment.Key {
+				fields[i] = field + "=" + h.labels[i]
+			}
+			fmt.Fprintf(writer, "%s: %d %s, error %d\n", strings.Join(fields, " "), h.count, segment.Metric, h.error)
+		}
+		writer.Flush()
+	}
+}
+
+func (segment *HeavyHitters) serveMetrics() {
+	labelNames := append([]string{"traffic_type"}, segment.Key...)
+	segment.values = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heavyhitters_" + segment.Metric,
+		Help: "Number of " + segment.Metric + " of the heavy hitters within the sliding window, as an upper bound.",
+	}, labelNames)
+	segment.errors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heavyhitters_" + segment.Metric + "_error",
+		Help: "Maximum overestimation of the number of " + segment.Metric + " of the heavy hitters.",
+	}, labelNames)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(segment.values, segment.errors)
+
+	mux := http.NewServeMux()
+	mux.Handle(segment.MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		err := http.ListenAndServe(segment.Endpoint, mux)
+		if err != nil {
+			log.Error().Err(err).Msgf("HeavyHitters: Failed to start http endpoint on %s", segment.Endpoint)
+		}
+	}()
+	log.Info().Msgf("HeavyHitters: Enabled metrics on %s, listening at %s.", segment.MetricsPath, segment.Endpoint)
+}
+
+// Returns the key fields formatted for reports. Their concatenation is the key
+// used for identifying heavy hitters.
+func (segment *HeavyHitters) labels(msg *pb.EnrichedFlow) []string {
+	labels := make([]string, len(segment.Key))
+	for i, field := range segment.Key {
+		switch field {
+		case "srcaddr":
+			labels[i] = msg.SrcAddrObj().String()
+		case "dstaddr":
+			labels[i] = msg.DstAddrObj().String()
+		case "srcport":
+			labels[i] = strconv.FormatUint(uint64(msg.SrcPort), 10)
+		case "dstport":
+			labels[i] = strconv.FormatUint(uint64(msg.DstPort), 10)
+		case "proto":
+			labels[i] = strconv.FormatUint(uint64(msg.Proto), 10)
+		case "cid":
+			labels[i] = strconv.FormatUint(uint64(msg.Cid), 10)
+		case "sampleraddress":
+			labels[i] = msg.SamplerAddressObj().String()
+		case "srcas":
+			labels[i] = strconv.FormatUint(uint64(msg.SrcAs), 10)
+		case "dstas":
+			labels[i] = strconv.FormatUint(uint64(msg.DstAs), 10)
+		}
+	}
+	return labels
+}
+
+func init() {
+	segment := &HeavyHitters{}
+	segments.RegisterSegment("heavyhitters", segment)
+}
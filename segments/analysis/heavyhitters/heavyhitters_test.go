@@ -0,0 +1,83 @@
+package heavyhitters
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Space-Saving test, counter takeover
+func TestSpaceSaving_add(t *testing.T) {
+	sketch := newSpaceSaving(2)
+	sketch.add("a", []string{"a"}, 10)
+	sketch.add("b", []string{"b"}, 5)
+	sketch.add("c", []string{"c"}, 1)
+	if _, ok := sketch.counters["b"]; ok {
+		t.Error("([error] Space-Saving does not replace the smallest counter.")
+	}
+	if c := sketch.counters["c"]; c == nil || c.count != 6 || c.error != 5 {
+		t.Error("([error] Space-Saving does not inherit the count of the replaced counter as error.")
+	}
+	if sketch.min() != 6 {
+		t.Error("([error] Space-Saving does not return the smallest count.")
+	}
+}
+
+// HeavyHitters Segment test, passthrough test
+func TestSegment_HeavyHitters_passthrough(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "heavyhitters.txt")
+	result := segments.TestSegment("heavyhitters", map[string]string{"report": "file", "filename": filename, "key": "srcas,dstport"},
+		&pb.EnrichedFlow{SrcAs: 553, DstPort: 443, Bytes: 1500})
+	if result == nil {
+		t.Error("([error] Segment HeavyHitters is not passing through flows.")
+	}
+	report, err := os.ReadFile(filename)
+	if err != nil || !strings.Contains(string(report), "srcas=553 dstport=443: 1500 bytes, error 0") {
+		t.Errorf("([error] Segment HeavyHitters did not report the heavy hitter: %s", report)
+	}
+}
+
+// HeavyHitters Segment test, sliding window and filter mode
+func TestSegment_HeavyHitters_filter(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*HeavyHitters]("heavyhitters", map[string]string{"report": "file", "filename": filepath.Join(t.TempDir(), "heavyhitters.txt"),
+		"key": "dstport", "metric": "packets", "topn": "1", "buckets": "2", "filter": "true"})
+	segment.setup()
+	segment.count(&pb.EnrichedFlow{DstPort: 53, Packets: 10})
+	segment.count(&pb.EnrichedFlow{DstPort: 443, Packets: 5})
+	segment.rotate()
+	if !segment.count(&pb.EnrichedFlow{DstPort: 53, Packets: 1}) || segment.count(&pb.EnrichedFlow{DstPort: 443, Packets: 8}) {
+		t.Error("([error] Segment HeavyHitters does not select flows of the heavy hitters.")
+	}
+	segment.rotate() // the window now contains 53 with 11 and 443 with 13 packets
+	if segment.count(&pb.EnrichedFlow{DstPort: 53}) || !segment.count(&pb.EnrichedFlow{DstPort: 443}) {
+		t.Error("([error] Segment HeavyHitters does not aggregate over all buckets of the window.")
+	}
+	// the first bucket has been discarded, 443 with 8 packets remains
+	if hitters := segment.top(); len(hitters) != 1 || hitters[0].labels[0] != "443" || hitters[0].count != 8 {
+		t.Error("([error] Segment HeavyHitters does not discard old buckets.")
+	}
+}
+
+// HeavyHitters Segment test, scaling by sampling rate
+func TestSegment_HeavyHitters_sampling(t *testing.T) {
+	segment, _ := segments.NewTestSegment[*HeavyHitters]("heavyhitters", map[string]string{"report": "file", "filename": filepath.Join(t.TempDir(), "heavyhitters.txt"),
+		"key": "dstport", "topn": "1"})
+	segment.setup()
+	segment.count(&pb.EnrichedFlow{DstPort: 53, Bytes: 1000, SamplingRate: 1})
+	segment.count(&pb.EnrichedFlow{DstPort: 443, Bytes: 200, SamplingRate: 10})
+	segment.count(&pb.EnrichedFlow{DstPort: 443, Bytes: 200, SamplingRate: 10, Normalized: pb.EnrichedFlow_Yes})
+	if hitters := segment.top(); len(hitters) != 1 || hitters[0].labels[0] != "443" || hitters[0].count != 2200 {
+		t.Error("([error] Segment HeavyHitters does not scale flows by their sampling rate.")
+	}
+}
+
+// HeavyHitters Segment test, invalid configuration
+func TestSegment_HeavyHitters_invalidKey(t *testing.T) {
+	if segment := (HeavyHitters{}).New(map[string]string{"key": "srcas,color"}); segment != nil {
+		t.Error("([error] Segment HeavyHitters accepts unknown key fields.")
+	}
+}
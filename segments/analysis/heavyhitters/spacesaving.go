@@ -0,0 +1,87 @@
+package heavyhitters
+
+import (
+	"container/heap"
+)
+
+// A counter of the Space-Saving algorithm. The true value of its key is
+// somewhere between count-error and count.
+type counter struct {
+	key    string
+	labels []string // the key fields formatted for reports
+	count  uint64
+	error  uint64
+	index  int // position in the heap
+}
+
+// Implements the Space-Saving algorithm by Metwally et al., which tracks the
+// most frequent keys using a fixed number of counters. When all counters are
+// in use, the counter with the smallest count is taken over by the new key,
+// keeping its count as the maximum error.
+type spaceSaving struct {
+	capacity int
+	counters map[string]*counter
+	minHeap  counterHeap
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: capacity,
+		counters: make(map[string]*counter, capacity),
+	}
+}
+
+// Adds weight to the counter of key.
+func (s *spaceSaving) add(key string, labels []string, weight uint64) {
+	if c, ok := s.counters[key]; ok {
+		c.count += weight
+		heap.Fix(&s.minHeap, c.index)
+		return
+	}
+	if len(s.minHeap) < s.capacity {
+		c := &counter{key: key, labels: labels, count: weight}
+		heap.Push(&s.minHeap, c)
+		s.counters[key] = c
+		return
+	}
+	c := s.minHeap[0]
+	delete(s.counters, c.key)
+	c.key, c.labels = key, labels
+	c.error = c.count
+	c.count += weight
+	heap.Fix(&s.minHeap, 0)
+	s.counters[key] = c
+}
+
+// Returns the smallest count if all counters are in use, which is the upper
+// bound for the value of any key not being tracked, or 0 otherwise.
+func (s *spaceSaving) min() uint64 {
+	if len(s.minHeap) < s.capacity {
+		return 0
+	}
+	return s.minHeap[0].count
+}
+
+type counterHeap []*counter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *counterHeap) Push(x any) {
+	c := x.(*counter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
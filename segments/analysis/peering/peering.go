@@ -0,0 +1,399 @@
+// The `peering` segment analyses the traffic volume exchanged with neighbour
+// ASes, for instance to plan new private network interconnects. Flows are
+// expected to be exported on border interfaces, incoming flows being received
+// from a neighbour and outgoing flows being sent to one.
+//
+// The neighbour AS of outgoing flows is NextHopAS. For incoming flows, it is
+// the last AS of SrcAsPath as annotated by the `bgp` segment, skipping our own
+// AS given by `asn`. The origin AS is SrcAS for incoming and DstAS for outgoing
+// flows, as annotated by the router, the `bgp` or the `aslookup` segment.
+// Traffic is direct if it originates from or is destined to the neighbour AS
+// itself, and transit otherwise. If either AS is unknown, the type of traffic
+// is unknown. Interfaces are identified by the description returned by
+// Peer(), falling back to the interface index.
+//
+// Traffic is summed up in buckets of the duration set by `bucket`, separately
+// per direction, interface, neighbour AS and type of traffic. Additionally,
+// the `topn` origin ASes per direction and neighbour AS are reported, tracking
+// at most `maxorigins` combinations of neighbour and origin AS per bucket.
+// Each completed bucket is exported in the ways given by the
+// comma-separated `export` parameter:
+//
+//   - 'prometheus' exports the counters `peering_bytes_total` and
+//     `peering_packets_total` as well as the gauge `peering_origin_bytes` with
+//     the bytes of the top origin ASes in the last bucket. All are labeled with
+//     `traffic_type` as set by `traffictype`. The `endpoint` and `metricspath`
+//     parameters determine where they are served.
+//
+//   - 'csv' writes each bucket to the file given by `filename`, defaulting to
+//     stdout. The `record` column distinguishes 'neighbor' and 'origin' rows.
+package peering
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Peering struct {
+	segments.BaseSegment
+	Asn         uint32        // optional, default is 0, own AS skipped in AS paths
+	Bucket      time.Duration // optional, default is 5m, duration of buckets
+	TopN        int           // optional, default is 10, number of origin ASes reported per neighbour AS
+	MaxOrigins  int           // optional, default is 100000, maximum number of neighbour and origin AS combinations per bucket
+	Export      []string      // optional, default is "prometheus", any of "prometheus" and "csv"
+	Endpoint    string        // optional, default is ":8080", relevant to 'prometheus' only
+	MetricsPath string        // optional, default is "/metrics", relevant to 'prometheus' only
+	TrafficType string        // optional, default is "", relevant to 'prometheus' only
+	File        *os.File      // optional, default is stdout, relevant to 'csv' only
+
+	neighbors   map[neighborKey]*volume
+	origins     map[originKey]*volume
+	bucketStart time.Time
+	writer      *bufio.Writer
+	bytes       *prometheus.CounterVec
+	packets     *prometheus.CounterVec
+	originBytes *prometheus.GaugeVec
+}
+
+type neighborKey struct {
+	direction string
+	iface     string
+	neighbor  uint32
+	kind      string // direct, transit or unknown
+}
+
+type originKey struct {
+	direction string
+	neighbor  uint32
+	origin    uint32
+}
+
+type volume struct {
+	bytes   uint64
+	packets uint64
+}
+
+var exports = []string{"prometheus", "csv"}
+
+func (segment Peering) New(config map[string]string) segments.Segment {
+	newsegment := &Peering{
+		Bucket:      5 * time.Minute,
+		TopN:        10,
+		MaxOrigins:  100000,
+		Export:      []string{"prometheus"},
+		Endpoint:    ":8080",
+		MetricsPath: "/metrics",
+		TrafficType: config["traffictype"],
+	}
+
+	if config["asn"] != "" {
+		if parsedAsn, err := strconv.ParseUint(config["asn"], 10, 32); err == nil {
+			newsegment.Asn = uint32(parsedAsn)
+		} else {
+			log.Error().Msg("Peering: Could not parse 'asn' parameter.")
+			return nil
+		}
+	} else {
+		log.Info().Msg("Peering: No 'asn' set, AS paths are expected not to contain our own AS.")
+	}
+
+	if config["bucket"] != "" {
+		if parsedBucket, err := time.ParseDuration(config["bucket"]); err == nil && parsedBucket > 0 {
+			newsegment.Bucket = parsedBucket
+		} else {
+			log.Error().Msg("Peering: Could not parse 'bucket' parameter, using default 5m.")
+		}
+	} else {
+		log.Info().Msg("Peering: 'bucket' set to default 5m.")
+	}
+
+	if config["topn"] != "" {
+		if parsedTopN, err := strconv.Atoi(config["topn"]); err == nil && parsedTopN >= 0 {
+			newsegment.TopN = parsedTopN
+		} else {
+			log.Error().Msg("Peering: Could not parse 'topn' parameter, using default 10.")
+		}
+	} else {
+		log.Info().Msg("Peering: 'topn' set to default 10.")
+	}
+
+	if config["maxorigins"] != "" {
+		if parsedMaxOrigins, err := strconv.Atoi(config["maxorigins"]); err == nil && parsedMaxOrigins > 0 {
+			newsegment.MaxOrigins = parsedMaxOrigins
+		} else {
+			log.Error().Msg("Peering: Could not parse 'maxorigins' parameter, using default 100000.")
+		}
+	} else {
+		log.Info().Msg("Peering: 'maxorigins' set to default 100000.")
+	}
+
+	if config["export"] != "" {
+		newsegment.Export = nil
+		for _, export := range strings.Split(config["export"], ",") {
+			export = strings.TrimSpace(strings.ToLower(export))
+			if !slices.Contains(exports, export) {
+				log.Error().Msgf("Peering: Unknown export '%s', options are %s.", export, strings.Join(exports, ", "))
+				return nil
+			}
+			newsegment.Export = append(newsegment.Export, export)
+		}
+	} else {
+		log.Info().Msg("Peering: 'export' set to default 'prometheus'.")
+	}
+
+	if slices.Contains(newsegment.Export, "prometheus") {
+		if config["endpoint"] != "" {
+			newsegment.Endpoint = config["endpoint"]
+		} else {
+			log.Info().Msg("Peering: Missing configuration parameter 'endpoint'. Using default port ':8080'")
+		}
+		if config["metricspath"] != "" {
+			newsegment.MetricsPath = config["metricspath"]
+		} else {
+			log.Info().Msg("Peering: Missing configuration parameter 'metricspath'. Using default path '/metrics'")
+		}
+	}
+
+	if slices.Contains(newsegment.Export, "csv") {
+		newsegment.File = os.Stdout
+		if config["filename"] != "" {
+			file, err := os.Create(config["filename"])
+			if err != nil {
+				log.Error().Err(err).Msg("Peering: File specified in 'filename' is not accessible.")
+				return nil
+			}
+			newsegment.File = file
+		}
+		log.Info().Msgf("Peering: configured output to %s", newsegment.File.Name())
+	}
+	return newsegment
+}
+
+func (segment *Peering) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup(time.Now())
+	if slices.Contains(segment.Export, "prometheus") {
+		segment.serveMetrics()
+	}
+
+	ticker := time.NewTicker(segment.Bucket)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				segment.flush(time.Now())
+				return
+			}
+			segment.account(msg)
+			segment.Out <- msg
+		case now := <-ticker.C:
+			segment.flush(now)
+		}
+	}
+}
+
+func (segment *Peering) setup(now time.Time) {
+	segment.neighbors = make(map[neighborKey]*volume)
+	segment.origins = make(map[originKey]*volume)
+	segment.bucketStart = now
+	if segment.File != nil {
+		segment.writer = bufio.NewWriter(segment.File)
+		fmt.Fprintln(segment.writer, "time,record,direction,interface,neighbor_as,origin_as,type,bytes,packets")
+	}
+}
+
+// Returns the direction, interface, neighbour AS and origin AS of a flow.
+func (segment *Peering) classify(msg *pb.EnrichedFlow) (string, string, uint32, uint32) {
+	iface := msg.Peer()
+	if msg.IsIncoming() {
+		if iface == "" {
+			iface = strconv.FormatUint(uint64(msg.InIf), 10)
+		}
+		var neighbor uint32
+		path := msg.SrcAsPath
+		if len(path) > 0 && segment.Asn != 0 && path[len(path)-1] == segment.Asn {
+			path = path[:len(path)-1]
+		}
+		if len(path) > 0 {
+			neighbor = path[len(path)-1]
+		}
+		return "incoming", iface, neighbor, msg.SrcAs
+	}
+	if iface == "" {
+		iface = strconv.FormatUint(uint64(msg.OutIf), 10)
+	}
+	return "outgoing", iface, msg.NextHopAs, msg.DstAs
+}
+
+// Adds a flow to the volumes of the current bucket.
+func (segment *Peering) account(msg *pb.EnrichedFlow) {
+	if !msg.IsIncoming() && !msg.IsOutgoing() {
+		return
+	}
+	direction, iface, neighbor, origin := segment.classify(msg)
+	kind := "transit"
+	if neighbor == 0 || origin == 0 {
+		kind = "unknown"
+	} else if neighbor == origin {
+		kind = "direct"
+	}
+
+	bytes, packets := msg.ScaledCounters()
+
+	nKey := neighborKey{direction: direction, iface: iface, neighbor: neighbor, kind: kind}
+	n, ok := segment.neighbors[nKey]
+	if !ok {
+		n = &volume{}
+		segment.neighbors[nKey] = n
+	}
+	n.bytes += bytes
+	n.packets += packets
+
+	if neighbor == 0 || origin == 0 {
+		return
+	}
+	oKey := originKey{direction: direction, neighbor: neighbor, origin: origin}
+	o, ok := segment.origins[oKey]
+	if !ok {
+		if len(segment.origins) >= segment.MaxOrigins {
+			return
+		}
+		o = &volume{}
+		segment.origins[oKey] = o
+	}
+	o.bytes += bytes
+	o.packets += packets
+}
+
+// Returns the top origin ASes of each direction and neighbour AS, sorted by
+// direction, neighbour AS and descending bytes.
+func (segment *Peering) topOrigins() []originKey {
+	keys := make([]originKey, 0, len(segment.origins))
+	for key := range segment.origins {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b originKey) int {
+		return cmp.Or(
+			cmp.Compare(a.direction, b.direction),
+			cmp.Compare(a.neighbor, b.neighbor),
+			cmp.Compare(segment.origins[b].bytes, segment.origins[a].bytes),
+			cmp.Compare(a.origin, b.origin),
+		)
+	})
+
+	var top []originKey
+	var rank int
+	for i, key := range keys {
+		if i == 0 || key.direction != keys[i-1].direction || key.neighbor != keys[i-1].neighbor {
+			rank = 0
+		}
+		if rank < segment.TopN {
+			top = append(top, key)
+		}
+		rank += 1
+	}
+	return top
+}
+
+// Exports all volumes of the current bucket and starts a new one.
+func (segment *Peering) flush(now time.Time) {
+	keys := make([]neighborKey, 0, len(segment.neighbors))
+	for key := range segment.neighbors {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b neighborKey) int {
+		return cmp.Or(
+			cmp.Compare(a.direction, b.direction),
+			cmp.Compare(a.iface, b.iface),
+			cmp.Compare(a.neighbor, b.neighbor),
+			cmp.Compare(a.kind, b.kind),
+		)
+	})
+	origins := segment.topOrigins()
+
+	for _, export := range segment.Export {
+		switch export {
+		case "prometheus":
+			for _, key := range keys {
+				n := segment.neighbors[key]
+				labels := []string{segment.TrafficType, key.direction, key.iface, strconv.FormatUint(uint64(key.neighbor), 10), key.kind}
+				segment.bytes.WithLabelValues(labels...).Add(float64(n.bytes))
+				segment.packets.WithLabelValues(labels...).Add(float64(n.packets))
+			}
+			segment.originBytes.Reset()
+			for _, key := range origins {
+				labels := []string{segment.TrafficType, key.direction, strconv.FormatUint(uint64(key.neighbor), 10), strconv.FormatUint(uint64(key.origin), 10)}
+				segment.originBytes.WithLabelValues(labels...).Set(float64(segment.origins[key].bytes))
+			}
+		case "csv":
+			for _, key := range keys {
+				n := segment.neighbors[key]
+				fmt.Fprintf(segment.writer, "%d,neighbor,%s,%s,%d,,%s,%d,%d\n", segment.bucketStart.Unix(), key.direction, key.iface, key.neighbor, key.kind, n.bytes, n.packets)
+			}
+			for _, key := range origins {
+				o := segment.origins[key]
+				kind := "transit"
+				if key.neighbor == key.origin {
+					kind = "direct"
+				}
+				fmt.Fprintf(segment.writer, "%d,origin,%s,,%d,%d,%s,%d,%d\n", segment.bucketStart.Unix(), key.direction, key.neighbor, key.origin, kind, o.bytes, o.packets)
+			}
+			segment.writer.Flush()
+		}
+	}
+	clear(segment.neighbors)
+	clear(segment.origins)
+	segment.bucketStart = now
+}
+
+func (segment *Peering) serveMetrics() {
+	labelNames := []string{"traffic_type", "direction", "interface", "neighbor_as", "type"}
+	segment.bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "peering_bytes_total",
+		Help: "Number of bytes exchanged with the neighbour AS on the given interface.",
+	}, labelNames)
+	segment.packets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "peering_packets_total",
+		Help: "Number of packets exchanged with the neighbour AS on the given interface.",
+	}, labelNames)
+	segment.originBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "peering_origin_bytes",
+		Help: "Number of bytes of the top origin ASes exchanged through the neighbour AS in the last bucket.",
+	}, []string{"traffic_type", "direction", "neighbor_as", "origin_as"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(segment.bytes, segment.packets, segment.originBytes)
+
+	mux := http.NewServeMux()
+	mux.Handle(segment.MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		err := http.ListenAndServe(segment.Endpoint, mux)
+		if err != nil {
+			log.Error().Err(err).Msgf("Peering: Failed to start http endpoint on %s", segment.Endpoint)
+		}
+	}()
+	log.Info().Msgf("Peering: Enabled metrics on %s, listening at %s.", segment.MetricsPath, segment.Endpoint)
+}
+
+func init() {
+	segment := &Peering{}
+	segments.RegisterSegment("peering", segment)
+}
@@ -0,0 +1,78 @@
+package peering
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Runs the given flows through a peering segment, returning the number of
+// flows passed.
+func runPeering(config map[string]string, flows ...*pb.EnrichedFlow) int {
+	segment := segments.LookupSegment("peering").New(config)
+	if segment == nil {
+		log.Fatal().Msg("Configured segment 'peering' could not be initialized properly, see previous messages.")
+	}
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(wg)
+
+	var passed int
+	done := make(chan struct{})
+	go func() {
+		for range out {
+			passed += 1
+		}
+		close(done)
+	}()
+	for _, flow := range flows {
+		in <- flow
+	}
+	close(in)
+	wg.Wait()
+	<-done
+	return passed
+}
+
+// Peering Segment test, csv export
+func TestSegment_Peering_csv(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "peering.csv")
+	passed := runPeering(map[string]string{"export": "csv", "filename": filename, "asn": "553", "topn": "1"},
+		// incoming from a neighbour, originating from itself and from its customers
+		&pb.EnrichedFlow{FlowDirection: 0, SrcIfDesc: "pni-1", SrcAs: 64496, SrcAsPath: []uint32{64496, 553}, Bytes: 100, Packets: 1},
+		&pb.EnrichedFlow{FlowDirection: 0, SrcIfDesc: "pni-1", SrcAs: 64511, SrcAsPath: []uint32{64511, 64496, 553}, Bytes: 300, Packets: 3},
+		&pb.EnrichedFlow{FlowDirection: 0, SrcIfDesc: "pni-1", SrcAs: 64510, SrcAsPath: []uint32{64510, 64496, 553}, Bytes: 20, Packets: 1, SamplingRate: 10},
+		// outgoing without any routing information
+		&pb.EnrichedFlow{FlowDirection: 1, OutIf: 7, Bytes: 50, Packets: 1})
+	if passed != 4 {
+		t.Error("([error] Segment Peering is not passing through flows.")
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		",neighbor,incoming,pni-1,64496,,direct,100,1\n",
+		",neighbor,incoming,pni-1,64496,,transit,500,13\n",
+		",neighbor,outgoing,7,0,,unknown,50,1\n",
+		",origin,incoming,,64496,64511,transit,300,3\n",
+	} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("([error] Segment Peering is not reporting '%s' correctly: %s", strings.TrimSpace(line), data)
+		}
+	}
+	if strings.Contains(string(data), ",64510,") {
+		t.Error("([error] Segment Peering is reporting more than the top origin ASes.")
+	}
+}
@@ -0,0 +1,333 @@
+// The `scandetect` segment detects port scans and address sweeps. Horizontal
+// scans are sources contacting at least `horizontal` distinct destination
+// addresses on the same port, vertical scans are sources contacting at least
+// `vertical` distinct ports on the same destination address, both within the
+// sliding window set by `window`. Setting either threshold to 0 disables the
+// respective detection.
+//
+// Only connection attempts are counted, so that servers answering many clients
+// are not mistaken for scanners. These are TCP flows with the SYN but not the
+// ACK flag set, ICMP and ICMPv6 echo requests, and flows of other protocols to
+// ports below `ephemeralports`, the first port of the range clients usually
+// connect from. Distinct targets are counted using sets bounded by the
+// thresholds, and at most `maxkeys` source and port or source and destination
+// combinations are tracked, evicting the least recently seen one.
+//
+// Flows of a detected scan have the Scan field set to HorizontalScan or
+// VerticalScan, the former taking precedence if both apply. A detection lasts
+// until the scan's targets have not been seen for the duration of the window.
+// Once a scan is detected, an event is emitted, which is a flow with the
+// ScanEvent field set, the scanning SrcAddr, the scanned DstPort or DstAddr
+// respectively, and ScanTargets set to the number of distinct targets.
+//
+// By default, events are injected into the pipeline in addition to all flows.
+// If `eventsonly` is set, only events are passed and all other flows are
+// available to the `else` branch when using this segment as a condition of the
+// `branch` segment. Events can additionally be written as JSON lines to a file
+// given by `filename`.
+package scandetect
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type ScanDetect struct {
+	segments.BaseFilterSegment
+	Horizontal     int           // optional, default is 100, distinct destination addresses on a port constituting a horizontal scan, 0 disables
+	Vertical       int           // optional, default is 100, distinct ports on a destination address constituting a vertical scan, 0 disables
+	Window         time.Duration // optional, default is 1m, sliding window in which targets are counted
+	MaxKeys        int           // optional, default is 100000, maximum number of combinations tracked
+	EphemeralPorts uint32        // optional, default is 32768, first port considered a client port for protocols other than TCP
+	EventsOnly     bool          // optional, default is false, whether to pass only events
+	File           *os.File      // optional, default is none, file to write events to
+
+	candidates map[string]*list.Element
+	lru        *list.List // candidates by last flow, least recently seen first
+	writer     *bufio.Writer
+}
+
+// A source and port or source and destination combination which might be
+// scanning.
+type candidate struct {
+	key      string
+	scan     pb.EnrichedFlow_ScanType
+	targets  map[string]time.Time // last seen time by target
+	detected bool
+}
+
+func (segment ScanDetect) New(config map[string]string) segments.Segment {
+	var horizontal = 100
+	if config["horizontal"] != "" {
+		if parsedHorizontal, err := strconv.Atoi(config["horizontal"]); err == nil && parsedHorizontal >= 0 {
+			horizontal = parsedHorizontal
+		} else {
+			log.Error().Msg("ScanDetect: Could not parse 'horizontal' parameter, using default 100.")
+		}
+	} else {
+		log.Info().Msg("ScanDetect: 'horizontal' set to default 100.")
+	}
+
+	var vertical = 100
+	if config["vertical"] != "" {
+		if parsedVertical, err := strconv.Atoi(config["vertical"]); err == nil && parsedVertical >= 0 {
+			vertical = parsedVertical
+		} else {
+			log.Error().Msg("ScanDetect: Could not parse 'vertical' parameter, using default 100.")
+		}
+	} else {
+		log.Info().Msg("ScanDetect: 'vertical' set to default 100.")
+	}
+	if horizontal == 0 && vertical == 0 {
+		log.Error().Msg("ScanDetect: Disabling both detections corresponds to no-op. Remove this segment or set a threshold.")
+		return nil
+	}
+
+	var window = time.Minute
+	if config["window"] != "" {
+		if parsedWindow, err := time.ParseDuration(config["window"]); err == nil && parsedWindow > 0 {
+			window = parsedWindow
+		} else {
+			log.Error().Msg("ScanDetect: Could not parse 'window' parameter, using default 1m.")
+		}
+	} else {
+		log.Info().Msg("ScanDetect: 'window' set to default 1m.")
+	}
+
+	var maxKeys = 100000
+	if config["maxkeys"] != "" {
+		if parsedMaxKeys, err := strconv.Atoi(config["maxkeys"]); err == nil && parsedMaxKeys > 0 {
+			maxKeys = parsedMaxKeys
+		} else {
+			log.Error().Msg("ScanDetect: Could not parse 'maxkeys' parameter, using default 100000.")
+		}
+	} else {
+		log.Info().Msg("ScanDetect: 'maxkeys' set to default 100000.")
+	}
+
+	var ephemeralPorts uint32 = 32768
+	if config["ephemeralports"] != "" {
+		if parsedEphemeralPorts, err := strconv.ParseUint(config["ephemeralports"], 10, 16); err == nil {
+			ephemeralPorts = uint32(parsedEphemeralPorts)
+		} else {
+			log.Error().Msg("ScanDetect: Could not parse 'ephemeralports' parameter, using default 32768.")
+		}
+	} else {
+		log.Info().Msg("ScanDetect: 'ephemeralports' set to default 32768.")
+	}
+
+	var eventsOnly bool
+	if config["eventsonly"] != "" {
+		if parsedEventsOnly, err := strconv.ParseBool(config["eventsonly"]); err == nil {
+			eventsOnly = parsedEventsOnly
+		} else {
+			log.Error().Msg("ScanDetect: Could not parse 'eventsonly' parameter, using default false.")
+		}
+	} else {
+		log.Info().Msg("ScanDetect: 'eventsonly' set to default false.")
+	}
+
+	var file *os.File
+	if config["filename"] != "" {
+		var err error
+		file, err = os.Create(config["filename"])
+		if err != nil {
+			log.Error().Err(err).Msg("ScanDetect: File specified in 'filename' is not accessible.")
+			return nil
+		}
+	}
+
+	return &ScanDetect{
+		Horizontal:     horizontal,
+		Vertical:       vertical,
+		Window:         window,
+		MaxKeys:        maxKeys,
+		EventsOnly:     eventsOnly,
+		File:           file,
+		EphemeralPorts: ephemeralPorts,
+	}
+}
+
+func (segment *ScanDetect) Run(wg *sync.WaitGroup) {
+	defer func() {
+		if segment.writer != nil {
+			segment.writer.Flush()
+		}
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup()
+
+	ticker := time.NewTicker(segment.Window / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.inspect(msg, time.Now())
+			if !segment.EventsOnly {
+				segment.Out <- msg
+			} else if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+		case now := <-ticker.C:
+			segment.expire(now.Add(-segment.Window))
+		}
+	}
+}
+
+func (segment *ScanDetect) setup() {
+	segment.candidates = make(map[string]*list.Element)
+	segment.lru = list.New()
+	if segment.File != nil {
+		segment.writer = bufio.NewWriter(segment.File)
+	}
+}
+
+// Adds the flow's target to its candidates, emitting events for new
+// detections and tagging the flow if it belongs to a detected scan.
+func (segment *ScanDetect) inspect(msg *pb.EnrichedFlow, now time.Time) {
+	if !segment.isAttempt(msg) {
+		return
+	}
+	var scan pb.EnrichedFlow_ScanType
+	if segment.Vertical > 0 {
+		key := "v" + string(msg.SrcAddr) + "\x00" + string(msg.DstAddr)
+		port := string(binary.BigEndian.AppendUint32(nil, msg.DstPort))
+		if segment.add(key, pb.EnrichedFlow_VerticalScan, port, segment.Vertical, msg, now) {
+			scan = pb.EnrichedFlow_VerticalScan
+		}
+	}
+	if segment.Horizontal > 0 {
+		key := "h" + string(msg.SrcAddr) + "\x00" + string(binary.BigEndian.AppendUint32(nil, msg.DstPort))
+		if segment.add(key, pb.EnrichedFlow_HorizontalScan, string(msg.DstAddr), segment.Horizontal, msg, now) {
+			scan = pb.EnrichedFlow_HorizontalScan
+		}
+	}
+	msg.Scan = scan
+}
+
+// Returns whether a flow is a connection attempt. Flows may span several
+// packets, hence TCP flows with the ACK flag set are ignored even if the SYN
+// flag is set as well.
+func (segment *ScanDetect) isAttempt(msg *pb.EnrichedFlow) bool {
+	switch msg.Proto {
+	case 6:
+		return msg.TcpFlags&0x12 == 0x02
+	case 1, 58:
+		return isEchoRequest(msg)
+	}
+	return msg.DstPort < segment.EphemeralPorts
+}
+
+// Returns whether an ICMP or ICMPv6 flow is an echo request. The ICMP type is
+// either set in IcmpType or encoded in DstPort as type * 256 + code.
+func isEchoRequest(msg *pb.EnrichedFlow) bool {
+	var echoRequest uint32 = 8
+	if msg.Proto == 58 {
+		echoRequest = 128
+	}
+	return msg.IcmpType == echoRequest || msg.DstPort/256 == echoRequest
+}
+
+// Adds a target to a candidate, returning whether the candidate is a detected
+// scan.
+func (segment *ScanDetect) add(key string, scan pb.EnrichedFlow_ScanType, target string, threshold int, msg *pb.EnrichedFlow, now time.Time) bool {
+	var c *candidate
+	if element, ok := segment.candidates[key]; ok {
+		segment.lru.MoveToBack(element)
+		c = element.Value.(*candidate)
+	} else {
+		if segment.lru.Len() >= segment.MaxKeys {
+			oldest := segment.lru.Front()
+			delete(segment.candidates, oldest.Value.(*candidate).key)
+			segment.lru.Remove(oldest)
+		}
+		c = &candidate{key: key, scan: scan, targets: make(map[string]time.Time)}
+		segment.candidates[key] = segment.lru.PushBack(c)
+	}
+
+	// the set is bounded by the threshold, as more targets are irrelevant
+	if _, ok := c.targets[target]; ok || len(c.targets) < threshold {
+		c.targets[target] = now
+	}
+	if !c.detected && len(c.targets) >= threshold {
+		c.detected = true
+		segment.emit(c, msg, now)
+	}
+	return c.detected
+}
+
+// Removes targets last seen before the given time and candidates without any
+// remaining targets.
+func (segment *ScanDetect) expire(before time.Time) {
+	for element := segment.lru.Front(); element != nil; {
+		next := element.Next()
+		c := element.Value.(*candidate)
+		for target, seen := range c.targets {
+			if seen.Before(before) {
+				delete(c.targets, target)
+			}
+		}
+		if len(c.targets) == 0 {
+			delete(segment.candidates, c.key)
+			segment.lru.Remove(element)
+		}
+		element = next
+	}
+}
+
+func (segment *ScanDetect) emit(c *candidate, msg *pb.EnrichedFlow, now time.Time) {
+	first := now
+	for _, seen := range c.targets {
+		if seen.Before(first) {
+			first = seen
+		}
+	}
+	event := &pb.EnrichedFlow{
+		SrcAddr:         bytes.Clone(msg.SrcAddr),
+		Proto:           msg.Proto,
+		TimeReceivedNs:  uint64(now.UnixNano()),
+		TimeFlowStartNs: uint64(first.UnixNano()),
+		TimeFlowEndNs:   uint64(now.UnixNano()),
+		Scan:            c.scan,
+		ScanEvent:       true,
+		ScanTargets:     uint64(len(c.targets)),
+	}
+	switch c.scan {
+	case pb.EnrichedFlow_HorizontalScan:
+		event.DstPort = msg.DstPort
+	case pb.EnrichedFlow_VerticalScan:
+		event.DstAddr = bytes.Clone(msg.DstAddr)
+	}
+
+	if segment.writer != nil {
+		if data, err := protojson.Marshal(event); err == nil {
+			segment.writer.Write(data)
+			segment.writer.WriteString("\n")
+			segment.writer.Flush()
+		} else {
+			log.Warn().Err(err).Msg("ScanDetect: Failed to encode event as JSON.")
+		}
+	}
+	segment.Out <- event
+}
+
+func init() {
+	segment := &ScanDetect{}
+	segments.RegisterSegment("scandetect", segment)
+}
@@ -0,0 +1,111 @@
+package scandetect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+var scanner = []byte{198, 51, 100, 1}
+
+// ScanDetect Segment test, passthrough test
+func TestSegment_ScanDetect_passthrough(t *testing.T) {
+	result := segments.TestSegment("scandetect", map[string]string{},
+		&pb.EnrichedFlow{SrcAddr: scanner, DstAddr: []byte{203, 0, 113, 1}, Proto: 6, DstPort: 22})
+	if result == nil || result.Scan != pb.EnrichedFlow_NoScan {
+		t.Error("([error] Segment ScanDetect is not passing through flows.")
+	}
+}
+
+// ScanDetect Segment test, horizontal scan
+func TestSegment_ScanDetect_horizontal(t *testing.T) {
+	segment, out := segments.NewTestSegment[*ScanDetect]("scandetect", map[string]string{"horizontal": "5", "vertical": "0"})
+	segment.setup()
+	now := time.Now()
+	var flows []*pb.EnrichedFlow
+	for i := byte(1); i <= 5; i++ {
+		flow := &pb.EnrichedFlow{SrcAddr: scanner, DstAddr: []byte{203, 0, 113, i}, Proto: 6, DstPort: 22, TcpFlags: 0x02}
+		segment.inspect(flow, now)
+		flows = append(flows, flow)
+	}
+	if flows[3].Scan != pb.EnrichedFlow_NoScan || flows[4].Scan != pb.EnrichedFlow_HorizontalScan {
+		t.Error("([error] Segment ScanDetect is not tagging flows of horizontal scans.")
+	}
+	if len(out) != 1 {
+		t.Fatal("([error] Segment ScanDetect is not emitting exactly one event per scan.")
+	}
+	if event := <-out; !event.ScanEvent || event.DstPort != 22 || event.ScanTargets != 5 {
+		t.Error("([error] Segment ScanDetect is not describing horizontal scans correctly.")
+	}
+
+	segment.expire(now.Add(time.Second))
+	flow := &pb.EnrichedFlow{SrcAddr: scanner, DstAddr: []byte{203, 0, 113, 1}, Proto: 6, DstPort: 22, TcpFlags: 0x02}
+	segment.inspect(flow, now.Add(time.Second))
+	if flow.Scan != pb.EnrichedFlow_NoScan {
+		t.Error("([error] Segment ScanDetect is not expiring old targets.")
+	}
+}
+
+// ScanDetect Segment test, vertical scan and responders
+func TestSegment_ScanDetect_vertical(t *testing.T) {
+	segment, out := segments.NewTestSegment[*ScanDetect]("scandetect", map[string]string{"horizontal": "0", "vertical": "3"})
+	segment.setup()
+	now := time.Now()
+	for _, port := range []uint32{22, 80, 443} {
+		// responses of a server to many clients ports are not a scan
+		segment.inspect(&pb.EnrichedFlow{SrcAddr: scanner, DstAddr: []byte{203, 0, 113, 1}, Proto: 6, DstPort: port + 40000, TcpFlags: 0x12}, now)
+		segment.inspect(&pb.EnrichedFlow{SrcAddr: scanner, DstAddr: []byte{203, 0, 113, 2}, Proto: 6, DstPort: port, TcpFlags: 0x02}, now)
+	}
+	if len(out) != 1 {
+		t.Fatal("([error] Segment ScanDetect is not detecting vertical scans or not ignoring responders.")
+	}
+	if event := <-out; event.Scan != pb.EnrichedFlow_VerticalScan || event.DstAddr[3] != 2 {
+		t.Error("([error] Segment ScanDetect is not describing vertical scans correctly.")
+	}
+}
+
+// ScanDetect Segment test, servers answering many clients
+func TestSegment_ScanDetect_responses(t *testing.T) {
+	segment, out := segments.NewTestSegment[*ScanDetect]("scandetect", map[string]string{"horizontal": "3", "vertical": "3"})
+	segment.setup()
+	now := time.Now()
+	server := []byte{203, 0, 113, 1}
+	for i := byte(1); i <= 5; i++ {
+		for _, port := range []uint32{41000, 52000, 60000} {
+			segment.inspect(&pb.EnrichedFlow{SrcAddr: server, DstAddr: []byte{198, 51, 100, i}, Proto: 6, SrcPort: 443, DstPort: port + uint32(i), TcpFlags: 0x10}, now)
+			segment.inspect(&pb.EnrichedFlow{SrcAddr: server, DstAddr: []byte{198, 51, 100, i}, Proto: 17, SrcPort: 53, DstPort: port + uint32(i)}, now)
+		}
+	}
+	if len(out) != 0 {
+		t.Error("([error] Segment ScanDetect is mistaking responses of a server for a scan.")
+	}
+	segment.inspect(&pb.EnrichedFlow{SrcAddr: server, DstAddr: []byte{198, 51, 100, 1}, Proto: 17, DstPort: 161}, now)
+	segment.inspect(&pb.EnrichedFlow{SrcAddr: server, DstAddr: []byte{198, 51, 100, 2}, Proto: 17, DstPort: 161}, now)
+	segment.inspect(&pb.EnrichedFlow{SrcAddr: server, DstAddr: []byte{198, 51, 100, 3}, Proto: 17, DstPort: 161}, now)
+	if len(out) != 1 {
+		t.Error("([error] Segment ScanDetect is not detecting scans using protocols other than TCP.")
+	}
+}
+
+// ScanDetect Segment test, ICMP echo requests and replies
+func TestSegment_ScanDetect_icmp(t *testing.T) {
+	segment, out := segments.NewTestSegment[*ScanDetect]("scandetect", map[string]string{"horizontal": "3", "vertical": "0"})
+	segment.setup()
+	now := time.Now()
+	server := []byte{203, 0, 113, 1}
+	for i := byte(1); i <= 5; i++ {
+		segment.inspect(&pb.EnrichedFlow{SrcAddr: server, DstAddr: []byte{198, 51, 100, i}, Proto: 1}, now)
+		segment.inspect(&pb.EnrichedFlow{SrcAddr: server, DstAddr: []byte{198, 51, 100, i}, Proto: 58, IcmpType: 129}, now)
+	}
+	if len(out) != 0 {
+		t.Error("([error] Segment ScanDetect is mistaking echo replies for a scan.")
+	}
+	for i := byte(1); i <= 3; i++ {
+		segment.inspect(&pb.EnrichedFlow{SrcAddr: scanner, DstAddr: []byte{198, 51, 100, i}, Proto: 1, DstPort: 8 * 256}, now)
+	}
+	if len(out) != 1 {
+		t.Error("([error] Segment ScanDetect is not detecting ping sweeps.")
+	}
+}
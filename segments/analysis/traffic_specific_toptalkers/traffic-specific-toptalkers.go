@@ -11,12 +11,12 @@ import (
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/BelWue/flowfilter/parser"
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/pipeline/config"
 	"github.com/BelWue/flowpipeline/segments"
 	"github.com/BelWue/flowpipeline/segments/analysis/toptalkers_metrics"
 	"github.com/BelWue/flowpipeline/segments/filter/flowfilter"
+	"github.com/BelWue/flowpipeline/segments/filter/flowfilter/parser"
 )
 
 type TrafficSpecificToptalkers struct {
@@ -84,7 +84,9 @@ func (segment *TrafficSpecificToptalkers) metricFromDefinition(definition *confi
 		metric.RelevantAddress = segment.RelevantAddress
 	}
 
-	metric.Expression, err = parser.Parse(definition.FilterDefinition)
+	metric.Expression, err = parser.ParseWithResolver(definition.FilterDefinition, func(filename string) string {
+		return segments.ContainerVolumePrefix + filename
+	})
 	if err != nil {
 		log.Error().Err(err).Msgf("ThresholdToptalkersMetrics: Syntax error in filter expression\"%s\"", definition.FilterDefinition)
 		return nil, err
@@ -0,0 +1,84 @@
+package trafficmatrix
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/BelWue/flowpipeline/pb"
+)
+
+// A flow field by which the matrix can be indexed.
+type dimension struct {
+	format func(msg *pb.EnrichedFlow) string
+	copy   func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow)
+}
+
+func formatUint(value uint32) string {
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+// Formats a network ID as set by the `addnetid` segment, which sets either the
+// string or the integer variant depending on its `useintids` parameter.
+func formatId(value uint32, valueString string) string {
+	if valueString != "" {
+		return valueString
+	}
+	return formatUint(value)
+}
+
+var dimensions = map[string]dimension{
+	"netid": {
+		func(msg *pb.EnrichedFlow) string { return formatId(msg.NetId, msg.NetIdString) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) {
+			dst.NetId, dst.NetIdString = src.NetId, src.NetIdString
+		},
+	},
+	"srcid": {
+		func(msg *pb.EnrichedFlow) string { return formatId(msg.SrcId, msg.SrcIdString) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) {
+			dst.SrcId, dst.SrcIdString = src.SrcId, src.SrcIdString
+		},
+	},
+	"dstid": {
+		func(msg *pb.EnrichedFlow) string { return formatId(msg.DstId, msg.DstIdString) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) {
+			dst.DstId, dst.DstIdString = src.DstId, src.DstIdString
+		},
+	},
+	"cid": {
+		func(msg *pb.EnrichedFlow) string { return formatUint(msg.Cid) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.Cid = src.Cid },
+	},
+	"srcas": {
+		func(msg *pb.EnrichedFlow) string { return formatUint(msg.SrcAs) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.SrcAs = src.SrcAs },
+	},
+	"dstas": {
+		func(msg *pb.EnrichedFlow) string { return formatUint(msg.DstAs) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.DstAs = src.DstAs },
+	},
+	"sampleraddress": {
+		func(msg *pb.EnrichedFlow) string { return msg.SamplerAddressObj().String() },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.SamplerAddress = bytes.Clone(src.SamplerAddress) },
+	},
+	"inif": {
+		func(msg *pb.EnrichedFlow) string { return formatUint(msg.InIf) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.InIf = src.InIf },
+	},
+	"outif": {
+		func(msg *pb.EnrichedFlow) string { return formatUint(msg.OutIf) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.OutIf = src.OutIf },
+	},
+	"srccountry": {
+		func(msg *pb.EnrichedFlow) string { return msg.SrcCountry },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.SrcCountry = src.SrcCountry },
+	},
+	"dstcountry": {
+		func(msg *pb.EnrichedFlow) string { return msg.DstCountry },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.DstCountry = src.DstCountry },
+	},
+	"proto": {
+		func(msg *pb.EnrichedFlow) string { return formatUint(msg.Proto) },
+		func(dst *pb.EnrichedFlow, src *pb.EnrichedFlow) { dst.Proto = src.Proto },
+	},
+}
@@ -0,0 +1,331 @@
+// The `trafficmatrix` segment aggregates traffic into a matrix between two
+// dimensions, for instance between source and destination networks. The
+// dimensions are set by the `rows` and `columns` parameters, each being one of
+// `netid`, `srcid`, `dstid`, `cid`, `srcas`, `dstas`, `sampleraddress`,
+// `inif`, `outif`, `srccountry`, `dstcountry` and `proto`. Network IDs set by
+// the `addnetid` segment are used in their string variant if it is set.
+//
+// Traffic is summed up in buckets of the duration set by `bucket`, and each
+// completed bucket is exported in the ways given by the comma-separated
+// `export` parameter:
+//
+//   - 'prometheus' exports the counters `trafficmatrix_bytes_total` and
+//     `trafficmatrix_packets_total`, labeled with both dimensions and
+//     `traffic_type` as set by `traffictype`. The `endpoint` and `metricspath`
+//     parameters determine where they are served.
+//
+//   - 'csv' and 'json' write a snapshot of each bucket to the file given by
+//     `filename`, defaulting to stdout. Only one of them can be used at a time.
+//
+//   - 'flows' injects summary flows into the pipeline, which contain the fields
+//     of both dimensions, the sums of Bytes and Packets, the bucket's time range
+//     and the label `trafficmatrix` with the dimensions.
+//
+// At most `maxcells` cells are kept, traffic of further cells is accounted to a
+// cell with both dimensions set to "other".
+package trafficmatrix
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type TrafficMatrix struct {
+	segments.BaseSegment
+	Rows        string        // optional, default is "srcid", dimension of the rows
+	Columns     string        // optional, default is "dstid", dimension of the columns
+	Bucket      time.Duration // optional, default is 1m, duration of buckets
+	Export      []string      // optional, default is "prometheus", any of "prometheus", "csv", "json" and "flows"
+	MaxCells    int           // optional, default is 10000, maximum number of cells per bucket
+	Endpoint    string        // optional, default is ":8080", relevant to 'prometheus' only
+	MetricsPath string        // optional, default is "/metrics", relevant to 'prometheus' only
+	TrafficType string        // optional, default is "", relevant to 'prometheus' only
+	File        *os.File      // optional, default is stdout, relevant to 'csv' and 'json' only
+
+	cells       map[cellKey]*cell
+	bucketStart time.Time
+	writer      *bufio.Writer
+	bytes       *prometheus.CounterVec
+	packets     *prometheus.CounterVec
+}
+
+type cellKey struct {
+	row    string
+	column string
+}
+
+type cell struct {
+	template *pb.EnrichedFlow // the dimension fields of this cell
+	bytes    uint64
+	packets  uint64
+}
+
+var exports = []string{"prometheus", "csv", "json", "flows"}
+
+func (segment TrafficMatrix) New(config map[string]string) segments.Segment {
+	newsegment := &TrafficMatrix{
+		Rows:        "srcid",
+		Columns:     "dstid",
+		Bucket:      time.Minute,
+		Export:      []string{"prometheus"},
+		MaxCells:    10000,
+		Endpoint:    ":8080",
+		MetricsPath: "/metrics",
+		TrafficType: config["traffictype"],
+	}
+
+	if config["rows"] != "" {
+		newsegment.Rows = strings.ToLower(config["rows"])
+	} else {
+		log.Info().Msg("TrafficMatrix: 'rows' set to default 'srcid'.")
+	}
+	if config["columns"] != "" {
+		newsegment.Columns = strings.ToLower(config["columns"])
+	} else {
+		log.Info().Msg("TrafficMatrix: 'columns' set to default 'dstid'.")
+	}
+	for _, dim := range []string{newsegment.Rows, newsegment.Columns} {
+		if _, ok := dimensions[dim]; !ok {
+			log.Error().Msgf("TrafficMatrix: Unknown dimension '%s'.", dim)
+			return nil
+		}
+	}
+	if newsegment.Rows == newsegment.Columns {
+		log.Error().Msg("TrafficMatrix: Rows and columns have to be different dimensions.")
+		return nil
+	}
+
+	if config["bucket"] != "" {
+		if parsedBucket, err := time.ParseDuration(config["bucket"]); err == nil && parsedBucket > 0 {
+			newsegment.Bucket = parsedBucket
+		} else {
+			log.Error().Msg("TrafficMatrix: Could not parse 'bucket' parameter, using default 1m.")
+		}
+	} else {
+		log.Info().Msg("TrafficMatrix: 'bucket' set to default 1m.")
+	}
+
+	if config["export"] != "" {
+		newsegment.Export = nil
+		for _, export := range strings.Split(config["export"], ",") {
+			export = strings.TrimSpace(strings.ToLower(export))
+			if !slices.Contains(exports, export) {
+				log.Error().Msgf("TrafficMatrix: Unknown export '%s', options are %s.", export, strings.Join(exports, ", "))
+				return nil
+			}
+			newsegment.Export = append(newsegment.Export, export)
+		}
+		if slices.Contains(newsegment.Export, "csv") && slices.Contains(newsegment.Export, "json") {
+			log.Error().Msg("TrafficMatrix: Exports 'csv' and 'json' can not be used at the same time.")
+			return nil
+		}
+	} else {
+		log.Info().Msg("TrafficMatrix: 'export' set to default 'prometheus'.")
+	}
+
+	if config["maxcells"] != "" {
+		if parsedMaxCells, err := strconv.Atoi(config["maxcells"]); err == nil && parsedMaxCells > 0 {
+			newsegment.MaxCells = parsedMaxCells
+		} else {
+			log.Error().Msg("TrafficMatrix: Could not parse 'maxcells' parameter, using default 10000.")
+		}
+	} else {
+		log.Info().Msg("TrafficMatrix: 'maxcells' set to default 10000.")
+	}
+
+	if slices.Contains(newsegment.Export, "prometheus") {
+		if config["endpoint"] != "" {
+			newsegment.Endpoint = config["endpoint"]
+		} else {
+			log.Info().Msg("TrafficMatrix: Missing configuration parameter 'endpoint'. Using default port ':8080'")
+		}
+		if config["metricspath"] != "" {
+			newsegment.MetricsPath = config["metricspath"]
+		} else {
+			log.Info().Msg("TrafficMatrix: Missing configuration parameter 'metricspath'. Using default path '/metrics'")
+		}
+	}
+
+	if slices.Contains(newsegment.Export, "csv") || slices.Contains(newsegment.Export, "json") {
+		newsegment.File = os.Stdout
+		if config["filename"] != "" {
+			file, err := os.Create(config["filename"])
+			if err != nil {
+				log.Error().Err(err).Msg("TrafficMatrix: File specified in 'filename' is not accessible.")
+				return nil
+			}
+			newsegment.File = file
+		}
+		log.Info().Msgf("TrafficMatrix: configured output to %s", newsegment.File.Name())
+	}
+	return newsegment
+}
+
+func (segment *TrafficMatrix) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup(time.Now())
+	if slices.Contains(segment.Export, "prometheus") {
+		segment.serveMetrics()
+	}
+
+	ticker := time.NewTicker(segment.Bucket)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				segment.flush(time.Now())
+				return
+			}
+			segment.account(msg)
+			segment.Out <- msg
+		case now := <-ticker.C:
+			segment.flush(now)
+		}
+	}
+}
+
+func (segment *TrafficMatrix) setup(now time.Time) {
+	segment.cells = make(map[cellKey]*cell)
+	segment.bucketStart = now
+	if segment.File != nil {
+		segment.writer = bufio.NewWriter(segment.File)
+		if slices.Contains(segment.Export, "csv") {
+			fmt.Fprintf(segment.writer, "time,%s,%s,bytes,packets\n", segment.Rows, segment.Columns)
+		}
+	}
+}
+
+// Adds a flow to its cell of the current bucket.
+func (segment *TrafficMatrix) account(msg *pb.EnrichedFlow) {
+	rows, columns := dimensions[segment.Rows], dimensions[segment.Columns]
+	key := cellKey{row: rows.format(msg), column: columns.format(msg)}
+	c, ok := segment.cells[key]
+	if !ok {
+		if len(segment.cells) >= segment.MaxCells {
+			key = cellKey{row: "other", column: "other"}
+			c = segment.cells[key]
+		}
+		if c == nil {
+			c = &cell{template: &pb.EnrichedFlow{}}
+			if key.row != "other" || key.column != "other" {
+				rows.copy(c.template, msg)
+				columns.copy(c.template, msg)
+			}
+			segment.cells[key] = c
+		}
+	}
+
+	bytes, packets := msg.ScaledCounters()
+	c.bytes += bytes
+	c.packets += packets
+}
+
+// Exports all cells of the current bucket and starts a new one.
+func (segment *TrafficMatrix) flush(now time.Time) {
+	keys := make([]cellKey, 0, len(segment.cells))
+	for key := range segment.cells {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b cellKey) int {
+		return cmp.Or(cmp.Compare(a.row, b.row), cmp.Compare(a.column, b.column))
+	})
+
+	for _, key := range keys {
+		c := segment.cells[key]
+		for _, export := range segment.Export {
+			switch export {
+			case "prometheus":
+				segment.bytes.WithLabelValues(segment.TrafficType, key.row, key.column).Add(float64(c.bytes))
+				segment.packets.WithLabelValues(segment.TrafficType, key.row, key.column).Add(float64(c.packets))
+			case "csv":
+				fmt.Fprintf(segment.writer, "%d,%s,%s,%d,%d\n", segment.bucketStart.Unix(), key.row, key.column, c.bytes, c.packets)
+			case "json":
+				data, err := json.Marshal(map[string]any{
+					"time":          segment.bucketStart.Unix(),
+					segment.Rows:    key.row,
+					segment.Columns: key.column,
+					"bytes":         c.bytes,
+					"packets":       c.packets,
+				})
+				if err != nil {
+					log.Warn().Err(err).Msg("TrafficMatrix: Failed to encode cell as JSON.")
+					continue
+				}
+				segment.writer.Write(data)
+				segment.writer.WriteString("\n")
+			case "flows":
+				segment.Out <- segment.summary(c, now)
+			}
+		}
+	}
+	if segment.writer != nil {
+		segment.writer.Flush()
+	}
+	clear(segment.cells)
+	segment.bucketStart = now
+}
+
+// Returns a summary flow for a cell of the current bucket.
+func (segment *TrafficMatrix) summary(c *cell, now time.Time) *pb.EnrichedFlow {
+	msg := &pb.EnrichedFlow{
+		Bytes:           c.bytes,
+		Packets:         c.packets,
+		Normalized:      pb.EnrichedFlow_Yes,
+		TimeReceivedNs:  uint64(now.UnixNano()),
+		TimeFlowStartNs: uint64(segment.bucketStart.UnixNano()),
+		TimeFlowEndNs:   uint64(now.UnixNano()),
+	}
+	dimensions[segment.Rows].copy(msg, c.template)
+	dimensions[segment.Columns].copy(msg, c.template)
+	msg.SetLabel("trafficmatrix", segment.Rows+"/"+segment.Columns)
+	return msg
+}
+
+func (segment *TrafficMatrix) serveMetrics() {
+	labelNames := []string{"traffic_type", segment.Rows, segment.Columns}
+	segment.bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "trafficmatrix_bytes_total",
+		Help: "Number of bytes between the given dimensions.",
+	}, labelNames)
+	segment.packets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "trafficmatrix_packets_total",
+		Help: "Number of packets between the given dimensions.",
+	}, labelNames)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(segment.bytes, segment.packets)
+
+	mux := http.NewServeMux()
+	mux.Handle(segment.MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		err := http.ListenAndServe(segment.Endpoint, mux)
+		if err != nil {
+			log.Error().Err(err).Msgf("TrafficMatrix: Failed to start http endpoint on %s", segment.Endpoint)
+		}
+	}()
+	log.Info().Msgf("TrafficMatrix: Enabled metrics on %s, listening at %s.", segment.MetricsPath, segment.Endpoint)
+}
+
+func init() {
+	segment := &TrafficMatrix{}
+	segments.RegisterSegment("trafficmatrix", segment)
+}
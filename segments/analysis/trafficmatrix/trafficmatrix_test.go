@@ -0,0 +1,110 @@
+package trafficmatrix
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Runs the given flows through a trafficmatrix segment, returning all flows
+// emitted by it.
+func runTrafficMatrix(config map[string]string, flows ...*pb.EnrichedFlow) []*pb.EnrichedFlow {
+	segment := segments.LookupSegment("trafficmatrix").New(config)
+	if segment == nil {
+		log.Fatal().Msg("Configured segment 'trafficmatrix' could not be initialized properly, see previous messages.")
+	}
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(wg)
+
+	var result []*pb.EnrichedFlow
+	done := make(chan struct{})
+	go func() {
+		for msg := range out {
+			result = append(result, msg)
+		}
+		close(done)
+	}()
+	for _, flow := range flows {
+		in <- flow
+	}
+	close(in)
+	wg.Wait()
+	<-done
+	return result
+}
+
+// TrafficMatrix Segment test, csv export
+func TestSegment_TrafficMatrix_csv(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "matrix.csv")
+	result := runTrafficMatrix(map[string]string{"export": "csv", "filename": filename},
+		&pb.EnrichedFlow{SrcId: 1, DstId: 2, Bytes: 100, Packets: 1},
+		&pb.EnrichedFlow{SrcId: 1, DstId: 2, Bytes: 100, Packets: 1, SamplingRate: 10},
+		&pb.EnrichedFlow{SrcId: 2, DstId: 1, Bytes: 50, Packets: 1})
+	if len(result) != 3 {
+		t.Error("([error] Segment TrafficMatrix is not passing through flows.")
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"time,srcid,dstid,bytes,packets\n", ",1,2,1100,11\n", ",2,1,50,1\n"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("([error] Segment TrafficMatrix is not writing the matrix correctly: %s", data)
+		}
+	}
+}
+
+// TrafficMatrix Segment test, string network IDs
+func TestSegment_TrafficMatrix_stringIds(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "matrix.csv")
+	runTrafficMatrix(map[string]string{"export": "csv", "filename": filename, "rows": "netid", "columns": "dstid"},
+		&pb.EnrichedFlow{NetIdString: "campus", DstIdString: "dorms", Bytes: 100, Packets: 1},
+		&pb.EnrichedFlow{NetIdString: "campus", DstIdString: "labs", Bytes: 50, Packets: 1},
+		&pb.EnrichedFlow{NetId: 3, DstId: 4, Bytes: 10, Packets: 1})
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"time,netid,dstid,bytes,packets\n", ",campus,dorms,100,1\n", ",campus,labs,50,1\n", ",3,4,10,1\n"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("([error] Segment TrafficMatrix is not using string network IDs: %s", data)
+		}
+	}
+}
+
+// TrafficMatrix Segment test, summary flows and cell limit
+func TestSegment_TrafficMatrix_flows(t *testing.T) {
+	result := runTrafficMatrix(map[string]string{"export": "flows", "rows": "cid", "columns": "srcas", "maxcells": "1"},
+		&pb.EnrichedFlow{Cid: 1, SrcAs: 553, Bytes: 100},
+		&pb.EnrichedFlow{Cid: 1, SrcAs: 64496, Bytes: 10},
+		&pb.EnrichedFlow{Cid: 2, SrcAs: 553, Bytes: 20})
+	if len(result) != 5 {
+		t.Fatal("([error] Segment TrafficMatrix is not emitting summary flows.")
+	}
+	cell, other := result[3], result[4]
+	if cell.Cid != 1 || cell.SrcAs != 553 || cell.Bytes != 100 || cell.GetLabel("trafficmatrix") != "cid/srcas" {
+		t.Error("([error] Segment TrafficMatrix is not describing cells correctly.")
+	}
+	if other.Cid != 0 || other.SrcAs != 0 || other.Bytes != 30 {
+		t.Error("([error] Segment TrafficMatrix is not accounting traffic beyond the cell limit.")
+	}
+}
+
+// TrafficMatrix Segment test, invalid configuration
+func TestSegment_TrafficMatrix_sameDimensions(t *testing.T) {
+	if segment := (TrafficMatrix{}).New(map[string]string{"rows": "srcas", "columns": "srcas"}); segment != nil {
+		t.Error("([error] Segment TrafficMatrix accepts identical dimensions.")
+	}
+}
@@ -0,0 +1,220 @@
+// The `biflow` segment stitches unidirectional flows and their reverse flows
+// into bidirectional flows. Two flows are considered reverse flows of each other
+// if their addresses and ports are swapped and their protocol is the same.
+//
+// Flows are kept in a cache until their reverse flow arrives or the `timeout`
+// passes. When a reverse flow is found, a single flow is emitted, with the
+// source being the initiator of the connection and the `ReverseBytes`,
+// `ReversePackets` and `ReverseTcpFlags` fields containing the counters of the
+// responder's flow. The initiator is determined by TCP flags if exactly one of
+// the flows contains a SYN without ACK. As TCP flags are cumulative over all
+// packets of a flow, this only applies to flows in which the handshake has not
+// completed, for instance a single SYN packet. Otherwise, if exactly one of the
+// ports is a well-known port (< 1024), the flow towards it is the initiator,
+// and the earlier start time decides in all remaining cases. The method used is
+// reflected in the `BiflowInitiator` field. Flows without a reverse flow are
+// emitted unchanged after the timeout, as are cached flows for which another
+// flow with the same direction arrives. Flows between the same address and port
+// on both sides are never stitched, as their reverse flow can not be told
+// apart.
+//
+// The cache is limited to `maxentries` flows. When it is full, the oldest flow
+// is emitted unchanged to make room for a new one.
+package biflow
+
+import (
+	"container/list"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Biflow struct {
+	segments.BaseSegment
+	Timeout    time.Duration // optional, default is 30s, time to wait for a reverse flow
+	MaxEntries int           // optional, default is 100000, maximum number of flows waiting for a reverse flow
+
+	cache map[flowKey]*list.Element
+	queue *list.List // cached flows by arrival, oldest first
+}
+
+type flowKey struct {
+	srcAddr string
+	dstAddr string
+	srcPort uint32
+	dstPort uint32
+	proto   uint32
+}
+
+type cacheEntry struct {
+	key     flowKey
+	flow    *pb.EnrichedFlow
+	arrived time.Time
+}
+
+func newFlowKey(flow *pb.EnrichedFlow) flowKey {
+	return flowKey{
+		srcAddr: string(net.IP(flow.SrcAddr).To16()),
+		dstAddr: string(net.IP(flow.DstAddr).To16()),
+		srcPort: flow.SrcPort,
+		dstPort: flow.DstPort,
+		proto:   flow.Proto,
+	}
+}
+
+func (key flowKey) reverse() flowKey {
+	return flowKey{
+		srcAddr: key.dstAddr,
+		dstAddr: key.srcAddr,
+		srcPort: key.dstPort,
+		dstPort: key.srcPort,
+		proto:   key.proto,
+	}
+}
+
+func (segment Biflow) New(config map[string]string) segments.Segment {
+	var timeout = 30 * time.Second
+	if config["timeout"] != "" {
+		if parsedTimeout, err := time.ParseDuration(config["timeout"]); err == nil {
+			if parsedTimeout <= 0 {
+				log.Error().Msg("Biflow: Timeout has to be > 0.")
+				return nil
+			}
+			timeout = parsedTimeout
+		} else {
+			log.Error().Msg("Biflow: Could not parse 'timeout' parameter, using default 30s.")
+		}
+	} else {
+		log.Info().Msg("Biflow: 'timeout' set to default 30s.")
+	}
+
+	var maxEntries = 100000
+	if config["maxentries"] != "" {
+		if parsedMaxEntries, err := strconv.Atoi(config["maxentries"]); err == nil {
+			if parsedMaxEntries <= 0 {
+				log.Error().Msg("Biflow: MaxEntries has to be > 0.")
+				return nil
+			}
+			maxEntries = parsedMaxEntries
+		} else {
+			log.Error().Msg("Biflow: Could not parse 'maxentries' parameter, using default 100000.")
+		}
+	} else {
+		log.Info().Msg("Biflow: 'maxentries' set to default 100000.")
+	}
+
+	return &Biflow{
+		Timeout:    timeout,
+		MaxEntries: maxEntries,
+	}
+}
+
+func (segment *Biflow) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.cache = make(map[flowKey]*list.Element)
+	segment.queue = list.New()
+
+	ticker := time.NewTicker(segment.Timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				segment.expire(time.Time{}) // flush all remaining flows
+				return
+			}
+			segment.insert(msg, time.Now())
+		case now := <-ticker.C:
+			segment.expire(now.Add(-segment.Timeout))
+		}
+	}
+}
+
+// Either stitches a flow with its cached reverse flow, or adds it to the cache.
+func (segment *Biflow) insert(msg *pb.EnrichedFlow, now time.Time) {
+	key := newFlowKey(msg)
+	if reverse := key.reverse(); reverse != key {
+		if element, ok := segment.cache[reverse]; ok {
+			segment.remove(element)
+			segment.Out <- stitch(element.Value.(*cacheEntry).flow, msg)
+			return
+		}
+	}
+	if element, ok := segment.cache[key]; ok {
+		segment.remove(element)
+		segment.Out <- element.Value.(*cacheEntry).flow
+	}
+	if segment.queue.Len() >= segment.MaxEntries {
+		oldest := segment.queue.Front()
+		segment.remove(oldest)
+		segment.Out <- oldest.Value.(*cacheEntry).flow
+	}
+	segment.cache[key] = segment.queue.PushBack(&cacheEntry{key: key, flow: msg, arrived: now})
+}
+
+// Emits all cached flows which arrived before the given time, or all flows if
+// it is zero.
+func (segment *Biflow) expire(before time.Time) {
+	for element := segment.queue.Front(); element != nil; element = segment.queue.Front() {
+		entry := element.Value.(*cacheEntry)
+		if !before.IsZero() && !entry.arrived.Before(before) {
+			return
+		}
+		segment.remove(element)
+		segment.Out <- entry.flow
+	}
+}
+
+func (segment *Biflow) remove(element *list.Element) {
+	delete(segment.cache, element.Value.(*cacheEntry).key)
+	segment.queue.Remove(element)
+}
+
+// Merges two flows into a single one, the first flow being the one which
+// arrived first.
+func stitch(first *pb.EnrichedFlow, second *pb.EnrichedFlow) *pb.EnrichedFlow {
+	initiator, responder := first, second
+	method := pb.EnrichedFlow_FirstSeen
+	if first.Proto == 6 && isSyn(first) != isSyn(second) {
+		method = pb.EnrichedFlow_TcpSyn
+		if isSyn(second) {
+			initiator, responder = second, first
+		}
+	} else if first.SrcPort < 1024 != (first.DstPort < 1024) {
+		method = pb.EnrichedFlow_ServicePort
+		if first.SrcPort < 1024 {
+			initiator, responder = second, first
+		}
+	} else if second.TimeFlowStartNs < first.TimeFlowStartNs {
+		initiator, responder = second, first
+	}
+
+	initiator.ReverseBytes = responder.Bytes
+	initiator.ReversePackets = responder.Packets
+	initiator.ReverseTcpFlags = responder.TcpFlags
+	initiator.BiflowInitiator = method
+	if responder.TimeFlowEndNs > initiator.TimeFlowEndNs {
+		initiator.TimeFlowEndNs = responder.TimeFlowEndNs
+	}
+	return initiator
+}
+
+// Checks whether a flow contains a SYN without ACK, i.e. whether it is the
+// initiating side of a TCP connection whose handshake has not completed.
+func isSyn(flow *pb.EnrichedFlow) bool {
+	return flow.TcpFlags&0x12 == 0x02
+}
+
+func init() {
+	segment := &Biflow{}
+	segments.RegisterSegment("biflow", segment)
+}
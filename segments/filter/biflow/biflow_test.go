@@ -0,0 +1,128 @@
+package biflow
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+func runBiflow(config map[string]string, flows ...*pb.EnrichedFlow) []*pb.EnrichedFlow {
+	segment := segments.LookupSegment("biflow").New(config)
+	if segment == nil {
+		log.Fatal().Msg("Configured segment 'biflow' could not be initialized properly, see previous messages.")
+	}
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(wg)
+
+	var results []*pb.EnrichedFlow
+	done := make(chan struct{})
+	go func() {
+		for msg := range out {
+			results = append(results, msg)
+		}
+		close(done)
+	}()
+	for _, flow := range flows {
+		in <- flow
+	}
+	close(in)
+	wg.Wait()
+	<-done
+	return results
+}
+
+// Biflow Segment test, stitching by TCP flags
+func TestSegment_Biflow_tcpSyn(t *testing.T) {
+	results := runBiflow(map[string]string{},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}, SrcPort: 443, DstPort: 50000, Proto: 6, TcpFlags: 0x12, Bytes: 1000, Packets: 10, TimeFlowStartNs: 1},
+		&pb.EnrichedFlow{SrcAddr: []byte{198, 51, 100, 1}, DstAddr: []byte{192, 0, 2, 1}, SrcPort: 50000, DstPort: 443, Proto: 6, TcpFlags: 0x02, Bytes: 100, Packets: 2, TimeFlowStartNs: 2})
+	if len(results) != 1 {
+		t.Fatalf("([error] Segment Biflow is not stitching reverse flows, got %d flows.", len(results))
+	}
+	result := results[0]
+	if result.DstPort != 443 || result.Bytes != 100 || result.ReverseBytes != 1000 || result.ReversePackets != 10 || result.ReverseTcpFlags != 0x12 {
+		t.Error("([error] Segment Biflow is not using the SYN sender as initiator.")
+	}
+	if result.BiflowInitiator != pb.EnrichedFlow_TcpSyn {
+		t.Error("([error] Segment Biflow is not setting BiflowInitiator correctly.")
+	}
+}
+
+// Biflow Segment test, stitching by start time
+func TestSegment_Biflow_firstSeen(t *testing.T) {
+	results := runBiflow(map[string]string{},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}, SrcPort: 5353, DstPort: 40000, Proto: 17, Bytes: 300, TimeFlowStartNs: 20, TimeFlowEndNs: 30},
+		&pb.EnrichedFlow{SrcAddr: []byte{198, 51, 100, 1}, DstAddr: []byte{192, 0, 2, 1}, SrcPort: 40000, DstPort: 5353, Proto: 17, Bytes: 60, TimeFlowStartNs: 10, TimeFlowEndNs: 20})
+	if len(results) != 1 {
+		t.Fatalf("([error] Segment Biflow is not stitching reverse flows, got %d flows.", len(results))
+	}
+	result := results[0]
+	if result.DstPort != 5353 || result.Bytes != 60 || result.ReverseBytes != 300 || result.TimeFlowEndNs != 30 {
+		t.Error("([error] Segment Biflow is not using the earlier flow as initiator.")
+	}
+	if result.BiflowInitiator != pb.EnrichedFlow_FirstSeen {
+		t.Error("([error] Segment Biflow is not setting BiflowInitiator correctly.")
+	}
+}
+
+// Biflow Segment test, stitching completed TCP connections by service port
+func TestSegment_Biflow_servicePort(t *testing.T) {
+	results := runBiflow(map[string]string{},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}, SrcPort: 443, DstPort: 50000, Proto: 6, TcpFlags: 0x1b, Bytes: 1000, TimeFlowStartNs: 1},
+		&pb.EnrichedFlow{SrcAddr: []byte{198, 51, 100, 1}, DstAddr: []byte{192, 0, 2, 1}, SrcPort: 50000, DstPort: 443, Proto: 6, TcpFlags: 0x1b, Bytes: 100, TimeFlowStartNs: 2})
+	if len(results) != 1 {
+		t.Fatalf("([error] Segment Biflow is not stitching reverse flows, got %d flows.", len(results))
+	}
+	result := results[0]
+	if result.DstPort != 443 || result.Bytes != 100 || result.ReverseBytes != 1000 {
+		t.Error("([error] Segment Biflow is not using the client of a completed TCP connection as initiator.")
+	}
+	if result.BiflowInitiator != pb.EnrichedFlow_ServicePort {
+		t.Error("([error] Segment Biflow is not setting BiflowInitiator correctly.")
+	}
+}
+
+// Biflow Segment test, flows between the same address and port are not stitched
+func TestSegment_Biflow_sameEndpoint(t *testing.T) {
+	results := runBiflow(map[string]string{},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{192, 0, 2, 1}, SrcPort: 53, DstPort: 53, Proto: 17},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{192, 0, 2, 1}, SrcPort: 53, DstPort: 53, Proto: 17})
+	if len(results) != 2 || results[0].BiflowInitiator != pb.EnrichedFlow_NoInitiator || results[1].BiflowInitiator != pb.EnrichedFlow_NoInitiator {
+		t.Error("([error] Segment Biflow is stitching flows between the same address and port.")
+	}
+}
+
+// Biflow Segment test, unmatched flows
+func TestSegment_Biflow_unmatched(t *testing.T) {
+	results := runBiflow(map[string]string{},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}, SrcPort: 53, DstPort: 40000, Proto: 17},
+		&pb.EnrichedFlow{SrcAddr: []byte{198, 51, 100, 1}, DstAddr: []byte{192, 0, 2, 1}, SrcPort: 40000, DstPort: 53, Proto: 6})
+	if len(results) != 2 {
+		t.Fatalf("([error] Segment Biflow is not emitting unmatched flows, got %d flows.", len(results))
+	}
+	for _, result := range results {
+		if result.BiflowInitiator != pb.EnrichedFlow_NoInitiator {
+			t.Error("([error] Segment Biflow is stitching flows of different protocols.")
+		}
+	}
+}
+
+// Biflow Segment test, cache size limit
+func TestSegment_Biflow_maxEntries(t *testing.T) {
+	results := runBiflow(map[string]string{"maxentries": "1"},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}, SrcPort: 1, DstPort: 2, Proto: 17},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}, SrcPort: 3, DstPort: 4, Proto: 17},
+		&pb.EnrichedFlow{SrcAddr: []byte{198, 51, 100, 1}, DstAddr: []byte{192, 0, 2, 1}, SrcPort: 2, DstPort: 1, Proto: 17})
+	if len(results) != 3 || results[0].SrcPort != 1 {
+		t.Error("([error] Segment Biflow is not evicting the oldest flow when full.")
+	}
+}
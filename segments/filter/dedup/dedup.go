@@ -0,0 +1,306 @@
+// The `dedup` segment removes duplicate flows, i.e. flows exported by multiple
+// samplers for the same traffic, for instance when traffic traverses several
+// border routers. Flows are considered duplicates if their addresses, ports
+// and protocol are identical, they were exported by different samplers, and
+// their start and end times differ by at most `tolerance`.
+//
+// Which of the duplicates is kept is determined by the `policy` parameter:
+//
+//   - 'first' keeps the first flow received and forwards it immediately.
+//
+//   - 'preferred' keeps the flow of the sampler listed first in the
+//     comma-separated `exporters` parameter. Flows from samplers not listed are
+//     kept only if there is no flow from a listed sampler.
+//
+//   - 'border' keeps the flow which entered on a border interface, as determined
+//     by the interface roles set by the `ifinventory` segment. The roles
+//     considered are set by the `borderroles` parameter, defaulting to 'border'.
+//     If no such flow exists, the first flow received is kept.
+//
+// For policies other than 'first', flows are held back for the duration set by
+// `timeout`, as all duplicates have to be received before a decision can be
+// made. With the 'first' policy, flows are remembered for this duration to
+// detect duplicates arriving later. At most `maxkeys` groups of duplicates are
+// kept, deciding on the oldest group early when this limit is reached. Removed
+// duplicates are available to the `else` branch when using this segment as a
+// condition of the `branch` segment.
+package dedup
+
+import (
+	"container/list"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Dedup struct {
+	segments.BaseFilterSegment
+	Policy      string        // optional, default is "first", one of "first", "preferred" and "border", see above
+	Tolerance   time.Duration // optional, default is 1s, maximum difference of start and end times of duplicates
+	Timeout     time.Duration // optional, default is 10s, time to wait for duplicates
+	Exporters   []string      // optional, default is none, relevant to 'preferred' only, samplers in order of preference
+	BorderRoles []string      // optional, default is "border", relevant to 'border' only, interface roles considered border interfaces
+	MaxKeys     int           // optional, default is 100000, maximum number of groups of duplicates kept
+
+	cache map[flowKey][]*group
+	order *list.List // groups by arrival, oldest first
+}
+
+type flowKey struct {
+	srcAddr string
+	dstAddr string
+	srcPort uint32
+	dstPort uint32
+	proto   uint32
+}
+
+// A set of flows considered duplicates of each other.
+type group struct {
+	key      flowKey
+	start    uint64
+	end      uint64
+	arrived  time.Time
+	samplers []string
+	flows    []*pb.EnrichedFlow // only kept if a decision is pending
+}
+
+func (segment Dedup) New(config map[string]string) segments.Segment {
+	var policy = "first"
+	switch config["policy"] {
+	case "":
+		log.Info().Msg("Dedup: 'policy' set to default 'first'.")
+	case "first", "preferred", "border":
+		policy = config["policy"]
+	default:
+		log.Error().Msg("Dedup: The 'policy' parameter is required to be one of 'first', 'preferred' or 'border'.")
+		return nil
+	}
+
+	var tolerance = time.Second
+	if config["tolerance"] != "" {
+		if parsedTolerance, err := time.ParseDuration(config["tolerance"]); err == nil {
+			if parsedTolerance < 0 {
+				log.Error().Msg("Dedup: Tolerance has to be >= 0.")
+				return nil
+			}
+			tolerance = parsedTolerance
+		} else {
+			log.Error().Msg("Dedup: Could not parse 'tolerance' parameter, using default 1s.")
+		}
+	} else {
+		log.Info().Msg("Dedup: 'tolerance' set to default 1s.")
+	}
+
+	var timeout = 10 * time.Second
+	if config["timeout"] != "" {
+		if parsedTimeout, err := time.ParseDuration(config["timeout"]); err == nil {
+			if parsedTimeout <= 0 {
+				log.Error().Msg("Dedup: Timeout has to be > 0.")
+				return nil
+			}
+			timeout = parsedTimeout
+		} else {
+			log.Error().Msg("Dedup: Could not parse 'timeout' parameter, using default 10s.")
+		}
+	} else {
+		log.Info().Msg("Dedup: 'timeout' set to default 10s.")
+	}
+
+	var exporters []string
+	if config["exporters"] != "" {
+		for _, exporter := range strings.Split(config["exporters"], ",") {
+			address := net.ParseIP(strings.TrimSpace(exporter))
+			if address == nil {
+				log.Error().Msgf("Dedup: Invalid exporter address '%s'.", exporter)
+				return nil
+			}
+			exporters = append(exporters, address.String())
+		}
+	} else if policy == "preferred" {
+		log.Error().Msg("Dedup: Policy 'preferred' requires the 'exporters' parameter.")
+		return nil
+	}
+
+	var borderRoles []string
+	if config["borderroles"] != "" {
+		for _, role := range strings.Split(config["borderroles"], ",") {
+			borderRoles = append(borderRoles, strings.TrimSpace(role))
+		}
+	} else {
+		if policy == "border" {
+			log.Info().Msg("Dedup: 'borderroles' set to default 'border'.")
+		}
+		borderRoles = []string{"border"}
+	}
+
+	var maxKeys = 100000
+	if config["maxkeys"] != "" {
+		if parsedMaxKeys, err := strconv.Atoi(config["maxkeys"]); err == nil && parsedMaxKeys > 0 {
+			maxKeys = parsedMaxKeys
+		} else {
+			log.Error().Msg("Dedup: Could not parse 'maxkeys' parameter, using default 100000.")
+		}
+	} else {
+		log.Info().Msg("Dedup: 'maxkeys' set to default 100000.")
+	}
+
+	return &Dedup{
+		Policy:      policy,
+		Tolerance:   tolerance,
+		Timeout:     timeout,
+		Exporters:   exporters,
+		BorderRoles: borderRoles,
+		MaxKeys:     maxKeys,
+	}
+}
+
+func (segment *Dedup) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.cache = make(map[flowKey][]*group)
+	segment.order = list.New()
+
+	ticker := time.NewTicker(segment.Timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				segment.expire(time.Time{}) // decide on all pending groups
+				return
+			}
+			segment.insert(msg, time.Now())
+		case now := <-ticker.C:
+			segment.expire(now.Add(-segment.Timeout))
+		}
+	}
+}
+
+func (segment *Dedup) insert(msg *pb.EnrichedFlow, now time.Time) {
+	key := flowKey{
+		srcAddr: string(net.IP(msg.SrcAddr).To16()),
+		dstAddr: string(net.IP(msg.DstAddr).To16()),
+		srcPort: msg.SrcPort,
+		dstPort: msg.DstPort,
+		proto:   msg.Proto,
+	}
+	sampler := net.IP(msg.SamplerAddress).String()
+	tolerance := uint64(segment.Tolerance.Nanoseconds())
+
+	for _, g := range segment.cache[key] {
+		if slices.Contains(g.samplers, sampler) {
+			continue
+		}
+		if difference(msg.TimeFlowStartNs, g.start) > tolerance || difference(msg.TimeFlowEndNs, g.end) > tolerance {
+			continue
+		}
+		g.samplers = append(g.samplers, sampler)
+		if segment.Policy == "first" {
+			segment.drop(msg)
+		} else {
+			g.flows = append(g.flows, msg)
+		}
+		return
+	}
+
+	if segment.order.Len() >= segment.MaxKeys {
+		segment.decide(segment.order.Front())
+	}
+	g := &group{
+		key:      key,
+		start:    msg.TimeFlowStartNs,
+		end:      msg.TimeFlowEndNs,
+		arrived:  now,
+		samplers: []string{sampler},
+	}
+	if segment.Policy == "first" {
+		segment.Out <- msg
+	} else {
+		g.flows = []*pb.EnrichedFlow{msg}
+	}
+	segment.cache[key] = append(segment.cache[key], g)
+	segment.order.PushBack(g)
+}
+
+// Removes all groups which were created before the given time, or all groups
+// if it is zero, and emits the flows kept by the policy.
+func (segment *Dedup) expire(before time.Time) {
+	for e := segment.order.Front(); e != nil; e = segment.order.Front() {
+		if !before.IsZero() && !e.Value.(*group).arrived.Before(before) {
+			return
+		}
+		segment.decide(e)
+	}
+}
+
+// Removes a single group and emits the flow kept by the policy.
+func (segment *Dedup) decide(e *list.Element) {
+	g := segment.order.Remove(e).(*group)
+	groups := slices.DeleteFunc(segment.cache[g.key], func(other *group) bool { return other == g })
+	if len(groups) > 0 {
+		segment.cache[g.key] = groups
+	} else {
+		delete(segment.cache, g.key)
+	}
+	if len(g.flows) == 0 {
+		return
+	}
+	kept := segment.choose(g.flows)
+	for i, msg := range g.flows {
+		if i == kept {
+			segment.Out <- msg
+		} else {
+			segment.drop(msg)
+		}
+	}
+}
+
+// Returns the index of the flow to keep according to the policy.
+func (segment *Dedup) choose(flows []*pb.EnrichedFlow) int {
+	switch segment.Policy {
+	case "preferred":
+		best, bestRank := 0, len(segment.Exporters)
+		for i, msg := range flows {
+			rank := slices.Index(segment.Exporters, net.IP(msg.SamplerAddress).String())
+			if rank >= 0 && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		return best
+	case "border":
+		for i, msg := range flows {
+			if slices.Contains(segment.BorderRoles, msg.SrcIfRole) {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+func difference(a uint64, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func (segment *Dedup) drop(msg *pb.EnrichedFlow) {
+	if segment.Drops != nil {
+		segment.Drops <- msg
+	}
+}
+
+func init() {
+	segment := &Dedup{}
+	segments.RegisterSegment("dedup", segment)
+}
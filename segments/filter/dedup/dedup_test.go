@@ -0,0 +1,116 @@
+package dedup
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Runs the given flows through a dedup segment, returning kept and dropped flows.
+func runDedup(config map[string]string, flows ...*pb.EnrichedFlow) ([]*pb.EnrichedFlow, []*pb.EnrichedFlow) {
+	segment := segments.LookupSegment("dedup").New(config)
+	if segment == nil {
+		log.Fatal().Msg("Configured segment 'dedup' could not be initialized properly, see previous messages.")
+	}
+
+	in, out, drops := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	segment.(*Dedup).SubscribeDrops(drops)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(wg)
+
+	var kept, dropped []*pb.EnrichedFlow
+	done := make(chan struct{})
+	go func() {
+		for out != nil || drops != nil {
+			select {
+			case msg, ok := <-out:
+				if !ok {
+					out = nil
+					close(drops)
+					continue
+				}
+				kept = append(kept, msg)
+			case msg, ok := <-drops:
+				if !ok {
+					drops = nil
+					continue
+				}
+				dropped = append(dropped, msg)
+			}
+		}
+		close(done)
+	}()
+	for _, flow := range flows {
+		in <- flow
+	}
+	close(in)
+	wg.Wait()
+	<-done
+	return kept, dropped
+}
+
+func testFlow(sampler byte, start uint64) *pb.EnrichedFlow {
+	return &pb.EnrichedFlow{
+		SamplerAddress:  []byte{192, 0, 2, sampler},
+		SrcAddr:         []byte{198, 51, 100, 1},
+		DstAddr:         []byte{203, 0, 113, 1},
+		SrcPort:         50000,
+		DstPort:         443,
+		Proto:           6,
+		TimeFlowStartNs: start,
+		TimeFlowEndNs:   start + 5e9,
+	}
+}
+
+// Dedup Segment test, first policy
+func TestSegment_Dedup_first(t *testing.T) {
+	kept, dropped := runDedup(map[string]string{},
+		testFlow(1, 10e9), testFlow(2, 10.5e9), testFlow(3, 20e9), testFlow(1, 30e9))
+	if len(kept) != 3 || len(dropped) != 1 || dropped[0].SamplerAddress[3] != 2 {
+		t.Error("([error] Segment Dedup is not removing duplicates with policy 'first'.")
+	}
+}
+
+// Dedup Segment test, preferred policy
+func TestSegment_Dedup_preferred(t *testing.T) {
+	kept, dropped := runDedup(map[string]string{"policy": "preferred", "exporters": "192.0.2.3,192.0.2.2"},
+		testFlow(1, 10e9), testFlow(2, 10e9), testFlow(3, 10e9))
+	if len(kept) != 1 || len(dropped) != 2 || kept[0].SamplerAddress[3] != 3 {
+		t.Error("([error] Segment Dedup is not keeping the flow of the preferred exporter.")
+	}
+}
+
+// Dedup Segment test, border policy
+func TestSegment_Dedup_border(t *testing.T) {
+	internal, border := testFlow(1, 10e9), testFlow(2, 10e9)
+	internal.SrcIfRole, border.SrcIfRole = "core", "border"
+	kept, dropped := runDedup(map[string]string{"policy": "border", "borderroles": "transit, border"}, internal, border)
+	if len(kept) != 1 || len(dropped) != 1 || kept[0].SrcIfRole != "border" {
+		t.Error("([error] Segment Dedup is not keeping the flow which entered on a border interface.")
+	}
+}
+
+// Dedup Segment test, maximum number of groups
+func TestSegment_Dedup_maxkeys(t *testing.T) {
+	other := testFlow(1, 10e9)
+	other.DstPort = 80
+	kept, dropped := runDedup(map[string]string{"policy": "preferred", "exporters": "192.0.2.2", "maxkeys": "1"},
+		testFlow(1, 10e9), other, testFlow(2, 10e9))
+	if len(kept) != 3 || len(dropped) != 0 || kept[0].SamplerAddress[3] != 1 || kept[0].DstPort != 443 {
+		t.Error("([error] Segment Dedup is not deciding on the oldest group when reaching 'maxkeys'.")
+	}
+}
+
+// Dedup Segment test, invalid configuration
+func TestSegment_Dedup_noExporters(t *testing.T) {
+	if segment := (Dedup{}).New(map[string]string{"policy": "preferred"}); segment != nil {
+		t.Error("([error] Segment Dedup accepts policy 'preferred' without exporters.")
+	}
+}
@@ -8,15 +8,32 @@
 // it can be useful to adjust the window size (in seconds).
 // The ramp up time defults to 0 (disabled), but can be configured to wait for analyzing
 // flows. All flows within this Timerange are dropped after the start of the pipeline.
+//
+// Instead of the top flows, a band of flows can be selected by setting
+// `upperpercentile`, i.e. flows between the percentiles given by `percentile`
+// and `upperpercentile` are kept. Setting `percentile` to 0 selects the bottom
+// flows up to `upperpercentile`.
+//
+// By default, a single sliding window is used for all flows. Using the `key`
+// parameter, windows can be partitioned by a comma-separated list of `srcaddr`,
+// `dstaddr`, `proto`, `cid`, `sampleraddress`, `netid`, `srcid`, `dstid`,
+// `srcas` and `dstas`, so that each customer or exporter is judged by its own
+// traffic. At most `maxkeys` windows are kept, evicting the least recently used
+// key when this limit is reached. Setting `maxkeys` to 0 keeps windows for any
+// number of keys. Kept flows are labeled with the thresholds computed for their
+// key, `elephant_threshold` and `elephant_upperthreshold` respectively.
 package elephant
 
 import (
+	"container/list"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
 	"github.com/rs/zerolog/log"
 
@@ -25,12 +42,24 @@ import (
 
 type Elephant struct {
 	segments.BaseFilterSegment
-	Aspect     string  // optional, one of "bytes", "bps", "packets", or "pps", default is "bytes", determines which aspect qualifies a flow as an elephant
-	Percentile float64 // optional, default is 99.00, determines the cutoff percentile for flows being dropped by this segment, i.e. 95.00 corresponds to outputting the top 5% only
-	// TODO: add option to get bottom percent?
-	Exact      bool // optional, default is false, determines whether to use percentiles that are exact or generated using the P-square estimation algorithm
-	Window     int  // optional, default is 300, sets the number of seconds used as a sliding window size
-	RampupTime int  // optional, default is 0, sets the time to wait for analyzing flows. All flows within this Timerange are dropped.
+	Aspect          string   // optional, one of "bytes", "bps", "packets", or "pps", default is "bytes", determines which aspect qualifies a flow as an elephant
+	Percentile      float64  // optional, default is 99.00, determines the cutoff percentile for flows being dropped by this segment, i.e. 95.00 corresponds to outputting the top 5% only
+	UpperPercentile float64  // optional, default is none, determines the percentile from which on flows are dropped, i.e. 10.00 with a Percentile of 0 corresponds to outputting the bottom 10% only
+	Exact           bool     // optional, default is false, determines whether to use percentiles that are exact or generated using the P-square estimation algorithm
+	Window          int      // optional, default is 300, sets the number of seconds used as a sliding window size
+	RampupTime      int      // optional, default is 0, sets the time to wait for analyzing flows. All flows within this Timerange are dropped.
+	Key             []string // optional, default is none, fields by which separate windows are kept
+	MaxKeys         int      // optional, default is 10000, maximum number of windows kept, 0 means unbounded
+
+	windows map[string]*list.Element
+	lru     *list.List // windows by last use, least recently used first
+}
+
+var keyFields = []string{"srcaddr", "dstaddr", "proto", "cid", "sampleraddress", "netid", "srcid", "dstid", "srcas", "dstas"}
+
+type keyWindow struct {
+	key    string
+	window *rolling.TimePolicy
 }
 
 func (segment Elephant) New(config map[string]string) segments.Segment {
@@ -49,10 +78,6 @@ func (segment Elephant) New(config map[string]string) segments.Segment {
 	if config["percentile"] != "" {
 		if parsedPercentile, err := strconv.ParseFloat(config["percentile"], 64); err == nil {
 			percentile = parsedPercentile
-			if percentile == 0 {
-				log.Error().Msg("Elephant: Using 0-Percentile corresponds to no-op. Remove this segment or use a higher value.")
-				return nil
-			}
 		} else {
 			log.Error().Msg("Elephant: Could not parse 'percentile' parameter, using default 99.00.")
 		}
@@ -60,6 +85,27 @@ func (segment Elephant) New(config map[string]string) segments.Segment {
 		log.Info().Msg("Elephant: 'percentile' set to default 99.00.")
 	}
 
+	var upperPercentile float64
+	if config["upperpercentile"] != "" {
+		if parsedPercentile, err := strconv.ParseFloat(config["upperpercentile"], 64); err == nil {
+			upperPercentile = parsedPercentile
+			if upperPercentile <= percentile || upperPercentile > 100 {
+				log.Error().Msg("Elephant: Upperpercentile has to be larger than percentile and <= 100.")
+				return nil
+			}
+		} else {
+			log.Error().Msg("Elephant: Could not parse 'upperpercentile' parameter, using no upper percentile.")
+		}
+	}
+	if percentile == 0 && (upperPercentile == 0 || upperPercentile == 100) {
+		log.Error().Msg("Elephant: Using 0-Percentile corresponds to no-op. Remove this segment or use a higher value.")
+		return nil
+	}
+	if percentile < 0 {
+		log.Error().Msg("Elephant: Percentile has to be >= 0.")
+		return nil
+	}
+
 	var exact = false
 	if config["exact"] != "" {
 		if parsedExact, err := strconv.ParseBool(config["exact"]); err == nil {
@@ -109,12 +155,40 @@ func (segment Elephant) New(config map[string]string) segments.Segment {
 		log.Info().Msg("Elephant: 'rampuptime' set to default 0.")
 	}
 
+	var key []string
+	if config["key"] != "" {
+		for _, field := range strings.Split(config["key"], ",") {
+			field = strings.TrimSpace(strings.ToLower(field))
+			if !slices.Contains(keyFields, field) {
+				log.Error().Msgf("Elephant: Unknown key field '%s', options are %s.", field, strings.Join(keyFields, ", "))
+				return nil
+			}
+			key = append(key, field)
+		}
+	} else {
+		log.Info().Msg("Elephant: 'key' set to default, using a single window.")
+	}
+
+	var maxKeys = 10000
+	if config["maxkeys"] != "" {
+		if parsedMaxKeys, err := strconv.Atoi(config["maxkeys"]); err == nil && parsedMaxKeys >= 0 {
+			maxKeys = parsedMaxKeys
+		} else {
+			log.Error().Msg("Elephant: Could not parse 'maxkeys' parameter, using default 10000.")
+		}
+	} else if key != nil {
+		log.Info().Msg("Elephant: 'maxkeys' set to default 10000.")
+	}
+
 	return &Elephant{
-		Aspect:     aspect,
-		Percentile: percentile,
-		Exact:      exact,
-		Window:     window,
-		RampupTime: rampuptime,
+		Aspect:          aspect,
+		Percentile:      percentile,
+		UpperPercentile: upperPercentile,
+		Exact:           exact,
+		Window:          window,
+		RampupTime:      rampuptime,
+		Key:             key,
+		MaxKeys:         maxKeys,
 	}
 }
 
@@ -130,7 +204,16 @@ func (segment *Elephant) Run(wg *sync.WaitGroup) {
 		inRampup = true
 		rampupEnd = time.Now().Add(time.Duration(segment.RampupTime) * time.Second)
 	}
-	var window = rolling.NewTimePolicy(rolling.NewWindow(segment.Window), time.Second)
+	segment.windows = make(map[string]*list.Element)
+	segment.lru = list.New()
+	var lower, upper func(rolling.Window) float64
+	if segment.Exact {
+		lower = rolling.Percentile(segment.Percentile)
+		upper = rolling.Percentile(segment.UpperPercentile)
+	} else {
+		lower = rolling.FastPercentile(segment.Percentile)
+		upper = rolling.FastPercentile(segment.UpperPercentile)
+	}
 	for msg := range segment.In {
 		// always determine a flow's aspect to append to the window
 		var aspect float64
@@ -152,6 +235,7 @@ func (segment *Elephant) Run(wg *sync.WaitGroup) {
 		case "packets":
 			aspect = float64(msg.Packets)
 		}
+		window := segment.window(msg)
 		window.Append(aspect)
 
 		// Check if ramp up phase is over. Shortcircuiting avoids
@@ -166,25 +250,48 @@ func (segment *Elephant) Run(wg *sync.WaitGroup) {
 		// else with the previous if to ensure the first flow after
 		// rampupEnd is considered.
 		if !inRampup {
-			var threshold float64
-			if segment.Exact {
-				threshold = window.Reduce(rolling.Percentile(segment.Percentile))
-			} else {
-				threshold = window.Reduce(rolling.FastPercentile(segment.Percentile))
+			// the 0th and 100th percentile are no thresholds at all
+			var threshold, upperThreshold = 0.0, math.Inf(1)
+			if segment.Percentile > 0 {
+				threshold = window.Reduce(lower)
 			}
-			if aspect >= threshold {
+			if segment.UpperPercentile > 0 && segment.UpperPercentile < 100 {
+				upperThreshold = window.Reduce(upper)
+			}
+			if aspect >= threshold && aspect < upperThreshold {
 				log.Debug().Msgf("Elephant: Found elephant with size %d (>=%f)", msg.Bytes, threshold)
+				msg.SetLabel("elephant_threshold", strconv.FormatFloat(threshold, 'f', -1, 64))
+				if !math.IsInf(upperThreshold, 1) {
+					msg.SetLabel("elephant_upperthreshold", strconv.FormatFloat(upperThreshold, 'f', -1, 64))
+				}
 				segment.Out <- msg
 				continue
 			}
 		}
-		// implicit "(if inRampup || aspect < threshold) && ..." due to the continue 3 lines above
+		// implicit "(if inRampup || aspect outside thresholds) && ..." due to the continue above
 		if segment.Drops != nil {
 			segment.Drops <- msg
 		}
 	}
 }
 
+// Returns the window for the flow's key, creating it if necessary.
+func (segment *Elephant) window(msg *pb.EnrichedFlow) *rolling.TimePolicy {
+	key := msg.GroupKey(segment.Key)
+	if element, ok := segment.windows[key]; ok {
+		segment.lru.MoveToBack(element)
+		return element.Value.(*keyWindow).window
+	}
+	if segment.MaxKeys > 0 && segment.lru.Len() >= segment.MaxKeys {
+		oldest := segment.lru.Front()
+		delete(segment.windows, oldest.Value.(*keyWindow).key)
+		segment.lru.Remove(oldest)
+	}
+	w := &keyWindow{key: key, window: rolling.NewTimePolicy(rolling.NewWindow(segment.Window), time.Second)}
+	segment.windows[key] = segment.lru.PushBack(w)
+	return w.window
+}
+
 func init() {
 	segment := &Elephant{}
 	segments.RegisterSegment("elephant", segment)
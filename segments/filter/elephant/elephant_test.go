@@ -1,6 +1,7 @@
 package elephant
 
 import (
+	"net"
 	"os"
 	"sync"
 	"testing"
@@ -38,6 +39,91 @@ func TestSegment_Elephant_passthrough(t *testing.T) {
 	wg.Wait()
 }
 
+// Runs the given flows through an elephant segment, returning the kept flows.
+func runElephant(config map[string]string, flows ...*pb.EnrichedFlow) []*pb.EnrichedFlow {
+	segment := segments.LookupSegment("elephant").New(config)
+	if segment == nil {
+		log.Fatal().Msg("Configured segment 'elephant' could not be initialized properly, see previous messages.")
+	}
+
+	in, out, drops := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	segment.(*Elephant).SubscribeDrops(drops)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(wg)
+
+	var kept []*pb.EnrichedFlow
+	for _, flow := range flows {
+		in <- flow
+		select {
+		case msg := <-out:
+			kept = append(kept, msg)
+		case <-drops:
+		}
+	}
+	close(in)
+	wg.Wait()
+	return kept
+}
+
+// Elephant Segment test, per key windows
+func TestSegment_Elephant_key(t *testing.T) {
+	flows := func() []*pb.EnrichedFlow {
+		return []*pb.EnrichedFlow{{Cid: 1, Bytes: 1e6}, {Cid: 1, Bytes: 1e6}, {Cid: 1, Bytes: 1e6}, {Cid: 2, Bytes: 100}}
+	}
+	kept := runElephant(map[string]string{"percentile": "50", "exact": "true"}, flows()...)
+	if len(kept) != 3 {
+		t.Error("([error] Segment Elephant is not using a single window by default.")
+	}
+	kept = runElephant(map[string]string{"percentile": "50", "exact": "true", "key": "cid"}, flows()...)
+	if len(kept) != 4 || kept[3].GetLabel("elephant_threshold") != "100" {
+		t.Error("([error] Segment Elephant is not using separate windows per key.")
+	}
+	kept = runElephant(map[string]string{"percentile": "50", "exact": "true", "key": "netid"},
+		&pb.EnrichedFlow{NetIdString: "campus", Bytes: 1e6}, &pb.EnrichedFlow{NetIdString: "campus", Bytes: 1e6},
+		&pb.EnrichedFlow{NetIdString: "campus", Bytes: 1e6}, &pb.EnrichedFlow{NetIdString: "dorms", Bytes: 100})
+	if len(kept) != 4 {
+		t.Error("([error] Segment Elephant is not using separate windows per string network ID.")
+	}
+	v4, v6 := []byte{192, 0, 2, 1}, net.ParseIP("2001:db8::1")
+	if (&pb.EnrichedFlow{SrcAddr: v4, DstAddr: v6}).GroupKey([]string{"srcaddr", "dstaddr"}) == (&pb.EnrichedFlow{SrcAddr: v6, DstAddr: v4}).GroupKey([]string{"srcaddr", "dstaddr"}) {
+		t.Error("([error] Segment Elephant is using ambiguous keys for addresses.")
+	}
+}
+
+// Elephant Segment test, unbounded number of keys
+func TestSegment_Elephant_unboundedKeys(t *testing.T) {
+	segment := (Elephant{}).New(map[string]string{"key": "cid", "maxkeys": "0"})
+	if segment == nil || segment.(*Elephant).MaxKeys != 0 {
+		t.Fatal("([error] Segment Elephant is not accepting 'maxkeys' 0.")
+	}
+	var flows []*pb.EnrichedFlow
+	for cid := uint32(1); cid <= 3; cid++ {
+		flows = append(flows, &pb.EnrichedFlow{Cid: cid, Bytes: 1e6}, &pb.EnrichedFlow{Cid: cid, Bytes: 100})
+	}
+	if kept := runElephant(map[string]string{"percentile": "50", "exact": "true", "key": "cid", "maxkeys": "0"}, flows...); len(kept) != 3 {
+		t.Error("([error] Segment Elephant is not keeping windows for all keys with 'maxkeys' 0.")
+	}
+}
+
+// Elephant Segment test, bottom percentile selection
+func TestSegment_Elephant_bottom(t *testing.T) {
+	kept := runElephant(map[string]string{"percentile": "0", "upperpercentile": "50", "exact": "true"},
+		&pb.EnrichedFlow{Bytes: 100}, &pb.EnrichedFlow{Bytes: 10})
+	if len(kept) != 1 || kept[0].Bytes != 10 || kept[0].GetLabel("elephant_upperthreshold") != "55" {
+		t.Error("([error] Segment Elephant is not selecting the bottom flows.")
+	}
+}
+
+// Elephant Segment test, invalid configuration
+func TestSegment_Elephant_invalidBand(t *testing.T) {
+	if segment := (Elephant{}).New(map[string]string{"percentile": "90", "upperpercentile": "50"}); segment != nil {
+		t.Error("([error] Segment Elephant accepts an upper percentile below the lower one.")
+	}
+}
+
 // Elephant Segment benchmark passthrough
 func BenchmarkElephant(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
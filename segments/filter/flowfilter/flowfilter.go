@@ -1,6 +1,29 @@
 // The `flowfilter` segment uses [flowfilter syntax](https://github.com/BelWue/flowfilter)
 // to drop flows based on the evaluation value of the provided filter conditional against
 // any flow passing through this segment.
+//
+// In addition to the upstream syntax, entries of the flow's Labels map can be
+// referenced using `labels.<key>`. On its own, such a statement matches any flow
+// which has this label set, optionally followed by a quoted string it matches
+// flows with exactly this value, e.g. `labels.customer 'acme'`.
+//
+// String fields set by other segments can be matched using `hostname`,
+// `netname`, `asname`, `city` and `region` with an optional direction,
+// `iface name`, `iface desc` and `iface role`, as well as `country`,
+// `protoname`, `service`, `application` and `note`. These are followed by a
+// quoted string, which is optionally preceded by one of the operators `equals`
+// (the default), `prefix`, `suffix`, `contains` and `regex`, e.g.
+// `src hostname suffix '.example.org'`. Without an operator, `iface name` and
+// `iface desc` keep their upstream behavior of case-insensitive containment.
+// The `city` and `region` predicates match the labels set by the `geolocation`
+// segment, the `service` and `application` predicates those set by `appclass`.
+//
+// The AS path can be matched using `aspath contains <asn>...`, which matches
+// if all given ASNs are part of the path, `aspath origin <asn>` and
+// `aspath length <range>`. Addresses and ASNs can be matched against sets read
+// from files when parsing the filter, e.g. `src address in @file:blocklist.txt`,
+// `asn in @file:asns.txt` or `aspath in @file:asns.txt`. These files contain
+// one address, prefix or ASN per line.
 package flowfilter
 
 import (
@@ -8,9 +31,9 @@ import (
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/BelWue/flowfilter/parser"
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
+	"github.com/BelWue/flowpipeline/segments/filter/flowfilter/parser"
 )
 
 type FlowFilter struct {
@@ -27,7 +50,9 @@ func (segment FlowFilter) New(config map[string]string) segments.Segment {
 		Filter: config["filter"],
 	}
 
-	newSegment.expression, err = parser.Parse(config["filter"])
+	newSegment.expression, err = parser.ParseWithResolver(config["filter"], func(filename string) string {
+		return segments.ContainerVolumePrefix + filename
+	})
 	if err != nil {
 		log.Error().Err(err).Msg("FlowFilter: Syntax error in filter expression: ")
 		return nil
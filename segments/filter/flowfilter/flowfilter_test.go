@@ -28,6 +28,67 @@ func TestSegment_FlowFilter_deny(t *testing.T) {
 	}
 }
 
+func TestSegment_FlowFilter_label(t *testing.T) {
+	result := segments.TestSegment("flowfilter", map[string]string{"filter": "labels.customer 'acme'"},
+		&pb.EnrichedFlow{Labels: map[string]string{"customer": "acme"}})
+	if result == nil {
+		t.Error("([error] Segment FlowFilter dropped a flow with a matching label incorrectly.")
+	}
+	result = segments.TestSegment("flowfilter", map[string]string{"filter": "labels.customer"},
+		&pb.EnrichedFlow{Labels: map[string]string{"threat": "drop"}})
+	if result != nil {
+		t.Error("([error] Segment FlowFilter accepted a flow without the label incorrectly.")
+	}
+}
+
+func TestSegment_FlowFilter_strings(t *testing.T) {
+	flow := &pb.EnrichedFlow{SrcHostName: "www.example.org", DstIfDesc: "transit provider", RemoteCountry: "DE", Note: "ticket-42", Labels: map[string]string{"appclass_service": "https", "geo_src_city": "Karlsruhe"}}
+	for _, filter := range []string{`src hostname suffix '.example.org'`, `dst iface desc regex '^transit'`, `country equals 'DE'`, `note 'ticket-42'`, `service prefix 'http'`, `src city 'Karlsruhe'`} {
+		if result := segments.TestSegment("flowfilter", map[string]string{"filter": filter}, flow); result == nil {
+			t.Errorf("([error] Segment FlowFilter dropped a flow matching `%s` incorrectly.", filter)
+		}
+	}
+	for _, filter := range []string{`dst hostname suffix '.example.org'`, `src iface desc regex '^transit'`, `note 'ticket'`, `application 'https'`, `dst city 'Karlsruhe'`} {
+		if result := segments.TestSegment("flowfilter", map[string]string{"filter": filter}, flow); result != nil {
+			t.Errorf("([error] Segment FlowFilter accepted a flow not matching `%s` incorrectly.", filter)
+		}
+	}
+}
+
+func TestSegment_FlowFilter_netname(t *testing.T) {
+	flow := &pb.EnrichedFlow{NetIdString: "campus"}
+	if result := segments.TestSegment("flowfilter", map[string]string{"filter": "netname 'campus'"}, flow); result == nil {
+		t.Error("([error] Segment FlowFilter is not matching netname against NetIdString.")
+	}
+	if result := segments.TestSegment("flowfilter", map[string]string{"filter": "src netname 'campus'"}, flow); result != nil {
+		t.Error("([error] Segment FlowFilter is matching src netname against NetIdString.")
+	}
+}
+
+func TestSegment_FlowFilter_sets(t *testing.T) {
+	flow := &pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 42}, DstAddr: []byte{203, 0, 113, 1}, DstAs: 64511, AsPath: []uint32{553, 64496, 64511}}
+	for _, filter := range []string{
+		`src address in @file:../../../examples/enricher/blocklist.txt`,
+		`dst asn in @file:../../../examples/enricher/asnlist.txt`,
+		`aspath in @file:../../../examples/enricher/asnlist.txt`,
+		`aspath contains 64511 553`,
+		`aspath origin 64511 and aspath length 3`,
+	} {
+		if result := segments.TestSegment("flowfilter", map[string]string{"filter": filter}, flow); result == nil {
+			t.Errorf("([error] Segment FlowFilter dropped a flow matching `%s` incorrectly.", filter)
+		}
+	}
+	for _, filter := range []string{
+		`dst address in @file:../../../examples/enricher/blocklist.txt`,
+		`src asn in @file:../../../examples/enricher/asnlist.txt`,
+		`aspath contains 553 1`,
+	} {
+		if result := segments.TestSegment("flowfilter", map[string]string{"filter": filter}, flow); result != nil {
+			t.Errorf("([error] Segment FlowFilter accepted a flow not matching `%s` incorrectly.", filter)
+		}
+	}
+}
+
 func TestSegment_FlowFilter_syntax(t *testing.T) {
 	filter := &FlowFilter{}
 	result := filter.New(map[string]string{"filter": "protoo 4"})
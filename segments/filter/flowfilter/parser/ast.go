@@ -0,0 +1,610 @@
+// FROM https://github.com/BelWue/flowfilter/
+
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwNetFlow/ip_prefix_trie"
+)
+
+// Node is an interface implemented by all AST nodes
+type Node interface {
+	children() []Node
+}
+
+// Branch Nodes have this struct embedded, as these fields can be used to pass
+// evaluation results from children.
+type BranchNode struct {
+	EvalResult    bool
+	EvalResultSrc bool
+	EvalResultDst bool
+}
+
+// The overall structure of this grammar. Expressions are made up of statements
+// in conjunction with more expressions. A statement consists of any matcher or
+// a subexpression in parenthesis and is optionally negated.
+type Expression struct {
+	BranchNode
+	Left        *Statement  `parser:"(@@ ("`
+	Conjunction *String     `parser:"@Conjunction"`
+	Right       *Expression `parser:"@@ )?)?"`
+}
+
+func (o Expression) children() []Node {
+	return []Node{o.Left, o.Conjunction, o.Right}
+}
+
+type Statement struct {
+	BranchNode
+	Negated          *Boolean               `parser:"@Negation? ("`
+	DirectionalMatch *DirectionalMatchGroup `parser:"  @@"`
+	RegularMatch     *RegularMatchGroup     `parser:"| @@"`
+	SubExpression    *Expression            `parser:"| '(' @@ ')' )"`
+}
+
+func (o Statement) children() []Node {
+	return []Node{o.Negated, o.DirectionalMatch, o.RegularMatch,
+		o.SubExpression}
+}
+
+// Basic data type nodes which are mostly just aliases
+type Address net.IP
+
+func (o Address) children() []Node { return nil }
+
+type Boolean bool
+
+func (o Boolean) children() []Node { return nil }
+
+type String string
+
+func (o String) children() []Node { return nil }
+
+type Number uint64
+
+func (o Number) children() []Node { return nil }
+
+// FIXME: pseudo "negative" Number
+// used by the printer visitor only, for emitting a "-" between children
+type RangeEnd Number
+
+func (o RangeEnd) children() []Node { return nil }
+
+type NumericRange struct {
+	BranchNode
+	Lower  *Number   `parser:"(@Number"`
+	Upper  *RangeEnd `parser:"'-' @Number) |"`
+	Unary  *String   `parser:"( @Unary?"`
+	Number *Number   `parser:"  @Number )"`
+}
+
+func (o NumericRange) children() []Node {
+	return []Node{o.Lower, o.Upper, o.Unary, o.Number}
+}
+
+// StringMatch compares a string field of a flow to a quoted string. Without an
+// operator, the field has to be equal to the string. Regular expressions are
+// compiled when parsing.
+type StringMatch struct {
+	BranchNode
+	Operator *String `parser:"( @('equals'|'prefix'|'suffix'|'contains')?"`
+	Value    *String `parser:"  @String"`
+	Regex    *Regex  `parser:"| 'regex' @String )"`
+}
+
+func (o StringMatch) children() []Node { return nil }
+
+type Regex struct {
+	*regexp.Regexp
+}
+
+func (o *Regex) Capture(values []string) error {
+	var err error
+	o.Regexp, err = regexp.Compile(values[0])
+	return err
+}
+
+// Reads a file referenced by '@file:<path>' line by line, skipping empty lines
+// and comments starting with '#' or ';'. Only the first word of each line is
+// passed on. The path is passed through resolvePath first, if it is set.
+func readReferencedFile(filename string, resolvePath func(string) string, handle func(string) error) error {
+	path := filename
+	if resolvePath != nil {
+		path = resolvePath(filename)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		if err := handle(fields[0]); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// AddressSet is a set of addresses and prefixes loaded from a file. Parsing
+// only records the file name, the contents are read by load.
+type AddressSet struct {
+	File   string
+	trieV4 *ip_prefix_trie.TrieNode
+	trieV6 *ip_prefix_trie.TrieNode
+}
+
+func (o *AddressSet) Capture(values []string) error {
+	o.File = strings.TrimPrefix(values[0], "@file:")
+	return nil
+}
+
+func (o *AddressSet) load(resolvePath func(string) string) error {
+	o.trieV4, o.trieV6 = &ip_prefix_trie.TrieNode{}, &ip_prefix_trie.TrieNode{}
+	return readReferencedFile(o.File, resolvePath, func(prefix string) error {
+		if !strings.Contains(prefix, "/") {
+			address := net.ParseIP(prefix)
+			if address == nil {
+				return fmt.Errorf("invalid address '%s'", prefix)
+			}
+			if address.To4() != nil {
+				prefix += "/32"
+			} else {
+				prefix += "/128"
+			}
+		}
+		address, _, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return err
+		}
+		if address.To4() != nil {
+			o.trieV4.Insert(true, []string{prefix})
+		} else {
+			o.trieV6.Insert(true, []string{prefix})
+		}
+		return nil
+	})
+}
+
+func (o *AddressSet) Contains(address net.IP) bool {
+	if address == nil {
+		return false
+	}
+	if address.To4() != nil {
+		return o.trieV4.Lookup(address) != nil
+	}
+	return o.trieV6.Lookup(address) != nil
+}
+
+// AsnSet is a set of AS numbers loaded from a file, with an optional 'AS'
+// prefix. Parsing only records the file name, the contents are read by load.
+type AsnSet struct {
+	File string
+	asns map[uint32]bool
+}
+
+func (o *AsnSet) Capture(values []string) error {
+	o.File = strings.TrimPrefix(values[0], "@file:")
+	return nil
+}
+
+func (o *AsnSet) load(resolvePath func(string) string) error {
+	o.asns = make(map[uint32]bool)
+	return readReferencedFile(o.File, resolvePath, func(asn string) error {
+		number, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid ASN '%s'", asn)
+		}
+		o.asns[uint32(number)] = true
+		return nil
+	})
+}
+
+func (o *AsnSet) Contains(asn uint32) bool {
+	return o.asns[asn]
+}
+
+// Match Nodes are the actual sub commands, without their command word
+// They are in turn organized into MatchGroups. There are Regular Matches and
+// Directional Matches.
+
+// Regular Matches:
+// * anything that has further sub commands or accepts fancy data
+// * no direction
+// * several dedicated Match structs for different data types and sub commands
+type RegularMatchGroup struct {
+	BranchNode
+	Router        *RouterMatch            `parser:"'router' @@"`
+	NextHop       *NextHopMatch           `parser:"| 'nexthop' @@"`
+	NextHopAsn    *NextHopAsnMatch        `parser:"| 'nexthopasn' @@"`
+	Bytes         *ByteRangeMatch         `parser:"| 'bytes' @@"`
+	Packets       *PacketRangeMatch       `parser:"| 'packets' @@"`
+	RemoteCountry *RemoteCountryMatch     `parser:"| 'country' @@"`
+	FlowDirection *FlowDirectionMatch     `parser:"| 'direction'? @@"`
+	Normalized    *NormalizedMatch        `parser:"| @@"`
+	Duration      *DurationRangeMatch     `parser:"| 'duration' @@"`
+	Etype         *EtypeMatch             `parser:"| 'etype' @@"`
+	Proto         *ProtoMatch             `parser:"| 'proto' @@"`
+	Status        *StatusMatch            `parser:"| 'status' @@"`
+	TcpFlags      *TcpFlagsMatch          `parser:"| 'tcpflags' @@"`
+	IpTos         *IpTosRangeMatch        `parser:"| 'iptos' @@"`
+	Dscp          *DscpMatch              `parser:"| 'dscp' @@"`
+	Ecn           *EcnMatch               `parser:"| 'ecn' @@"`
+	SamplingRate  *SamplingRateRangeMatch `parser:"| 'samplingrate' @@"`
+	Icmp          *IcmpMatch              `parser:"| 'icmp' @@"`
+	Bps           *BpsRangeMatch          `parser:"| 'bps' @@"`
+	Pps           *PpsRangeMatch          `parser:"| 'pps' @@"`
+	PassesThrough *PassesThroughListMatch `parser:"| 'passes-through' @@"`
+	Med           *MedRangeMatch          `parser:"| 'med' @@"`
+	LocalPref     *LocalPrefRangeMatch    `parser:"| 'localpref' @@"`
+	Rpki          *RpkiMatch              `parser:"| 'rpki' @@"`
+	AsPath        *AsPathMatch            `parser:"| 'aspath' @@"`
+	ProtoName     *ProtoNameMatch         `parser:"| 'protoname' @@"`
+	Service       *ServiceMatch           `parser:"| 'service' @@"`
+	Application   *ApplicationMatch       `parser:"| 'application' @@"`
+	Note          *NoteMatch              `parser:"| 'note' @@"`
+	Label         *LabelMatch             `parser:"| @@"`
+}
+
+func (o RegularMatchGroup) children() []Node {
+	return []Node{o.Router, o.NextHop, o.NextHopAsn, o.Bytes, o.Packets, o.RemoteCountry,
+		o.FlowDirection, o.Normalized, o.Duration, o.Etype, o.Proto,
+		o.Status, o.TcpFlags, o.IpTos, o.Dscp, o.Ecn, o.SamplingRate,
+		o.Icmp, o.Bps, o.Pps, o.PassesThrough, o.Med, o.LocalPref, o.Rpki,
+		o.AsPath, o.ProtoName, o.Service, o.Application, o.Note, o.Label}
+}
+
+type RouterMatch struct {
+	BranchNode
+	Address *net.IP `parser:"@Address"`
+}
+
+func (o RouterMatch) children() []Node { return nil }
+
+type NextHopMatch struct {
+	BranchNode
+	Address *net.IP `parser:"@Address"`
+}
+
+func (o NextHopMatch) children() []Node { return nil }
+
+type NextHopAsnMatch struct {
+	BranchNode
+	Asn *uint32 `parser:"@Number"`
+}
+
+func (o NextHopAsnMatch) children() []Node { return nil }
+
+type ByteRangeMatch struct{ NumericRange }
+
+type PacketRangeMatch struct{ NumericRange }
+
+type RemoteCountryMatch struct {
+	BranchNode
+	CountryCode *String      `parser:"  @CountryCode"`
+	Value       *StringMatch `parser:"| @@"`
+}
+
+func (o RemoteCountryMatch) children() []Node { return nil }
+
+type FlowDirectionMatch struct {
+	BranchNode
+	FlowDirection *String `parser:"@('incoming'|'outgoing')"`
+}
+
+func (o FlowDirectionMatch) children() []Node { return nil }
+
+type NormalizedMatch struct {
+	BranchNode
+	Normalized bool `parser:"@'normalized'"`
+}
+
+func (o NormalizedMatch) children() []Node { return nil }
+
+type DurationRangeMatch struct{ NumericRange }
+
+type EtypeMatch struct {
+	BranchNode
+	Etype    *Number   `parser:"  @Number"`
+	EtypeKey *EtypeKey `parser:"| @EtypeMagic"`
+}
+
+func (o EtypeMatch) children() []Node {
+	return []Node{o.Etype, o.EtypeKey}
+}
+
+type EtypeKey Number
+
+func (o EtypeKey) children() []Node { return nil }
+
+func (o *EtypeKey) Capture(values []string) error {
+	*o = EtypeKey(EtypeMagicMap[values[0]])
+	return nil
+}
+
+type ProtoMatch struct {
+	BranchNode
+	Proto    *Number   `parser:"  @Number"`
+	ProtoKey *ProtoKey `parser:"| @ProtoMagic"`
+}
+
+func (o ProtoMatch) children() []Node {
+	return []Node{o.Proto, o.ProtoKey}
+}
+
+type ProtoKey Number
+
+func (o ProtoKey) children() []Node { return nil }
+
+func (o *ProtoKey) Capture(values []string) error {
+	*o = ProtoKey(ProtoMagicMap[values[0]])
+	return nil
+}
+
+type StatusMatch struct {
+	BranchNode
+	Status    *Number    `parser:"  @Number"`
+	StatusKey *StatusKey `parser:"| @StatusMagic"`
+}
+
+func (o StatusMatch) children() []Node {
+	return []Node{o.Status, o.StatusKey}
+}
+
+type StatusKey Number
+
+func (o StatusKey) children() []Node { return nil }
+
+func (o *StatusKey) Capture(values []string) error {
+	*o = StatusKey(StatusMagicMap[values[0]])
+	return nil
+}
+
+type TcpFlagsMatch struct {
+	BranchNode
+	TcpFlags    *Number      `parser:"  @Number"`
+	TcpFlagsKey *TcpFlagsKey `parser:"| @TcpFlagsMagic"`
+}
+
+func (o TcpFlagsMatch) children() []Node {
+	return []Node{o.TcpFlags, o.TcpFlagsKey}
+}
+
+type TcpFlagsKey Number
+
+func (o TcpFlagsKey) children() []Node { return nil }
+
+func (o *TcpFlagsKey) Capture(values []string) error {
+	*o = TcpFlagsKey(TcpFlagsMagicMap[values[0]])
+	return nil
+}
+
+type IpTosRangeMatch struct{ NumericRange }
+
+type DscpMatch struct {
+	BranchNode
+	Dscp    *Number  `parser:"  @Number"` // first 6 bits of iptos
+	DscpKey *DscpKey `parser:"| @DscpMagic"`
+}
+
+func (o DscpMatch) children() []Node {
+	return []Node{o.Dscp, o.DscpKey}
+}
+
+type DscpKey Number
+
+func (o DscpKey) children() []Node { return nil }
+
+func (o *DscpKey) Capture(values []string) error {
+	*o = DscpKey(DscpMagicMap[values[0]])
+	return nil
+}
+
+type EcnMatch struct {
+	BranchNode
+	Ecn    *Number `parser:"  @Number"` // last 2 bits of iptos
+	EcnKey *EcnKey `parser:"| @EcnMagic"`
+}
+
+func (o EcnMatch) children() []Node {
+	return []Node{o.Ecn, o.EcnKey}
+}
+
+type EcnKey Number
+
+func (o EcnKey) children() []Node { return nil }
+
+func (o *EcnKey) Capture(values []string) error {
+	*o = EcnKey(EcnMagicMap[values[0]])
+	return nil
+}
+
+type SamplingRateRangeMatch struct{ NumericRange }
+
+type CidRangeMatch struct{ NumericRange }
+
+type IcmpMatch struct {
+	BranchNode
+	Type *Number `parser:"  ( 'type' @Number )"`
+	Code *Number `parser:"| ( 'code' @Number )"`
+}
+
+func (o IcmpMatch) children() []Node {
+	return []Node{o.Type, o.Code}
+}
+
+type BpsRangeMatch struct{ NumericRange }
+
+type PpsRangeMatch struct{ NumericRange }
+
+type PassesThroughListMatch struct {
+	BranchNode
+	Numbers []Number `parser:"@Number @Number*"`
+}
+
+func (o PassesThroughListMatch) children() []Node { return nil }
+
+type MedRangeMatch struct{ NumericRange }
+
+type LocalPrefRangeMatch struct{ NumericRange }
+
+type RpkiMatch struct {
+	BranchNode
+	RpkiKey *RpkiKey `parser:"@RpkiMagic"`
+}
+
+func (o RpkiMatch) children() []Node {
+	return []Node{o.RpkiKey}
+}
+
+type RpkiKey Number
+
+func (o RpkiKey) children() []Node { return nil }
+
+func (o *RpkiKey) Capture(values []string) error {
+	*o = RpkiKey(RpkiMagicMap[values[0]])
+	return nil
+}
+
+type AsPathMatch struct {
+	BranchNode
+	Origin   *Number                 `parser:"  ( 'origin' @Number )"`
+	Length   *AsPathLengthRangeMatch `parser:"| ( 'length' @@ )"`
+	Contains []Number                `parser:"| ( 'contains' @Number+ )"`
+	Set      *AsnSet                 `parser:"| ( 'in' @FileReference )"`
+}
+
+func (o AsPathMatch) children() []Node {
+	return []Node{o.Length}
+}
+
+type AsPathLengthRangeMatch struct{ NumericRange }
+
+type ProtoNameMatch struct{ StringMatch }
+
+type ServiceMatch struct{ StringMatch }
+
+type ApplicationMatch struct{ StringMatch }
+
+type NoteMatch struct{ StringMatch }
+
+type LabelMatch struct {
+	BranchNode
+	Key   *LabelKey `parser:"@Label"`
+	Value *String   `parser:"@String?"`
+}
+
+func (o LabelMatch) children() []Node { return nil }
+
+type LabelKey string
+
+func (o LabelKey) children() []Node { return nil }
+
+func (o *LabelKey) Capture(values []string) error {
+	*o = LabelKey(strings.TrimPrefix(values[0], "labels."))
+	return nil
+}
+
+// Directional Matches:
+// * anything that has further sub commands or accepts fancy data
+// * no direction
+// * several dedicated Match structs for different data types and sub commands
+type DirectionalMatchGroup struct {
+	BranchNode
+	Direction  *String            `parser:"@Direction?"`
+	AddressSet *AddressSetMatch   `parser:"( 'address' ( 'in' @@"`
+	Address    *AddressMatch      `parser:"            | @@ )"`
+	Interface  *InterfaceMatch    `parser:"| ('iface'|'interface') @@"`
+	Port       *PortRangeMatch    `parser:"| 'port' @@"`
+	AsnSet     *AsnSetMatch       `parser:"| 'asn' ( 'in' @@"`
+	Asn        *AsnRangeMatch     `parser:"        | @@ )"`
+	Netsize    *NetsizeRangeMatch `parser:"| 'netsize' @@"`
+	Cid        *CidRangeMatch     `parser:"| 'cid' @@"`
+	Vrf        *VrfRangeMatch     `parser:"| 'vrf' @@"`
+	HostName   *HostNameMatch     `parser:"| 'hostname' @@"`
+	NetName    *NetNameMatch      `parser:"| 'netname' @@"`
+	AsName     *AsNameMatch       `parser:"| 'asname' @@"`
+	City       *CityMatch         `parser:"| 'city' @@"`
+	Region     *RegionMatch       `parser:"| 'region' @@ )"`
+}
+
+func (o DirectionalMatchGroup) children() []Node {
+	return []Node{o.Direction, o.AddressSet, o.Address, o.Interface, o.Port,
+		o.AsnSet, o.Asn, o.Netsize, o.Cid, o.Vrf, o.HostName, o.NetName,
+		o.AsName, o.City, o.Region}
+}
+
+type AddressMatch struct {
+	BranchNode
+	Address *net.IP `parser:"@Address"`
+	Mask    *Number `parser:"( '/' @Number)?"`
+}
+
+func (o AddressMatch) children() []Node { return nil }
+
+type AddressSetMatch struct {
+	BranchNode
+	Set *AddressSet `parser:"@FileReference"`
+}
+
+func (o AddressSetMatch) children() []Node { return nil }
+
+type InterfaceMatch struct {
+	BranchNode
+	SnmpId           *Number            `parser:"  (   'id'? @Number )"`
+	Name             *String            `parser:"| ( 'name' ( @String"`
+	NameMatch        *IfNameMatch       `parser:"           | @@ ) )"`
+	Description      *String            `parser:"| ( 'desc' ( @String"`
+	DescriptionMatch *IfDescMatch       `parser:"           | @@ ) )"`
+	Speed            *IfSpeedRangeMatch `parser:"| ('speed'  @@)"`
+	Role             *IfRoleMatch       `parser:"| ('role'  @@)"`
+}
+
+func (o InterfaceMatch) children() []Node {
+	return []Node{o.SnmpId, o.Name, o.NameMatch, o.Description,
+		o.DescriptionMatch, o.Speed, o.Role}
+}
+
+type IfNameMatch struct{ StringMatch }
+
+type IfDescMatch struct{ StringMatch }
+
+type IfRoleMatch struct{ StringMatch }
+
+type IfSpeedRangeMatch struct{ NumericRange }
+
+type PortRangeMatch struct{ NumericRange }
+
+type AsnRangeMatch struct{ NumericRange }
+
+type AsnSetMatch struct {
+	BranchNode
+	Set *AsnSet `parser:"@FileReference"`
+}
+
+func (o AsnSetMatch) children() []Node { return nil }
+
+type NetsizeRangeMatch struct{ NumericRange }
+
+type VrfRangeMatch struct{ NumericRange }
+
+type HostNameMatch struct{ StringMatch }
+
+type NetNameMatch struct{ StringMatch }
+
+type AsNameMatch struct{ StringMatch }
+
+type CityMatch struct{ StringMatch }
+
+type RegionMatch struct{ StringMatch }
@@ -4,10 +4,11 @@ package flowfilter
 import (
 	"fmt"
 	"net"
+	"slices"
 	"strings"
 
-	"github.com/BelWue/flowfilter/parser"
 	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments/filter/flowfilter/parser"
 )
 
 type Filter struct {
@@ -28,12 +29,19 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 	// new nodes haven't been added to this visitor yet.
 	switch node := n.(type) {
 	case *parser.AddressMatch:
+	case *parser.AddressSetMatch:
 	case *parser.Address:
+	case *parser.ApplicationMatch:
+	case *parser.AsNameMatch:
 	case *parser.AsnRangeMatch:
+	case *parser.AsnSetMatch:
+	case *parser.AsPathLengthRangeMatch:
+	case *parser.AsPathMatch:
 	case *parser.Boolean:
 	case *parser.BpsRangeMatch:
 	case *parser.ByteRangeMatch:
 	case *parser.CidRangeMatch:
+	case *parser.CityMatch:
 	case *parser.DirectionalMatchGroup:
 	case *parser.DurationRangeMatch:
 	case *parser.DscpKey:
@@ -44,16 +52,23 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 	case *parser.EtypeMatch:
 	case *parser.Expression:
 	case *parser.FlowDirectionMatch:
+	case *parser.HostNameMatch:
 	case *parser.IcmpMatch:
+	case *parser.IfDescMatch:
+	case *parser.IfNameMatch:
+	case *parser.IfRoleMatch:
 	case *parser.IfSpeedRangeMatch:
 	case *parser.InterfaceMatch:
 	case *parser.IpTosRangeMatch:
+	case *parser.LabelMatch:
 	case *parser.MedRangeMatch:
 	case *parser.LocalPrefRangeMatch:
+	case *parser.NetNameMatch:
 	case *parser.NetsizeRangeMatch:
 	case *parser.NextHopMatch:
 	case *parser.NextHopAsnMatch:
 	case *parser.NormalizedMatch:
+	case *parser.NoteMatch:
 	case *parser.Number:
 	case *parser.PacketRangeMatch:
 	case *parser.PortRangeMatch:
@@ -61,13 +76,16 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 	case *parser.PassesThroughListMatch:
 	case *parser.ProtoKey:
 	case *parser.ProtoMatch:
+	case *parser.ProtoNameMatch:
 	case *parser.RangeEnd:
+	case *parser.RegionMatch:
 	case *parser.RegularMatchGroup:
 	case *parser.RemoteCountryMatch:
 	case *parser.RouterMatch:
 	case *parser.RpkiKey:
 	case *parser.RpkiMatch:
 	case *parser.SamplingRateRangeMatch:
+	case *parser.ServiceMatch:
 	case *parser.Statement:
 	case *parser.StatusKey:
 	case *parser.StatusMatch:
@@ -103,6 +121,48 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 			(*node).EvalResultSrc = net.IP(f.flowmsg.SrcAddr).Equal(*node.Address)
 			(*node).EvalResultDst = net.IP(f.flowmsg.DstAddr).Equal(*node.Address)
 		}
+	case *parser.AddressSetMatch:
+		(*node).EvalResultSrc = node.Set.Contains(f.flowmsg.SrcAddr)
+		(*node).EvalResultDst = node.Set.Contains(f.flowmsg.DstAddr)
+	case *parser.ApplicationMatch:
+		(*node).EvalResult = processStringMatch(node.StringMatch, f.flowmsg.GetLabel("appclass_application"))
+	case *parser.AsNameMatch:
+		(*node).EvalResultSrc = processStringMatch(node.StringMatch, f.flowmsg.SrcASName)
+		(*node).EvalResultDst = processStringMatch(node.StringMatch, f.flowmsg.DstASName)
+	case *parser.AsnSetMatch:
+		(*node).EvalResultSrc = node.Set.Contains(f.flowmsg.SrcAs)
+		(*node).EvalResultDst = node.Set.Contains(f.flowmsg.DstAs)
+	case *parser.AsPathLengthRangeMatch:
+		(*node).EvalResult, err = processNumericRange(node.NumericRange, uint64(len(f.flowmsg.AsPath)))
+		if err != nil {
+			return fmt.Errorf("[error] Bad aspath length range, lower %d > upper %d",
+				*node.Lower,
+				*node.Upper)
+		}
+	case *parser.AsPathMatch:
+		switch {
+		case node.Origin != nil:
+			length := len(f.flowmsg.AsPath)
+			(*node).EvalResult = length > 0 && f.flowmsg.AsPath[length-1] == uint32(*node.Origin)
+		case node.Length != nil:
+			(*node).EvalResult = node.Length.EvalResult
+		case node.Contains != nil:
+			(*node).EvalResult = true
+			for _, asn := range node.Contains {
+				if !slices.Contains(f.flowmsg.AsPath, uint32(asn)) {
+					(*node).EvalResult = false
+					break
+				}
+			}
+		case node.Set != nil:
+			(*node).EvalResult = false
+			for _, asn := range f.flowmsg.AsPath {
+				if node.Set.Contains(asn) {
+					(*node).EvalResult = true
+					break
+				}
+			}
+		}
 	case *parser.AsnRangeMatch:
 		(*node).EvalResultSrc, _ = processNumericRange(node.NumericRange, uint64(f.flowmsg.SrcAs))
 		(*node).EvalResultDst, err = processNumericRange(node.NumericRange, uint64(f.flowmsg.DstAs))
@@ -134,6 +194,9 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 				*node.Lower,
 				*node.Upper)
 		}
+	case *parser.CityMatch:
+		(*node).EvalResultSrc = processStringMatch(node.StringMatch, f.flowmsg.GetLabel("geo_src_city"))
+		(*node).EvalResultDst = processStringMatch(node.StringMatch, f.flowmsg.GetLabel("geo_dst_city"))
 	case *parser.RegularMatchGroup:
 		switch {
 		case node.Router != nil:
@@ -184,6 +247,18 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 			(*node).EvalResult = node.PassesThrough.EvalResult
 		case node.Rpki != nil:
 			(*node).EvalResult = node.Rpki.EvalResult
+		case node.AsPath != nil:
+			(*node).EvalResult = node.AsPath.EvalResult
+		case node.ProtoName != nil:
+			(*node).EvalResult = node.ProtoName.EvalResult
+		case node.Service != nil:
+			(*node).EvalResult = node.Service.EvalResult
+		case node.Application != nil:
+			(*node).EvalResult = node.Application.EvalResult
+		case node.Note != nil:
+			(*node).EvalResult = node.Note.EvalResult
+		case node.Label != nil:
+			(*node).EvalResult = node.Label.EvalResult
 		}
 	case *parser.DirectionalMatchGroup:
 		if node.Direction == nil {
@@ -202,6 +277,20 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 				(*node).EvalResult = node.Cid.EvalResult || node.Cid.EvalResultSrc || node.Cid.EvalResultDst
 			case node.Vrf != nil:
 				(*node).EvalResult = node.Vrf.EvalResultSrc || node.Vrf.EvalResultDst
+			case node.AddressSet != nil:
+				(*node).EvalResult = node.AddressSet.EvalResultSrc || node.AddressSet.EvalResultDst
+			case node.AsnSet != nil:
+				(*node).EvalResult = node.AsnSet.EvalResultSrc || node.AsnSet.EvalResultDst
+			case node.HostName != nil:
+				(*node).EvalResult = node.HostName.EvalResultSrc || node.HostName.EvalResultDst
+			case node.NetName != nil:
+				(*node).EvalResult = node.NetName.EvalResult || node.NetName.EvalResultSrc || node.NetName.EvalResultDst
+			case node.AsName != nil:
+				(*node).EvalResult = node.AsName.EvalResultSrc || node.AsName.EvalResultDst
+			case node.City != nil:
+				(*node).EvalResult = node.City.EvalResultSrc || node.City.EvalResultDst
+			case node.Region != nil:
+				(*node).EvalResult = node.Region.EvalResultSrc || node.Region.EvalResultDst
 			}
 		} else if *node.Direction == "src" {
 			switch {
@@ -219,6 +308,20 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 				(*node).EvalResult = node.Cid.EvalResultSrc
 			case node.Vrf != nil:
 				(*node).EvalResult = node.Vrf.EvalResultSrc
+			case node.AddressSet != nil:
+				(*node).EvalResult = node.AddressSet.EvalResultSrc
+			case node.AsnSet != nil:
+				(*node).EvalResult = node.AsnSet.EvalResultSrc
+			case node.HostName != nil:
+				(*node).EvalResult = node.HostName.EvalResultSrc
+			case node.NetName != nil:
+				(*node).EvalResult = node.NetName.EvalResultSrc
+			case node.AsName != nil:
+				(*node).EvalResult = node.AsName.EvalResultSrc
+			case node.City != nil:
+				(*node).EvalResult = node.City.EvalResultSrc
+			case node.Region != nil:
+				(*node).EvalResult = node.Region.EvalResultSrc
 			}
 		} else if *node.Direction == "dst" {
 			switch {
@@ -236,6 +339,20 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 				(*node).EvalResult = node.Cid.EvalResultDst
 			case node.Vrf != nil:
 				(*node).EvalResult = node.Vrf.EvalResultDst
+			case node.AddressSet != nil:
+				(*node).EvalResult = node.AddressSet.EvalResultDst
+			case node.AsnSet != nil:
+				(*node).EvalResult = node.AsnSet.EvalResultDst
+			case node.HostName != nil:
+				(*node).EvalResult = node.HostName.EvalResultDst
+			case node.NetName != nil:
+				(*node).EvalResult = node.NetName.EvalResultDst
+			case node.AsName != nil:
+				(*node).EvalResult = node.AsName.EvalResultDst
+			case node.City != nil:
+				(*node).EvalResult = node.City.EvalResultDst
+			case node.Region != nil:
+				(*node).EvalResult = node.Region.EvalResultDst
 			}
 		}
 	case *parser.DurationRangeMatch:
@@ -284,6 +401,9 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 		} else if *node.FlowDirection == "outgoing" {
 			(*node).EvalResult = f.flowmsg.FlowDirection == 1
 		}
+	case *parser.HostNameMatch:
+		(*node).EvalResultSrc = processStringMatch(node.StringMatch, f.flowmsg.SrcHostName)
+		(*node).EvalResultDst = processStringMatch(node.StringMatch, f.flowmsg.DstHostName)
 	case *parser.IcmpMatch:
 		if f.flowmsg.Proto != 1 {
 			(*node).EvalResult = false
@@ -295,6 +415,15 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 		case node.Code != nil:
 			(*node).EvalResult = uint32(*node.Code) == f.flowmsg.DstPort%256
 		}
+	case *parser.IfDescMatch:
+		(*node).EvalResultSrc = processStringMatch(node.StringMatch, f.flowmsg.SrcIfDesc)
+		(*node).EvalResultDst = processStringMatch(node.StringMatch, f.flowmsg.DstIfDesc)
+	case *parser.IfNameMatch:
+		(*node).EvalResultSrc = processStringMatch(node.StringMatch, f.flowmsg.SrcIfName)
+		(*node).EvalResultDst = processStringMatch(node.StringMatch, f.flowmsg.DstIfName)
+	case *parser.IfRoleMatch:
+		(*node).EvalResultSrc = processStringMatch(node.StringMatch, f.flowmsg.SrcIfRole)
+		(*node).EvalResultDst = processStringMatch(node.StringMatch, f.flowmsg.DstIfRole)
 	case *parser.IfSpeedRangeMatch:
 		(*node).EvalResultSrc, _ = processNumericRange(node.NumericRange, uint64(f.flowmsg.SrcIfSpeed)/1000)
 		(*node).EvalResultDst, err = processNumericRange(node.NumericRange, uint64(f.flowmsg.DstIfSpeed)/1000)
@@ -326,9 +455,18 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 				strings.ToLower(f.flowmsg.DstIfDesc),
 				strings.ToLower(string(*node.Description)),
 			)
+		case node.NameMatch != nil:
+			(*node).EvalResultSrc = node.NameMatch.EvalResultSrc
+			(*node).EvalResultDst = node.NameMatch.EvalResultDst
+		case node.DescriptionMatch != nil:
+			(*node).EvalResultSrc = node.DescriptionMatch.EvalResultSrc
+			(*node).EvalResultDst = node.DescriptionMatch.EvalResultDst
 		case node.Speed != nil:
 			(*node).EvalResultSrc = node.Speed.EvalResultSrc
 			(*node).EvalResultDst = node.Speed.EvalResultDst
+		case node.Role != nil:
+			(*node).EvalResultSrc = node.Role.EvalResultSrc
+			(*node).EvalResultDst = node.Role.EvalResultDst
 		}
 	case *parser.IpTosRangeMatch:
 		(*node).EvalResult, err = processNumericRange(node.NumericRange, uint64(f.flowmsg.IpTos))
@@ -337,6 +475,13 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 				*node.Lower,
 				*node.Upper)
 		}
+	case *parser.LabelMatch:
+		value, found := f.flowmsg.GetLabels()[string(*node.Key)]
+		if node.Value == nil {
+			(*node).EvalResult = found
+		} else {
+			(*node).EvalResult = found && value == string(*node.Value)
+		}
 	case *parser.LocalPrefRangeMatch:
 		(*node).EvalResult, err = processNumericRange(node.NumericRange, uint64(f.flowmsg.LocalPref))
 		if err != nil {
@@ -351,6 +496,10 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 				*node.Lower,
 				*node.Upper)
 		}
+	case *parser.NetNameMatch:
+		(*node).EvalResult = processStringMatch(node.StringMatch, f.flowmsg.NetIdString)
+		(*node).EvalResultSrc = processStringMatch(node.StringMatch, f.flowmsg.SrcIdString)
+		(*node).EvalResultDst = processStringMatch(node.StringMatch, f.flowmsg.DstIdString)
 	case *parser.NetsizeRangeMatch:
 		(*node).EvalResultSrc, _ = processNumericRange(node.NumericRange, uint64(f.flowmsg.SrcNet))
 		(*node).EvalResultDst, err = processNumericRange(node.NumericRange, uint64(f.flowmsg.DstNet))
@@ -365,6 +514,8 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 		(*node).EvalResult = f.flowmsg.NextHopAs == *node.Asn
 	case *parser.NormalizedMatch:
 		(*node).EvalResult = f.flowmsg.Normalized == 1
+	case *parser.NoteMatch:
+		(*node).EvalResult = processStringMatch(node.StringMatch, f.flowmsg.Note)
 	case *parser.PacketRangeMatch:
 		(*node).EvalResult, err = processNumericRange(node.NumericRange, f.flowmsg.Packets)
 		if err != nil {
@@ -419,8 +570,18 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 		case node.ProtoKey != nil:
 			(*node).EvalResult = f.flowmsg.Proto == uint32(*node.ProtoKey)
 		}
+	case *parser.ProtoNameMatch:
+		(*node).EvalResult = processStringMatch(node.StringMatch, f.flowmsg.ProtoName)
+	case *parser.RegionMatch:
+		(*node).EvalResultSrc = processStringMatch(node.StringMatch, f.flowmsg.GetLabel("geo_src_region"))
+		(*node).EvalResultDst = processStringMatch(node.StringMatch, f.flowmsg.GetLabel("geo_dst_region"))
 	case *parser.RemoteCountryMatch:
-		(*node).EvalResult = strings.Contains(f.flowmsg.RemoteCountry, strings.ToUpper(string(*node.CountryCode)))
+		switch {
+		case node.CountryCode != nil:
+			(*node).EvalResult = strings.Contains(f.flowmsg.RemoteCountry, strings.ToUpper(string(*node.CountryCode)))
+		case node.Value != nil:
+			(*node).EvalResult = processStringMatch(*node.Value, f.flowmsg.RemoteCountry)
+		}
 	case *parser.RouterMatch:
 		(*node).EvalResult = net.IP(f.flowmsg.SamplerAddress).Equal(*node.Address)
 	case *parser.RpkiMatch:
@@ -436,6 +597,8 @@ func (f *Filter) Visit(n parser.Node, next func() error) error {
 				*node.Lower,
 				*node.Upper)
 		}
+	case *parser.ServiceMatch:
+		(*node).EvalResult = processStringMatch(node.StringMatch, f.flowmsg.GetLabel("appclass_service"))
 	case *parser.Statement:
 		switch {
 		case node.DirectionalMatch != nil:
@@ -500,3 +663,23 @@ func processNumericRange(node parser.NumericRange, compare uint64) (bool, error)
 		}
 	}
 }
+
+func processStringMatch(node parser.StringMatch, compare string) bool {
+	if node.Regex != nil {
+		return node.Regex.MatchString(compare)
+	}
+	var operator string
+	if node.Operator != nil {
+		operator = string(*node.Operator)
+	}
+	switch operator {
+	case "prefix":
+		return strings.HasPrefix(compare, string(*node.Value))
+	case "suffix":
+		return strings.HasSuffix(compare, string(*node.Value))
+	case "contains":
+		return strings.Contains(compare, string(*node.Value))
+	default:
+		return compare == string(*node.Value)
+	}
+}
@@ -0,0 +1,240 @@
+// The `ratelimit` segment limits the number of flows per key using token
+// buckets, for instance to keep a single attacked destination from flooding
+// subsequent alerting or storage segments. The key is set by the `key`
+// parameter, a comma-separated list of `srcaddr`, `dstaddr`, `srcport`,
+// `dstport`, `proto`, `cid`, `sampleraddress`, `srcas` and `dstas`.
+//
+// Each key has its own bucket holding up to `burst` tokens, which is refilled
+// with `rate` tokens per second. Each flow takes one token, and flows arriving
+// at an empty bucket are suppressed. Buckets are kept for at most `maxkeys`
+// keys, evicting the least recently used key when this limit is reached.
+//
+// In the interval given by `summaryinterval`, a summary flow is emitted for
+// each key with suppressed flows. Summary flows contain the key fields, the sum
+// of Bytes and Packets of all suppressed flows and the label
+// `ratelimit_suppressed` with the number of suppressed flows. Suppressed flows
+// are available to the `else` branch when using this segment as a condition of
+// the `branch` segment.
+package ratelimit
+
+import (
+	"bytes"
+	"container/list"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type RateLimit struct {
+	segments.BaseFilterSegment
+	Key             []string      // optional, default is "dstaddr", fields identifying a bucket
+	Rate            float64       // optional, default is 100, tokens added to each bucket per second
+	Burst           float64       // optional, default is 1000, maximum number of tokens per bucket
+	MaxKeys         int           // optional, default is 100000, maximum number of buckets kept
+	SummaryInterval time.Duration // optional, default is 1m, interval for emitting summary flows, 0 disables them
+
+	buckets map[string]*list.Element
+	lru     *list.List // buckets by last use, least recently used first
+}
+
+var keyFields = []string{"srcaddr", "dstaddr", "srcport", "dstport", "proto", "cid", "sampleraddress", "srcas", "dstas"}
+
+type bucket struct {
+	key        string
+	tokens     float64
+	updated    time.Time
+	template   *pb.EnrichedFlow // the key fields of this bucket
+	suppressed uint64
+	bytes      uint64
+	packets    uint64
+}
+
+func (segment RateLimit) New(config map[string]string) segments.Segment {
+	var key = []string{"dstaddr"}
+	if config["key"] != "" {
+		key = nil
+		for _, field := range strings.Split(config["key"], ",") {
+			field = strings.TrimSpace(strings.ToLower(field))
+			if !slices.Contains(keyFields, field) {
+				log.Error().Msgf("RateLimit: Unknown key field '%s', options are %s.", field, strings.Join(keyFields, ", "))
+				return nil
+			}
+			key = append(key, field)
+		}
+	} else {
+		log.Info().Msg("RateLimit: 'key' set to default 'dstaddr'.")
+	}
+
+	var rate float64 = 100
+	if config["rate"] != "" {
+		if parsedRate, err := strconv.ParseFloat(config["rate"], 64); err == nil && parsedRate > 0 {
+			rate = parsedRate
+		} else {
+			log.Error().Msg("RateLimit: Could not parse 'rate' parameter, using default 100.")
+		}
+	} else {
+		log.Info().Msg("RateLimit: 'rate' set to default 100.")
+	}
+
+	var burst float64 = 1000
+	if config["burst"] != "" {
+		if parsedBurst, err := strconv.ParseFloat(config["burst"], 64); err == nil && parsedBurst >= 1 {
+			burst = parsedBurst
+		} else {
+			log.Error().Msg("RateLimit: Could not parse 'burst' parameter, using default 1000.")
+		}
+	} else {
+		log.Info().Msg("RateLimit: 'burst' set to default 1000.")
+	}
+
+	var maxKeys = 100000
+	if config["maxkeys"] != "" {
+		if parsedMaxKeys, err := strconv.Atoi(config["maxkeys"]); err == nil && parsedMaxKeys > 0 {
+			maxKeys = parsedMaxKeys
+		} else {
+			log.Error().Msg("RateLimit: Could not parse 'maxkeys' parameter, using default 100000.")
+		}
+	} else {
+		log.Info().Msg("RateLimit: 'maxkeys' set to default 100000.")
+	}
+
+	var summaryInterval = time.Minute
+	if config["summaryinterval"] != "" {
+		if parsedInterval, err := time.ParseDuration(config["summaryinterval"]); err == nil && parsedInterval >= 0 {
+			summaryInterval = parsedInterval
+		} else {
+			log.Error().Msg("RateLimit: Could not parse 'summaryinterval' parameter, using default 1m.")
+		}
+	} else {
+		log.Info().Msg("RateLimit: 'summaryinterval' set to default 1m.")
+	}
+
+	return &RateLimit{
+		Key:             key,
+		Rate:            rate,
+		Burst:           burst,
+		MaxKeys:         maxKeys,
+		SummaryInterval: summaryInterval,
+	}
+}
+
+func (segment *RateLimit) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.buckets = make(map[string]*list.Element)
+	segment.lru = list.New()
+
+	var tick <-chan time.Time
+	if segment.SummaryInterval > 0 {
+		ticker := time.NewTicker(segment.SummaryInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				segment.summarize()
+				return
+			}
+			if segment.allow(msg, time.Now()) {
+				segment.Out <- msg
+			} else if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+		case <-tick:
+			segment.summarize()
+		}
+	}
+}
+
+// Takes a token from the flow's bucket, returning false if there is none.
+func (segment *RateLimit) allow(msg *pb.EnrichedFlow, now time.Time) bool {
+	key := msg.GroupKey(segment.Key)
+	var b *bucket
+	if element, ok := segment.buckets[key]; ok {
+		segment.lru.MoveToBack(element)
+		b = element.Value.(*bucket)
+		b.tokens = min(segment.Burst, b.tokens+now.Sub(b.updated).Seconds()*segment.Rate)
+		b.updated = now
+	} else {
+		if segment.lru.Len() >= segment.MaxKeys {
+			oldest := segment.lru.Front()
+			segment.emitSummary(oldest.Value.(*bucket))
+			delete(segment.buckets, oldest.Value.(*bucket).key)
+			segment.lru.Remove(oldest)
+		}
+		b = &bucket{key: key, tokens: segment.Burst, updated: now, template: segment.keyFlow(msg)}
+		segment.buckets[key] = segment.lru.PushBack(b)
+	}
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true
+	}
+	b.suppressed += 1
+	b.bytes += msg.Bytes
+	b.packets += msg.Packets
+	return false
+}
+
+func (segment *RateLimit) summarize() {
+	for element := segment.lru.Front(); element != nil; element = element.Next() {
+		segment.emitSummary(element.Value.(*bucket))
+	}
+}
+
+// Emits a summary flow for a bucket if it has suppressed any flows since the
+// last summary.
+func (segment *RateLimit) emitSummary(b *bucket) {
+	if b.suppressed == 0 {
+		return
+	}
+	summary := segment.keyFlow(b.template)
+	summary.Bytes, summary.Packets = b.bytes, b.packets
+	summary.TimeReceivedNs = uint64(time.Now().UnixNano())
+	summary.SetLabel("ratelimit_suppressed", strconv.FormatUint(b.suppressed, 10))
+	b.suppressed, b.bytes, b.packets = 0, 0, 0
+	segment.Out <- summary
+}
+
+// Returns a new flow containing only the key fields of the given flow.
+func (segment *RateLimit) keyFlow(msg *pb.EnrichedFlow) *pb.EnrichedFlow {
+	flow := &pb.EnrichedFlow{}
+	for _, field := range segment.Key {
+		switch field {
+		case "srcaddr":
+			flow.SrcAddr = bytes.Clone(msg.SrcAddr)
+		case "dstaddr":
+			flow.DstAddr = bytes.Clone(msg.DstAddr)
+		case "srcport":
+			flow.SrcPort = msg.SrcPort
+		case "dstport":
+			flow.DstPort = msg.DstPort
+		case "proto":
+			flow.Proto = msg.Proto
+		case "cid":
+			flow.Cid = msg.Cid
+		case "sampleraddress":
+			flow.SamplerAddress = bytes.Clone(msg.SamplerAddress)
+		case "srcas":
+			flow.SrcAs = msg.SrcAs
+		case "dstas":
+			flow.DstAs = msg.DstAs
+		}
+	}
+	return flow
+}
+
+func init() {
+	segment := &RateLimit{}
+	segments.RegisterSegment("ratelimit", segment)
+}
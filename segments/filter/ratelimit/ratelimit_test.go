@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Runs the given flows through a ratelimit segment, returning passed and suppressed flows.
+func runRateLimit(config map[string]string, flows ...*pb.EnrichedFlow) ([]*pb.EnrichedFlow, []*pb.EnrichedFlow) {
+	segment := segments.LookupSegment("ratelimit").New(config)
+	if segment == nil {
+		log.Fatal().Msg("Configured segment 'ratelimit' could not be initialized properly, see previous messages.")
+	}
+
+	in, out, drops := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	segment.(*RateLimit).SubscribeDrops(drops)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(wg)
+
+	var passed, suppressed []*pb.EnrichedFlow
+	done := make(chan struct{})
+	go func() {
+		for out != nil {
+			select {
+			case msg, ok := <-out:
+				if !ok {
+					out = nil
+					continue
+				}
+				passed = append(passed, msg)
+			case msg := <-drops:
+				suppressed = append(suppressed, msg)
+			}
+		}
+		close(done)
+	}()
+	for _, flow := range flows {
+		in <- flow
+	}
+	close(in)
+	wg.Wait()
+	<-done
+	return passed, suppressed
+}
+
+// RateLimit Segment test, burst and summary
+func TestSegment_RateLimit_burst(t *testing.T) {
+	var flows []*pb.EnrichedFlow
+	for i := 0; i < 5; i++ {
+		flows = append(flows, &pb.EnrichedFlow{DstAddr: []byte{192, 0, 2, 1}, Bytes: 100, Packets: 1})
+	}
+	flows = append(flows, &pb.EnrichedFlow{DstAddr: []byte{192, 0, 2, 2}, Bytes: 100, Packets: 1})
+	passed, suppressed := runRateLimit(map[string]string{"burst": "2", "rate": "0.001"}, flows...)
+	if len(suppressed) != 3 {
+		t.Errorf("([error] Segment RateLimit suppressed %d flows instead of 3.", len(suppressed))
+	}
+	if len(passed) != 4 {
+		t.Fatalf("([error] Segment RateLimit passed %d flows instead of 3 flows and a summary.", len(passed))
+	}
+	summary := passed[3]
+	if summary.GetLabel("ratelimit_suppressed") != "3" || summary.Bytes != 300 || summary.DstAddrObj().String() != "192.0.2.1" {
+		t.Error("([error] Segment RateLimit is not emitting a correct summary flow.")
+	}
+}
+
+// RateLimit Segment test, LRU eviction
+func TestSegment_RateLimit_maxKeys(t *testing.T) {
+	passed, suppressed := runRateLimit(map[string]string{"burst": "1", "rate": "0.001", "maxkeys": "1", "key": "srcport"},
+		&pb.EnrichedFlow{SrcPort: 1},
+		&pb.EnrichedFlow{SrcPort: 2},
+		&pb.EnrichedFlow{SrcPort: 1})
+	if len(passed) != 3 || len(suppressed) != 0 {
+		t.Error("([error] Segment RateLimit is not evicting buckets.")
+	}
+}
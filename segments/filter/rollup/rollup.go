@@ -0,0 +1,341 @@
+// The `rollup` segment summarizes flows into time-bucketed records. Flows are
+// assigned to fixed, aligned buckets of the duration set by `bucket` using
+// their end time, falling back to the time they were received, and grouped by
+// the fields given in `fields`. For a list of fields, check our protobuf
+// definition. Single entries of the Labels field can be grouped by as
+// `labels.<key>`.
+//
+// Once a bucket closes, one flow is emitted for each of its groups, carrying
+// only the grouped fields, the bucket's start and end time, the summed Bytes
+// and Packets, and the number of summarized flows in RollupFlows. As the sums
+// account for the sampling rate of each flow, summaries are marked as
+// normalized.
+//
+// Buckets are closed by a watermark trailing the latest flow time seen by
+// `lateness`, allowing delayed flows to be included. Flow times ahead of the
+// local clock do not advance the watermark beyond it, so that exporters with a
+// skewed clock can not close the buckets of all other flows. Flows belonging to
+// a bucket which has been closed already are not included in any summary, and
+// their number is logged periodically. If no flows arrive for the duration of
+// `lateness`, all open buckets are closed. At most `maxgroups` groups are held
+// across all open buckets, further groups being skipped until buckets close.
+//
+// The summarized flows themselves are not passed on, but are available to the
+// `else` branch when using this segment as a condition of the `branch`
+// segment, allowing to store summaries alongside raw data.
+package rollup
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Rollup struct {
+	segments.BaseFilterSegment
+	Fields    []string      // required, fields by which flows are grouped
+	Bucket    time.Duration // optional, default is 1m, duration of a bucket
+	Lateness  time.Duration // optional, default is 30s, how long after a bucket's end delayed flows are accepted
+	MaxGroups int           // optional, default is 100000, maximum number of groups held across all open buckets
+
+	buckets     map[int64]map[string]*group // groups by key by bucket start
+	groups      int
+	latest      int64  // latest flow time seen, at most the local time
+	closedUntil int64  // end of the latest closed bucket
+	late        uint64 // flows of closed buckets since the last report
+	skipped     uint64 // flows skipped because of 'maxgroups' since it was reached
+	skipping    bool   // whether 'maxgroups' is reached, for limiting logs
+}
+
+// A set of flows sharing the values of all grouped fields within a bucket.
+type group struct {
+	key     string
+	flow    *pb.EnrichedFlow // the grouped fields, becoming the summary
+	bytes   uint64
+	packets uint64
+	flows   uint64
+}
+
+func (segment Rollup) New(config map[string]string) segments.Segment {
+	if config["fields"] == "" {
+		log.Error().Msg("Rollup: The 'fields' parameter is required.")
+		return nil
+	}
+	var fields []string
+	reflected := reflect.ValueOf(&pb.EnrichedFlow{}).Elem()
+	for _, field := range strings.Split(config["fields"], ",") {
+		field = strings.TrimSpace(field)
+		fields = append(fields, field)
+		if _, ok := pb.LabelKey(field); ok {
+			continue
+		}
+		if value := reflected.FieldByName(field); !value.IsValid() || !value.CanSet() {
+			log.Error().Msgf("Rollup: Field '%s' in 'fields' parameter is not valid.", field)
+			return nil
+		}
+	}
+
+	var bucket = time.Minute
+	if config["bucket"] != "" {
+		if parsedBucket, err := time.ParseDuration(config["bucket"]); err == nil && parsedBucket > 0 {
+			bucket = parsedBucket
+		} else {
+			log.Error().Msg("Rollup: Could not parse 'bucket' parameter, using default 1m.")
+		}
+	} else {
+		log.Info().Msg("Rollup: 'bucket' set to default 1m.")
+	}
+
+	var lateness = 30 * time.Second
+	if config["lateness"] != "" {
+		if parsedLateness, err := time.ParseDuration(config["lateness"]); err == nil && parsedLateness >= 0 {
+			lateness = parsedLateness
+		} else {
+			log.Error().Msg("Rollup: Could not parse 'lateness' parameter, using default 30s.")
+		}
+	} else {
+		log.Info().Msg("Rollup: 'lateness' set to default 30s.")
+	}
+
+	var maxGroups = 100000
+	if config["maxgroups"] != "" {
+		if parsedMaxGroups, err := strconv.Atoi(config["maxgroups"]); err == nil && parsedMaxGroups > 0 {
+			maxGroups = parsedMaxGroups
+		} else {
+			log.Error().Msg("Rollup: Could not parse 'maxgroups' parameter, using default 100000.")
+		}
+	} else {
+		log.Info().Msg("Rollup: 'maxgroups' set to default 100000.")
+	}
+
+	return &Rollup{
+		Fields:    fields,
+		Bucket:    bucket,
+		Lateness:  lateness,
+		MaxGroups: maxGroups,
+	}
+}
+
+func (segment *Rollup) Run(wg *sync.WaitGroup) {
+	defer func() {
+		segment.flush()
+		close(segment.Out)
+		wg.Done()
+	}()
+	segment.setup()
+
+	idle := segment.Lateness
+	if idle < time.Second {
+		idle = time.Second
+	}
+	ticker := time.NewTicker(idle)
+	defer ticker.Stop()
+	lastFlow := time.Now()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			lastFlow = time.Now()
+			segment.add(msg, lastFlow)
+			if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+		case now := <-ticker.C:
+			if now.Sub(lastFlow) >= segment.Lateness {
+				segment.flush()
+			}
+			if segment.late > 0 {
+				log.Warn().Msgf("Rollup: Skipped %d flows of already closed buckets, consider increasing 'lateness'.", segment.late)
+				segment.late = 0
+			}
+		}
+	}
+}
+
+func (segment *Rollup) setup() {
+	segment.buckets = make(map[int64]map[string]*group)
+}
+
+// Returns the time a flow is bucketed by.
+func flowTime(msg *pb.EnrichedFlow) int64 {
+	if msg.TimeFlowEndNs != 0 {
+		return int64(msg.TimeFlowEndNs)
+	}
+	return int64(msg.TimeReceivedNs)
+}
+
+// Returns the values of all grouped fields, separated by null bytes.
+func (segment *Rollup) key(msg *pb.EnrichedFlow) string {
+	var key strings.Builder
+	reflected := reflect.ValueOf(msg).Elem()
+	for _, field := range segment.Fields {
+		if labelKey, ok := pb.LabelKey(field); ok {
+			key.WriteString(msg.GetLabel(labelKey))
+		} else if value := reflected.FieldByName(field); value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8 {
+			key.Write(value.Bytes())
+		} else {
+			fmt.Fprint(&key, value.Interface())
+		}
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+// Closes all buckets passed by the watermark and accounts a flow to its bucket
+// and group.
+func (segment *Rollup) add(msg *pb.EnrichedFlow, now time.Time) {
+	ts := flowTime(msg)
+	bucketNs := int64(segment.Bucket)
+	start := ts - ts%bucketNs
+	if start+bucketNs <= segment.closedUntil {
+		segment.late += 1
+		return
+	}
+	if latest := min(ts, now.UnixNano()); latest > segment.latest {
+		segment.latest = latest
+		segment.close(latest - int64(segment.Lateness))
+	}
+
+	key := segment.key(msg)
+	g, ok := segment.buckets[start][key]
+	if !ok {
+		if segment.groups >= segment.MaxGroups {
+			if !segment.skipping {
+				log.Warn().Msg("Rollup: Reached 'maxgroups', skipping flows of new groups.")
+				log.Warn().Msg("Rollup: Above message will not repeat for every flow and is effective until resolved.")
+				segment.skipping = true
+			}
+			segment.skipped += 1
+			return
+		}
+		if segment.buckets[start] == nil {
+			segment.buckets[start] = make(map[string]*group)
+		}
+		g = &group{key: key, flow: segment.skeleton(msg)}
+		segment.buckets[start][key] = g
+		segment.groups += 1
+	}
+	g.flows += 1
+	bytes, packets := msg.ScaledCounters()
+	g.bytes += bytes
+	g.packets += packets
+}
+
+// Emits and removes all buckets ending at or before the given watermark.
+func (segment *Rollup) close(watermark int64) {
+	bucketNs := int64(segment.Bucket)
+	closedUntil := watermark - watermark%bucketNs
+	if closedUntil <= segment.closedUntil {
+		return
+	}
+	segment.closedUntil = closedUntil
+
+	var starts []int64
+	for start := range segment.buckets {
+		if start+bucketNs <= closedUntil {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, start := range starts {
+		segment.emit(start, segment.buckets[start])
+		segment.groups -= len(segment.buckets[start])
+		delete(segment.buckets, start)
+	}
+	if segment.skipping && segment.groups < segment.MaxGroups {
+		log.Info().Msgf("Rollup: Buckets have been closed, accepting new groups again after skipping %d flows.", segment.skipped)
+		segment.skipping = false
+		segment.skipped = 0
+	}
+}
+
+// Closes all open buckets.
+func (segment *Rollup) flush() {
+	if len(segment.buckets) == 0 {
+		return
+	}
+	segment.close(segment.latest + int64(segment.Bucket))
+}
+
+func (segment *Rollup) emit(start int64, groups map[string]*group) {
+	var sorted []*group
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].key < sorted[j].key })
+
+	now := uint64(time.Now().UnixNano())
+	for _, g := range sorted {
+		summary := g.flow
+		summary.TimeReceivedNs = now
+		summary.TimeFlowStartNs = uint64(start)
+		summary.TimeFlowEndNs = uint64(start + int64(segment.Bucket))
+		summary.Bytes = g.bytes
+		summary.Packets = g.packets
+		summary.Normalized = pb.EnrichedFlow_Yes
+		summary.RollupFlows = g.flows
+		segment.Out <- summary
+	}
+}
+
+// Returns a new flow carrying only the grouped fields of the given one.
+func (segment *Rollup) skeleton(msg *pb.EnrichedFlow) *pb.EnrichedFlow {
+	result := &pb.EnrichedFlow{}
+	original := reflect.ValueOf(msg).Elem()
+	reflected := reflect.ValueOf(result).Elem()
+	for _, field := range segment.Fields {
+		if labelKey, ok := pb.LabelKey(field); ok {
+			if value, found := msg.GetLabels()[labelKey]; found {
+				result.SetLabel(labelKey, value)
+			}
+			continue
+		}
+		reflected.FieldByName(field).Set(clone(original.FieldByName(field)))
+	}
+	return result
+}
+
+// Returns a copy of a field's value not sharing memory with the original flow,
+// which is passed on independently.
+func clone(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return reflect.ValueOf(bytes.Clone(value.Bytes()))
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(copied, value)
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return copied
+	}
+	return value
+}
+
+func init() {
+	segment := &Rollup{}
+	segments.RegisterSegment("rollup", segment)
+}
@@ -0,0 +1,103 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+var base = uint64(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+
+// Rollup Segment test, summary on input close
+func TestSegment_Rollup_summary(t *testing.T) {
+	result := segments.TestSegment("rollup", map[string]string{"fields": "SrcAs,labels.customer"},
+		&pb.EnrichedFlow{SrcAs: 553, DstAs: 64496, Bytes: 100, Packets: 2, SamplingRate: 10, TimeFlowEndNs: base})
+	if result == nil {
+		t.Fatal("([error] Segment Rollup is not emitting summaries.")
+	}
+	if result.SrcAs != 553 || result.DstAs != 0 || result.Bytes != 1000 || result.Packets != 20 || result.RollupFlows != 1 || result.Normalized != pb.EnrichedFlow_Yes {
+		t.Error("([error] Segment Rollup is not summarizing flows correctly.")
+	}
+	if result.TimeFlowStartNs != base || result.TimeFlowEndNs != base+uint64(time.Minute) {
+		t.Error("([error] Segment Rollup is not setting the bucket times.")
+	}
+}
+
+// Rollup Segment test, grouping and watermark
+func TestSegment_Rollup_watermark(t *testing.T) {
+	segment, out := segments.NewTestSegment[*Rollup]("rollup", map[string]string{"fields": "SrcAddr,labels.customer", "bucket": "1m", "lateness": "10s"})
+	segment.setup()
+	customer := map[string]string{"customer": "a"}
+	now := time.Now()
+	segment.add(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, Labels: customer, Bytes: 1, TimeFlowEndNs: base + uint64(30*time.Second)}, now)
+	segment.add(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, Labels: customer, Bytes: 2, TimeFlowEndNs: base + uint64(40*time.Second)}, now)
+	segment.add(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 2}, Bytes: 4, TimeFlowEndNs: base + uint64(50*time.Second)}, now)
+	// within lateness, the first bucket stays open
+	segment.add(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 2}, Bytes: 8, TimeFlowEndNs: base + uint64(65*time.Second)}, now)
+	if len(out) != 0 {
+		t.Fatal("([error] Segment Rollup is closing buckets before the watermark passed them.")
+	}
+	segment.add(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 2}, Bytes: 16, TimeFlowEndNs: base + uint64(55*time.Second)}, now)
+	if segment.late != 0 {
+		t.Error("([error] Segment Rollup is not accepting delayed flows.")
+	}
+	segment.add(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 2}, Bytes: 32, TimeFlowEndNs: base + uint64(70*time.Second)}, now)
+	if len(out) != 2 {
+		t.Fatalf("([error] Segment Rollup is not emitting one summary per group, got %d.", len(out))
+	}
+	first, second := <-out, <-out
+	if first.SrcAddr[3] != 1 || first.Bytes != 3 || first.RollupFlows != 2 || first.GetLabel("customer") != "a" {
+		t.Error("([error] Segment Rollup is not grouping flows correctly.")
+	}
+	if second.SrcAddr[3] != 2 || second.Bytes != 20 || second.RollupFlows != 2 || second.GetLabel("customer") != "" {
+		t.Error("([error] Segment Rollup is not grouping flows correctly.")
+	}
+	segment.add(&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 2}, Bytes: 64, TimeFlowEndNs: base + uint64(59*time.Second)}, now)
+	if segment.late != 1 {
+		t.Error("([error] Segment Rollup is accepting flows for closed buckets.")
+	}
+
+	segment.flush()
+	if summary := <-out; summary.Bytes != 40 || summary.TimeFlowStartNs != base+uint64(time.Minute) {
+		t.Error("([error] Segment Rollup is not flushing open buckets.")
+	}
+}
+
+// Rollup Segment test, flow times ahead of the local clock
+func TestSegment_Rollup_clockSkew(t *testing.T) {
+	segment, out := segments.NewTestSegment[*Rollup]("rollup", map[string]string{"fields": "SrcAs", "bucket": "1m", "lateness": "10s"})
+	segment.setup()
+	now := time.Now()
+	segment.add(&pb.EnrichedFlow{SrcAs: 1, TimeFlowEndNs: uint64(now.Add(-5 * time.Second).UnixNano())}, now)
+	segment.add(&pb.EnrichedFlow{SrcAs: 2, TimeFlowEndNs: uint64(now.Add(time.Hour).UnixNano())}, now)
+	segment.add(&pb.EnrichedFlow{SrcAs: 1, TimeFlowEndNs: uint64(now.Add(-3 * time.Second).UnixNano())}, now)
+	if len(out) != 0 || segment.late != 0 {
+		t.Error("([error] Segment Rollup is closing buckets because of a flow from the future.")
+	}
+}
+
+// Rollup Segment test, maximum number of groups
+func TestSegment_Rollup_maxgroups(t *testing.T) {
+	segment, out := segments.NewTestSegment[*Rollup]("rollup", map[string]string{"fields": "SrcAs", "maxgroups": "1"})
+	segment.setup()
+	now := time.Now()
+	segment.add(&pb.EnrichedFlow{SrcAs: 1, TimeFlowEndNs: base}, now)
+	segment.add(&pb.EnrichedFlow{SrcAs: 2, TimeFlowEndNs: base}, now)
+	segment.add(&pb.EnrichedFlow{SrcAs: 3, TimeFlowEndNs: base}, now)
+	if !segment.skipping || segment.skipped != 2 {
+		t.Error("([error] Segment Rollup is not skipping groups beyond 'maxgroups'.")
+	}
+	segment.flush()
+	if len(out) != 1 || segment.skipping {
+		t.Error("([error] Segment Rollup is not accepting new groups after closing buckets.")
+	}
+}
+
+// Rollup Segment test, invalid fields
+func TestSegment_Rollup_invalidFields(t *testing.T) {
+	if segment := (Rollup{}).New(map[string]string{"fields": "SrcAs,NoSuchField"}); segment != nil {
+		t.Error("([error] Segment Rollup accepts invalid fields.")
+	}
+}
@@ -0,0 +1,165 @@
+// The `sample` segment reduces the number of flows by sampling, for instance
+// before expensive outputs. The `rate` parameter sets the sampling rate N, i.e.
+// one in N flows is kept on average. The following methods are available:
+//
+//   - 'random' keeps each flow with a probability of 1/N.
+//
+//   - 'hash' keeps flows based on a hash of the fields given in the `key`
+//     parameter, meaning that all flows of the same host or connection are
+//     either kept or removed. The available fields are `srcaddr`, `dstaddr`,
+//     `srcport`, `dstport` and `proto`, defaulting to all of them.
+//
+//   - 'bytes' keeps flows with a probability depending on their size: Flows
+//     with at least `threshold` bytes are always kept, smaller flows are kept
+//     with a probability of one in the ratio of threshold and flow size, rounded
+//     up. The rate parameter is not used by this method.
+//
+// To keep statistics unbiased, the SamplingRate of kept flows is multiplied by
+// the inverse of their sampling probability. Flows which have been normalized
+// already have their Bytes and Packets scaled instead. Removed flows are
+// available to the `else` branch when using this segment as a condition of the
+// `branch` segment.
+package sample
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Sample struct {
+	segments.BaseFilterSegment
+	Method    string   // optional, default is "random", one of "random", "hash" and "bytes", see above
+	Rate      uint64   // required for 'random' and 'hash', one in Rate flows is kept
+	Key       []string // optional, default is "srcaddr,dstaddr,srcport,dstport,proto", relevant to 'hash' only
+	Threshold uint64   // required for 'bytes', size in bytes from which on flows are always kept
+}
+
+var keyFields = []string{"srcaddr", "dstaddr", "srcport", "dstport", "proto"}
+
+func (segment Sample) New(config map[string]string) segments.Segment {
+	var method = "random"
+	switch config["method"] {
+	case "":
+		log.Info().Msg("Sample: 'method' set to default 'random'.")
+	case "random", "hash", "bytes":
+		method = config["method"]
+	default:
+		log.Error().Msg("Sample: The 'method' parameter is required to be one of 'random', 'hash' or 'bytes'.")
+		return nil
+	}
+
+	var rate, threshold uint64
+	var err error
+	if method == "bytes" {
+		threshold, err = strconv.ParseUint(config["threshold"], 10, 64)
+		if err != nil || threshold == 0 {
+			log.Error().Msg("Sample: Method 'bytes' requires a positive 'threshold' parameter.")
+			return nil
+		}
+	} else {
+		rate, err = strconv.ParseUint(config["rate"], 10, 64)
+		if err != nil || rate == 0 {
+			log.Error().Msgf("Sample: Method '%s' requires a positive 'rate' parameter.", method)
+			return nil
+		}
+	}
+
+	var key = keyFields
+	if config["key"] != "" {
+		key = nil
+		for _, field := range strings.Split(config["key"], ",") {
+			field = strings.TrimSpace(strings.ToLower(field))
+			if !slices.Contains(keyFields, field) {
+				log.Error().Msgf("Sample: Unknown key field '%s', options are 'srcaddr', 'dstaddr', 'srcport', 'dstport' and 'proto'.", field)
+				return nil
+			}
+			key = append(key, field)
+		}
+	} else if method == "hash" {
+		log.Info().Msg("Sample: 'key' set to default 'srcaddr,dstaddr,srcport,dstport,proto'.")
+	}
+
+	return &Sample{
+		Method:    method,
+		Rate:      rate,
+		Key:       key,
+		Threshold: threshold,
+	}
+}
+
+func (segment *Sample) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	for msg := range segment.In {
+		var rate uint64
+		switch segment.Method {
+		case "random":
+			rate = segment.Rate
+			if rand.Uint64N(rate) != 0 {
+				rate = 0
+			}
+		case "hash":
+			rate = segment.Rate
+			if segment.hash(msg)%rate != 0 {
+				rate = 0
+			}
+		case "bytes":
+			rate = 1
+			if size := max(msg.Bytes, 1); size < segment.Threshold {
+				rate = (segment.Threshold + size - 1) / size
+				if rand.Uint64N(rate) != 0 {
+					rate = 0
+				}
+			}
+		}
+		if rate == 0 {
+			if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+			continue
+		}
+		if msg.Normalized == 1 {
+			msg.Bytes *= rate
+			msg.Packets *= rate
+		} else {
+			msg.SamplingRate = max(msg.SamplingRate, 1) * rate
+		}
+		segment.Out <- msg
+	}
+}
+
+func (segment *Sample) hash(msg *pb.EnrichedFlow) uint64 {
+	h := fnv.New64a()
+	for _, field := range segment.Key {
+		switch field {
+		case "srcaddr":
+			h.Write(msg.SrcAddr)
+		case "dstaddr":
+			h.Write(msg.DstAddr)
+		case "srcport":
+			h.Write(binary.BigEndian.AppendUint32(nil, msg.SrcPort))
+		case "dstport":
+			h.Write(binary.BigEndian.AppendUint32(nil, msg.DstPort))
+		case "proto":
+			h.Write(binary.BigEndian.AppendUint32(nil, msg.Proto))
+		}
+	}
+	return h.Sum64()
+}
+
+func init() {
+	segment := &Sample{}
+	segments.RegisterSegment("sample", segment)
+}
@@ -0,0 +1,60 @@
+package sample
+
+import (
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Sample Segment test, random sampling
+func TestSegment_Sample_random(t *testing.T) {
+	result := segments.TestSegment("sample", map[string]string{"rate": "1"},
+		&pb.EnrichedFlow{SamplingRate: 32})
+	if result == nil || result.SamplingRate != 32 {
+		t.Error("([error] Segment Sample is not keeping all flows with rate 1.")
+	}
+}
+
+// Sample Segment test, hash based sampling
+func TestSegment_Sample_hash(t *testing.T) {
+	config := map[string]string{"method": "hash", "rate": "4", "key": "srcaddr"}
+	var kept int
+	for i := 0; i < 256; i++ {
+		first := segments.TestSegment("sample", config, &pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, byte(i)}, SrcPort: 1})
+		second := segments.TestSegment("sample", config, &pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, byte(i)}, SrcPort: 2})
+		if (first == nil) != (second == nil) {
+			t.Fatal("([error] Segment Sample is not sampling deterministically.")
+		}
+		if first != nil {
+			kept += 1
+			if first.SamplingRate != 4 {
+				t.Error("([error] Segment Sample is not adjusting the SamplingRate.")
+			}
+		}
+	}
+	if kept == 0 || kept == 256 {
+		t.Errorf("([error] Segment Sample is not sampling, kept %d of 256 flows.", kept)
+	}
+}
+
+// Sample Segment test, byte weighted sampling
+func TestSegment_Sample_bytes(t *testing.T) {
+	result := segments.TestSegment("sample", map[string]string{"method": "bytes", "threshold": "1000"},
+		&pb.EnrichedFlow{Bytes: 5000, SamplingRate: 2})
+	if result == nil || result.SamplingRate != 2 {
+		t.Error("([error] Segment Sample is not keeping flows above the threshold.")
+	}
+	result = segments.TestSegment("sample", map[string]string{"method": "bytes", "threshold": "1000"},
+		&pb.EnrichedFlow{Bytes: 1000, Packets: 1, SamplingRate: 1, Normalized: 1})
+	if result == nil || result.Bytes != 1000 || result.Packets != 1 {
+		t.Error("([error] Segment Sample is modifying flows at the threshold.")
+	}
+}
+
+// Sample Segment test, invalid configuration
+func TestSegment_Sample_noRate(t *testing.T) {
+	if segment := (Sample{}).New(map[string]string{"method": "hash"}); segment != nil {
+		t.Error("([error] Segment Sample accepts method 'hash' without a rate.")
+	}
+}
@@ -0,0 +1,332 @@
+// The `appclass` segment classifies flows into services and applications. It
+// sets the labels `appclass_port`, `appclass_service` and `appclass_application`
+// to the server port, service name and application of a flow respectively.
+//
+// First, the server side of a flow is determined using the following
+// heuristics, in order:
+//
+// * TCP flows with SYN but without ACK flags are sent by the client
+// * if only one of the ports is not in the ephemeral port range, it is the server port
+// * if only one of the ports is a known service, it is the server port
+// * if only one of the ports is a well-known port (< 1024), it is the server port
+// * otherwise, the lower port is assumed to be the server port
+//
+// The service name is then looked up from a service table, which defaults to a
+// built-in subset of the IANA registry. A complete table can be provided using
+// the `servicesfile` parameter, either in `/etc/services` format or as the CSV
+// export of the IANA registry, the latter being detected by the `.csv` suffix.
+//
+// Optionally, a `rulesfile` can be used to define applications. It is a CSV
+// file of the format `application,protocol,port,asn,prefix`, where any of the
+// criteria may be left empty to match anything. The ASN and prefix criteria
+// are matched against the server side of the flow, for instance the rule
+// `Google QUIC,udp,443,15169,` would match any QUIC flows to or from Google's
+// AS. Rules are evaluated in order, with the first match determining the
+// application. If no rule matches, the service name is used instead. Labels are
+// only set if the respective value is known.
+package appclass
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/csv"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+//go:embed services
+var defaultServices string
+
+// Transport protocols as used in service tables.
+var transportProtocols = map[string]uint32{"tcp": 6, "udp": 17, "dccp": 33, "sctp": 132}
+
+type serviceKey struct {
+	proto uint32
+	port  uint32
+}
+
+type AppClass struct {
+	segments.BaseSegment
+	ServicesFile   string // optional, default is a built-in subset of the IANA registry
+	RulesFile      string // optional, default is no rules
+	EphemeralStart uint32 // optional, default is 32768, lowest port considered ephemeral
+
+	services map[serviceKey]string
+	rules    []rule
+}
+
+// An application rule, zero values match anything.
+type rule struct {
+	application string
+	proto       uint32
+	port        uint32
+	asn         uint32
+	prefix      *net.IPNet
+}
+
+func (segment AppClass) New(config map[string]string) segments.Segment {
+	newsegment := &AppClass{
+		ServicesFile: config["servicesfile"],
+		RulesFile:    config["rulesfile"],
+	}
+
+	newsegment.EphemeralStart = 32768
+	if config["ephemeralstart"] != "" {
+		if parsedStart, err := strconv.ParseUint(config["ephemeralstart"], 10, 16); err == nil {
+			newsegment.EphemeralStart = uint32(parsedStart)
+		} else {
+			log.Error().Msg("AppClass: Could not parse 'ephemeralstart' parameter, using default 32768.")
+		}
+	} else {
+		log.Info().Msg("AppClass: 'ephemeralstart' set to default '32768'.")
+	}
+
+	var err error
+	if newsegment.ServicesFile == "" {
+		log.Info().Msg("AppClass: 'servicesfile' not set, using built-in service table.")
+		newsegment.services, err = readServices(strings.NewReader(defaultServices))
+	} else {
+		var f *os.File
+		f, err = os.Open(segments.ContainerVolumePrefix + newsegment.ServicesFile)
+		if err != nil {
+			log.Error().Err(err).Msg("AppClass: Could not open services file: ")
+			return nil
+		}
+		defer f.Close()
+		if strings.HasSuffix(newsegment.ServicesFile, ".csv") {
+			newsegment.services, err = readIANAServices(f)
+		} else {
+			newsegment.services, err = readServices(f)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AppClass: Could not read service table: ")
+		return nil
+	}
+
+	if newsegment.RulesFile != "" {
+		f, err := os.Open(segments.ContainerVolumePrefix + newsegment.RulesFile)
+		if err != nil {
+			log.Error().Err(err).Msg("AppClass: Could not open rules file: ")
+			return nil
+		}
+		defer f.Close()
+		newsegment.rules, err = readRules(f)
+		if err != nil {
+			log.Error().Err(err).Msg("AppClass: Could not read rules file: ")
+			return nil
+		}
+	}
+	return newsegment
+}
+
+func (segment *AppClass) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	log.Info().Msgf("AppClass: Using %d services and %d rules.", len(segment.services), len(segment.rules))
+	for msg := range segment.In {
+		segment.classify(msg)
+		segment.Out <- msg
+	}
+}
+
+func (segment *AppClass) classify(msg *pb.EnrichedFlow) {
+	port, serverIsSrc := segment.serverPort(msg)
+	if port != 0 {
+		msg.SetLabel("appclass_port", strconv.FormatUint(uint64(port), 10))
+	}
+	service := segment.services[serviceKey{msg.Proto, port}]
+	if service != "" {
+		msg.SetLabel("appclass_service", service)
+	}
+
+	serverAddr, serverAs := msg.DstAddrObj(), msg.DstAs
+	if serverIsSrc {
+		serverAddr, serverAs = msg.SrcAddrObj(), msg.SrcAs
+	}
+	for _, r := range segment.rules {
+		if (r.proto == 0 || r.proto == msg.Proto) &&
+			(r.port == 0 || r.port == port) &&
+			(r.asn == 0 || r.asn == serverAs) &&
+			(r.prefix == nil || r.prefix.Contains(serverAddr)) {
+			msg.SetLabel("appclass_application", r.application)
+			return
+		}
+	}
+	if service != "" {
+		msg.SetLabel("appclass_application", service)
+	}
+}
+
+// Determines the server port of a flow and whether it is the source port.
+func (segment *AppClass) serverPort(msg *pb.EnrichedFlow) (uint32, bool) {
+	if msg.SrcPort == 0 && msg.DstPort == 0 {
+		return 0, false
+	}
+	// a TCP SYN without ACK is sent by the client
+	if msg.Proto == 6 && msg.TcpFlags&0x02 != 0 && msg.TcpFlags&0x10 == 0 {
+		return msg.DstPort, false
+	}
+	srcEphemeral, dstEphemeral := msg.SrcPort >= segment.EphemeralStart, msg.DstPort >= segment.EphemeralStart
+	if srcEphemeral != dstEphemeral {
+		return segment.pick(msg, dstEphemeral)
+	}
+	_, srcKnown := segment.services[serviceKey{msg.Proto, msg.SrcPort}]
+	_, dstKnown := segment.services[serviceKey{msg.Proto, msg.DstPort}]
+	if srcKnown != dstKnown {
+		return segment.pick(msg, srcKnown)
+	}
+	srcWellKnown, dstWellKnown := msg.SrcPort < 1024, msg.DstPort < 1024
+	if srcWellKnown != dstWellKnown {
+		return segment.pick(msg, srcWellKnown)
+	}
+	return segment.pick(msg, msg.SrcPort < msg.DstPort)
+}
+
+func (segment *AppClass) pick(msg *pb.EnrichedFlow, src bool) (uint32, bool) {
+	if src {
+		return msg.SrcPort, true
+	}
+	return msg.DstPort, false
+}
+
+// Reads a service table in /etc/services format.
+func readServices(r io.Reader) (map[serviceKey]string, error) {
+	services := make(map[serviceKey]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		portString, protoString, found := strings.Cut(fields[1], "/")
+		proto, knownProto := transportProtocols[strings.ToLower(protoString)]
+		port, err := strconv.ParseUint(portString, 10, 16)
+		if !found || !knownProto || err != nil {
+			log.Warn().Msgf("AppClass: Skipping invalid service definition '%s'.", scanner.Text())
+			continue
+		}
+		key := serviceKey{proto, uint32(port)}
+		if _, exists := services[key]; !exists {
+			services[key] = fields[0]
+		}
+	}
+	return services, scanner.Err()
+}
+
+// Reads a service table in the CSV format of the IANA registry, i.e. with the
+// columns service name, port number and transport protocol first.
+func readIANAServices(r io.Reader) (map[serviceKey]string, error) {
+	services := make(map[serviceKey]string)
+	csvr := csv.NewReader(r)
+	csvr.FieldsPerRecord = -1
+	for {
+		row, err := csvr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(row) < 3 || row[0] == "" || row[1] == "" {
+			continue // header, unassigned or reserved entries
+		}
+		proto, knownProto := transportProtocols[strings.ToLower(row[2])]
+		if !knownProto {
+			continue
+		}
+		first, last, isRange := strings.Cut(row[1], "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.ParseUint(first, 10, 16)
+		if err != nil {
+			continue
+		}
+		end, err := strconv.ParseUint(last, 10, 16)
+		if err != nil {
+			continue
+		}
+		for port := start; port <= end; port++ {
+			key := serviceKey{proto, uint32(port)}
+			if _, exists := services[key]; !exists {
+				services[key] = row[0]
+			}
+		}
+	}
+	return services, nil
+}
+
+// Reads application rules in the format `application,protocol,port,asn,prefix`.
+func readRules(r io.Reader) ([]rule, error) {
+	var rules []rule
+	csvr := csv.NewReader(r)
+	csvr.Comment = '#'
+	csvr.FieldsPerRecord = 5
+	csvr.TrimLeadingSpace = true
+	for {
+		row, err := csvr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		r := rule{application: row[0]}
+		if row[1] != "" {
+			proto, known := transportProtocols[strings.ToLower(row[1])]
+			if !known {
+				parsedProto, err := strconv.ParseUint(row[1], 10, 8)
+				if err != nil {
+					log.Warn().Msgf("AppClass: Skipping rule '%s' with invalid protocol '%s'.", row[0], row[1])
+					continue
+				}
+				proto = uint32(parsedProto)
+			}
+			r.proto = proto
+		}
+		if row[2] != "" {
+			port, err := strconv.ParseUint(row[2], 10, 16)
+			if err != nil {
+				log.Warn().Msgf("AppClass: Skipping rule '%s' with invalid port '%s'.", row[0], row[2])
+				continue
+			}
+			r.port = uint32(port)
+		}
+		if row[3] != "" {
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(row[3]), "AS"), 10, 32)
+			if err != nil {
+				log.Warn().Msgf("AppClass: Skipping rule '%s' with invalid ASN '%s'.", row[0], row[3])
+				continue
+			}
+			r.asn = uint32(asn)
+		}
+		if row[4] != "" {
+			_, prefix, err := net.ParseCIDR(row[4])
+			if err != nil {
+				log.Warn().Msgf("AppClass: Skipping rule '%s' with invalid prefix '%s'.", row[0], row[4])
+				continue
+			}
+			r.prefix = prefix
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func init() {
+	segment := &AppClass{}
+	segments.RegisterSegment("appclass", segment)
+}
@@ -0,0 +1,59 @@
+package appclass
+
+import (
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// AppClass Segment testing uses the built-in service table
+func TestSegment_AppClass_ephemeralPort(t *testing.T) {
+	result := segments.TestSegment("appclass", map[string]string{},
+		&pb.EnrichedFlow{Proto: 6, SrcPort: 443, DstPort: 51234})
+	if result.GetLabel("appclass_port") != "443" || result.GetLabel("appclass_service") != "https" || result.GetLabel("appclass_application") != "https" {
+		t.Errorf("([error] Segment AppClass is not classifying by ephemeral port: %v", result.Labels)
+	}
+}
+
+func TestSegment_AppClass_knownService(t *testing.T) {
+	result := segments.TestSegment("appclass", map[string]string{},
+		&pb.EnrichedFlow{Proto: 17, SrcPort: 20000, DstPort: 1194})
+	if result.GetLabel("appclass_port") != "1194" || result.GetLabel("appclass_service") != "openvpn" {
+		t.Error("([error] Segment AppClass is not preferring known services.")
+	}
+}
+
+func TestSegment_AppClass_tcpSyn(t *testing.T) {
+	result := segments.TestSegment("appclass", map[string]string{},
+		&pb.EnrichedFlow{Proto: 6, SrcPort: 22, DstPort: 8080, TcpFlags: 0x02})
+	if result.GetLabel("appclass_port") != "8080" || result.GetLabel("appclass_service") != "http-alt" {
+		t.Error("([error] Segment AppClass is not using TCP flags to determine the server.")
+	}
+}
+
+func TestSegment_AppClass_rules(t *testing.T) {
+	result := segments.TestSegment("appclass", map[string]string{"rulesfile": "../../../examples/enricher/appclass_rules.csv"},
+		&pb.EnrichedFlow{Proto: 17, SrcPort: 443, DstPort: 60000, SrcAs: 15169})
+	if result.GetLabel("appclass_application") != "Google QUIC" || result.GetLabel("appclass_service") != "https" {
+		t.Error("([error] Segment AppClass is not applying ASN rules.")
+	}
+	result = segments.TestSegment("appclass", map[string]string{"rulesfile": "../../../examples/enricher/appclass_rules.csv"},
+		&pb.EnrichedFlow{Proto: 6, SrcPort: 60000, DstPort: 443, SrcAddr: []byte{192, 168, 0, 1}, DstAddr: []byte{10, 0, 0, 1}})
+	if result.GetLabel("appclass_application") != "Internal Web" {
+		t.Error("([error] Segment AppClass is not applying prefix rules.")
+	}
+	result = segments.TestSegment("appclass", map[string]string{"rulesfile": "../../../examples/enricher/appclass_rules.csv"},
+		&pb.EnrichedFlow{Proto: 6, SrcPort: 10443, DstPort: 443, SrcAddr: []byte{10, 0, 0, 1}, DstAddr: []byte{192, 168, 0, 1}})
+	if result.GetLabel("appclass_application") != "https" {
+		t.Error("([error] Segment AppClass is matching prefix rules against the client.")
+	}
+}
+
+func TestSegment_AppClass_unknown(t *testing.T) {
+	result := segments.TestSegment("appclass", map[string]string{},
+		&pb.EnrichedFlow{Proto: 1})
+	if len(result.Labels) != 0 {
+		t.Errorf("([error] Segment AppClass is setting labels of unknown services: %v", result.Labels)
+	}
+}
@@ -26,32 +26,52 @@
 //
 // If no `fallbackrouter` is set, no data will be annotated. The annotated fields are
 // `ASPath`, `Med`, `LocalPref`, `DstAS`, `NextHopAS`, `NextHop`, wheras the last
-// three are possibly overwritten from the original router export.
+// three are possibly overwritten from the original router export. Additionally,
+// the standard and large communities of the destination's route are annotated
+// in `BgpCommunities` and `LargeCommunities`.
+//
+// The `ValidationStatus` field is set from the RPKI validation state the router
+// attached to a route, if any. To perform route origin validation locally
+// instead, a set of Validated ROA Payloads (VRPs) can be provided, either using
+// the `rpkifile` parameter pointing to the JSON export of an RPKI validator
+// such as Routinator or rpki-client, or using the `rtrserver` parameter
+// pointing to an RTR cache server in the `host:port` format. In both cases, the
+// VRPs are reloaded in the interval set by `rpkirefresh`.
 package bgp
 
 import (
 	"net"
+	"net/netip"
 	"os"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/bgp_routeinfo/routeinfo"
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
+	"github.com/osrg/gobgp/v3/pkg/server"
 	"gopkg.in/yaml.v2"
 )
 
 type Bgp struct {
 	segments.BaseSegment
-	FileName        string // required
-	FallbackRouter  string // optional, default is "" (i.e., none or disabled), this will determine the BGP session that is used when SamplerAddress has no corresponding session
-	UseFallbackOnly bool   // optional, default is false, this will disable looking for SamplerAddress BGP sessions
-	RouterASN       uint32 // ASN of the local router
+	FileName        string        // required
+	FallbackRouter  string        // optional, default is "" (i.e., none or disabled), this will determine the BGP session that is used when SamplerAddress has no corresponding session
+	UseFallbackOnly bool          // optional, default is false, this will disable looking for SamplerAddress BGP sessions
+	RouterASN       uint32        // ASN of the local router
+	RpkiFile        string        // optional, default is "" (i.e., use the router's validation state), JSON export of VRPs to validate against
+	RtrServer       string        // optional, default is "" (i.e., use the router's validation state), RTR cache server to fetch VRPs from
+	RpkiRefresh     time.Duration // optional, default is 10m, interval for reloading VRPs
 
 	routeInfoServer routeinfo.RouteInfoServer
+	rtrClient       *server.BgpServer
+	vrps            *atomic.Pointer[vrpTable]
 }
 
 func (segment Bgp) New(config map[string]string) segments.Segment {
@@ -103,12 +123,37 @@ func (segment Bgp) New(config map[string]string) segments.Segment {
 		return nil
 	}
 
+	if config["rpkifile"] != "" && config["rtrserver"] != "" {
+		log.Error().Msg("Bgp: Only one of 'rpkifile' and 'rtrserver' can be set.")
+		return nil
+	}
+	if config["rtrserver"] != "" {
+		if _, _, err := net.SplitHostPort(config["rtrserver"]); err != nil {
+			log.Error().Err(err).Msg("Bgp: Parameter 'rtrserver' has to be of the form 'host:port': ")
+			return nil
+		}
+	}
+	var rpkiRefresh = 10 * time.Minute
+	if config["rpkirefresh"] != "" {
+		if parsedRefresh, err := time.ParseDuration(config["rpkirefresh"]); err == nil && parsedRefresh > 0 {
+			rpkiRefresh = parsedRefresh
+		} else {
+			log.Error().Msg("Bgp: Could not parse 'rpkirefresh' parameter, using default 10m.")
+		}
+	} else if config["rpkifile"] != "" || config["rtrserver"] != "" {
+		log.Info().Msg("Bgp: 'rpkirefresh' set to default '10m'.")
+	}
+
 	newSegment := &Bgp{
 		FileName:        config["filename"],
 		FallbackRouter:  config["fallbackrouter"],
 		UseFallbackOnly: fallbackonly,
 		RouterASN:       routerASN,
+		RpkiFile:        config["rpkifile"],
+		RtrServer:       config["rtrserver"],
+		RpkiRefresh:     rpkiRefresh,
 		routeInfoServer: rs,
+		vrps:            &atomic.Pointer[vrpTable]{},
 	}
 	return newSegment
 }
@@ -124,6 +169,33 @@ func (segment *Bgp) Run(wg *sync.WaitGroup) {
 		segment.routeInfoServer.Stop()
 	}()
 
+	if segment.RpkiFile != "" || segment.RtrServer != "" {
+		done := make(chan struct{})
+		defer close(done)
+		if segment.RtrServer != "" {
+			var err error
+			segment.rtrClient, err = startRtrClient(segment.RtrServer, segment.RouterASN)
+			if err != nil {
+				log.Error().Err(err).Msg("Bgp: Could not start RTR client: ")
+			} else {
+				defer segment.rtrClient.Stop()
+			}
+		}
+		segment.reloadVrps()
+		go func() {
+			ticker := time.NewTicker(segment.RpkiRefresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					segment.reloadVrps()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
 	for msg := range segment.In {
 		// The following conversions to String are stupid, but it is
 		// what gobgp requires at the end of this call hierarchy.
@@ -154,16 +226,7 @@ func (segment *Bgp) Run(wg *sync.WaitGroup) {
 			slices.Reverse(path.AsPath)
 			msg.AsPath = path.AsPath
 			srcAsPath = path.AsPath
-			switch path.Validation {
-			case routeinfo.Valid:
-				msg.ValidationStatus = pb.EnrichedFlow_Valid
-			case routeinfo.NotFound:
-				msg.ValidationStatus = pb.EnrichedFlow_NotFound
-			case routeinfo.Invalid:
-				msg.ValidationStatus = pb.EnrichedFlow_Invalid
-			default:
-				msg.ValidationStatus = pb.EnrichedFlow_Unknown
-			}
+			msg.ValidationStatus = segment.validationStatus(path)
 			break
 		}
 		if segment.RouterASN != 0 {
@@ -179,16 +242,9 @@ func (segment *Bgp) Run(wg *sync.WaitGroup) {
 			msg.AsPath = append(msg.AsPath, dstAsPath...)
 			msg.Med = path.Med
 			msg.LocalPref = path.LocalPref
-			switch path.Validation {
-			case routeinfo.Valid:
-				msg.ValidationStatus = pb.EnrichedFlow_Valid
-			case routeinfo.NotFound:
-				msg.ValidationStatus = pb.EnrichedFlow_NotFound
-			case routeinfo.Invalid:
-				msg.ValidationStatus = pb.EnrichedFlow_Invalid
-			default:
-				msg.ValidationStatus = pb.EnrichedFlow_Unknown
-			}
+			msg.ValidationStatus = segment.validationStatus(path)
+			msg.BgpCommunities = parseCommunities(path.Communities)
+			msg.LargeCommunities = path.LargeCommunities
 			// for router exported netflow, the following are likely overwriting their own annotations
 			if len(path.AsPath) > 0 {
 				msg.DstAs = path.AsPath[len(path.AsPath)-1]
@@ -206,6 +262,76 @@ func (segment *Bgp) Run(wg *sync.WaitGroup) {
 	}
 }
 
+// Determines the validation status of a route, either using the configured
+// VRPs or the validation state attached by the router.
+func (segment *Bgp) validationStatus(path routeinfo.RouteInfo) pb.EnrichedFlow_ValidationStatusType {
+	if vrps := segment.vrps.Load(); vrps != nil {
+		prefix, err := netip.ParsePrefix(path.Prefix)
+		if err != nil {
+			return pb.EnrichedFlow_Unknown
+		}
+		return vrps.validate(prefix, path.OriginAs)
+	}
+	switch path.Validation {
+	case routeinfo.Valid:
+		return pb.EnrichedFlow_Valid
+	case routeinfo.NotFound:
+		return pb.EnrichedFlow_NotFound
+	case routeinfo.Invalid:
+		return pb.EnrichedFlow_Invalid
+	default:
+		return pb.EnrichedFlow_Unknown
+	}
+}
+
+// Reloads the VRPs from the configured source. The previous VRPs are kept if
+// this fails.
+func (segment *Bgp) reloadVrps() {
+	var vrps *vrpTable
+	var err error
+	if segment.RpkiFile != "" {
+		vrps, err = readVrpFile(segment.RpkiFile)
+	} else if segment.rtrClient != nil {
+		vrps, err = readRtrTable(segment.rtrClient)
+	} else {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Bgp: Could not load VRPs, keeping previous set: ")
+		return
+	}
+	if vrps.count() == 0 && segment.vrps.Load() == nil {
+		// RTR sessions might not be established yet, validating against an
+		// empty set would yield NotFound for all routes
+		log.Warn().Msg("Bgp: No VRPs available yet.")
+		return
+	}
+	segment.vrps.Store(vrps)
+	log.Info().Msgf("Bgp: Loaded %d VRPs.", vrps.count())
+}
+
+// Converts communities from their `high:low` representation to the 32 bit
+// integers used in flows.
+func parseCommunities(communities []string) []uint32 {
+	var result []uint32
+	for _, community := range communities {
+		high, low, found := strings.Cut(community, ":")
+		if !found {
+			continue
+		}
+		parsedHigh, err := strconv.ParseUint(high, 10, 16)
+		if err != nil {
+			continue
+		}
+		parsedLow, err := strconv.ParseUint(low, 10, 16)
+		if err != nil {
+			continue
+		}
+		result = append(result, uint32(parsedHigh<<16|parsedLow))
+	}
+	return result
+}
+
 func init() {
 	segment := &Bgp{}
 	segments.RegisterSegment("bgp", segment)
@@ -1,4 +1,48 @@
 package bgp
 
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+)
+
 // Bgp Segment tests are thorough and try every combination
 // TODO: figure out how to mock this
+
+func TestSegment_Bgp_validation(t *testing.T) {
+	vrps, err := readVrpFile("../../../examples/bgp/vrps.json")
+	if err != nil {
+		t.Fatalf("([error] Segment Bgp could not read the example VRPs: %v", err)
+	}
+	if vrps.count() != 3 {
+		t.Errorf("([error] Segment Bgp read %d instead of 3 VRPs.", vrps.count())
+	}
+	tests := []struct {
+		route  string
+		origin uint32
+		status pb.EnrichedFlow_ValidationStatusType
+	}{
+		{"192.0.2.0/24", 64496, pb.EnrichedFlow_Valid},
+		{"192.0.2.0/24", 64497, pb.EnrichedFlow_Invalid},
+		{"192.0.2.128/25", 64496, pb.EnrichedFlow_Invalid}, // exceeds maxLength
+		{"198.51.100.0/23", 64497, pb.EnrichedFlow_Valid},
+		{"198.51.102.0/24", 64497, pb.EnrichedFlow_Invalid},
+		{"203.0.113.0/24", 64496, pb.EnrichedFlow_NotFound},
+		{"2001:db8:1::/48", 64498, pb.EnrichedFlow_Valid},
+		{"2001:db9::/32", 64498, pb.EnrichedFlow_NotFound},
+	}
+	for _, test := range tests {
+		if status := vrps.validate(netip.MustParsePrefix(test.route), test.origin); status != test.status {
+			t.Errorf("([error] Segment Bgp validated %s from AS%d as %s instead of %s.", test.route, test.origin, status, test.status)
+		}
+	}
+}
+
+func TestSegment_Bgp_communities(t *testing.T) {
+	result := parseCommunities([]string{"65535:666", "553:1", "invalid", "70000:1"})
+	if !slices.Equal(result, []uint32{65535<<16 | 666, 553<<16 | 1}) {
+		t.Errorf("([error] Segment Bgp is not parsing communities correctly: %v", result)
+	}
+}
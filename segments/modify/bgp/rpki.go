@@ -0,0 +1,221 @@
+package bgp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	api "github.com/osrg/gobgp/v3/api"
+	gobgplog "github.com/osrg/gobgp/v3/pkg/log"
+	"github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// A single Validated ROA Payload.
+type vrp struct {
+	asn       uint32
+	maxLength int
+}
+
+// A set of VRPs, indexed by prefix. The prefix lengths present are tracked to
+// limit the lookups necessary to find all covering VRPs of a route.
+type vrpTable struct {
+	vrps         map[netip.Prefix][]vrp
+	lengthsV4    []int
+	lengthsV6    []int
+	knownLengths map[int]bool
+}
+
+func newVrpTable() *vrpTable {
+	return &vrpTable{
+		vrps:         make(map[netip.Prefix][]vrp),
+		knownLengths: make(map[int]bool),
+	}
+}
+
+func (table *vrpTable) add(prefix netip.Prefix, asn uint32, maxLength int) {
+	prefix = prefix.Masked()
+	if maxLength < prefix.Bits() {
+		maxLength = prefix.Bits()
+	}
+	table.vrps[prefix] = append(table.vrps[prefix], vrp{asn: asn, maxLength: maxLength})
+	key := prefix.Bits()
+	if prefix.Addr().Is6() {
+		key += 1000 // keep lengths of both address families apart
+	}
+	if !table.knownLengths[key] {
+		table.knownLengths[key] = true
+		if prefix.Addr().Is6() {
+			table.lengthsV6 = append(table.lengthsV6, prefix.Bits())
+		} else {
+			table.lengthsV4 = append(table.lengthsV4, prefix.Bits())
+		}
+	}
+}
+
+func (table *vrpTable) count() int {
+	var count int
+	for _, vrps := range table.vrps {
+		count += len(vrps)
+	}
+	return count
+}
+
+// Performs route origin validation as specified in RFC 6811.
+func (table *vrpTable) validate(route netip.Prefix, origin uint32) pb.EnrichedFlow_ValidationStatusType {
+	lengths := table.lengthsV4
+	if route.Addr().Is6() {
+		lengths = table.lengthsV6
+	}
+	var covered bool
+	for _, length := range lengths {
+		if length > route.Bits() {
+			continue
+		}
+		covering, err := route.Addr().Prefix(length)
+		if err != nil {
+			continue
+		}
+		for _, v := range table.vrps[covering] {
+			covered = true
+			if v.asn != 0 && v.asn == origin && route.Bits() <= v.maxLength {
+				return pb.EnrichedFlow_Valid
+			}
+		}
+	}
+	if covered {
+		return pb.EnrichedFlow_Invalid
+	}
+	return pb.EnrichedFlow_NotFound
+}
+
+// The JSON export format shared by common RPKI validators such as Routinator,
+// rpki-client or OctoRPKI.
+type vrpExport struct {
+	Roas []struct {
+		Asn       interface{} `json:"asn"`
+		Prefix    string      `json:"prefix"`
+		MaxLength int         `json:"maxLength"`
+	} `json:"roas"`
+}
+
+func readVrpFile(filename string) (*vrpTable, error) {
+	data, err := os.ReadFile(segments.ContainerVolumePrefix + filename)
+	if err != nil {
+		return nil, err
+	}
+	var export vrpExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	table := newVrpTable()
+	for _, roa := range export.Roas {
+		var asn uint64
+		switch value := roa.Asn.(type) {
+		case float64:
+			asn = uint64(value)
+		case string:
+			asn, err = strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+			if err != nil {
+				log.Warn().Msgf("Bgp: Skipping VRP with invalid ASN '%s'.", value)
+				continue
+			}
+		default:
+			log.Warn().Msgf("Bgp: Skipping VRP for '%s' without ASN.", roa.Prefix)
+			continue
+		}
+		prefix, err := netip.ParsePrefix(roa.Prefix)
+		if err != nil {
+			log.Warn().Err(err).Msg("Bgp: Skipping VRP with invalid prefix: ")
+			continue
+		}
+		table.add(prefix, uint32(asn), roa.MaxLength)
+	}
+	return table, nil
+}
+
+// Forwards gobgp log messages of warning level and above to zerolog.
+type rtrLogger struct{}
+
+func (l *rtrLogger) Panic(msg string, fields gobgplog.Fields) {
+	log.Panic().Fields(map[string]interface{}(fields)).Msg("Bgp: RTR: " + msg)
+}
+func (l *rtrLogger) Fatal(msg string, fields gobgplog.Fields) {
+	log.Fatal().Fields(map[string]interface{}(fields)).Msg("Bgp: RTR: " + msg)
+}
+func (l *rtrLogger) Error(msg string, fields gobgplog.Fields) {
+	log.Error().Fields(map[string]interface{}(fields)).Msg("Bgp: RTR: " + msg)
+}
+func (l *rtrLogger) Warn(msg string, fields gobgplog.Fields) {
+	log.Warn().Fields(map[string]interface{}(fields)).Msg("Bgp: RTR: " + msg)
+}
+func (l *rtrLogger) Info(msg string, fields gobgplog.Fields)  {}
+func (l *rtrLogger) Debug(msg string, fields gobgplog.Fields) {}
+func (l *rtrLogger) SetLevel(level gobgplog.LogLevel)         {}
+func (l *rtrLogger) GetLevel() gobgplog.LogLevel              { return gobgplog.WarnLevel }
+
+// Starts a gobgp instance solely for maintaining an RTR session with the
+// given cache server.
+func startRtrClient(address string, asn uint32) (*server.BgpServer, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", portString)
+	}
+	if asn == 0 {
+		asn = 65535 // the local ASN is irrelevant for RTR, but required by gobgp
+	}
+
+	s := server.NewBgpServer(server.LoggerOption(&rtrLogger{}))
+	go s.Serve()
+	if err := s.StartBgp(context.Background(), &api.StartBgpRequest{
+		Global: &api.Global{
+			Asn:        asn,
+			RouterId:   "255.255.255.255",
+			ListenPort: -1, // gobgp won't listen on tcp:179
+		},
+	}); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	if err := s.AddRpki(context.Background(), &api.AddRpkiRequest{Address: host, Port: uint32(port), Lifetime: 3600}); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reads the VRPs currently known to the RTR client.
+func readRtrTable(s *server.BgpServer) (*vrpTable, error) {
+	table := newVrpTable()
+	for _, afi := range []api.Family_Afi{api.Family_AFI_IP, api.Family_AFI_IP6} {
+		err := s.ListRpkiTable(context.Background(), &api.ListRpkiTableRequest{
+			Family: &api.Family{Afi: afi, Safi: api.Family_SAFI_UNICAST},
+		}, func(roa *api.Roa) {
+			addr, err := netip.ParseAddr(roa.Prefix)
+			if err != nil {
+				return
+			}
+			prefix, err := addr.Prefix(int(roa.Prefixlen))
+			if err != nil {
+				return
+			}
+			table.add(prefix, roa.Asn, int(roa.Maxlen))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
@@ -3,6 +3,8 @@
 // "keep" or "drop". It will then either keep or drop all fields specified in the
 // fields parameter. For a list of fields, check our
 // [protobuf definition](https://github.com/bwNetFlow/protobuf/blob/master/flow-messages-enriched.proto).
+// Single entries of the Labels field can be kept or dropped by referencing them
+// as `labels.<key>`.
 package dropfields
 
 import (
@@ -74,6 +76,12 @@ func (segment *DropFields) Run(wg *sync.WaitGroup) {
 		case PolicyKeep:
 			resultFlow := &pb.EnrichedFlow{}
 			for _, fieldName := range segment.Fields {
+				if key, ok := pb.LabelKey(fieldName); ok {
+					if value, found := original.GetLabels()[key]; found {
+						resultFlow.SetLabel(key, value)
+					}
+					continue
+				}
 				originalField := reflectedOriginal.FieldByName(fieldName)
 				resultFlowDestinationField := reflect.ValueOf(resultFlow).Elem().FieldByName(fieldName)
 				if originalField.IsValid() && resultFlowDestinationField.CanSet() {
@@ -85,6 +93,10 @@ func (segment *DropFields) Run(wg *sync.WaitGroup) {
 			segment.Out <- resultFlow
 		case PolicyDrop:
 			for _, fieldName := range segment.Fields {
+				if key, ok := pb.LabelKey(fieldName); ok {
+					delete(original.Labels, key)
+					continue
+				}
 				originalField := reflect.Indirect(reflectedOriginal).FieldByName(fieldName)
 				if originalField.IsValid() && originalField.CanSet() {
 					originalField.SetZero()
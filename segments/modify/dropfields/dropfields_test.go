@@ -26,6 +26,10 @@ var (
 		SrcPort: 2323,
 		DstPort: 4242,
 	}
+	testPacketThree = pb.EnrichedFlow{
+		SrcPort: 2323,
+		Labels:  map[string]string{"customer": "acme", "threat": "drop"},
+	}
 	tests = map[string]struct {
 		config   map[string]string
 		input    pb.EnrichedFlow
@@ -73,6 +77,21 @@ var (
 				SrcPort: 2323,
 			},
 		},
+		"drop one label": {
+			config: map[string]string{"policy": "drop", "fields": "labels.threat"},
+			input:  testPacketThree,
+			expected: &pb.EnrichedFlow{
+				SrcPort: 2323,
+				Labels:  map[string]string{"customer": "acme"},
+			},
+		},
+		"keep one label": {
+			config: map[string]string{"policy": "keep", "fields": "labels.customer"},
+			input:  testPacketThree,
+			expected: &pb.EnrichedFlow{
+				Labels: map[string]string{"customer": "acme"},
+			},
+		},
 	}
 )
 
@@ -6,26 +6,82 @@
 // is set to its default `false`. If matchboth is true, the result will be written for both
 // SrcAddr and DstAddr into SrcCountry and DstCountry. The dropunmatched parameter will
 // drop flows without any remote country data set.
+//
+// Additional attributes can be selected using the fields parameter, which is a
+// comma-separated list of `country`, `city`, `region`, `coordinates`, `asn` and
+// `asorg`. The attributes `city`, `region` (the name of the first subdivision)
+// and `coordinates` require a MaxMind City database given in the citydb
+// parameter, which will also be used for countries if no filename is set. The
+// attributes `asn` and `asorg` require a MaxMind ASN database given in the asndb
+// parameter, and will only set SrcAs and DstAs if these are not set already.
+// The attributes `city`, `region` and `coordinates` are set as labels named
+// after the direction of the address, i.e. `geo_src_city`, `geo_src_region`,
+// `geo_src_latitude` and `geo_src_longitude` and their `geo_dst_` counterparts,
+// while `asn` and `asorg` are written into the respective fields. If matchboth
+// is false, only the remote address' direction is annotated.
 package geolocation
 
 import (
 	"net"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
 	maxmind "github.com/oschwald/maxminddb-golang"
 )
 
 type GeoLocation struct {
 	segments.BaseSegment
-	FileName      string // required
-	DropUnmatched bool   // optional, default is false, determines whether flows are dropped when location is indeterminate
-	MatchBoth     bool   // optional, default is false, determines whether both addresses are matched
+	FileName      string   // optional, required if CityDB is not set and the country is selected
+	CityDB        string   // optional, required for the city, region and coordinates fields
+	ASNDB         string   // optional, required for the asn and asorg fields
+	Fields        []string // optional, default is "country", selects the attributes to annotate
+	DropUnmatched bool     // optional, default is false, determines whether flows are dropped when location is indeterminate
+	MatchBoth     bool     // optional, default is false, determines whether both addresses are matched
+
+	dbHandle     *maxmind.Reader
+	cityDbHandle *maxmind.Reader
+	asnDbHandle  *maxmind.Reader
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+type cityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
 
-	dbHandle *maxmind.Reader
+type asnRecord struct {
+	ASN          uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// The results of looking up a single address.
+type location struct {
+	country, city, region string
+	latitude, longitude   float64
+	asn                   uint32
+	asorg                 string
 }
 
 func (segment GeoLocation) New(config map[string]string) segments.Segment {
@@ -37,19 +93,65 @@ func (segment GeoLocation) New(config map[string]string) segments.Segment {
 	if err != nil {
 		log.Info().Msg("GeoLocation: 'matchboth' set to default 'false'.")
 	}
-	if config["filename"] == "" {
-		log.Error().Msg("GeoLocation: This segment requires the 'filename' parameter.")
+
+	var fields = []string{"country"}
+	if config["fields"] != "" {
+		fields = nil
+		for _, field := range strings.Split(config["fields"], ",") {
+			field = strings.TrimSpace(field)
+			switch field {
+			case "country", "city", "region", "coordinates", "asn", "asorg":
+				fields = append(fields, field)
+			default:
+				log.Error().Msgf("GeoLocation: Unknown field '%s', options are 'country', 'city', 'region', 'coordinates', 'asn' and 'asorg'.", field)
+				return nil
+			}
+		}
+	} else {
+		log.Info().Msg("GeoLocation: 'fields' set to default 'country'.")
+	}
+
+	if slices.Contains(fields, "country") && config["filename"] == "" && config["citydb"] == "" {
+		log.Error().Msg("GeoLocation: This segment requires the 'filename' or 'citydb' parameter.")
+		return nil
+	}
+	if (slices.Contains(fields, "city") || slices.Contains(fields, "region") || slices.Contains(fields, "coordinates")) && config["citydb"] == "" {
+		log.Error().Msg("GeoLocation: The fields 'city', 'region' and 'coordinates' require the 'citydb' parameter.")
 		return nil
 	}
+	if (slices.Contains(fields, "asn") || slices.Contains(fields, "asorg")) && config["asndb"] == "" {
+		log.Error().Msg("GeoLocation: The fields 'asn' and 'asorg' require the 'asndb' parameter.")
+		return nil
+	}
+
 	newSegment := &GeoLocation{
 		FileName:      config["filename"],
+		CityDB:        config["citydb"],
+		ASNDB:         config["asndb"],
+		Fields:        fields,
 		DropUnmatched: drop,
 		MatchBoth:     both,
 	}
-	newSegment.dbHandle, err = maxmind.Open(segments.ContainerVolumePrefix + config["filename"])
-	if err != nil {
-		log.Error().Err(err).Msg("GeoLocation: Could not open specified Maxmind DB file: ")
-		return nil
+	if config["filename"] != "" {
+		newSegment.dbHandle, err = maxmind.Open(segments.ContainerVolumePrefix + config["filename"])
+		if err != nil {
+			log.Error().Err(err).Msg("GeoLocation: Could not open specified Maxmind DB file: ")
+			return nil
+		}
+	}
+	if config["citydb"] != "" {
+		newSegment.cityDbHandle, err = maxmind.Open(segments.ContainerVolumePrefix + config["citydb"])
+		if err != nil {
+			log.Error().Err(err).Msg("GeoLocation: Could not open specified Maxmind City DB file: ")
+			return nil
+		}
+	}
+	if config["asndb"] != "" {
+		newSegment.asnDbHandle, err = maxmind.Open(segments.ContainerVolumePrefix + config["asndb"])
+		if err != nil {
+			log.Error().Err(err).Msg("GeoLocation: Could not open specified Maxmind ASN DB file: ")
+			return nil
+		}
 	}
 	return newSegment
 }
@@ -61,15 +163,13 @@ func (segment *GeoLocation) Run(wg *sync.WaitGroup) {
 	}()
 
 	defer func() {
-		segment.dbHandle.Close()
+		for _, handle := range []*maxmind.Reader{segment.dbHandle, segment.cityDbHandle, segment.asnDbHandle} {
+			if handle != nil {
+				handle.Close()
+			}
+		}
 	}()
 
-	var dbrecord struct {
-		Country struct {
-			ISOCode string `maxminddb:"iso_code"`
-		} `maxminddb:"country"`
-	}
-
 	for msg := range segment.In {
 		if !segment.MatchBoth {
 			var raddress net.IP
@@ -85,22 +185,31 @@ func (segment *GeoLocation) Run(wg *sync.WaitGroup) {
 				continue
 			}
 
-			err := segment.dbHandle.Lookup(raddress, &dbrecord)
+			result, err := segment.lookup(raddress)
 			if err == nil {
-				msg.RemoteCountry = dbrecord.Country.ISOCode
+				if slices.Contains(segment.Fields, "country") {
+					msg.RemoteCountry = result.country
+				}
+				segment.annotate(msg, result, msg.RemoteAddr == 1)
 			} else {
 				log.Error().Err(err).Msg("GeoLocation: Lookup of remote address failed: ")
 			}
 		} else {
-			err := segment.dbHandle.Lookup(msg.SrcAddr, &dbrecord)
+			result, err := segment.lookup(msg.SrcAddr)
 			if err == nil {
-				msg.SrcCountry = dbrecord.Country.ISOCode
+				if slices.Contains(segment.Fields, "country") {
+					msg.SrcCountry = result.country
+				}
+				segment.annotate(msg, result, true)
 			} else {
 				log.Error().Err(err).Msg("GeoLocation: Lookup of source address failed: ")
 			}
-			err = segment.dbHandle.Lookup(msg.DstAddr, &dbrecord)
+			result, err = segment.lookup(msg.DstAddr)
 			if err == nil {
-				msg.DstCountry = dbrecord.Country.ISOCode
+				if slices.Contains(segment.Fields, "country") {
+					msg.DstCountry = result.country
+				}
+				segment.annotate(msg, result, false)
 			} else {
 				log.Error().Err(err).Msg("GeoLocation: Lookup of destination address failed: ")
 			}
@@ -109,6 +218,78 @@ func (segment *GeoLocation) Run(wg *sync.WaitGroup) {
 	}
 }
 
+// Looks up an address in all configured databases.
+func (segment *GeoLocation) lookup(address net.IP) (location, error) {
+	var result location
+	if segment.dbHandle != nil {
+		var record countryRecord
+		if err := segment.dbHandle.Lookup(address, &record); err != nil {
+			return result, err
+		}
+		result.country = record.Country.ISOCode
+	}
+	if segment.cityDbHandle != nil {
+		var record cityRecord
+		if err := segment.cityDbHandle.Lookup(address, &record); err != nil {
+			return result, err
+		}
+		if segment.dbHandle == nil {
+			result.country = record.Country.ISOCode
+		}
+		result.city = record.City.Names["en"]
+		if len(record.Subdivisions) > 0 {
+			result.region = record.Subdivisions[0].Names["en"]
+		}
+		result.latitude, result.longitude = record.Location.Latitude, record.Location.Longitude
+	}
+	if segment.asnDbHandle != nil {
+		var record asnRecord
+		if err := segment.asnDbHandle.Lookup(address, &record); err != nil {
+			return result, err
+		}
+		result.asn, result.asorg = record.ASN, record.Organization
+	}
+	return result, nil
+}
+
+// Sets all selected attributes except for the country in the labels and
+// fields of the given direction.
+func (segment *GeoLocation) annotate(msg *pb.EnrichedFlow, result location, src bool) {
+	prefix := "geo_dst_"
+	if src {
+		prefix = "geo_src_"
+	}
+	for _, field := range segment.Fields {
+		switch field {
+		case "city":
+			if result.city != "" {
+				msg.SetLabel(prefix+"city", result.city)
+			}
+		case "region":
+			if result.region != "" {
+				msg.SetLabel(prefix+"region", result.region)
+			}
+		case "coordinates":
+			if result.latitude != 0 || result.longitude != 0 {
+				msg.SetLabel(prefix+"latitude", strconv.FormatFloat(result.latitude, 'f', -1, 64))
+				msg.SetLabel(prefix+"longitude", strconv.FormatFloat(result.longitude, 'f', -1, 64))
+			}
+		case "asn":
+			if src && msg.SrcAs == 0 {
+				msg.SrcAs = result.asn
+			} else if !src && msg.DstAs == 0 {
+				msg.DstAs = result.asn
+			}
+		case "asorg":
+			if src {
+				msg.SrcASName = result.asorg
+			} else {
+				msg.DstASName = result.asorg
+			}
+		}
+	}
+}
+
 func init() {
 	segment := &GeoLocation{}
 	segments.RegisterSegment("geolocation", segment)
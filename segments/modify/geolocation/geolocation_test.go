@@ -51,6 +51,35 @@ func TestSegment_GeoLocation_both(t *testing.T) {
 	}
 }
 
+func TestSegment_GeoLocation_city(t *testing.T) {
+	result := segments.TestSegment("geolocation", map[string]string{"citydb": "../../../examples/enricher/GeoLite2-City-Test.mmdb", "fields": "country,city,region,coordinates"},
+		&pb.EnrichedFlow{RemoteAddr: 1, SrcAddr: []byte{192, 0, 2, 7}})
+	if result == nil || result.RemoteCountry != "DE" || result.GetLabel("geo_src_city") != "Karlsruhe" || result.GetLabel("geo_src_region") != "Baden-Württemberg" {
+		t.Error("([error] Segment GeoLocation is not adding country, city and region from a City database.")
+	}
+	if result != nil && (result.GetLabel("geo_src_latitude") != "49" || result.GetLabel("geo_src_longitude") != "8.4") {
+		t.Error("([error] Segment GeoLocation is not adding coordinates from a City database.")
+	}
+}
+
+func TestSegment_GeoLocation_asn(t *testing.T) {
+	result := segments.TestSegment("geolocation", map[string]string{"asndb": "../../../examples/enricher/GeoLite2-ASN-Test.mmdb", "fields": "asn,asorg", "matchboth": "1"},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 7}, DstAddr: []byte{2, 125, 160, 218}, DstAs: 1})
+	if result == nil || result.SrcAs != 553 || result.SrcASName != "BelWue" {
+		t.Error("([error] Segment GeoLocation is not adding ASN data from an ASN database.")
+	}
+	if result != nil && (result.DstAs != 1 || result.DstASName != "Example Transit") {
+		t.Error("([error] Segment GeoLocation is overwriting an existing DstAs.")
+	}
+}
+
+func TestSegment_GeoLocation_missingDatabase(t *testing.T) {
+	segment := GeoLocation{}.New(map[string]string{"filename": "../../../examples/enricher/GeoLite2-Country-Test.mmdb", "fields": "country,city"})
+	if segment != nil {
+		t.Error("([error] Segment GeoLocation accepts the city field without a City database.")
+	}
+}
+
 // GeoLocation Segment benchmark passthrough
 func BenchmarkGeoLocation(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
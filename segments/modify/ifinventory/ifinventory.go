@@ -0,0 +1,189 @@
+// The `ifinventory` segment annotates flows with interface information from a
+// static inventory file, as an alternative to the `snmp` segment for routers
+// which can not be queried using SNMP. Interfaces are identified by the flow's
+// SamplerAddress and the interface index in InIf and OutIf, and the fields
+// `{Src,Dst}IfName`, `{Src,Dst}IfDesc` and `{Src,Dst}IfSpeed` are populated
+// the same way the `snmp` segment does. Additionally, each interface can have a
+// role such as `border`, `customer` or `core`, which is written to the
+// `{Src,Dst}IfRole` fields. These roles can be used by the `role` policy of the
+// `remoteaddress` segment.
+//
+// The inventory is read as YAML if the file name ends in `.yml` or `.yaml`:
+//
+//	# inventory.yml
+//	- sampler: 192.0.2.1
+//	  interfaces:
+//	    - index: 42
+//	      name: et-0/0/0
+//	      description: transit provider
+//	      speed: 100000
+//	      role: border
+//
+// Otherwise, it is read as CSV with the columns
+// `sampler,index,name,description,speed,role`. In both cases, the speed is
+// given in Mbit/s, matching the ifHighSpeed values used by the `snmp` segment.
+package ifinventory
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type IfInventory struct {
+	segments.BaseSegment
+	FileName string // required, YAML or CSV inventory file
+
+	interfaces map[interfaceKey]Interface
+}
+
+type interfaceKey struct {
+	sampler string
+	index   uint32
+}
+
+// A single interface as read from the inventory.
+type Interface struct {
+	Index       uint32 `yaml:"index"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Speed       uint32 `yaml:"speed"`
+	Role        string `yaml:"role"`
+}
+
+// The interfaces of a single sampler as read from a YAML inventory.
+type Sampler struct {
+	Sampler    string      `yaml:"sampler"`
+	Interfaces []Interface `yaml:"interfaces"`
+}
+
+func (segment IfInventory) New(config map[string]string) segments.Segment {
+	if config["filename"] == "" {
+		log.Error().Msg("IfInventory: This segment requires a 'filename' parameter.")
+		return nil
+	}
+	newsegment := &IfInventory{
+		FileName: config["filename"],
+	}
+	var err error
+	if strings.HasSuffix(newsegment.FileName, ".yml") || strings.HasSuffix(newsegment.FileName, ".yaml") {
+		newsegment.interfaces, err = readYAMLInventory(segments.ContainerVolumePrefix + newsegment.FileName)
+	} else {
+		newsegment.interfaces, err = readCSVInventory(segments.ContainerVolumePrefix + newsegment.FileName)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("IfInventory: Could not read inventory: ")
+		return nil
+	}
+	log.Info().Msgf("IfInventory: Read inventory with %d interfaces.", len(newsegment.interfaces))
+	return newsegment
+}
+
+func (segment *IfInventory) Run(wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	for msg := range segment.In {
+		sampler := net.IP(msg.SamplerAddress).String()
+		if iface, ok := segment.interfaces[interfaceKey{sampler, msg.InIf}]; ok && msg.InIf > 0 {
+			msg.SrcIfName, msg.SrcIfDesc, msg.SrcIfSpeed, msg.SrcIfRole = iface.Name, iface.Description, iface.Speed, iface.Role
+		}
+		if iface, ok := segment.interfaces[interfaceKey{sampler, msg.OutIf}]; ok && msg.OutIf > 0 {
+			msg.DstIfName, msg.DstIfDesc, msg.DstIfSpeed, msg.DstIfRole = iface.Name, iface.Description, iface.Speed, iface.Role
+		}
+		segment.Out <- msg
+	}
+}
+
+// Normalizes a sampler address to the representation used for lookups.
+func parseSampler(sampler string) (string, error) {
+	address := net.ParseIP(strings.TrimSpace(sampler))
+	if address == nil {
+		return "", fmt.Errorf("invalid sampler address '%s'", sampler)
+	}
+	return address.String(), nil
+}
+
+func readYAMLInventory(filename string) (map[interfaceKey]Interface, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var samplers []Sampler
+	if err := yaml.Unmarshal(data, &samplers); err != nil {
+		return nil, err
+	}
+	interfaces := make(map[interfaceKey]Interface)
+	for _, sampler := range samplers {
+		address, err := parseSampler(sampler.Sampler)
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range sampler.Interfaces {
+			interfaces[interfaceKey{address, iface.Index}] = iface
+		}
+	}
+	return interfaces, nil
+}
+
+func readCSVInventory(filename string) (map[interfaceKey]Interface, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	interfaces := make(map[interfaceKey]Interface)
+	csvr := csv.NewReader(f)
+	csvr.Comment = '#'
+	csvr.FieldsPerRecord = 6
+	for {
+		row, err := csvr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		address, err := parseSampler(row[0])
+		if err != nil {
+			return nil, err
+		}
+		index, err := strconv.ParseUint(row[1], 10, 32)
+		if err != nil {
+			log.Warn().Msgf("IfInventory: Skipping interface with invalid index '%s'.", row[1])
+			continue
+		}
+		var speed uint64
+		if row[4] != "" {
+			speed, err = strconv.ParseUint(row[4], 10, 32)
+			if err != nil {
+				log.Warn().Msgf("IfInventory: Ignoring invalid speed '%s' of interface %d.", row[4], index)
+			}
+		}
+		interfaces[interfaceKey{address, uint32(index)}] = Interface{
+			Index:       uint32(index),
+			Name:        row[2],
+			Description: row[3],
+			Speed:       uint32(speed),
+			Role:        row[5],
+		}
+	}
+	return interfaces, nil
+}
+
+func init() {
+	segment := &IfInventory{}
+	segments.RegisterSegment("ifinventory", segment)
+}
@@ -0,0 +1,48 @@
+package ifinventory
+
+import (
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// IfInventory Segment testing is done using the example inventories
+func TestSegment_IfInventory_yaml(t *testing.T) {
+	result := segments.TestSegment("ifinventory", map[string]string{"filename": "../../../examples/enricher/ifinventory.yml"},
+		&pb.EnrichedFlow{SamplerAddress: []byte{192, 0, 2, 1}, InIf: 42, OutIf: 43})
+	if result.SrcIfName != "et-0/0/0" || result.SrcIfDesc != "transit provider" || result.SrcIfSpeed != 100000 || result.SrcIfRole != "border" {
+		t.Error("([error] Segment IfInventory is not annotating the source interface correctly.")
+	}
+	if result.DstIfName != "xe-0/0/1" || result.DstIfRole != "customer" {
+		t.Error("([error] Segment IfInventory is not annotating the destination interface correctly.")
+	}
+}
+
+func TestSegment_IfInventory_csv(t *testing.T) {
+	result := segments.TestSegment("ifinventory", map[string]string{"filename": "../../../examples/enricher/ifinventory.csv"},
+		&pb.EnrichedFlow{SamplerAddress: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, InIf: 7, OutIf: 8})
+	if result.SrcIfName != "ae0" || result.SrcIfSpeed != 400000 || result.SrcIfRole != "core" {
+		t.Error("([error] Segment IfInventory is not annotating the source interface correctly.")
+	}
+	if result.DstIfName != "" {
+		t.Error("([error] Segment IfInventory is annotating an unknown interface.")
+	}
+}
+
+func TestSegment_IfInventory_otherSampler(t *testing.T) {
+	result := segments.TestSegment("ifinventory", map[string]string{"filename": "../../../examples/enricher/ifinventory.csv"},
+		&pb.EnrichedFlow{SamplerAddress: []byte{192, 0, 2, 2}, InIf: 42})
+	if result.SrcIfName != "" {
+		t.Error("([error] Segment IfInventory is annotating interfaces of other samplers.")
+	}
+}
+
+func TestSegment_IfInventory_instanciation(t *testing.T) {
+	if (IfInventory{}).New(map[string]string{}) != nil {
+		t.Error("([error] Segment IfInventory initiated without filename.")
+	}
+	if (IfInventory{}).New(map[string]string{"filename": "../../../examples/enricher/nonexistent.yml"}) != nil {
+		t.Error("([error] Segment IfInventory initiated without inventory.")
+	}
+}
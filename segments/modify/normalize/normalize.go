@@ -1,26 +1,49 @@
 // The `normalize` segment multiplies the Bytes and the Packets field by the flows
-// SamplingRate field. Additionally, it sets the Normalized field for this flow to 1.
+// SamplingRate field. Afterwards, the original rate is kept in the
+// OriginalSamplingRate field, SamplingRate is set to 1 and the Normalized field
+// is set to 1. Flows which have already been normalized are passed on
+// unchanged, i.e. running this segment twice will not scale flows twice.
 //
 // The fallback parameter is for flows known to be sampled which do not include
 // the sampling rate for some reason.
 //
-// Roadmap:
-// * replace Normalized with an OriginalSamplingRate field and set SamplingRate to 1
-// instead
+// Sampling rates for specific samplers can be set using a CSV file given in the
+// ratefile parameter, with the columns `sampler,ifindex,rate`. The ifindex is
+// matched against the flow's InIf, an empty ifindex applies to all interfaces of
+// a sampler. By default, the rates from this file are only used for flows
+// without a sampling rate, and they are used in favor of the fallback. If
+// override is set, they also replace any sampling rate found in a flow.
 package normalize
 
 import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
 )
 
 type Normalize struct {
 	segments.BaseSegment
 	Fallback uint64 // optional, default is no fallback, determines a assumed sampling rate of flows if none is found in a given flow
+	RateFile string // optional, default is no file, CSV file with sampling rates per sampler and interface
+	Override bool   // optional, default is false, determines whether rates from the RateFile replace rates found in flows
+
+	rates map[rateKey]uint64
+}
+
+// Identifies a sampler's interface, or all of its interfaces if ifIndex is 0.
+type rateKey struct {
+	sampler string
+	ifIndex uint32
 }
 
 func (segment Normalize) New(config map[string]string) segments.Segment {
@@ -36,9 +59,28 @@ func (segment Normalize) New(config map[string]string) segments.Segment {
 		log.Info().Msg("Normalize: 'fallback' set to default '0'.")
 	}
 
-	return &Normalize{
+	override, err := strconv.ParseBool(config["override"])
+	if err != nil {
+		log.Info().Msg("Normalize: 'override' set to default 'false'.")
+	}
+
+	newsegment := &Normalize{
 		Fallback: fallback,
+		RateFile: config["ratefile"],
+		Override: override,
+	}
+	if newsegment.RateFile != "" {
+		newsegment.rates, err = readRateFile(segments.ContainerVolumePrefix + newsegment.RateFile)
+		if err != nil {
+			log.Error().Err(err).Msg("Normalize: Could not read 'ratefile': ")
+			return nil
+		}
+		log.Info().Msgf("Normalize: Read %d sampling rates.", len(newsegment.rates))
+	} else if override {
+		log.Error().Msg("Normalize: Parameter 'override' requires the 'ratefile' parameter.")
+		return nil
 	}
+	return newsegment
 }
 
 func (segment *Normalize) Run(wg *sync.WaitGroup) {
@@ -47,20 +89,83 @@ func (segment *Normalize) Run(wg *sync.WaitGroup) {
 		wg.Done()
 	}()
 	for msg := range segment.In {
-		if msg.SamplingRate > 0 {
-			msg.Bytes *= msg.SamplingRate
-			msg.Packets *= msg.SamplingRate
-			msg.Normalized = 1
-		} else if segment.Fallback != 0 {
-			msg.Bytes *= segment.Fallback
-			msg.Packets *= segment.Fallback
-			msg.SamplingRate = segment.Fallback
+		if msg.Normalized == 1 {
+			segment.Out <- msg
+			continue
+		}
+		var rate uint64
+		if segment.Override || msg.SamplingRate == 0 {
+			rate = segment.lookupRate(msg)
+		}
+		if rate == 0 {
+			rate = msg.SamplingRate
+		}
+		if rate == 0 {
+			rate = segment.Fallback
+		}
+		if rate != 0 {
+			msg.Bytes *= rate
+			msg.Packets *= rate
+			msg.OriginalSamplingRate = rate
+			msg.SamplingRate = 1
 			msg.Normalized = 1
 		}
 		segment.Out <- msg
 	}
 }
 
+// Returns the configured sampling rate of a flow's sampler and input
+// interface, or 0 if there is none.
+func (segment *Normalize) lookupRate(msg *pb.EnrichedFlow) uint64 {
+	if segment.rates == nil {
+		return 0
+	}
+	sampler := net.IP(msg.SamplerAddress).String()
+	if rate, ok := segment.rates[rateKey{sampler, msg.InIf}]; ok && msg.InIf != 0 {
+		return rate
+	}
+	return segment.rates[rateKey{sampler, 0}]
+}
+
+func readRateFile(filename string) (map[rateKey]uint64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rates := make(map[rateKey]uint64)
+	csvr := csv.NewReader(f)
+	csvr.Comment = '#'
+	csvr.FieldsPerRecord = 3
+	for {
+		row, err := csvr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		address := net.ParseIP(strings.TrimSpace(row[0]))
+		if address == nil {
+			return nil, fmt.Errorf("invalid sampler address '%s'", row[0])
+		}
+		var ifIndex uint64
+		if strings.TrimSpace(row[1]) != "" {
+			ifIndex, err = strconv.ParseUint(strings.TrimSpace(row[1]), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interface index '%s'", row[1])
+			}
+		}
+		rate, err := strconv.ParseUint(strings.TrimSpace(row[2]), 10, 32)
+		if err != nil || rate == 0 {
+			return nil, fmt.Errorf("invalid sampling rate '%s'", row[2])
+		}
+		rates[rateKey{address.String(), uint32(ifIndex)}] = rate
+	}
+	return rates, nil
+}
+
 func init() {
 	segment := &Normalize{}
 	segments.RegisterSegment("normalize", segment)
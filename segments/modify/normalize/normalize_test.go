@@ -37,6 +37,58 @@ func TestSegment_Normalize_noFallbackSamplingRate(t *testing.T) {
 	}
 }
 
+// Normalize Segment test, original SamplingRate test
+func TestSegment_Normalize_originalSamplingRate(t *testing.T) {
+	result := segments.TestSegment("normalize", map[string]string{},
+		&pb.EnrichedFlow{SamplingRate: 32, Bytes: 1, Packets: 1})
+	if result.OriginalSamplingRate != 32 || result.SamplingRate != 1 || result.Packets != 32 {
+		t.Error("([error] Segment Normalize is not keeping the original SamplingRate.")
+	}
+}
+
+// Normalize Segment test, double normalization test
+func TestSegment_Normalize_alreadyNormalized(t *testing.T) {
+	result := segments.TestSegment("normalize", map[string]string{"fallback": "42"},
+		&pb.EnrichedFlow{SamplingRate: 1, OriginalSamplingRate: 32, Normalized: 1, Bytes: 32})
+	if result.Bytes != 32 || result.OriginalSamplingRate != 32 {
+		t.Error("([error] Segment Normalize is normalizing flows twice.")
+	}
+}
+
+// Normalize Segment test, rate file test
+func TestSegment_Normalize_rateFile(t *testing.T) {
+	config := map[string]string{"ratefile": "../../../examples/enricher/samplingrates.csv", "fallback": "42"}
+	result := segments.TestSegment("normalize", config,
+		&pb.EnrichedFlow{SamplerAddress: []byte{192, 0, 2, 1}, InIf: 42, Bytes: 1})
+	if result.Bytes != 100 {
+		t.Error("([error] Segment Normalize is not using the interface specific rate from its rate file.")
+	}
+	result = segments.TestSegment("normalize", config,
+		&pb.EnrichedFlow{SamplerAddress: []byte{192, 0, 2, 1}, InIf: 23, Bytes: 1})
+	if result.Bytes != 1000 {
+		t.Error("([error] Segment Normalize is not using the sampler specific rate from its rate file.")
+	}
+	result = segments.TestSegment("normalize", config,
+		&pb.EnrichedFlow{SamplerAddress: []byte{192, 0, 2, 1}, SamplingRate: 32, Bytes: 1})
+	if result.Bytes != 32 {
+		t.Error("([error] Segment Normalize is overriding in-flow SamplingRate without 'override'.")
+	}
+	result = segments.TestSegment("normalize", config,
+		&pb.EnrichedFlow{SamplerAddress: []byte{192, 0, 2, 2}, Bytes: 1})
+	if result.Bytes != 42 {
+		t.Error("([error] Segment Normalize is not using the fallback for unknown samplers.")
+	}
+}
+
+// Normalize Segment test, rate file override test
+func TestSegment_Normalize_rateFileOverride(t *testing.T) {
+	result := segments.TestSegment("normalize", map[string]string{"ratefile": "../../../examples/enricher/samplingrates.csv", "override": "true"},
+		&pb.EnrichedFlow{SamplerAddress: []byte{192, 0, 2, 1}, SamplingRate: 32, Bytes: 1})
+	if result.Bytes != 1000 || result.OriginalSamplingRate != 1000 {
+		t.Error("([error] Segment Normalize is not overriding in-flow SamplingRate.")
+	}
+}
+
 // Normalize Segment benchmark passthrough
 func BenchmarkNormalize(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
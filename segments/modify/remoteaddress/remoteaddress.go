@@ -22,11 +22,19 @@
 //     its destination address inside our user's network. The same logic applies
 //     vice versa: 'egress' flows have a remote source address.
 //
+//   - 'role' uses the interface roles set by the `ifinventory` segment instead of
+//     assuming one kind of interface globally: If a flow entered on a border
+//     interface or leaves on a user interface, its remote address is the source
+//     address. If it leaves on a border interface or entered on a user
+//     interface, its remote address is the destination address. Border roles
+//     take precedence. The roles considered are set by the 'borderroles' and
+//     'userroles' parameters, defaulting to 'border' and 'customer,user'.
+//
 //   - 'clear' assumes flows are exported whereever, and thus all remote address
 //     info is cleared in this case.
 //
-// Any optional parameters relate to the `cidr` policy only and behave as in the
-// `addnetid` segment.
+// The remaining optional parameters relate to the `cidr` policy only and behave
+// as in the `addnetid` segment.
 package remoteaddress
 
 import (
@@ -34,7 +42,9 @@ import (
 	"io"
 	"net"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/rs/zerolog/log"
@@ -46,17 +56,19 @@ import (
 
 type RemoteAddress struct {
 	segments.BaseSegment
-	Policy        string // required, 'cidr', 'border', 'user' and 'clear' are available options, see above
-	FileName      string // optional, required if policy is set to 'cidr', default is empty
-	DropUnmatched bool   // optional, default is false, relevant to 'cidr' only, determines what to do with unmatched flows
+	Policy        string   // required, 'cidr', 'border', 'user', 'role' and 'clear' are available options, see above
+	FileName      string   // optional, required if policy is set to 'cidr', default is empty
+	DropUnmatched bool     // optional, default is false, relevant to 'cidr' only, determines what to do with unmatched flows
+	BorderRoles   []string // optional, default is "border", relevant to 'role' only, interface roles considered border interfaces
+	UserRoles     []string // optional, default is "customer,user", relevant to 'role' only, interface roles considered user interfaces
 
 	trieV4 ip_prefix_trie.TrieNode
 	trieV6 ip_prefix_trie.TrieNode
 }
 
 func (segment RemoteAddress) New(config map[string]string) segments.Segment {
-	if !(config["policy"] == "cidr" || config["policy"] == "border" || config["policy"] == "user" || config["policy"] == "clear") {
-		log.Error().Msg("RemoteAddress: The 'policy' parameter is required to be one of 'cidr', 'border', 'user', or 'clear'.")
+	if !(config["policy"] == "cidr" || config["policy"] == "border" || config["policy"] == "user" || config["policy"] == "role" || config["policy"] == "clear") {
+		log.Error().Msg("RemoteAddress: The 'policy' parameter is required to be one of 'cidr', 'border', 'user', 'role', or 'clear'.")
 		return nil
 	}
 	drop, err := strconv.ParseBool(config["dropunmatched"])
@@ -67,10 +79,29 @@ func (segment RemoteAddress) New(config map[string]string) segments.Segment {
 		log.Error().Msg("RemoteAddress: This segment requires a 'filename' parameter.")
 		return nil
 	}
+	borderRoles, userRoles := []string{"border"}, []string{"customer", "user"}
+	if config["borderroles"] != "" {
+		borderRoles = nil
+		for _, role := range strings.Split(config["borderroles"], ",") {
+			borderRoles = append(borderRoles, strings.TrimSpace(role))
+		}
+	} else if config["policy"] == "role" {
+		log.Info().Msg("RemoteAddress: 'borderroles' set to default 'border'.")
+	}
+	if config["userroles"] != "" {
+		userRoles = nil
+		for _, role := range strings.Split(config["userroles"], ",") {
+			userRoles = append(userRoles, strings.TrimSpace(role))
+		}
+	} else if config["policy"] == "role" {
+		log.Info().Msg("RemoteAddress: 'userroles' set to default 'customer,user'.")
+	}
 	return &RemoteAddress{
 		Policy:        config["policy"],
 		FileName:      config["filename"],
 		DropUnmatched: drop,
+		BorderRoles:   borderRoles,
+		UserRoles:     userRoles,
 	}
 }
 
@@ -120,6 +151,20 @@ func (segment *RemoteAddress) Run(wg *sync.WaitGroup) {
 			}
 			segment.Out <- msg
 		}
+	case "role":
+		for msg := range segment.In {
+			switch {
+			case slices.Contains(segment.BorderRoles, msg.SrcIfRole): // flow entered on border interface
+				msg.RemoteAddr = 1 // thus, RemoteAddr should indicate SrcAddr
+			case slices.Contains(segment.BorderRoles, msg.DstIfRole): // flow leaves on border interface
+				msg.RemoteAddr = 2 // thus, RemoteAddr should indicate DstAddr
+			case slices.Contains(segment.UserRoles, msg.SrcIfRole): // flow entered on user interface
+				msg.RemoteAddr = 2 // thus, RemoteAddr should indicate DstAddr
+			case slices.Contains(segment.UserRoles, msg.DstIfRole): // flow leaves on user interface
+				msg.RemoteAddr = 1 // thus, RemoteAddr should indicate SrcAddr
+			}
+			segment.Out <- msg
+		}
 	case "clear":
 		for msg := range segment.In {
 			msg.RemoteAddr = 0 // reset previous info, we can't tell in a mixed env
@@ -64,3 +64,26 @@ func BenchmarkRemoteAddress(b *testing.B) {
 	}
 	close(in)
 }
+
+func TestSegment_RemoteAddress_role(t *testing.T) {
+	result := segments.TestSegment("remoteaddress", map[string]string{"policy": "role"},
+		&pb.EnrichedFlow{SrcIfRole: "core", DstIfRole: "border"})
+	if result.RemoteAddr != 2 {
+		t.Error("([error] Segment RemoteAddress is not determining the remote address correctly by 'role'.")
+	}
+	result = segments.TestSegment("remoteaddress", map[string]string{"policy": "role"},
+		&pb.EnrichedFlow{SrcIfRole: "customer", DstIfRole: "core"})
+	if result.RemoteAddr != 2 {
+		t.Error("([error] Segment RemoteAddress is not determining the remote address correctly by 'role'.")
+	}
+	result = segments.TestSegment("remoteaddress", map[string]string{"policy": "role", "userroles": "customer, downstream"},
+		&pb.EnrichedFlow{SrcIfRole: "border", DstIfRole: "downstream"})
+	if result.RemoteAddr != 1 {
+		t.Error("([error] Segment RemoteAddress is not preferring border roles.")
+	}
+	result = segments.TestSegment("remoteaddress", map[string]string{"policy": "role"},
+		&pb.EnrichedFlow{SrcIfRole: "core", DstIfRole: "core"})
+	if result.RemoteAddr != 0 {
+		t.Error("([error] Segment RemoteAddress is determining a remote address on core interfaces.")
+	}
+}
@@ -15,52 +15,90 @@
 //   interface will always remain untouched)
 // * add any interface's data to a cache, which will be used to enrich the
 //   next flow using that same interface
-// * clear the cache value after 1 hour has elapsed, resulting in another flow
-//   without these annotations at that time
+// * clear the cache value after the `cachetimeout` (default 1 hour) has
+//   elapsed, resulting in another flow without these annotations at that time
 //
 // These rules are applied for source and destination interfaces separately.
 //
-// The paramters to this segment specify the SNMPv2 community as well as the
-// connection limit employed by this segment. The latter is again to not overload
-// the routers SNMPd. Lastly, the regex parameter can be used to limit the
-// `IfDesc` annotations to a certain part of the actual interface description.
-// For instance, descriptions follow the format `customerid - blablalba`, the
-// regex `(.*) -.*` would grab just that customer ID to put into the `IfDesc`
-// fields. Also see the full examples linked below.
+// To have annotations complete from the first flow on, the `prefetch` option
+// walks the ifXTable (ifName, ifAlias, ifHighSpeed) of each known sampler
+// in bulk. Samplers are known if they are listed in the `samplers` parameter,
+// if they have been seen in any flow, or if they are part of the persisted
+// cache. Walks are done at startup or when a sampler is first seen, and are
+// repeated in the `prefetchinterval`. Additionally, the `cachefile` parameter
+// can be used to persist the cache to disk on shutdown and after each round
+// of walks, from where it is restored on startup.
 //
-// Roadmap:
-// * cache timeout should be configurable
+// The paramters to this segment specify the SNMP version and credentials as
+// well as the connection limit employed by this segment. The latter is again to
+// not overload the routers SNMPd. SNMPv2c requires a community, while SNMPv3
+// requires a username and optionally authentication and privacy settings.
+// Lastly, the regex parameter can be used to limit the `IfDesc` annotations to
+// a certain part of the actual interface description. For instance,
+// descriptions follow the format `customerid - blablalba`, the regex `(.*) -.*`
+// would grab just that customer ID to put into the `IfDesc` fields. Also see
+// the full examples linked below.
 package snmp
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
+	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/segments"
-	"github.com/alouca/gosnmp"
+	"github.com/gosnmp/gosnmp"
 	cache "github.com/patrickmn/go-cache"
 )
 
 var (
-	oidBase = ".1.3.6.1.2.1.31.1.1.1.%d.%d"
+	oidBase = ".1.3.6.1.2.1.31.1.1.1.%d"
 	oidExts = map[string]uint8{"name": 1, "speed": 15, "desc": 18}
+
+	authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{"": gosnmp.NoAuth,
+		"md5": gosnmp.MD5, "sha": gosnmp.SHA, "sha224": gosnmp.SHA224,
+		"sha256": gosnmp.SHA256, "sha384": gosnmp.SHA384, "sha512": gosnmp.SHA512}
+	privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{"": gosnmp.NoPriv,
+		"des": gosnmp.DES, "aes": gosnmp.AES, "aes192": gosnmp.AES192,
+		"aes256": gosnmp.AES256, "aes192c": gosnmp.AES192C, "aes256c": gosnmp.AES256C}
 )
 
 type SNMP struct {
 	segments.BaseSegment
-	Community string // optional, default is 'public'
-	Regex     string // optional, default matches all, can be used to extract content from descriptions, see examples/enricher
-	ConnLimit uint64 // optional, default is 16
+	Version          string        // optional, default is "2c", options are "2c" and "3"
+	Community        string        // optional, default is 'public', used for SNMPv2c only
+	Username         string        // required for SNMPv3
+	AuthProtocol     string        // optional, default is no authentication, options are "md5", "sha", "sha224", "sha256", "sha384", "sha512"
+	AuthPassphrase   string        // required if AuthProtocol is set
+	PrivProtocol     string        // optional, default is no privacy, options are "des", "aes", "aes192", "aes256", "aes192c", "aes256c"
+	PrivPassphrase   string        // required if PrivProtocol is set
+	Regex            string        // optional, default matches all, can be used to extract content from descriptions, see examples/enricher
+	ConnLimit        uint64        // optional, default is 16
+	CacheTimeout     time.Duration // optional, default is 1h
+	Prefetch         bool          // optional, default is false, walk the ifXTable of all known samplers
+	PrefetchInterval time.Duration // optional, default is 1h, interval for repeating prefetch walks
+	Samplers         []string      // optional, default is none, samplers to prefetch at startup
+	CacheFile        string        // optional, default is no persistence, file to persist the cache to
 
 	compiledRegex      *regexp.Regexp
 	snmpCache          *cache.Cache
 	connLimitSemaphore chan struct{}
+	knownSamplers      map[string]bool
+	samplersLock       *sync.Mutex
+	cacheFileLock      *sync.Mutex
+}
+
+// A persisted cache item.
+type cacheItem struct {
+	Value      interface{}
+	Expiration int64
 }
 
 func (segment SNMP) New(config map[string]string) segments.Segment {
@@ -79,12 +117,48 @@ func (segment SNMP) New(config map[string]string) segments.Segment {
 		log.Info().Msg("SNMP: 'connlimit' set to default '16'.")
 	}
 
+	var version string = "2c"
+	switch config["version"] {
+	case "2c", "3":
+		version = config["version"]
+	case "":
+		log.Info().Msg("SNMP: 'version' set to default '2c'.")
+	default:
+		log.Error().Msg("SNMP: Unknown 'version', options are '2c' and '3'.")
+		return nil
+	}
+
 	var community string = "public"
 	if config["community"] != "" {
 		community = config["community"]
-	} else {
+	} else if version == "2c" {
 		log.Info().Msg("SNMP: 'community' set to default 'public'.")
 	}
+
+	authProtocol, privProtocol := strings.ToLower(config["authprotocol"]), strings.ToLower(config["privprotocol"])
+	if version == "3" {
+		if config["username"] == "" {
+			log.Error().Msg("SNMP: SNMPv3 requires the 'username' parameter.")
+			return nil
+		}
+		if _, ok := authProtocols[authProtocol]; !ok {
+			log.Error().Msg("SNMP: Unknown 'authprotocol', options are 'md5', 'sha', 'sha224', 'sha256', 'sha384' and 'sha512'.")
+			return nil
+		}
+		if _, ok := privProtocols[privProtocol]; !ok {
+			log.Error().Msg("SNMP: Unknown 'privprotocol', options are 'des', 'aes', 'aes192', 'aes256', 'aes192c' and 'aes256c'.")
+			return nil
+		}
+		if authProtocol != "" && config["authpassphrase"] == "" {
+			log.Error().Msg("SNMP: Setting 'authprotocol' requires the 'authpassphrase' parameter.")
+			return nil
+		}
+		if privProtocol != "" && (authProtocol == "" || config["privpassphrase"] == "") {
+			log.Error().Msg("SNMP: Setting 'privprotocol' requires the 'authprotocol' and 'privpassphrase' parameters.")
+			return nil
+		}
+	}
+
 	var regex string = "^(.*)$"
 	if config["regex"] != "" {
 		regex = config["regex"]
@@ -96,27 +170,113 @@ func (segment SNMP) New(config map[string]string) segments.Segment {
 		log.Error().Err(err).Msg("SNMP: Configuration error, regex does not compile: ")
 		return nil
 	}
+
+	var cacheTimeout = time.Hour
+	if config["cachetimeout"] != "" {
+		if parsedTimeout, err := time.ParseDuration(config["cachetimeout"]); err == nil && parsedTimeout > 0 {
+			cacheTimeout = parsedTimeout
+		} else {
+			log.Error().Msg("SNMP: Could not parse 'cachetimeout' parameter, using default 1h.")
+		}
+	} else {
+		log.Info().Msg("SNMP: 'cachetimeout' set to default '1h'.")
+	}
+
+	prefetch, err := strconv.ParseBool(config["prefetch"])
+	if err != nil {
+		log.Info().Msg("SNMP: 'prefetch' set to default 'false'.")
+	}
+	var prefetchInterval = time.Hour
+	if config["prefetchinterval"] != "" {
+		if parsedInterval, err := time.ParseDuration(config["prefetchinterval"]); err == nil && parsedInterval > 0 {
+			prefetchInterval = parsedInterval
+		} else {
+			log.Error().Msg("SNMP: Could not parse 'prefetchinterval' parameter, using default 1h.")
+		}
+	} else if prefetch {
+		log.Info().Msg("SNMP: 'prefetchinterval' set to default '1h'.")
+	}
+	var samplers []string
+	if config["samplers"] != "" {
+		for _, sampler := range strings.Split(config["samplers"], ",") {
+			address := net.ParseIP(strings.TrimSpace(sampler))
+			if address == nil {
+				log.Error().Msgf("SNMP: Sampler '%s' is not a valid address.", sampler)
+				return nil
+			}
+			samplers = append(samplers, address.String())
+		}
+	}
+
 	return &SNMP{
-		Community:     community,
-		Regex:         regex,
-		ConnLimit:     connLimit,
-		compiledRegex: compiledRegex,
+		Version:          version,
+		Community:        community,
+		Username:         config["username"],
+		AuthProtocol:     authProtocol,
+		AuthPassphrase:   config["authpassphrase"],
+		PrivProtocol:     privProtocol,
+		PrivPassphrase:   config["privpassphrase"],
+		Regex:            regex,
+		ConnLimit:        connLimit,
+		CacheTimeout:     cacheTimeout,
+		Prefetch:         prefetch,
+		PrefetchInterval: prefetchInterval,
+		Samplers:         samplers,
+		CacheFile:        config["cachefile"],
+		compiledRegex:    compiledRegex,
 	}
 }
 
 func (segment *SNMP) Run(wg *sync.WaitGroup) {
+	done := make(chan struct{})
 	defer func() {
+		close(done)
+		segment.saveCache()
 		close(segment.Out)
 		wg.Done()
 	}()
 
 	// init cache:			expiry       purge
-	segment.snmpCache = cache.New(1*time.Hour, 1*time.Hour) // TODO: make configurable
+	segment.snmpCache = cache.New(segment.CacheTimeout, segment.CacheTimeout)
 	// init semaphore for connection limit
 	segment.connLimitSemaphore = make(chan struct{}, segment.ConnLimit)
+	segment.knownSamplers = make(map[string]bool)
+	segment.samplersLock = &sync.Mutex{}
+	segment.cacheFileLock = &sync.Mutex{}
+
+	segment.loadCache()
+	if segment.Prefetch {
+		for _, sampler := range segment.Samplers {
+			segment.addSampler(sampler)
+		}
+		go func() {
+			ticker := time.NewTicker(segment.PrefetchInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					segment.samplersLock.Lock()
+					var samplers []string
+					for sampler := range segment.knownSamplers {
+						samplers = append(samplers, sampler)
+					}
+					segment.samplersLock.Unlock()
+					for _, sampler := range samplers {
+						segment.walkInterfaces(sampler)
+					}
+					segment.saveCache()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
 
 	for msg := range segment.In {
 		router := net.IP(msg.SamplerAddress).String()
+		if segment.Prefetch {
+			segment.addSampler(router)
+		}
 		// TODO: rename SrcIf and DstIf fields to match goflow InIf/OutIf
 		if msg.InIf > 0 {
 			msg.SrcIfName, msg.SrcIfDesc, msg.SrcIfSpeed = segment.fetchInterfaceData(router, msg.InIf)
@@ -140,6 +300,60 @@ func (segment *SNMP) Run(wg *sync.WaitGroup) {
 	}
 }
 
+// Create a new SNMP connection to a router using the configured version and
+// credentials.
+func (segment *SNMP) connect(router string) (*gosnmp.GoSNMP, error) {
+	s := &gosnmp.GoSNMP{
+		Target:    router,
+		Port:      161,
+		Transport: "udp",
+		Timeout:   time.Second,
+		Retries:   1,
+		MaxOids:   gosnmp.MaxOids,
+	}
+	if segment.Version == "3" {
+		s.Version = gosnmp.Version3
+		s.SecurityModel = gosnmp.UserSecurityModel
+		switch {
+		case segment.PrivProtocol != "":
+			s.MsgFlags = gosnmp.AuthPriv
+		case segment.AuthProtocol != "":
+			s.MsgFlags = gosnmp.AuthNoPriv
+		default:
+			s.MsgFlags = gosnmp.NoAuthNoPriv
+		}
+		s.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 segment.Username,
+			AuthenticationProtocol:   authProtocols[segment.AuthProtocol],
+			AuthenticationPassphrase: segment.AuthPassphrase,
+			PrivacyProtocol:          privProtocols[segment.PrivProtocol],
+			PrivacyPassphrase:        segment.PrivPassphrase,
+		}
+	} else {
+		s.Version = gosnmp.Version2c
+		s.Community = segment.Community
+	}
+	return s, s.Connect()
+}
+
+// Convert a SNMP variable to the type cached for the given key.
+func parseValue(key string, variable gosnmp.SnmpPDU) (interface{}, bool) {
+	switch variable.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+		return nil, false
+	}
+	if key == "speed" {
+		return uint32(gosnmp.ToBigInt(variable.Value).Uint64()), true
+	}
+	switch value := variable.Value.(type) {
+	case []byte:
+		return string(value), true
+	case string:
+		return value, true
+	}
+	return nil, false
+}
+
 // Query a single SNMP datapoint. Supposedly a short-lived goroutine.
 func (segment *SNMP) querySNMP(router string, iface uint32, key string) {
 	defer func() {
@@ -147,30 +361,150 @@ func (segment *SNMP) querySNMP(router string, iface uint32, key string) {
 	}()
 	segment.connLimitSemaphore <- struct{}{} // acquire
 
-	s, err := gosnmp.NewGoSNMP(router, segment.Community, gosnmp.Version2c, 1)
+	s, err := segment.connect(router)
 	if err != nil {
 		log.Error().Err(err).Msg("SNMP: Connection Error")
 		segment.snmpCache.Delete(fmt.Sprintf("%s-%d-%s", router, iface, key))
 		return
 	}
+	defer s.Conn.Close()
 
-	var result *gosnmp.SnmpPacket
-	oid := fmt.Sprintf(oidBase, oidExts[key], iface)
-	resp, err := s.Get(oid)
+	oid := fmt.Sprintf(oidBase+".%d", oidExts[key], iface)
+	resp, err := s.Get([]string{oid})
 	if err != nil {
 		log.Warn().Err(err).Msgf("SNMP: Failed getting OID '%s' from %s.", oid, router)
 		segment.snmpCache.Delete(fmt.Sprintf("%s-%d-%s", router, iface, key))
 		return
-	} else {
-		result = resp
 	}
 
 	// parse and cache
-	if len(result.Variables) == 1 {
-		snmpvalue := resp.Variables[0].Value
-		segment.snmpCache.Set(fmt.Sprintf("%s-%d-%s", router, iface, key), snmpvalue, cache.DefaultExpiration)
-	} else {
-		log.Warn().Msgf("SNMP: Bad response getting %s from %s. Error: %v", key, router, resp.Variables)
+	if len(resp.Variables) == 1 {
+		if snmpvalue, ok := parseValue(key, resp.Variables[0]); ok {
+			segment.snmpCache.Set(fmt.Sprintf("%s-%d-%s", router, iface, key), snmpvalue, cache.DefaultExpiration)
+			return
+		}
+	}
+	log.Warn().Msgf("SNMP: Bad response getting %s from %s. Error: %v", key, router, resp.Variables)
+	segment.snmpCache.Delete(fmt.Sprintf("%s-%d-%s", router, iface, key))
+}
+
+// Register a sampler for prefetching, starting an initial walk if it has not
+// been known before.
+func (segment *SNMP) addSampler(router string) {
+	segment.samplersLock.Lock()
+	defer segment.samplersLock.Unlock()
+	if segment.knownSamplers[router] {
+		return
+	}
+	segment.knownSamplers[router] = true
+	go segment.walkInterfaces(router)
+}
+
+// Walk the ifXTable columns of a router and cache all interfaces found.
+func (segment *SNMP) walkInterfaces(router string) {
+	defer func() {
+		<-segment.connLimitSemaphore // release
+	}()
+	segment.connLimitSemaphore <- struct{}{} // acquire
+
+	s, err := segment.connect(router)
+	if err != nil {
+		log.Error().Err(err).Msg("SNMP: Connection Error")
+		return
+	}
+	defer s.Conn.Close()
+
+	var count int
+	for key, ext := range oidExts {
+		oid := fmt.Sprintf(oidBase, ext)
+		variables, err := s.BulkWalkAll(oid)
+		if err != nil {
+			log.Warn().Err(err).Msgf("SNMP: Failed walking OID '%s' on %s.", oid, router)
+			return
+		}
+		for _, variable := range variables {
+			iface, err := strconv.ParseUint(strings.TrimPrefix(variable.Name, oid+"."), 10, 32)
+			if err != nil {
+				continue
+			}
+			if snmpvalue, ok := parseValue(key, variable); ok {
+				segment.snmpCache.Set(fmt.Sprintf("%s-%d-%s", router, iface, key), snmpvalue, cache.DefaultExpiration)
+				count += 1
+			}
+		}
+	}
+	log.Info().Msgf("SNMP: Prefetched %d interface values from %s.", count, router)
+}
+
+// Restore the cache from the configured cache file, if any.
+func (segment *SNMP) loadCache() {
+	if segment.CacheFile == "" {
+		return
+	}
+	data, err := os.ReadFile(segments.ContainerVolumePrefix + segment.CacheFile)
+	if err != nil {
+		log.Warn().Err(err).Msg("SNMP: Could not read cache file, starting with an empty cache.")
+		return
+	}
+	var items map[string]cacheItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		log.Warn().Err(err).Msg("SNMP: Could not parse cache file, starting with an empty cache.")
+		return
+	}
+	now := time.Now().UnixNano()
+	samplers := make(map[string]bool)
+	for key, item := range items {
+		if item.Expiration > 0 && item.Expiration < now {
+			continue
+		}
+		sampler, _, found := strings.Cut(key, "-")
+		if !found {
+			continue
+		}
+		value := item.Value
+		if strings.HasSuffix(key, "-speed") {
+			speed, ok := value.(float64)
+			if !ok {
+				continue
+			}
+			value = uint32(speed)
+		} else if _, ok := value.(string); !ok {
+			continue
+		}
+		segment.snmpCache.Set(key, value, time.Duration(item.Expiration-now))
+		samplers[sampler] = true
+	}
+	if segment.Prefetch {
+		for sampler := range samplers {
+			segment.addSampler(sampler)
+		}
+	}
+	log.Info().Msgf("SNMP: Restored %d cached values.", segment.snmpCache.ItemCount())
+}
+
+// Persist the cache to the configured cache file, if any. Values being
+// currently queried are skipped.
+func (segment *SNMP) saveCache() {
+	if segment.CacheFile == "" {
+		return
+	}
+	segment.cacheFileLock.Lock()
+	defer segment.cacheFileLock.Unlock()
+
+	items := make(map[string]cacheItem)
+	for key, item := range segment.snmpCache.Items() {
+		if item.Object == nil {
+			continue
+		}
+		items[key] = cacheItem{Value: item.Object, Expiration: item.Expiration}
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		log.Error().Err(err).Msg("SNMP: Could not serialize cache.")
+		return
+	}
+	if err := os.WriteFile(segments.ContainerVolumePrefix+segment.CacheFile, data, 0644); err != nil {
+		log.Error().Err(err).Msg("SNMP: Could not write cache file.")
 	}
 }
 
@@ -191,7 +525,7 @@ func (segment *SNMP) fetchInterfaceData(router string, iface uint32) (string, st
 			case "desc":
 				desc = value.(string)
 			case "speed":
-				speed = uint32(value.(uint64))
+				speed = value.(uint32)
 			}
 		} else {
 			// mark as "being queried" by putting nil into the cache, so a future run will use the cached nil
@@ -1,7 +1,12 @@
 package snmp
 
 import (
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
+
+	cache "github.com/patrickmn/go-cache"
 )
 
 // SNMP Segment test
@@ -59,4 +64,66 @@ func TestSegment_SNMP_instanciation(t *testing.T) {
 	if result != nil {
 		t.Error("([error] Segment SNMP initiated despide bad config.")
 	}
+
+	snmpInterface = &SNMP{}
+	result = snmpInterface.New(map[string]string{"version": "3", "username": "flows", "authprotocol": "sha256", "authpassphrase": "secret", "privprotocol": "aes", "privpassphrase": "secret"})
+	if result == nil {
+		t.Error("([error] Segment SNMP did not initiate despite good SNMPv3 config.")
+	}
+
+	snmpInterface = &SNMP{}
+	result = snmpInterface.New(map[string]string{"version": "3"})
+	if result != nil {
+		t.Error("([error] Segment SNMP initiated despite missing SNMPv3 username.")
+	}
+
+	snmpInterface = &SNMP{}
+	result = snmpInterface.New(map[string]string{"version": "3", "username": "flows", "privprotocol": "aes", "privpassphrase": "secret"})
+	if result != nil {
+		t.Error("([error] Segment SNMP initiated despite privacy without authentication.")
+	}
+
+	snmpInterface = &SNMP{}
+	result = snmpInterface.New(map[string]string{"version": "1"})
+	if result != nil {
+		t.Error("([error] Segment SNMP initiated despite unsupported version.")
+	}
+
+	snmpInterface = &SNMP{}
+	result = snmpInterface.New(map[string]string{"prefetch": "true", "samplers": "192.0.2.1,2001:db8::1"})
+	if result == nil {
+		t.Error("([error] Segment SNMP did not initiate despite good prefetch config.")
+	}
+
+	snmpInterface = &SNMP{}
+	result = snmpInterface.New(map[string]string{"prefetch": "true", "samplers": "router1"})
+	if result != nil {
+		t.Error("([error] Segment SNMP initiated despite bad sampler address.")
+	}
+}
+
+func TestSegment_SNMP_persistentCache(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "snmp_cache.json")
+	segment := &SNMP{CacheFile: cacheFile, CacheTimeout: time.Hour, cacheFileLock: &sync.Mutex{}}
+	segment.snmpCache = cache.New(time.Hour, time.Hour)
+	segment.snmpCache.Set("192.0.2.1-42-name", "et-0/0/0", cache.DefaultExpiration)
+	segment.snmpCache.Set("192.0.2.1-42-desc", "customer", cache.DefaultExpiration)
+	segment.snmpCache.Set("192.0.2.1-42-speed", uint32(100000), cache.DefaultExpiration)
+	segment.snmpCache.Set("192.0.2.1-43-name", nil, cache.DefaultExpiration)
+	segment.snmpCache.Set("malformed", "value", cache.DefaultExpiration)
+	segment.saveCache()
+
+	restored := &SNMP{CacheFile: cacheFile, CacheTimeout: time.Hour}
+	restored.snmpCache = cache.New(time.Hour, time.Hour)
+	restored.loadCache()
+	name, desc, speed := restored.fetchInterfaceData("192.0.2.1", 42)
+	if name != "et-0/0/0" || desc != "customer" || speed != 100000 {
+		t.Errorf("([error] Segment SNMP did not restore its cache correctly: %s %s %d", name, desc, speed)
+	}
+	if _, found := restored.snmpCache.Get("192.0.2.1-43-name"); found {
+		t.Error("([error] Segment SNMP persisted an interface which was being queried.")
+	}
+	if _, found := restored.snmpCache.Get("malformed"); found {
+		t.Error("([error] Segment SNMP restored a malformed cache entry.")
+	}
 }
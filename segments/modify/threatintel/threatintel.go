@@ -0,0 +1,335 @@
+// The `threatintel` segment matches the source and destination addresses of
+// flows against any number of IP reputation or threat intelligence lists. Flows
+// matching any list are tagged using labels, which contain the names of all
+// matching lists and the categories of the matching entries:
+//
+// * `threat_src` and `threat_dst`: comma-separated names of matching lists
+// * `threat_src_category` and `threat_dst_category`: comma-separated categories
+//
+// The `threat` prefix of these label keys can be changed using the `labelprefix`
+// parameter. These labels can be referenced by subsequent segments as usual, for
+// instance using the `labels.threat_src` statement in a `flowfilter`.
+//
+// Lists are configured using the `lists` parameter, a comma-separated list of
+// `name:format:path` entries. The supported formats are:
+//
+// * `plain`: one address or prefix per line, anything after the first
+// whitespace is ignored, as are empty lines and lines starting with `#` or `;`
+// * `drop`: the Spamhaus DROP/EDROP format, i.e. `prefix ; SBL reference`,
+// with the SBL reference being used as category
+// * `csv`: lines in the format `prefix,category`, additional columns are ignored
+//
+// Similar to the `addnetid` segment, all lists are loaded into prefix tries and
+// matched on a longest-prefix basis. All lists are reloaded from disk in the
+// interval given by `refresh`, which can be set to 0 to disable reloading. If a
+// list can not be read when reloading, its previous contents are kept.
+//
+// If `dropmatched` is set to true, flows matching any list are removed from the
+// pipeline, and if `dropunmatched` is set, only those matching a list are kept.
+// In both cases, removed flows are available to the `else` branch when using
+// this segment as a condition of the `branch` segment.
+package threatintel
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwNetFlow/ip_prefix_trie"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type ThreatIntel struct {
+	segments.BaseFilterSegment
+	Lists         []*List       // required, lists to match flows against
+	Refresh       time.Duration // optional, default is 1h, interval for reloading all lists, 0 disables reloading
+	LabelPrefix   string        // optional, default is "threat", prefix of all label keys set by this segment
+	DropMatched   bool          // optional, default is false, determines whether flows matching any list are dropped
+	DropUnmatched bool          // optional, default is false, determines whether flows not matching any list are dropped
+
+	lock *sync.RWMutex
+}
+
+// A single IP reputation list, loaded from a local file.
+type List struct {
+	Name     string
+	Format   string // one of "plain", "drop", "csv"
+	FileName string
+
+	trieV4 *ip_prefix_trie.TrieNode
+	trieV6 *ip_prefix_trie.TrieNode
+}
+
+// Payload of a list entry in the prefix tries. This is a pointer type to allow
+// distinguishing entries without category from the absence of a match.
+type entry struct {
+	category string
+}
+
+func (segment ThreatIntel) New(config map[string]string) segments.Segment {
+	var lists []*List
+	if config["lists"] == "" {
+		log.Error().Msg("ThreatIntel: This segment requires a 'lists' parameter.")
+		return nil
+	}
+	for _, listConfig := range strings.Split(config["lists"], ",") {
+		parts := strings.SplitN(strings.TrimSpace(listConfig), ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			log.Error().Msgf("ThreatIntel: List '%s' is not of the form 'name:format:path'.", listConfig)
+			return nil
+		}
+		switch parts[1] {
+		case "plain", "drop", "csv":
+		default:
+			log.Error().Msgf("ThreatIntel: List '%s' has unknown format '%s', options are 'plain', 'drop' and 'csv'.", parts[0], parts[1])
+			return nil
+		}
+		lists = append(lists, &List{Name: parts[0], Format: parts[1], FileName: parts[2]})
+	}
+
+	var refresh = time.Hour
+	if config["refresh"] != "" {
+		if parsedRefresh, err := time.ParseDuration(config["refresh"]); err == nil {
+			if parsedRefresh < 0 {
+				log.Error().Msg("ThreatIntel: Refresh has to be >= 0.")
+				return nil
+			}
+			refresh = parsedRefresh
+		} else {
+			log.Error().Msg("ThreatIntel: Could not parse 'refresh' parameter, using default 1h.")
+		}
+	} else {
+		log.Info().Msg("ThreatIntel: 'refresh' set to default 1h.")
+	}
+
+	var labelPrefix = "threat"
+	if config["labelprefix"] != "" {
+		labelPrefix = config["labelprefix"]
+	} else {
+		log.Info().Msg("ThreatIntel: 'labelprefix' set to default 'threat'.")
+	}
+
+	dropMatched, err := strconv.ParseBool(config["dropmatched"])
+	if err != nil {
+		log.Info().Msg("ThreatIntel: 'dropmatched' set to default 'false'.")
+	}
+	dropUnmatched, err := strconv.ParseBool(config["dropunmatched"])
+	if err != nil {
+		log.Info().Msg("ThreatIntel: 'dropunmatched' set to default 'false'.")
+	}
+	if dropMatched && dropUnmatched {
+		log.Error().Msg("ThreatIntel: Setting both 'dropmatched' and 'dropunmatched' would drop all flows.")
+		return nil
+	}
+
+	return &ThreatIntel{
+		Lists:         lists,
+		Refresh:       refresh,
+		LabelPrefix:   labelPrefix,
+		DropMatched:   dropMatched,
+		DropUnmatched: dropUnmatched,
+		lock:          &sync.RWMutex{},
+	}
+}
+
+func (segment *ThreatIntel) Run(wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		close(segment.Out)
+		wg.Done()
+	}()
+
+	segment.reloadLists()
+	if segment.Refresh > 0 {
+		go func() {
+			ticker := time.NewTicker(segment.Refresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					segment.reloadLists()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for msg := range segment.In {
+		matched := segment.tagFlow(msg)
+		if (matched && segment.DropMatched) || (!matched && segment.DropUnmatched) {
+			if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+			continue
+		}
+		segment.Out <- msg
+	}
+}
+
+// Looks up both addresses of a flow in all lists and sets the labels
+// accordingly. Returns whether any list matched.
+func (segment *ThreatIntel) tagFlow(msg *pb.EnrichedFlow) bool {
+	segment.lock.RLock()
+	defer segment.lock.RUnlock()
+
+	var srcLists, srcCategories, dstLists, dstCategories []string
+	for _, list := range segment.Lists {
+		if match := list.lookup(msg.SrcAddr); match != nil {
+			srcLists = append(srcLists, list.Name)
+			if match.category != "" {
+				srcCategories = append(srcCategories, match.category)
+			}
+		}
+		if match := list.lookup(msg.DstAddr); match != nil {
+			dstLists = append(dstLists, list.Name)
+			if match.category != "" {
+				dstCategories = append(dstCategories, match.category)
+			}
+		}
+	}
+	if len(srcLists) > 0 {
+		msg.SetLabel(segment.LabelPrefix+"_src", strings.Join(srcLists, ","))
+		msg.SetLabel(segment.LabelPrefix+"_src_category", strings.Join(srcCategories, ","))
+	}
+	if len(dstLists) > 0 {
+		msg.SetLabel(segment.LabelPrefix+"_dst", strings.Join(dstLists, ","))
+		msg.SetLabel(segment.LabelPrefix+"_dst_category", strings.Join(dstCategories, ","))
+	}
+	return len(srcLists) > 0 || len(dstLists) > 0
+}
+
+func (segment *ThreatIntel) reloadLists() {
+	for _, list := range segment.Lists {
+		trieV4, trieV6, count, err := list.read()
+		if err != nil {
+			log.Error().Err(err).Msgf("ThreatIntel: Could not read list '%s', keeping previous entries: ", list.Name)
+			continue
+		}
+		segment.lock.Lock()
+		list.trieV4, list.trieV6 = trieV4, trieV6
+		segment.lock.Unlock()
+		log.Info().Msgf("ThreatIntel: Read list '%s' with %d prefixes.", list.Name, count)
+	}
+}
+
+func (list *List) lookup(address net.IP) *entry {
+	if address == nil {
+		return nil
+	}
+	var match interface{}
+	if address.To4() != nil {
+		if list.trieV4 == nil {
+			return nil
+		}
+		match = list.trieV4.Lookup(address)
+	} else {
+		if list.trieV6 == nil {
+			return nil
+		}
+		match = list.trieV6.Lookup(address)
+	}
+	result, _ := match.(*entry)
+	return result
+}
+
+// Reads this list's file into new prefix tries according to its format.
+func (list *List) read() (*ip_prefix_trie.TrieNode, *ip_prefix_trie.TrieNode, int, error) {
+	f, err := os.Open(segments.ContainerVolumePrefix + list.FileName)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer f.Close()
+
+	trieV4, trieV6 := &ip_prefix_trie.TrieNode{}, &ip_prefix_trie.TrieNode{}
+	var count int
+	insert := func(prefix string, category string) {
+		prefix = strings.TrimSpace(prefix)
+		if !strings.Contains(prefix, "/") {
+			address := net.ParseIP(prefix)
+			if address == nil {
+				log.Warn().Msgf("ThreatIntel: Encountered invalid address '%s' in list '%s'.", prefix, list.Name)
+				return
+			}
+			if address.To4() != nil {
+				prefix += "/32"
+			} else {
+				prefix += "/128"
+			}
+		}
+		address, _, err := net.ParseCIDR(prefix)
+		if err != nil {
+			log.Warn().Err(err).Msgf("ThreatIntel: Encountered invalid prefix in list '%s': ", list.Name)
+			return
+		}
+		if address.To4() != nil {
+			trieV4.Insert(&entry{category: strings.TrimSpace(category)}, []string{prefix})
+		} else {
+			trieV6.Insert(&entry{category: strings.TrimSpace(category)}, []string{prefix})
+		}
+		count += 1
+	}
+
+	switch list.Format {
+	case "csv":
+		csvr := csv.NewReader(f)
+		csvr.Comment = '#'
+		csvr.FieldsPerRecord = -1
+		for {
+			row, err := csvr.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				log.Warn().Err(err).Msgf("ThreatIntel: Encountered non-CSV line in list '%s': ", list.Name)
+				continue
+			}
+			if len(row) > 1 {
+				insert(row[0], row[1])
+			} else {
+				insert(row[0], "")
+			}
+		}
+	case "drop":
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, ";") {
+				continue
+			}
+			prefix, reference, _ := strings.Cut(line, ";")
+			insert(prefix, reference)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, 0, err
+		}
+	default: // plain
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+				continue
+			}
+			insert(fields[0], "")
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	return trieV4, trieV6, count, nil
+}
+
+func init() {
+	segment := &ThreatIntel{}
+	segments.RegisterSegment("threatintel", segment)
+}
@@ -0,0 +1,63 @@
+package threatintel
+
+import (
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+const testLists = "plain:plain:../../../examples/enricher/threatintel_plain.txt,drop:drop:../../../examples/enricher/threatintel_drop.txt,csv:csv:../../../examples/enricher/threatintel.csv"
+
+// ThreatIntel Segment testing is done using the example lists
+func TestSegment_ThreatIntel_matchSrc(t *testing.T) {
+	result := segments.TestSegment("threatintel", map[string]string{"lists": testLists},
+		&pb.EnrichedFlow{SrcAddr: []byte{198, 51, 100, 10}, DstAddr: []byte{10, 0, 0, 1}})
+	if result.GetLabel("threat_src") != "plain,csv" || result.GetLabel("threat_src_category") != "botnet" {
+		t.Error("([error] Segment ThreatIntel is not tagging a matching source address correctly.")
+	}
+	if result.GetLabel("threat_dst") != "" {
+		t.Error("([error] Segment ThreatIntel is tagging a non-matching destination address.")
+	}
+}
+
+func TestSegment_ThreatIntel_matchDstDrop(t *testing.T) {
+	result := segments.TestSegment("threatintel", map[string]string{"lists": testLists, "labelprefix": "intel"},
+		&pb.EnrichedFlow{SrcAddr: []byte{10, 0, 0, 1}, DstAddr: []byte{203, 0, 113, 5}})
+	if result.GetLabel("intel_dst") != "drop" || result.GetLabel("intel_dst_category") != "SBL000001" {
+		t.Error("([error] Segment ThreatIntel is not tagging a matching destination address correctly.")
+	}
+}
+
+func TestSegment_ThreatIntel_matchHostV6(t *testing.T) {
+	result := segments.TestSegment("threatintel", map[string]string{"lists": testLists},
+		&pb.EnrichedFlow{SrcAddr: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}})
+	if result.GetLabel("threat_src") != "plain" {
+		t.Error("([error] Segment ThreatIntel is not matching IPv6 prefixes.")
+	}
+}
+
+func TestSegment_ThreatIntel_dropMatched(t *testing.T) {
+	result := segments.TestSegment("threatintel", map[string]string{"lists": testLists, "dropmatched": "true"},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}})
+	if result != nil {
+		t.Error("([error] Segment ThreatIntel is not dropping matching flows.")
+	}
+}
+
+func TestSegment_ThreatIntel_dropUnmatched(t *testing.T) {
+	result := segments.TestSegment("threatintel", map[string]string{"lists": testLists, "dropunmatched": "true"},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 2}})
+	if result != nil {
+		t.Error("([error] Segment ThreatIntel is not dropping unmatched flows.")
+	}
+}
+
+func TestSegment_ThreatIntel_invalidConfig(t *testing.T) {
+	if (ThreatIntel{}).New(map[string]string{"lists": "foo:json:bar.json"}) != nil {
+		t.Error("([error] Segment ThreatIntel accepts an unknown list format.")
+	}
+	if (ThreatIntel{}).New(map[string]string{"lists": testLists, "dropmatched": "true", "dropunmatched": "true"}) != nil {
+		t.Error("([error] Segment ThreatIntel accepts dropping all flows.")
+	}
+}
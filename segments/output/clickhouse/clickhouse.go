@@ -2,11 +2,14 @@
 //
 // The `batchsize` parameter determines the number of flows stored in memory before writing them to the database. Default is 1000.\
 // The `dsn` parameter is used to specify the `Data Source Name` of the clickhouse database to which the flows should be dumped.\
-// The `preset` parameter is used to specify the schema used to insert into clickhouse. Currently only the default value `flowhouse` is supported.
+// The `preset` parameter is used to specify the schema used to insert into clickhouse. Currently only the default value `flowhouse` is supported.\
+// The `labels` parameter optionally takes a comma-separated list of `labels.<key>` references, each of which is stored in an additional `String` column of the same name.
+// Note that existing tables are not altered, i.e. these columns have to be added manually when enabling this later on.
 package clickhouse_segment
 
 import (
 	"database/sql"
+	"fmt"
 	"math"
 	"net"
 	"strconv"
@@ -28,9 +31,10 @@ type Clickhouse struct {
 	createStatement string
 	insertStatement string
 
-	DSN       string // required
-	Preset    string // optional, what schema to use, currently only the option and default is "flowhouse"
-	BatchSize int    // optional how many flows to hold in memory between INSERTs, default is 1000
+	DSN       string   // required
+	Preset    string   // optional, what schema to use, currently only the option and default is "flowhouse"
+	BatchSize int      // optional how many flows to hold in memory between INSERTs, default is 1000
+	Labels    []string // optional, label keys to store in additional columns, default is none
 
 	bulkInsert func(unsavedFlows []*pb.EnrichedFlow) error
 }
@@ -66,11 +70,27 @@ func (segment Clickhouse) New(config map[string]string) segments.Segment {
 		log.Info().Msg("Clickhouse: 'batchsize' set to default '1000'.")
 	}
 
+	var labelColumns, labelNames, labelPlaceholders string
+	if config["labels"] != "" {
+		for _, field := range strings.Split(config["labels"], ",") {
+			field = strings.TrimSpace(field)
+			key, ok := pb.LabelKey(field)
+			if !ok {
+				log.Error().Msgf("Clickhouse: '%s' specified in 'labels' is not of the form 'labels.<key>'.", field)
+				return nil
+			}
+			newsegment.Labels = append(newsegment.Labels, key)
+			labelColumns += fmt.Sprintf(",\n\t\t\t`%s` String", field)
+			labelNames += fmt.Sprintf(",\n\t\t\t`%s`", field)
+			labelPlaceholders += ", ?"
+		}
+	}
+
 	// determine field set
 	newsegment.Preset = strings.ToLower(config["preset"])
 	switch newsegment.Preset {
 	case "flowhouse":
-		newsegment.createStatement = `CREATE TABLE IF NOT EXISTS flows (
+		newsegment.createStatement = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS flows (
 			agent           IPv6,
 			int_in          String,
 			int_out         String,
@@ -90,13 +110,13 @@ func (segment Clickhouse) New(config map[string]string) segments.Segment {
 			timestamp       DateTime,
 			size            UInt64,
 			packets         UInt64,
-			samplerate      UInt64
+			samplerate      UInt64%s
 		) ENGINE = MergeTree()
 		PARTITION BY toStartOfTenMinutes(timestamp)
 		ORDER BY (timestamp)
 		TTL timestamp + INTERVAL 14 DAY
-		SETTINGS index_granularity = 8192`
-		newsegment.insertStatement = `INSERT INTO flows (
+		SETTINGS index_granularity = 8192`, labelColumns)
+		newsegment.insertStatement = fmt.Sprintf(`INSERT INTO flows (
 			agent,
 			int_in,
 			int_out,
@@ -116,8 +136,8 @@ func (segment Clickhouse) New(config map[string]string) segments.Segment {
 			timestamp,
 			size,
 			packets,
-			samplerate
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? , ?, ?, ?)`
+			samplerate%s
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? , ?, ?, ?%s)`, labelNames, labelPlaceholders)
 		newsegment.bulkInsert = newsegment.bulkInsertFlowhouse
 	default:
 		log.Error().Msgf("Clickhouse: Unknown preset selected.")
@@ -205,6 +225,9 @@ func (segment Clickhouse) bulkInsertFlowhouse(unsavedFlows []*pb.EnrichedFlow) e
 			msg.Packets,
 			msg.SamplingRate,
 		}
+		for _, key := range segment.Labels {
+			valueArgs = append(valueArgs, msg.GetLabel(key))
+		}
 		_, err := tx.Exec(segment.insertStatement, valueArgs...)
 		if err != nil {
 			log.Error().Err(err).Msg("Clickhouse: Error inserting flow into transaction")
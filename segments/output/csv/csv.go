@@ -2,7 +2,8 @@
 // can be instructed to write to file using the filename parameter. The fields
 // parameter can be used to limit which fields will be exported. If no filename is
 // provided or empty, the output goes to stdout. By default all fields are exported.
-// To reduce them, use a valid comma separated list of fields.
+// To reduce them, use a valid comma separated list of fields. Single labels can
+// be exported as their own column by referencing them as `labels.<key>`.
 package csv
 
 import (
@@ -43,6 +44,11 @@ func (segment Csv) New(config map[string]string) segments.Segment {
 		conffields := strings.Split(config["fields"], ",")
 		for _, field := range conffields {
 			field = strings.TrimSpace(field)
+			if _, ok := pb.LabelKey(field); ok {
+				heading = append(heading, field)
+				newsegment.fieldNames = append(newsegment.fieldNames, field)
+				continue
+			}
 			protoField, found := protofields.FieldByName(field)
 			if !found || !protoField.IsExported() {
 				log.Error().Msgf("Csv: Field '%s' specified in 'fields' does not exist.", field)
@@ -84,6 +90,10 @@ func (segment *Csv) Run(wg *sync.WaitGroup) {
 		var record []string
 		values := reflect.ValueOf(msg).Elem()
 		for _, fieldname := range segment.fieldNames {
+			if key, ok := pb.LabelKey(fieldname); ok {
+				record = append(record, msg.GetLabel(key))
+				continue
+			}
 			value := values.FieldByName(fieldname).Interface()
 			switch value := value.(type) {
 			case []uint8: // this is necessary for proper formatting
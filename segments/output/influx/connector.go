@@ -54,6 +54,10 @@ func (c *Connector) CreatePoint(msg *pb.EnrichedFlow) *write.Point {
 	tags := make(map[string]string)
 	values := reflect.ValueOf(msg).Elem()
 	for _, tagname := range c.Tags {
+		if key, ok := pb.LabelKey(tagname); ok {
+			tags[tagname] = msg.GetLabel(key)
+			continue
+		}
 		value := values.FieldByName(tagname).Interface()
 		switch value := value.(type) {
 		case []uint8: // this is necessary for proper formatting
@@ -1,6 +1,7 @@
 // The `influx` segment provides a way to write into an Influxdb instance.
 // The `tags` parameter allows any field to be used as a tag and takes a comma-separated list from any
 // field available in the [protobuf definition](https://github.com/BelWue/flowpipeline/blob/master/pb/flow.proto).
+// Single labels can be used as tags by referencing them as `labels.<key>`.
 // The `fields` works in the exact same way, except that these protobuf fields won't be indexed by InfluxDB.
 //
 // Note that some of the above fields might not be present depending on the method
@@ -76,6 +77,9 @@ func (segment Influx) New(config map[string]string) segments.Segment {
 		protomembers := reflect.TypeOf(pb.EnrichedFlow{})
 		for _, tagname := range newsegment.Tags {
 			tagname = strings.TrimSpace(tagname)
+			if _, ok := pb.LabelKey(tagname); ok {
+				continue
+			}
 			_, found := protomembers.FieldByName(tagname)
 			if !found {
 				log.Error().Msgf("Influx: Unknown name '%s' specified in 'tags'.", tagname)
@@ -2,6 +2,9 @@
 // own monitoring info at `:8080/metrics` and its flow data at `:8080/flowdata` by
 // default. The label set included with each metric is freely configurable with a
 // comma-separated list from any field available in the [protobuf definition](https://github.com/BelWue/flowpipeline/blob/master/pb/flow.proto).
+// Single labels of a flow can be referenced as `labels.<key>` and are exported
+// using the label name `labels_<key>`, with any characters not allowed by
+// Prometheus replaced by underscores.
 //
 // Note that some of the above fields might not be present depending on the method
 // of flow export, the input segment used in this pipeline, or the modify segments
@@ -12,6 +15,7 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +28,8 @@ import (
 	"github.com/go-co-op/gocron/v2"
 )
 
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
 type Prometheus struct {
 	segments.BaseSegment
 	Endpoint          string         // optional, default value is ":8080"
@@ -35,6 +41,7 @@ type Prometheus struct {
 	ExportASPaths     bool           // optional, if true, as paths will be exported
 
 	PromExporter *Exporter
+	labelNames   []string // Prometheus label names corresponding to Labels
 }
 
 func (segment Prometheus) New(config map[string]string) segments.Segment {
@@ -102,12 +109,18 @@ func (segment Prometheus) New(config map[string]string) segments.Segment {
 	protofields := reflect.TypeOf(pb.EnrichedFlow{})
 	for _, field := range labels {
 		field = strings.TrimSpace(field)
+		if key, ok := pb.LabelKey(field); ok {
+			newsegment.Labels = append(newsegment.Labels, field)
+			newsegment.labelNames = append(newsegment.labelNames, "labels_"+invalidLabelChars.ReplaceAllString(key, "_"))
+			continue
+		}
 		_, found := protofields.FieldByName(field)
 		if !found {
 			log.Error().Msgf("Prometheus: Field '%s' specified in 'labels' does not exist.", field)
 			return nil
 		}
 		newsegment.Labels = append(newsegment.Labels, field)
+		newsegment.labelNames = append(newsegment.labelNames, field)
 	}
 	return newsegment
 }
@@ -127,7 +140,11 @@ func (segment *Prometheus) Run(wg *sync.WaitGroup) {
 	for msg := range segment.In {
 		labelset := make(map[string]string)
 		values := reflect.ValueOf(msg).Elem()
-		for _, fieldname := range segment.Labels {
+		for i, fieldname := range segment.Labels {
+			if key, ok := pb.LabelKey(fieldname); ok {
+				labelset[segment.labelNames[i]] = msg.GetLabel(key)
+				continue
+			}
 			value := values.FieldByName(fieldname).Interface()
 			switch value.(type) {
 			case []uint8: // this is necessary for proper formatting
@@ -160,7 +177,7 @@ func (segment *Prometheus) Run(wg *sync.WaitGroup) {
 }
 
 func (segment *Prometheus) initializeExporter(exporter *Exporter) {
-	exporter.Initialize(segment.Labels)
+	exporter.Initialize(segment.labelNames)
 	exporter.ServeEndpoints(segment)
 }
 
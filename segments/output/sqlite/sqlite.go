@@ -6,7 +6,9 @@
 // The `sqlite` segment provides a SQLite output option. It is intended for use as
 // an ad-hoc dump method to answer questions on live traffic, i.e. average packet
 // size for a specific class of traffic. The fields parameter optionally takes a
-// string of comma-separated fieldnames, e.g. `SrcAddr,Bytes,Packets`.
+// string of comma-separated fieldnames, e.g. `SrcAddr,Bytes,Packets`. Single
+// labels can be stored in their own column by referencing them as
+// `labels.<key>`.
 //
 // The batchsize parameter determines the number of flows stored in memory before
 // writing them to the database in a transaction made up from as many insert
@@ -87,6 +89,11 @@ func (segment Sqlite) New(config map[string]string) segments.Segment {
 		protofields := reflect.TypeOf(pb.EnrichedFlow{})
 		conffields := strings.Split(config["fields"], ",")
 		for _, field := range conffields {
+			if _, ok := pb.LabelKey(field); ok {
+				newsegment.fieldNames = append(newsegment.fieldNames, field)
+				newsegment.fieldTypes = append(newsegment.fieldTypes, "label")
+				continue
+			}
 			protofield, found := protofields.FieldByName(field)
 			if !found || !protofield.IsExported() {
 				log.Error().Msgf("Sqlite: Field '%s' specified in 'fields' does not exist.", field)
@@ -109,13 +116,15 @@ func (segment Sqlite) New(config map[string]string) segments.Segment {
 
 	// use field set to pre-gen statements
 	// create
-	var fields []string
+	var fields, columns []string
 	for i, fieldname := range newsegment.fieldNames {
+		column := fmt.Sprintf("%q", fieldname) // quoted, as label references contain a dot
+		columns = append(columns, column)
 		switch newsegment.fieldTypes[i] {
 		case "uint64", "uint32":
-			fields = append(fields, fieldname+" INTEGER")
+			fields = append(fields, column+" INTEGER")
 		default:
-			fields = append(fields, fieldname+" TEXT")
+			fields = append(fields, column+" TEXT")
 		}
 	}
 	newsegment.createStatement = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS flows (%s);`, strings.Join(fields, ","))
@@ -127,7 +136,7 @@ func (segment Sqlite) New(config map[string]string) segments.Segment {
 	}
 	valueStrings := make([]string, 0, len(newsegment.fieldNames))
 	valueStrings = append(valueStrings, fmt.Sprintf("(%s)", strings.Join(qmList, ",")))
-	newsegment.insertStatement = fmt.Sprintf("INSERT INTO flows (%s) VALUES %s", strings.Join(newsegment.fieldNames, ","), strings.Join(valueStrings, ","))
+	newsegment.insertStatement = fmt.Sprintf("INSERT INTO flows (%s) VALUES %s", strings.Join(columns, ","), strings.Join(valueStrings, ","))
 
 	return newsegment
 }
@@ -183,6 +192,11 @@ func (segment Sqlite) bulkInsert(unsavedFlows []*pb.EnrichedFlow) error {
 		valueArgs := make([]interface{}, 0, len(segment.fieldNames))
 		values := reflect.ValueOf(msg).Elem()
 		for i, fieldname := range segment.fieldNames {
+			if segment.fieldTypes[i] == "label" {
+				key, _ := pb.LabelKey(fieldname)
+				valueArgs = append(valueArgs, msg.GetLabel(key))
+				continue
+			}
 			protofield := values.FieldByName(fieldname)
 			switch segment.fieldTypes[i] {
 			case "[]uint8": // this is neccessary for proper formatting
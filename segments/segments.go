@@ -68,6 +68,19 @@ func TestSegment(name string, config map[string]string, msg *pb.EnrichedFlow) *p
 	return resultMsg
 }
 
+// Used by the tests to create a segment of the given type without running it,
+// for instance to call its internal methods directly. Its output is buffered
+// in the returned channel.
+func NewTestSegment[T Segment](name string, config map[string]string) (T, chan *pb.EnrichedFlow) {
+	segment, ok := LookupSegment(name).New(config).(T)
+	if !ok {
+		log.Fatal().Msgf("Configured segment '%s' could not be initialized properly, see previous messages.", name)
+	}
+	out := make(chan *pb.EnrichedFlow, 10)
+	segment.Rewire(nil, out)
+	return segment, out
+}
+
 // This interface is central to an Pipeline object, as it operates on a list of
 // them. In general, Segments should embed the BaseSegment to provide the
 // Rewire function and the associated vars.